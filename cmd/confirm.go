@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Answers of every prompt are read through the same reader, as a reader
+// buffers the answers of the prompts after its own when they are piped.
+var confirmInput = bufio.NewReader(os.Stdin)
+
+// Read the answer to a prompt up to the end of its line.
+func readAnswer() (string, error) {
+	answer, err := confirmInput.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// Ask the user to type the name of what is about to be changed, so a
+// destructive command can not run against the wrong environment by accident.
+func confirmName(action, kind, name string) error {
+	fmt.Printf("This will %s %s %s. Type the name of the %s to confirm: ", action, kind, name, kind)
+
+	answer, err := readAnswer()
+	if err != nil {
+		return err
+	}
+
+	if answer != name {
+		return fmt.Errorf("confirmation did not match %s %s, nothing was changed", kind, name)
+	}
+	return nil
+}
+
+// Report whether stdin is a terminal someone can answer prompts on, rather
+// than a pipe or /dev/null as in CI.
+func interactive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Ask the user to answer yes before acting on what was listed to them.
+func confirmYes(question string) error {
+	fmt.Printf("%s [y/N]: ", question)
+
+	answer, err := readAnswer()
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("not confirmed, nothing was changed")
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/urfave/cli"
+)
+
+func DeployCommand() cli.Command {
+	return cli.Command{
+		Name:  "deploy",
+		Usage: "Operations on the journals of multi-service deployments",
+		Subcommands: []cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the deployment journals, newest first",
+				Flags: []cli.Flag{
+					formatFlag,
+				},
+				Action: DeployListAction,
+			},
+			{
+				Name:      "resume",
+				Usage:     "Resume an interrupted deployment from its journal",
+				ArgsUsage: "<id>",
+				Action:    DeployResumeAction,
+			},
+		},
+	}
+}
+
+func DeployListAction(c *cli.Context) error {
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	journals, err := rancher.ListJournals(rancher.JournalDir(stateDir))
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(journals)
+	}
+
+	rows := [][]string{}
+	for _, journal := range journals {
+		rows = append(rows, []string{
+			journal.Id,
+			journal.Operation,
+			journal.Started.Format(time.RFC3339),
+			fmt.Sprint(journal.Services),
+			fmt.Sprint(journal.Done),
+		})
+	}
+	printTable([]string{"ID", "OPERATION", "STARTED", "SERVICES", "DONE"}, rows)
+	return nil
+}
+
+func DeployResumeAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("deploy resume requires the id of the journal, see deploy list")
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	return client.ResumeDeployment(rancher.JournalDir(stateDir), c.Args().First())
+}
@@ -1,11 +1,23 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
 	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/compose"
 	"github.com/nowait/rancher-cli/rancher/config"
+	rancherClient "github.com/rancher/go-rancher/client"
 	"github.com/urfave/cli"
 )
 
+const (
+	defaultCloneResumeFile = ".rancher-clone-resume.json"
+)
+
 func EnvironmentCommand() cli.Command {
 	return cli.Command{
 		Name:  "env",
@@ -21,23 +33,674 @@ func EnvironmentCommand() cli.Command {
 					cli.StringFlag{
 						Name: "target-env",
 					},
+					cli.StringFlag{
+						Name:  "source-context",
+						Usage: "Context of the Rancher server to clone from, defaults to --context",
+					},
+					cli.StringFlag{
+						Name:  "target-context",
+						Usage: "Context of the Rancher server to clone into, defaults to --context",
+					},
+					cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "List the stacks that would be cloned without changing anything",
+					},
+					cli.StringSliceFlag{
+						Name:  "stack",
+						Usage: "Only clone stacks whose name matches this glob, can be repeated",
+					},
+					cli.StringSliceFlag{
+						Name:  "exclude-stack",
+						Usage: "Do not clone stacks whose name matches this glob, can be repeated",
+					},
+					cli.StringFlag{
+						Name:  "on-exists",
+						Usage: "What to do with stacks that already exist in the target: fail, skip, update or rename",
+						Value: config.ON_EXISTS_FAIL,
+					},
+					cli.StringFlag{
+						Name:  "rewrite",
+						Usage: "Path to rewrite rules applied to the compose files of every cloned stack",
+					},
+					cli.BoolFlag{
+						Name:  "keep-partial",
+						Usage: "Leave the stacks of a failed clone in place and write a resume file instead of removing them",
+					},
+					cli.BoolFlag{
+						Name:  "resume",
+						Usage: "Continue a failed clone from the resume file",
+					},
+					cli.StringFlag{
+						Name:  "resume-file",
+						Usage: "Path of the resume file",
+						Value: defaultCloneResumeFile,
+					},
+					yesFlag,
+					reportFlag,
+					overrideFreezeFlag,
+					reasonFlag,
+					formatFlag,
 				},
 				Action: CloneEnvironmentAction,
 			},
+			{
+				Name:  "diff",
+				Usage: "Compare the stacks of two environments",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name: "source-env",
+					},
+					cli.StringFlag{
+						Name: "target-env",
+					},
+					cli.StringFlag{
+						Name:  "source-context",
+						Usage: "Context of the Rancher server of the source environment, defaults to --context",
+					},
+					cli.StringFlag{
+						Name:  "target-context",
+						Usage: "Context of the Rancher server of the target environment, defaults to --context",
+					},
+					cli.BoolFlag{
+						Name:  "changed-only",
+						Usage: "Leave out the stacks that are equal in both environments",
+					},
+					formatFlag,
+				},
+				Action: DiffEnvironmentAction,
+			},
+			{
+				Name:  "list",
+				Usage: "List the environments",
+				Flags: []cli.Flag{
+					formatFlag,
+				},
+				Action: ListEnvironmentsAction,
+			},
+			{
+				Name:  "create",
+				Usage: "Create an environment, optionally with the stacks of another environment",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "Name of the environment",
+					},
+					cli.StringFlag{
+						Name:  "description",
+						Usage: "Description of the environment",
+					},
+					cli.StringFlag{
+						Name:  "orchestration",
+						Usage: "Orchestration of the environment: cattle, kubernetes, mesos or swarm",
+						Value: rancher.ORCHESTRATION_CATTLE,
+					},
+					cli.StringFlag{
+						Name:  "seed-from",
+						Usage: "Name of an environment whose stacks are cloned into the new environment",
+					},
+					cli.StringSliceFlag{
+						Name:  "stack",
+						Usage: "Only clone stacks whose name matches this glob, can be repeated",
+					},
+					cli.StringSliceFlag{
+						Name:  "exclude-stack",
+						Usage: "Do not clone stacks whose name matches this glob, can be repeated",
+					},
+					cli.StringFlag{
+						Name:  "rewrite",
+						Usage: "Path to rewrite rules applied to the compose files of every cloned stack",
+					},
+					cli.Int64Flag{
+						Name:  "timeout",
+						Usage: "Seconds to wait for the environment to become active",
+						Value: int64(defaultProjectCreateTimeout / time.Second),
+					},
+				},
+				Action: CreateEnvironmentAction,
+			},
+			{
+				Name:  "deactivate",
+				Usage: "Deactivate an environment",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "project",
+						Usage: "Name of the environment",
+					},
+					yesFlag,
+				},
+				Action: DeactivateEnvironmentAction,
+			},
+			{
+				Name:  "remove",
+				Usage: "Remove an environment and all its stacks",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "project",
+						Usage: "Name of the environment",
+					},
+					yesFlag,
+				},
+				Action: RemoveEnvironmentAction,
+			},
+			{
+				Name:  "members",
+				Usage: "Manage the members of an environment",
+				Subcommands: []cli.Command{
+					{
+						Name:  "list",
+						Usage: "List the members of an environment",
+						Flags: []cli.Flag{
+							cli.StringFlag{
+								Name:  "project",
+								Usage: "Name of the environment",
+							},
+							formatFlag,
+						},
+						Action: ListMembersAction,
+					},
+					{
+						Name:   "add",
+						Usage:  "Add a member to an environment or change its role",
+						Flags:  append(memberFlags(), cli.StringFlag{Name: "role", Usage: "Role of the member: owner, member, readonly or restricted", Value: rancher.MEMBER_ROLE_MEMBER}),
+						Action: AddMemberAction,
+					},
+					{
+						Name:   "remove",
+						Usage:  "Remove a member from an environment",
+						Flags:  memberFlags(),
+						Action: RemoveMemberAction,
+					},
+				},
+			},
 		},
 	}
 }
 
 func CloneEnvironmentAction(c *cli.Context) error {
 	opts := config.EnvUpgradeOpts{
-		SourceEnv: c.String("source-env"),
-		TargetEnv: c.String("target-env"),
+		SourceEnv:     c.String("source-env"),
+		TargetEnv:     c.String("target-env"),
+		Stacks:        c.StringSlice("stack"),
+		ExcludeStacks: c.StringSlice("exclude-stack"),
+		OnExists:      c.String("on-exists"),
+		KeepPartial:   c.Bool("keep-partial"),
+	}
+
+	if path := c.String("rewrite"); path != "" {
+		rules, err := compose.LoadRewriteRules(path)
+		if err != nil {
+			return err
+		}
+		opts.Rewrite = rules
+	}
+
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	resumeFile := c.String("resume-file")
+	state := &rancher.CloneState{
+		Plan: &rancher.ClonePlan{
+			SourceContext: contextOr(c.String("source-context"), c.GlobalString("context")),
+			TargetContext: contextOr(c.String("target-context"), c.GlobalString("context")),
+		},
+	}
+	if c.Bool("resume") {
+		resumed, err := rancher.LoadCloneState(resumeFile)
+		if err != nil {
+			return err
+		}
+		state = resumed
+		log.Infof("Resuming clone at stack %d of %d", state.Completed+1, len(state.Plan.Stacks))
+	}
+
+	source, target, err := cloneClients(c, state.Plan.SourceContext, state.Plan.TargetContext)
+	if err != nil {
+		return err
+	}
+
+	if !c.Bool("resume") {
+		plan, err := source.PlanCloneTo(target, opts)
+		if err != nil {
+			return err
+		}
+		plan.SourceContext, plan.TargetContext = state.Plan.SourceContext, state.Plan.TargetContext
+		state.Plan = plan
+	}
+
+	missing, err := source.MissingCloneReferences(target, state.Plan)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("dry-run") {
+		return printClonePlan(source, state, missing, format)
+	}
+
+	g, err := confirmClone(c, source, target, state, missing)
+	if err != nil {
+		return err
+	}
+
+	conf, err := config.LoadCliConfig(cliConfigPath)
+	if err != nil {
+		return err
+	}
+	if missing, err = source.CarryCloneReferences(target, state.Plan, missing, conf.RegistryCredentials); err != nil {
+		return g.recordOverrides(err)
+	}
+	if len(missing) > 0 {
+		log.Warnf("Missing in the target environment: %s", rancher.FormatReferences(missing))
+	}
+
+	finish, err := startReport(c, "env clone", source)
+	if err != nil {
+		return err
+	}
+	if err := source.CloneStacksTo(target, state, opts.KeepPartial); err != nil {
+		err = finish(g.recordOverrides(rancher.NewDeployError(rancher.EXIT_UPGRADE, err)))
+		if !opts.KeepPartial {
+			return err
+		}
+		if saveErr := rancher.SaveCloneState(resumeFile, state); saveErr != nil {
+			return &rancher.DeployError{Code: rancher.ExitCode(err), Err: fmt.Errorf("%v, writing the resume file also failed: %v", err, saveErr)}
+		}
+		return &rancher.DeployError{Code: rancher.ExitCode(err), Err: fmt.Errorf("%v, kept %d cloned stacks, continue with env clone --resume --resume-file %s", err, state.Completed, resumeFile)}
+	}
+
+	if err := finish(g.recordOverrides(nil)); err != nil {
+		return err
+	}
+	if c.Bool("resume") {
+		return os.Remove(resumeFile)
+	}
+	return nil
+}
+
+func DiffEnvironmentAction(c *cli.Context) error {
+	if c.String("source-env") == "" || c.String("target-env") == "" {
+		return errors.New("env diff requires --source-env and --target-env")
+	}
+
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	source, target, err := cloneClients(c,
+		contextOr(c.String("source-context"), c.GlobalString("context")),
+		contextOr(c.String("target-context"), c.GlobalString("context")),
+	)
+	if err != nil {
+		return err
+	}
+
+	sourceProject, targetProject, err := diffProjects(source, target, c.String("source-env"), c.String("target-env"))
+	if err != nil {
+		return err
+	}
+
+	diffs, err := source.DiffProjects(target, sourceProject.Id, targetProject.Id)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("changed-only") {
+		changed := []rancher.StackDiff{}
+		for _, diff := range diffs {
+			if diff.Status != rancher.STACK_DIFF_EQUAL {
+				changed = append(changed, diff)
+			}
+		}
+		diffs = changed
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(diffs)
+	}
+
+	rows := [][]string{}
+	for _, diff := range diffs {
+		rows = append(rows, []string{diff.Stack, diff.Status, fmt.Sprint(len(diff.Changes))})
+	}
+	printTable([]string{"STACK", "STATUS", "CHANGES"}, rows)
+
+	for _, diff := range diffs {
+		if len(diff.Changes) > 0 {
+			fmt.Printf("\n%s\n", diff.Stack)
+			printChanges(diff.Changes)
+		}
+	}
+	return nil
+}
+
+// Ask for the name of the target environment when it is protected, after
+// printing the stacks about to be cloned into it.  Clones into a frozen
+// environment are refused, the returned guard records overridden freezes once
+// the clone is done.
+func confirmClone(c *cli.Context, source, target *rancher.Client, state *rancher.CloneState, missing []rancher.MissingReference) (*guard, error) {
+	g, err := newGuard(c, state.Plan.TargetContext)
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := target.ProjectName(state.Plan.TargetProjectId)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.checkFreeze("clone", fmt.Sprintf("%d stacks", len(state.Plan.Stacks)-state.Completed), project); err != nil {
+		return nil, err
+	}
+
+	return g, g.confirm("clone stacks into", []string{project}, func() error {
+		return printClonePlan(source, state, missing, "")
+	})
+}
+
+func memberFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "project",
+			Usage: "Name of the environment",
+		},
+		cli.StringFlag{
+			Name:  "external-id",
+			Usage: "Id of the user or group in the authentication provider",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Usage: "Type of the external id, such as github_user, github_team or ldap_user",
+		},
+	}
+}
+
+func ListEnvironmentsAction(c *cli.Context) error {
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	projects, err := client.ListProjects()
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(projects)
+	}
+
+	rows := [][]string{}
+	for _, project := range projects {
+		rows = append(rows, []string{project.Id, project.Name, project.Orchestration, project.State, fmt.Sprint(project.Stacks)})
+	}
+	printTable([]string{"ID", "NAME", "ORCHESTRATION", "STATE", "STACKS"}, rows)
+	return nil
+}
+
+func CreateEnvironmentAction(c *cli.Context) error {
+	name := c.String("name")
+	if name == "" {
+		return errors.New("env create requires --name")
+	}
+
+	opts := config.EnvUpgradeOpts{
+		SourceEnv:     c.String("seed-from"),
+		TargetEnv:     name,
+		Stacks:        c.StringSlice("stack"),
+		ExcludeStacks: c.StringSlice("exclude-stack"),
+		OnExists:      config.ON_EXISTS_FAIL,
+	}
+	if path := c.String("rewrite"); path != "" {
+		rules, err := compose.LoadRewriteRules(path)
+		if err != nil {
+			return err
+		}
+		opts.Rewrite = rules
 	}
 
-	client, err := rancher.NewClient(cattleUrl, cattleAccessKey, cattleSecret, "")
+	client, err := newClient(c, "")
 	if err != nil {
 		return err
 	}
 
-	return client.CloneProject(opts)
+	if opts.SourceEnv != "" {
+		if _, err := client.ProjectByName(opts.SourceEnv); err != nil {
+			return err
+		}
+	}
+
+	project, err := client.CreateProject(name, c.String("description"), c.String("orchestration"), time.Duration(c.Int64("timeout"))*time.Second)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created environment %s with id %s\n", project.Name, project.Id)
+
+	if opts.SourceEnv == "" {
+		return nil
+	}
+
+	if err := client.CloneProject(opts); err != nil {
+		return fmt.Errorf("environment %s was created but cloning the stacks of %s failed: %v", name, opts.SourceEnv, err)
+	}
+	fmt.Printf("Cloned the stacks of %s\n", opts.SourceEnv)
+	return nil
+}
+
+func DeactivateEnvironmentAction(c *cli.Context) error {
+	client, project, err := confirmedProject(c, "deactivate")
+	if err != nil {
+		return err
+	}
+
+	return client.DeactivateProject(project)
+}
+
+func RemoveEnvironmentAction(c *cli.Context) error {
+	client, project, err := confirmedProject(c, "remove")
+	if err != nil {
+		return err
+	}
+
+	return client.RemoveProject(project)
+}
+
+// Look up the project of the --project flag and ask the user to confirm the
+// action by typing its name, unless --yes is passed.
+func confirmedProject(c *cli.Context, action string) (*rancher.Client, *rancherClient.Project, error) {
+	client, project, err := namedProject(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !c.Bool("yes") {
+		if err := confirmName(action, "environment", project.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+	return client, project, nil
+}
+
+func namedProject(c *cli.Context) (*rancher.Client, *rancherClient.Project, error) {
+	if c.String("project") == "" {
+		return nil, nil, errors.New("--project is required")
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	project, err := client.ProjectByName(c.String("project"))
+	return client, project, err
+}
+
+func ListMembersAction(c *cli.Context) error {
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	client, project, err := namedProject(c)
+	if err != nil {
+		return err
+	}
+
+	members, err := client.ProjectMembers(project.Id)
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(members)
+	}
+
+	rows := [][]string{}
+	for _, member := range members {
+		rows = append(rows, []string{member.Name, member.ExternalId, member.ExternalIdType, member.Role})
+	}
+	printTable([]string{"NAME", "EXTERNAL ID", "TYPE", "ROLE"}, rows)
+	return nil
+}
+
+func AddMemberAction(c *cli.Context) error {
+	member, err := namedMember(c)
+	if err != nil {
+		return err
+	}
+	member.Role = c.String("role")
+
+	client, project, err := namedProject(c)
+	if err != nil {
+		return err
+	}
+
+	return client.AddProjectMember(project, member)
+}
+
+func RemoveMemberAction(c *cli.Context) error {
+	member, err := namedMember(c)
+	if err != nil {
+		return err
+	}
+
+	client, project, err := namedProject(c)
+	if err != nil {
+		return err
+	}
+
+	return client.RemoveProjectMember(project, member)
+}
+
+func namedMember(c *cli.Context) (rancher.ProjectMember, error) {
+	if c.String("external-id") == "" || c.String("type") == "" {
+		return rancher.ProjectMember{}, errors.New("--external-id and --type are required")
+	}
+	return rancher.ProjectMember{
+		ExternalId:     c.String("external-id"),
+		ExternalIdType: c.String("type"),
+	}, nil
+}
+
+// Create the clients for the source and target servers set up with the cli
+// config, sharing the client when both use the same context.
+func cloneClients(c *cli.Context, sourceContext, targetContext string) (*rancher.Client, *rancher.Client, error) {
+	source, err := newContextClient(sourceContext, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := configureClient(c, source); err != nil {
+		return nil, nil, err
+	}
+
+	if sourceContext == targetContext {
+		return source, source, nil
+	}
+
+	target, err := newContextClient(targetContext, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := configureClient(c, target); err != nil {
+		return nil, nil, err
+	}
+	return source, target, nil
+}
+
+func contextOr(name, defaultName string) string {
+	if name != "" {
+		return name
+	}
+	return defaultName
+}
+
+type stackClone struct {
+	Stack  string `json:"stack"`
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+type clonePlan struct {
+	Stacks  []stackClone               `json:"stacks"`
+	Missing []rancher.MissingReference `json:"missing"`
+}
+
+// Print the stacks of the plan that have not been cloned yet, along with the
+// changes the rewrite rules make to their compose files and the references
+// missing in the target environment.
+func printClonePlan(client *rancher.Client, state *rancher.CloneState, missing []rancher.MissingReference, format string) error {
+	plan := clonePlan{
+		Stacks:  []stackClone{},
+		Missing: missing,
+	}
+	for _, stack := range state.Plan.Stacks[state.Completed:] {
+		clone := stackClone{
+			Stack:  stack.Source.Name,
+			Action: stack.Action,
+			Target: stack.Name,
+		}
+
+		if state.Plan.Rewrite != nil && stack.Action != rancher.CLONE_ACTION_SKIP {
+			diff, err := client.CloneDiff(state.Plan, stack)
+			if err != nil {
+				return err
+			}
+			clone.Diff = diff
+		}
+
+		plan.Stacks = append(plan.Stacks, clone)
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(plan)
+	}
+
+	rows := [][]string{}
+	for _, stack := range plan.Stacks {
+		rows = append(rows, []string{stack.Stack, stack.Action, stack.Target})
+	}
+	printTable([]string{"STACK", "ACTION", "TARGET STACK"}, rows)
+
+	for _, stack := range plan.Stacks {
+		if stack.Diff != "" {
+			fmt.Printf("\n%s -> %s\n%s", stack.Stack, stack.Target, stack.Diff)
+		}
+	}
+
+	if len(missing) > 0 {
+		fmt.Println("\nMissing in the target environment:")
+		rows = [][]string{}
+		for _, ref := range missing {
+			rows = append(rows, []string{ref.Stack, ref.Kind, ref.Name})
+		}
+		printTable([]string{"STACK", "KIND", "NAME"}, rows)
+	}
+
+	return nil
 }
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	rancherClient "github.com/rancher/go-rancher/client"
+	"github.com/urfave/cli"
+)
+
+type CloneProjects struct {
+	mocks.FailedProjectOperations
+}
+
+func (proj *CloneProjects) List(opts *rancherClient.ListOpts) (*rancherClient.ProjectCollection, error) {
+	return &rancherClient.ProjectCollection{
+		Data: []rancherClient.Project{*mocks.ProjectOne, *mocks.ProjectTwo},
+	}, nil
+}
+
+func (proj *CloneProjects) ById(id string) (*rancherClient.Project, error) {
+	for _, project := range []*rancherClient.Project{mocks.ProjectOne, mocks.ProjectTwo} {
+		if project.Id == id {
+			return project, nil
+		}
+	}
+	return nil, nil
+}
+
+// CloneStacks holds the stack api of project one and records the stacks
+// created by the clone.
+type CloneStacks struct {
+	mocks.NoopEnvironmentOperations
+	Created []string
+}
+
+func (env *CloneStacks) List(opts *rancherClient.ListOpts) (*rancherClient.EnvironmentCollection, error) {
+	stacks := []rancherClient.Environment{}
+	if opts.Filters["accountId_eq"] == mocks.ProjectOne.Id {
+		stacks = append(stacks, rancherClient.Environment{Resource: rancherClient.Resource{Id: "1e1"}, Name: "api", State: "active"})
+	}
+	return &rancherClient.EnvironmentCollection{Data: stacks}, nil
+}
+
+func (env *CloneStacks) ActionExportconfig(*rancherClient.Environment, *rancherClient.ComposeConfigInput) (*rancherClient.ComposeConfig, error) {
+	return &rancherClient.ComposeConfig{
+		DockerComposeConfig:  "version: '2'\nservices:\n  api:\n    image: nowait/api:1.0\n",
+		RancherComposeConfig: "version: '2'\n",
+	}, nil
+}
+
+func (env *CloneStacks) Create(opts *rancherClient.Environment) (*rancherClient.Environment, error) {
+	env.Created = append(env.Created, opts.Name)
+	return &rancherClient.Environment{Resource: rancherClient.Resource{Id: "1e2"}, Name: opts.Name, State: "active"}, nil
+}
+
+type EmptyCertificates struct {
+	rancherClient.CertificateOperations
+}
+
+func (ops *EmptyCertificates) List(opts *rancherClient.ListOpts) (*rancherClient.CertificateCollection, error) {
+	return &rancherClient.CertificateCollection{}, nil
+}
+
+type EmptyRegistries struct {
+	rancherClient.RegistryOperations
+}
+
+func (ops *EmptyRegistries) List(opts *rancherClient.ListOpts) (*rancherClient.RegistryCollection, error) {
+	return &rancherClient.RegistryCollection{}, nil
+}
+
+type EmptyRegistryCredentials struct {
+	rancherClient.RegistryCredentialOperations
+}
+
+func (ops *EmptyRegistryCredentials) List(opts *rancherClient.ListOpts) (*rancherClient.RegistryCredentialCollection, error) {
+	return &rancherClient.RegistryCredentialCollection{}, nil
+}
+
+type EmptyServices struct {
+	rancherClient.ServiceOperations
+}
+
+func (ops *EmptyServices) List(opts *rancherClient.ListOpts) (*rancherClient.ServiceCollection, error) {
+	return &rancherClient.ServiceCollection{}, nil
+}
+
+func TestCloneEnvironmentNotifies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	standIn, err := rancher.NewStandIn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standIn.Close()
+
+	origConfig, origState, origClient := cliConfigPath, stateDir, newRancherClient
+	defer func() { cliConfigPath, stateDir, newRancherClient = origConfig, origState, origClient }()
+	cliConfigPath = filepath.Join(dir, "rancher-cli.yml")
+	stateDir = dir
+	conf := "notifiers:\n  - name: hook\n    type: webhook\n    url: " + standIn.Url + "/hook\n    events: [clone-complete]\n"
+	if err := ioutil.WriteFile(cliConfigPath, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stacks := &CloneStacks{}
+	newRancherClient = func(url, accessKey, secretKey, envFile string) (*rancher.Client, error) {
+		return &rancher.Client{
+			RancherClient: &rancherClient.RancherClient{
+				Project:            &CloneProjects{},
+				Environment:        stacks,
+				Certificate:        &EmptyCertificates{},
+				Registry:           &EmptyRegistries{},
+				RegistryCredential: &EmptyRegistryCredentials{},
+				Service:            &EmptyServices{},
+			},
+		}, nil
+	}
+
+	app := cli.NewApp()
+	app.Flags = GlobalFlags()
+	app.Commands = []cli.Command{EnvironmentCommand()}
+	err = app.Run([]string{"rancher", "env", "clone", "--source-env", mocks.ProjectOneName, "--target-env", mocks.ProjectTwoName, "--yes"})
+	if err != nil {
+		t.Fatalf("cloning failed: %v", err)
+	}
+
+	if len(stacks.Created) != 1 || stacks.Created[0] != "api" {
+		t.Errorf("expected stack api to be cloned, received %v", stacks.Created)
+	}
+	received := standIn.Received()
+	if len(received) != 1 || received[0].Target != "/hook" || !strings.Contains(received[0].Body, "cloned 1 stacks into environment two: api") {
+		t.Errorf("expected the clone to be notified, received %v", received)
+	}
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/urfave/cli"
+)
+
+var (
+	yesFlag = cli.BoolFlag{
+		Name:  "yes",
+		Usage: "Do not ask for confirmation, even for protected environments",
+	}
+	maxServicesFlag = cli.IntFlag{
+		Name:  "max-services",
+		Usage: "Allow acting on up to this many services, defaults to max_services of the cli config",
+	}
+	overrideFreezeFlag = cli.BoolFlag{
+		Name:  "override-freeze",
+		Usage: "Deploy during a freeze of the cli config, requires --reason",
+	}
+	reasonFlag = cli.StringFlag{
+		Name:  "reason",
+		Usage: "Why the deployment is made, recorded in the deployment history",
+	}
+)
+
+// guard keeps destructive commands from changing protected environments
+// without the user typing their name, and deployments out of frozen
+// environments, following the cli config.
+type guard struct {
+	conf    *config.CliConfig
+	context string
+	yes     bool
+	// Limit of --max-services, 0 uses the limit of the cli config
+	maxServices int
+
+	overrideFreeze bool
+	reason         string
+	// Freeze overrides recorded once the deployment is done
+	overrides []rancher.AuditEntry
+}
+
+// Create the guard of the named context.  The cli config is optional, without
+// it nothing is protected.
+func newGuard(c *cli.Context, context string) (*guard, error) {
+	conf, err := config.LoadCliConfig(cliConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return &guard{
+		conf:    conf,
+		context: context,
+		yes:     c.Bool("yes"),
+
+		maxServices: c.Int("max-services"),
+
+		overrideFreeze: c.Bool("override-freeze"),
+		reason:         c.String("reason"),
+	}, nil
+}
+
+// Return the protected environments among the named ones in sorted order.
+func (g *guard) protected(projects ...string) []string {
+	found := make(map[string]bool)
+	for _, name := range projects {
+		if g.conf.IsProtected(g.context, name) {
+			found[name] = true
+		}
+	}
+
+	names := []string{}
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Ask the user to type the name of every protected environment the action
+// changes, after showing them the plan.  Nothing is asked with --yes.
+func (g *guard) confirm(action string, projects []string, plan func() error) error {
+	protected := g.protected(projects...)
+	if len(protected) == 0 {
+		return nil
+	}
+
+	if err := plan(); err != nil {
+		return err
+	}
+
+	if g.yes {
+		log.Warnf("Not asking for confirmation to %s protected environments %s", action, strings.Join(protected, ", "))
+		return nil
+	}
+
+	for _, name := range protected {
+		if err := confirmName(action, "protected environment", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refuse to deploy to environments that are frozen, unless the freeze is
+// overridden with a reason.  Overrides are recorded by recordOverrides.
+func (g *guard) checkFreeze(action, target string, projects ...string) error {
+	if g.overrideFreeze && g.reason == "" {
+		return errors.New("--override-freeze requires --reason")
+	}
+
+	checked := make(map[string]bool)
+	for _, project := range projects {
+		if checked[project] {
+			continue
+		}
+		checked[project] = true
+
+		freeze := g.conf.ActiveFreeze(project, time.Now())
+		if freeze == nil {
+			continue
+		}
+		if !g.overrideFreeze {
+			return fmt.Errorf("environment %s is frozen by %s, pass --override-freeze --reason to deploy anyway", project, freeze)
+		}
+
+		log.Warnf("Overriding %s of environment %s: %s", freeze, project, g.reason)
+		g.overrides = append(g.overrides, rancher.AuditEntry{
+			Action:      rancher.AUDIT_ACTION_FREEZE_OVERRIDE,
+			Environment: project,
+			Target:      action + " " + target,
+			Reason:      g.reason,
+			Freeze:      freeze.Name,
+		})
+	}
+	return nil
+}
+
+// Record the freezes overridden by the deployment in the audit log
+// along with its outcome, once the deployment is done.  The error of the
+// deployment is returned, or the failure to record it when it succeeded.
+func (g *guard) recordOverrides(deployErr error) error {
+	var recordErr error
+	for _, entry := range g.overrides {
+		entry.Outcome = rancher.AUDIT_OUTCOME_DEPLOYED
+		if deployErr != nil {
+			entry.Outcome = rancher.AUDIT_OUTCOME_FAILED
+			entry.Error = deployErr.Error()
+		}
+		if err := rancher.AppendAudit(stateDir, entry); err != nil {
+			recordErr = fmt.Errorf("failed to record the freeze override in the audit log: %v", err)
+			log.Error(recordErr)
+		}
+	}
+	g.overrides = nil
+
+	if deployErr != nil {
+		return deployErr
+	}
+	return recordErr
+}
+
+// Names of the freezes overridden by the deployment, comma separated, to be
+// recorded on the upgraded services.
+func (g *guard) overriddenFreezes() string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, entry := range g.overrides {
+		if !seen[entry.Freeze] {
+			seen[entry.Freeze] = true
+			names = append(names, entry.Freeze)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Refuse to act on more services than the cli config allows, unless
+// --max-services raises the limit.
+func (g *guard) checkServiceLimit(count int) error {
+	limit := g.conf.ServiceLimit()
+	if g.maxServices > 0 {
+		limit = g.maxServices
+	}
+
+	if count > limit {
+		return fmt.Errorf("%d services match, more than the limit of %d, pass --max-services %d to act on all of them", count, limit, count)
+	}
+	return nil
+}
+
+// Names of the projects of the services.
+func serviceProjects(client *rancher.Client, services []rancher.SelectedService) ([]string, error) {
+	names := []string{}
+	for _, sel := range services {
+		name, err := client.ProjectName(sel.Service.AccountId)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
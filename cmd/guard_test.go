@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/config"
+	rancherClient "github.com/rancher/go-rancher/client"
+)
+
+func guardConfig() *config.CliConfig {
+	return &config.CliConfig{
+		Contexts: map[string]config.Context{
+			"prod": {Protected: true},
+		},
+		Protected:   []string{"production"},
+		MaxServices: 3,
+	}
+}
+
+func TestGuardConfirm(t *testing.T) {
+	orig := confirmInput
+	defer func() { confirmInput = orig }()
+
+	tests := []struct {
+		Description string
+		Context     string
+		Projects    []string
+		Yes         bool
+		Input       string
+		PlanErr     error
+		Planned     bool
+		Error       string
+	}{
+		{
+			Description: "Unprotected environments are not confirmed",
+			Projects:    []string{"staging"},
+		},
+		{
+			Description: "Protected environment confirmed by its name",
+			Projects:    []string{"staging", "production"},
+			Input:       "production\n",
+			Planned:     true,
+		},
+		{
+			Description: "Protected environment confirmed with another name",
+			Projects:    []string{"production"},
+			Input:       "staging\n",
+			Planned:     true,
+			Error:       "confirmation did not match protected environment production, nothing was changed",
+		},
+		{
+			Description: "Protected environment without an answer",
+			Projects:    []string{"production"},
+			Planned:     true,
+			Error:       "confirmation did not match protected environment production, nothing was changed",
+		},
+		{
+			Description: "Protected environment with --yes",
+			Projects:    []string{"production"},
+			Yes:         true,
+			Planned:     true,
+		},
+		{
+			Description: "Every environment of a protected context",
+			Context:     "prod",
+			Projects:    []string{"staging"},
+			Input:       "staging\n",
+			Planned:     true,
+		},
+		{
+			Description: "Several protected environments answered from one input",
+			Context:     "prod",
+			Projects:    []string{"staging", "production"},
+			Input:       "production\nstaging\n",
+			Planned:     true,
+		},
+		{
+			Description: "Failing plan is not confirmed",
+			Projects:    []string{"production"},
+			Yes:         true,
+			PlanErr:     errors.New("listing failed"),
+			Planned:     true,
+			Error:       "listing failed",
+		},
+	}
+
+	for _, test := range tests {
+		confirmInput = bufio.NewReader(strings.NewReader(test.Input))
+		g := &guard{conf: guardConfig(), context: test.Context, yes: test.Yes}
+
+		planned := false
+		err := g.confirm("upgrade services of", test.Projects, func() error {
+			planned = true
+			return test.PlanErr
+		})
+
+		if planned != test.Planned {
+			t.Errorf("%s: expected the plan shown to be %v", test.Description, test.Planned)
+		}
+		if test.Error == "" && err != nil {
+			t.Errorf("%s: expected no error but received %v", test.Description, err)
+		}
+		if test.Error != "" && (err == nil || err.Error() != test.Error) {
+			t.Errorf("%s: expected error %q but received %v", test.Description, test.Error, err)
+		}
+	}
+}
+
+func TestGuardCheckServiceLimit(t *testing.T) {
+	tests := []struct {
+		Description string
+		Conf        *config.CliConfig
+		MaxServices int
+		Count       int
+		Error       string
+	}{
+		{
+			Description: "Within the limit of the cli config",
+			Conf:        guardConfig(),
+			Count:       3,
+		},
+		{
+			Description: "Over the limit of the cli config",
+			Conf:        guardConfig(),
+			Count:       4,
+			Error:       "4 services match, more than the limit of 3, pass --max-services 4 to act on all of them",
+		},
+		{
+			Description: "Over the limit of the cli config raised by --max-services",
+			Conf:        guardConfig(),
+			MaxServices: 5,
+			Count:       4,
+		},
+		{
+			Description: "Over the limit of --max-services",
+			Conf:        guardConfig(),
+			MaxServices: 5,
+			Count:       6,
+			Error:       "6 services match, more than the limit of 5, pass --max-services 6 to act on all of them",
+		},
+		{
+			Description: "Over the default limit",
+			Conf:        &config.CliConfig{},
+			Count:       config.DEFAULT_MAX_SERVICES + 1,
+			Error:       "services match, more than the limit",
+		},
+	}
+
+	for _, test := range tests {
+		g := &guard{conf: test.Conf, maxServices: test.MaxServices}
+
+		err := g.checkServiceLimit(test.Count)
+
+		if test.Error == "" && err != nil {
+			t.Errorf("%s: expected no error but received %v", test.Description, err)
+		}
+		if test.Error != "" && (err == nil || !strings.Contains(err.Error(), test.Error)) {
+			t.Errorf("%s: expected error %q but received %v", test.Description, test.Error, err)
+		}
+	}
+}
+
+func TestGuardFreezeOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	orig := stateDir
+	stateDir = dir
+	defer func() { stateDir = orig }()
+
+	frozen := &config.CliConfig{
+		Freezes: []config.Freeze{
+			{Name: "always", Environments: []string{"production"}, Days: []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}},
+		},
+	}
+
+	tests := []struct {
+		Description    string
+		OverrideFreeze bool
+		Reason         string
+		Projects       []string
+		DeployErr      error
+		Error          string
+		Outcome        string
+	}{
+		{
+			Description: "Environment without a freeze",
+			Projects:    []string{"staging"},
+		},
+		{
+			Description: "Frozen environment",
+			Projects:    []string{"staging", "production"},
+			Error:       "environment production is frozen by freeze always, pass --override-freeze --reason to deploy anyway",
+		},
+		{
+			Description:    "Override without a reason",
+			OverrideFreeze: true,
+			Projects:       []string{"production"},
+			Error:          "--override-freeze requires --reason",
+		},
+		{
+			Description:    "Override of a deployment that went through",
+			OverrideFreeze: true,
+			Reason:         "hotfix",
+			Projects:       []string{"production", "production"},
+			Outcome:        rancher.AUDIT_OUTCOME_DEPLOYED,
+		},
+		{
+			Description:    "Override of a deployment that failed",
+			OverrideFreeze: true,
+			Reason:         "hotfix",
+			Projects:       []string{"production"},
+			DeployErr:      errors.New("upgrade failed"),
+			Outcome:        rancher.AUDIT_OUTCOME_FAILED,
+		},
+	}
+
+	for _, test := range tests {
+		os.Remove(dir + "/" + rancher.AUDIT_FILE)
+		g := &guard{conf: frozen, overrideFreeze: test.OverrideFreeze, reason: test.Reason}
+
+		err := g.checkFreeze("upgrade", "backend/api", test.Projects...)
+		if test.Error != "" {
+			if err == nil || err.Error() != test.Error {
+				t.Errorf("%s: expected error %q but received %v", test.Description, test.Error, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error but received %v", test.Description, err)
+			continue
+		}
+
+		if freezes := g.overriddenFreezes(); (test.Outcome != "") != (freezes == "always") {
+			t.Errorf("%s: unexpected overridden freezes %q", test.Description, freezes)
+		}
+		if entries, _ := rancher.ReadAudit(dir); len(entries) != 0 {
+			t.Errorf("%s: expected nothing recorded before the deployment but received %v", test.Description, entries)
+		}
+		if err := g.recordOverrides(test.DeployErr); err != test.DeployErr {
+			t.Errorf("%s: expected the deployment error %v but received %v", test.Description, test.DeployErr, err)
+		}
+
+		entries, err := rancher.ReadAudit(dir)
+		if test.Outcome == "" {
+			if err != nil || len(entries) != 0 {
+				t.Errorf("%s: expected nothing recorded but received %v, %v", test.Description, entries, err)
+			}
+			continue
+		}
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("%s: expected a single override recorded but received %v, %v", test.Description, entries, err)
+		}
+		entry := entries[0]
+		if entry.Action != rancher.AUDIT_ACTION_FREEZE_OVERRIDE || entry.Freeze != "always" || entry.Reason != test.Reason || entry.Target != "upgrade backend/api" || entry.Outcome != test.Outcome {
+			t.Errorf("%s: unexpected audit entry %#v", test.Description, entry)
+		}
+		if test.DeployErr != nil && entry.Error != test.DeployErr.Error() {
+			t.Errorf("%s: expected the error %v recorded but received %q", test.Description, test.DeployErr, entry.Error)
+		}
+	}
+}
+
+type NamedProjects struct {
+	rancherClient.ProjectOperations
+}
+
+func (proj *NamedProjects) ById(id string) (*rancherClient.Project, error) {
+	if id == "1a5" {
+		return &rancherClient.Project{Resource: rancherClient.Resource{Id: id}, Name: "production"}, nil
+	}
+	return nil, nil
+}
+
+func TestServiceProjects(t *testing.T) {
+	client := &rancher.Client{
+		RancherClient: &rancherClient.RancherClient{Project: &NamedProjects{}},
+	}
+
+	tests := []struct {
+		AccountIds []string
+		Projects   []string
+		Error      string
+	}{
+		{
+			AccountIds: []string{"1a5", "1a5"},
+			Projects:   []string{"production", "production"},
+		},
+		{
+			AccountIds: []string{"1a5", "1a6"},
+			Error:      "failed to find project with id 1a6",
+		},
+	}
+
+	for index, test := range tests {
+		selected := []rancher.SelectedService{}
+		for _, id := range test.AccountIds {
+			selected = append(selected, rancher.SelectedService{Service: rancherClient.Service{AccountId: id}})
+		}
+
+		projects, err := serviceProjects(client, selected)
+
+		if test.Error != "" {
+			if err == nil || err.Error() != test.Error {
+				t.Errorf("test case %d: expected error %q but received %v", index, test.Error, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(projects, test.Projects) {
+			t.Errorf("test case %d: expected projects %v but received %v, %v", index, test.Projects, projects, err)
+		}
+	}
+}
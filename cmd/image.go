@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/urfave/cli"
+)
+
+func ImageCommand() cli.Command {
+	return cli.Command{
+		Name:  "image",
+		Usage: "Operations on docker images",
+		Subcommands: []cli.Command{
+			{
+				Name:      "where",
+				Usage:     "Find the services running an image",
+				ArgsUsage: "<repo>[:tag|@digest]",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "all-contexts",
+						Usage: "Search every context defined in the cli config",
+					},
+					formatFlag,
+				},
+				Action: ImageWhereAction,
+			},
+			{
+				Name:      "promote",
+				Usage:     "Copy an image between repositories or registries",
+				ArgsUsage: "<source>:<tag> <destination>[:tag]",
+				Action:    ImagePromoteAction,
+			},
+			{
+				Name:      "prepull",
+				Usage:     "Pull images onto hosts ahead of an upgrade",
+				ArgsUsage: "<image>...",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "service",
+						Usage: "Pull onto the hosts running this service instead of every host",
+					},
+					cli.Int64Flag{
+						Name:  "timeout",
+						Usage: "Seconds to wait for the images to be pulled",
+						Value: int64(defaultPrepullTimeout / time.Second),
+					},
+				},
+				Action: ImagePrepullAction,
+			},
+		},
+	}
+}
+
+type contextImageUsage struct {
+	Context string `json:"context,omitempty"`
+	rancher.ImageUsage
+}
+
+func ImageWhereAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("image where requires exactly one image argument")
+	}
+
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	pattern, err := rancher.ParseImagePattern(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	contexts := []string{c.GlobalString("context")}
+	if c.Bool("all-contexts") {
+		conf, err := config.LoadCliConfig(cliConfigPath)
+		if err != nil {
+			return err
+		}
+		contexts = conf.ContextNames()
+	}
+
+	usages := []contextImageUsage{}
+	for _, name := range contexts {
+		client, err := newContextClient(name, "")
+		if err != nil {
+			return err
+		}
+
+		found, err := client.ImageUsages(pattern)
+		if err != nil {
+			return fmt.Errorf("context %s: %v", name, err)
+		}
+
+		for _, usage := range found {
+			usages = append(usages, contextImageUsage{
+				Context:    name,
+				ImageUsage: usage,
+			})
+		}
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(usages)
+	}
+
+	headers := []string{"PROJECT", "STACK", "SERVICE", "CONTAINER", "IMAGE"}
+	if c.Bool("all-contexts") {
+		headers = append([]string{"CONTEXT"}, headers...)
+	}
+
+	rows := [][]string{}
+	for _, usage := range usages {
+		row := []string{usage.Project, usage.Stack, usage.Service, usage.Role, usage.Image}
+		if c.Bool("all-contexts") {
+			row = append([]string{usage.Context}, row...)
+		}
+		rows = append(rows, row)
+	}
+	printTable(headers, rows)
+
+	return nil
+}
+
+func ImagePromoteAction(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("image promote requires a source and a destination image")
+	}
+
+	promoted, err := promoteImage(c.Args().Get(0), c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Promoted %s to %s\n", c.Args().Get(0), promoted)
+	return nil
+}
+
+// Promote the src image to dst and return the promoted image.  When dst has
+// no tag the tag of src is kept.
+func promoteImage(src, dst string) (string, error) {
+	srcRef, err := config.ParseImageRef(src)
+	if err != nil {
+		return "", err
+	}
+
+	if pos := strings.LastIndex(dst, ":"); pos == -1 || pos < strings.LastIndex(dst, "/") {
+		dst = dst + ":" + srcRef.Tag
+	}
+
+	dstRef, err := config.ParseImageRef(dst)
+	if err != nil {
+		return "", err
+	}
+
+	conf, err := config.LoadCliConfig(cliConfigPath)
+	if err != nil {
+		return "", err
+	}
+
+	if err := config.NewImagePromoter(conf).Promote(srcRef, dstRef); err != nil {
+		return "", fmt.Errorf("promoting %s to %s failed: %v", srcRef, dstRef, err)
+	}
+
+	return dst, nil
+}
+
+func ImagePrepullAction(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("image prepull requires at least one image")
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(c.Int64("timeout")) * time.Second
+	images := []string(c.Args())
+
+	if name := c.String("service"); name != "" {
+		service, err := client.ServiceByName(name)
+		if err != nil {
+			return err
+		}
+		return client.PrepullServiceImages(service, images, timeout)
+	}
+
+	return client.PrepullImages(images, map[string]interface{}{}, timeout)
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+func LockCommand() cli.Command {
+	return cli.Command{
+		Name:  "lock",
+		Usage: "Operations on the deployment locks of services",
+		Subcommands: []cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the locked services",
+				Flags: []cli.Flag{
+					formatFlag,
+				},
+				Action: LockListAction,
+			},
+			{
+				Name:  "release",
+				Usage: "Release the lock on a service",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "service",
+						Usage: "Name of the service",
+					},
+					cli.BoolFlag{
+						Name:  "force",
+						Usage: "Release a lock held by somebody else that has not expired",
+					},
+				},
+				Action: LockReleaseAction,
+			},
+		},
+	}
+}
+
+func LockListAction(c *cli.Context) error {
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	locks, err := client.ListLocks()
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(locks)
+	}
+
+	rows := [][]string{}
+	for _, locked := range locks {
+		rows = append(rows, []string{
+			locked.Stack,
+			locked.Service,
+			locked.Lock.Owner,
+			locked.Lock.Host,
+			locked.Lock.Started.Format(time.RFC3339),
+			locked.Lock.Expires().Format(time.RFC3339),
+			fmt.Sprint(locked.Expired),
+		})
+	}
+	printTable([]string{"STACK", "SERVICE", "OWNER", "HOST", "STARTED", "EXPIRES", "EXPIRED"}, rows)
+	return nil
+}
+
+func LockReleaseAction(c *cli.Context) error {
+	if c.String("service") == "" {
+		return errors.New("lock release requires --service")
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	return client.ReleaseLock(c.String("service"), c.Bool("force"))
+}
@@ -3,6 +3,10 @@ package cmd
 import (
 	"os"
 	"time"
+
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/urfave/cli"
 )
 
 var (
@@ -10,7 +14,18 @@ var (
 	cattleAccessKey string
 	cattleSecret    string
 
+	cliConfigPath string
+	stateDir      string
+
 	defaultUpgradeInterval time.Duration
+	defaultPrepullTimeout  time.Duration
+
+	defaultStackUpgradeTimeout  time.Duration
+	defaultProjectCreateTimeout time.Duration
+	defaultLockTTL              time.Duration
+
+	// Replaced by tests to run commands against mocks
+	newRancherClient = rancher.NewClient
 )
 
 func init() {
@@ -18,5 +33,107 @@ func init() {
 	cattleAccessKey = os.Getenv("CATTLE_ACCESS_KEY")
 	cattleSecret = os.Getenv("CATTLE_SECRET_KEY")
 
+	cliConfigPath = config.DefaultCliConfigPath()
+	stateDir = config.DefaultStateDir()
+
 	defaultUpgradeInterval = 10 * time.Second
+	defaultPrepullTimeout = 10 * time.Minute
+	defaultStackUpgradeTimeout = 10 * time.Minute
+	defaultProjectCreateTimeout = 2 * time.Minute
+	defaultLockTTL = 30 * time.Minute
+}
+
+// GlobalFlags are the flags shared by every command.
+func GlobalFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "context",
+			Usage: "Name of a context from the cli config to use instead of the CATTLE_* environment variables",
+		},
+		cli.StringFlag{
+			Name:   "env, project, scope-env, scope-project",
+			Usage:  "Name of the environment to limit service and stack lookups to",
+			EnvVar: "RANCHER_ENV",
+		},
+		cli.StringFlag{
+			Name:  "stack, scope-stack",
+			Usage: "Name of the stack to limit service lookups to",
+		},
+		cli.BoolFlag{
+			Name:  "all-projects",
+			Usage: "Allow acting on matching services of more than one environment",
+		},
+		cli.StringFlag{
+			Name:   "policy",
+			Usage:  "Policy file with the rules every upgraded launch config must satisfy",
+			EnvVar: "RANCHER_CLI_POLICY",
+		},
+	}
+}
+
+// Create a client for the context selected with the global --context flag,
+// limited to the environment and stack of the global --env and --stack
+// flags.
+func newClient(c *cli.Context, envFile string) (*rancher.Client, error) {
+	client, err := newContextClient(c.GlobalString("context"), envFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.SetScope(c.GlobalString("env"), c.GlobalString("stack"), c.GlobalBool("all-projects")); err != nil {
+		return nil, err
+	}
+	if err := configureClient(c, client); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Set up the history, journals, notifiers and policy of the cli config and
+// global flags on the client.
+func configureClient(c *cli.Context, client *rancher.Client) error {
+	conf, err := config.LoadCliConfig(cliConfigPath)
+	if err != nil {
+		return err
+	}
+	if client.History, err = rancher.NewHistoryStore(conf.History.Store, client, stateDir); err != nil {
+		return err
+	}
+	client.HistoryKeep = conf.History.Keep
+	client.CliVersion = c.App.Version
+	client.JournalDir = rancher.JournalDir(stateDir)
+	if client.Notifiers, err = rancher.NewNotifiers(conf.Notifiers); err != nil {
+		return err
+	}
+
+	if path := c.GlobalString("policy"); path != "" {
+		policy, err := config.LoadPolicy(path)
+		if err != nil {
+			return err
+		}
+		policy.ProjectName = client.ProjectName
+		client.UpgradeValidators = append(client.UpgradeValidators, policy)
+	}
+	return nil
+}
+
+// Create a client for the named context.  An empty name uses the CATTLE_*
+// environment variables.
+func newContextClient(name string, envFile string) (*rancher.Client, error) {
+	if name == "" {
+		return newRancherClient(cattleUrl, cattleAccessKey, cattleSecret, envFile)
+	}
+
+	conf, err := config.LoadCliConfig(cliConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := conf.Context(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRancherClient(ctx.Url, ctx.AccessKey, ctx.SecretKey, envFile)
 }
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/urfave/cli"
+)
+
+func NotifyCommand() cli.Command {
+	return cli.Command{
+		Name:  "notify",
+		Usage: "Operations on the deployment notifiers of the cli config",
+		Subcommands: []cli.Command{
+			{
+				Name:  "test",
+				Usage: "Send sample notifications to a local stand-in and print what the notifiers sent",
+				Flags: []cli.Flag{
+					cli.StringSliceFlag{
+						Name:  "event",
+						Usage: "Event to send, every event when not set. Can be repeated",
+					},
+				},
+				Action: NotifyTestAction,
+			},
+		},
+	}
+}
+
+func NotifyTestAction(c *cli.Context) error {
+	conf, err := config.LoadCliConfig(cliConfigPath)
+	if err != nil {
+		return err
+	}
+	notifiers, err := rancher.NewNotifiers(conf.Notifiers)
+	if err != nil {
+		return err
+	}
+	if notifiers == nil {
+		return fmt.Errorf("no notifiers are configured in %s", cliConfigPath)
+	}
+
+	events := c.StringSlice("event")
+	if len(events) == 0 {
+		events = config.NotifyEvents
+	}
+
+	standIn, err := rancher.NewStandIn()
+	if err != nil {
+		return err
+	}
+	defer standIn.Close()
+	notifiers.Redirect(standIn)
+
+	failed := false
+	for _, event := range events {
+		if err := notifiers.Notify(sampleNotification(event)); err != nil {
+			fmt.Printf("%s: %v\n", event, err)
+			failed = true
+		}
+	}
+
+	for _, message := range standIn.Received() {
+		fmt.Printf("--- %s\n%s\n", message.Target, strings.TrimSpace(message.Body))
+	}
+	if failed {
+		return errors.New("sending the sample notifications failed")
+	}
+	return nil
+}
+
+func sampleNotification(event string) rancher.Notification {
+	user := rancher.CurrentUser()
+	results := []rancher.NotifiedResult{
+		{ServiceId: "1s1", Service: "backend/api", State: "upgraded", Images: []string{"nowait/api:1.2.0"}},
+		{ServiceId: "1s2", Service: "backend/worker", State: "upgraded", Images: []string{"nowait/worker:1.2.0"}},
+	}
+
+	notification := rancher.Notification{
+		Event:   event,
+		User:    user,
+		Reason:  "testing the notifiers",
+		Results: results,
+	}
+	switch event {
+	case config.NOTIFY_UPGRADE_START:
+		notification.Summary = fmt.Sprintf("%s started upgrading 2 services: backend/api, backend/worker", user)
+	case config.NOTIFY_UPGRADE_SUCCESS:
+		notification.Summary = fmt.Sprintf("%s upgraded 2 services: backend/api, backend/worker", user)
+	case config.NOTIFY_UPGRADE_FAILURE:
+		results[1].Error = "finishing upgrade timed out"
+		notification.Summary = fmt.Sprintf("%s failed to upgrade 1 of 2 services: backend/worker", user)
+	case config.NOTIFY_ROLLBACK:
+		notification.Results = results[1:]
+		notification.Summary = fmt.Sprintf("%s rolled back 1 services: backend/worker", user)
+	case config.NOTIFY_CLONE_COMPLETE:
+		notification.Results = nil
+		notification.Stacks = []string{"backend", "frontend"}
+		notification.Environment = "Staging"
+		notification.Summary = fmt.Sprintf("%s cloned 2 stacks into environment Staging: backend, frontend", user)
+	}
+	return notification
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	FORMAT_TABLE = "table"
+	FORMAT_JSON  = "json"
+)
+
+var formatFlag = cli.StringFlag{
+	Name:  "format",
+	Value: FORMAT_TABLE,
+	Usage: "Output format, either table or json",
+}
+
+func validateFormat(format string) error {
+	if format != FORMAT_TABLE && format != FORMAT_JSON {
+		return fmt.Errorf("invalid format %s, expected %s or %s", format, FORMAT_TABLE, FORMAT_JSON)
+	}
+	return nil
+}
+
+// Print the rows as an aligned table with the headers as the first line.
+func printTable(headers []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/urfave/cli"
+)
+
+var reportFlag = cli.StringSliceFlag{
+	Name:  "report",
+	Usage: "Write a report of the deployed services or stacks as junit=path or json=path. Can be repeated",
+}
+
+// Start recording the deployment into the clients when --report is given.
+// The returned func writes the reports and passes the error of the command
+// through.  A command failing before it deployed anything is reported as a
+// failed case of its own.
+func startReport(c *cli.Context, name string, clients ...*rancher.Client) (func(error) error, error) {
+	targets, err := config.ParseReportTargets(c.StringSlice("report"))
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return func(err error) error { return err }, nil
+	}
+
+	report := rancher.NewReport(name)
+	for _, client := range clients {
+		client.Report = report
+	}
+	return func(err error) error {
+		if err != nil && len(report.Cases) == 0 {
+			report.Add(rancher.REPORT_KIND_COMMAND, name, report.Started, "", "", err)
+		}
+		if writeErr := report.Write(targets); writeErr != nil {
+			if err != nil {
+				log.Errorf("%v", writeErr)
+				return err
+			}
+			return writeErr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/urfave/cli"
+)
+
+func TestStartReportRecordsRefusedCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.json")
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.Var(&cli.StringSlice{"json=" + path}, "report", "")
+	c := cli.NewContext(nil, set, nil)
+
+	finish, err := startReport(c, "service upgrade", &rancher.Client{})
+	if err != nil {
+		t.Fatalf("starting the report failed: %v", err)
+	}
+	refused := errors.New("environment production is frozen")
+	if err := finish(refused); err != refused {
+		t.Errorf("expected the error of the command passed through, received %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected the report to be written: %v", err)
+	}
+	report := rancher.Report{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("reading the report failed: %v", err)
+	}
+	if len(report.Cases) != 1 {
+		t.Fatalf("expected a single case, received %v", report.Cases)
+	}
+	reported := report.Cases[0]
+	if reported.Kind != rancher.REPORT_KIND_COMMAND || reported.Name != "service upgrade" || reported.Error != refused.Error() {
+		t.Errorf("expected the refused command reported, received %#v", reported)
+	}
+}
@@ -1,18 +1,80 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/compose"
 	"github.com/nowait/rancher-cli/rancher/config"
+	rancherClient "github.com/rancher/go-rancher/client"
 	"github.com/urfave/cli"
 )
 
+var (
+	matchFlag = cli.StringSliceFlag{
+		Name:  "match",
+		Usage: "Glob of the service names to select, or a regular expression as /expr/, patterns with a slash match stack/service",
+	}
+	selectorFlag = cli.StringFlag{
+		Name:  "selector",
+		Usage: "Labels the selected services must have, such as tier=api,!canary",
+	}
+	excludeFlag = cli.StringSliceFlag{
+		Name:  "exclude",
+		Usage: "Glob or /regular expression/ of the service names to leave out",
+	}
+)
+
 func ServiceCommand() cli.Command {
 	return cli.Command{
 		Name:  "service",
 		Usage: "Operations on services",
 		Subcommands: []cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the services",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "stack",
+						Usage: "Only list the services of this stack",
+					},
+					cli.StringFlag{
+						Name:  "service-like",
+						Usage: "Only list the services whose name starts with this prefix",
+					},
+					cli.StringFlag{
+						Name:  "state",
+						Usage: "Only list the services in this state, such as active or upgraded",
+					},
+					cli.StringFlag{
+						Name:  "health",
+						Usage: "Only list the services with this health, such as healthy or degraded",
+					},
+					matchFlag,
+					selectorFlag,
+					excludeFlag,
+					formatFlag,
+				},
+				Action: ServiceListAction,
+			},
+			{
+				Name:  "inspect",
+				Usage: "Show the details of a service",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name: "service",
+					},
+					cli.BoolFlag{
+						Name:  "show-secrets",
+						Usage: "Show the values of environment variables that look like secrets",
+					},
+					formatFlag,
+				},
+				Action: ServiceInspectAction,
+			},
 			{
 				Name:  "upgrade",
 				Usage: "Upgrade a service",
@@ -23,6 +85,22 @@ func ServiceCommand() cli.Command {
 					cli.StringFlag{
 						Name: "service-like",
 					},
+					matchFlag,
+					selectorFlag,
+					excludeFlag,
+					yesFlag,
+					reportFlag,
+					maxServicesFlag,
+					overrideFreezeFlag,
+					reasonFlag,
+					cli.StringFlag{
+						Name:  "git-sha",
+						Usage: "Commit the deployed images were built from, stamped on the services",
+					},
+					cli.StringFlag{
+						Name:  "hooks",
+						Usage: "File of the hooks run before and after upgrading every service, post hooks require --wait",
+					},
 					cli.StringFlag{
 						Name:  "env-file",
 						Usage: "File containing environment variables that will be used for validating that the Rancher service has all variables defined",
@@ -37,6 +115,10 @@ func ServiceCommand() cli.Command {
 					cli.StringFlag{
 						Name: "code-tag",
 					},
+					cli.StringFlag{
+						Name:  "promote-to",
+						Usage: "Repository to promote the runtime image into before upgrading, the services are upgraded to the promoted image",
+					},
 					cli.Int64Flag{
 						Name:  "interval",
 						Usage: "Interval between starting new containers and stopping old ones",
@@ -45,9 +127,84 @@ func ServiceCommand() cli.Command {
 						Name:  "wait",
 						Usage: "Wait for the upgrade to fully complete",
 					},
+					cli.BoolFlag{
+						Name:  "prepull",
+						Usage: "Pull the new images onto the hosts running the service before upgrading",
+					},
+					cli.Int64Flag{
+						Name:  "prepull-timeout",
+						Usage: "Seconds to wait for the images to be pulled",
+						Value: int64(defaultPrepullTimeout / time.Second),
+					},
+					cli.BoolFlag{
+						Name:  "skip-scheduling-check",
+						Usage: "Do not check that the upgraded containers can be placed on the hosts before upgrading",
+					},
+					cli.Int64Flag{
+						Name:  "lock-ttl",
+						Usage: "Seconds the lock taken on every upgraded service lasts, 0 upgrades without locking",
+						Value: int64(defaultLockTTL / time.Second),
+					},
 				},
 				Action: UpgradeAction,
 			},
+			{
+				Name:  "history",
+				Usage: "Show the launch configs a service was upgraded to",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name: "service",
+					},
+					formatFlag,
+				},
+				Action: ServiceHistoryAction,
+			},
+			{
+				Name:  "rollback",
+				Usage: "Upgrade a service to a launch config of its history",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name: "service",
+					},
+					cli.IntFlag{
+						Name:  "to",
+						Usage: "Revision of the service history to roll back to",
+					},
+					cli.Int64Flag{
+						Name:  "interval",
+						Usage: "Interval between starting new containers and stopping old ones",
+					},
+					cli.BoolFlag{
+						Name:  "wait",
+						Usage: "Wait for the rollback to complete and finish it, cancelling it when it fails",
+					},
+					cli.Int64Flag{
+						Name:  "lock-ttl",
+						Usage: "Seconds the lock taken on the service lasts, 0 rolls back without locking",
+						Value: int64(defaultLockTTL / time.Second),
+					},
+					reasonFlag,
+					yesFlag,
+					reportFlag,
+				},
+				Action: ServiceRollbackAction,
+			},
+			{
+				Name:  "scale",
+				Usage: "Set the number of containers of a service",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name: "service",
+					},
+					cli.Int64Flag{
+						Name:  "scale",
+						Usage: "Number of containers, scaling protected environments to 0 asks for confirmation",
+						Value: -1,
+					},
+					yesFlag,
+				},
+				Action: ServiceScaleAction,
+			},
 			{
 				Name:  "upgrade-finish",
 				Usage: "",
@@ -57,7 +214,7 @@ func ServiceCommand() cli.Command {
 					},
 				},
 				Action: func(c *cli.Context) error {
-					client, err := rancher.NewClient(cattleUrl, cattleAccessKey, cattleSecret, "")
+					client, err := newClient(c, "")
 
 					if err != nil {
 						return err
@@ -79,7 +236,13 @@ func UpgradeAction(c *cli.Context) error {
 		return err
 	}
 
-	client, err := rancher.NewClient(cattleUrl, cattleAccessKey, cattleSecret, envFile)
+	runtimeTag := c.String("runtime-tag")
+	promoteTo := c.String("promote-to")
+	if promoteTo != "" && !strings.Contains(runtimeTag, ":") {
+		return errors.New("--promote-to requires --runtime-tag to name the repository and tag to promote")
+	}
+
+	client, err := newClient(c, envFile)
 	if err != nil {
 		return err
 	}
@@ -94,14 +257,410 @@ func UpgradeAction(c *cli.Context) error {
 		ServiceLike: c.String("service-like"),
 		Service:     c.String("service"),
 		CodeTag:     c.String("code-tag"),
-		RuntimeTag:  c.String("runtime-tag"),
+		RuntimeTag:  runtimeTag,
 		Wait:        c.Bool("wait"),
+
+		Prepull:        c.Bool("prepull"),
+		PrepullTimeout: time.Duration(c.Int64("prepull-timeout")) * time.Second,
+
+		SkipSchedulingCheck: c.Bool("skip-scheduling-check"),
+
+		LockTTL: time.Duration(c.Int64("lock-ttl")) * time.Second,
+		Reason:  c.String("reason"),
+		GitSha:  c.String("git-sha"),
 	}
-	if name := opts.ServiceLike; name != "" {
-		return client.UpgradeServiceWithNameLike(opts)
+	if opts.Selector, err = serviceSelector(c); err != nil {
+		return err
+	}
+	if path := c.String("hooks"); path != "" {
+		if opts.Hooks, err = config.LoadHooks(path); err != nil {
+			return err
+		}
+		if len(opts.Hooks.Post) > 0 && !opts.Wait {
+			return errors.New("post hooks require --wait")
+		}
+	}
+	if opts.ServiceLike == "" && !opts.Selector.Selective() && !opts.Selector.Empty() {
+		return errors.New("--exclude requires --service-like, --match or --selector")
+	}
+	g, err := newGuard(c, c.GlobalString("context"))
+	if err != nil {
+		return err
+	}
+	finish, err := startReport(c, "service upgrade", client)
+	if err != nil {
+		return err
+	}
+	if opts.ServiceLike != "" || opts.Selector.Selective() {
+		return finish(upgradeSelected(client, g, opts, promoteTo))
+	}
+	return finish(upgradeNamed(client, g, opts, promoteTo))
+}
+
+// Promote the runtime image to promoteTo once the upgrade has been
+// confirmed, returning the image to upgrade to.
+func promoteRuntimeTag(runtimeTag, promoteTo string) (string, error) {
+	if promoteTo == "" {
+		return runtimeTag, nil
+	}
+	return promoteImage(runtimeTag, promoteTo)
+}
+
+// Upgrade the service named by --service once the user confirms its
+// protected environment.
+func upgradeNamed(client *rancher.Client, g *guard, opts config.UpgradeOpts, promoteTo string) error {
+	selected, err := client.SelectService(opts.Service)
+	if err != nil {
+		return err
+	}
+	projects, err := serviceProjects(client, []rancher.SelectedService{*selected})
+	if err != nil {
+		return err
+	}
+	if err := g.checkFreeze("upgrade", selected.Stack+"/"+selected.Service.Name, projects...); err != nil {
+		return err
+	}
+	opts.FreezeOverride = g.overriddenFreezes()
+	if err := g.confirm("upgrade services of", projects, printSelected(*selected)); err != nil {
+		return err
+	}
+	if opts.RuntimeTag, err = promoteRuntimeTag(opts.RuntimeTag, promoteTo); err != nil {
+		return g.recordOverrides(err)
+	}
+
+	if len(opts.Hooks.Phase(config.HOOK_PHASE_POST)) > 0 {
+		// Post hooks run once the upgrade completes, which only the upgrade
+		// of several services waits for
+		return g.recordOverrides(client.UpgradeServices([]rancherClient.Service{selected.Service}, opts))
 	}
 
 	_, err = client.UpgradeService(opts)
 
-	return err
+	return g.recordOverrides(err)
+}
+
+func serviceSelector(c *cli.Context) (*config.ServiceSelector, error) {
+	return config.NewServiceSelector(c.StringSlice("match"), c.String("selector"), c.StringSlice("exclude"))
+}
+
+// List the selected services and upgrade them once the user confirms.  The
+// question is only asked when stdin is a terminal, protected environments
+// require typing their name instead.
+func upgradeSelected(client *rancher.Client, g *guard, opts config.UpgradeOpts, promoteTo string) error {
+	selected, err := client.SelectServices(opts.ServiceLike, opts.Selector)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		return errors.New("no services match the selection")
+	}
+	if err := g.checkServiceLimit(len(selected)); err != nil {
+		return err
+	}
+
+	projects, err := serviceProjects(client, selected)
+	if err != nil {
+		return err
+	}
+	if err := g.checkFreeze("upgrade", selectedNames(selected), projects...); err != nil {
+		return err
+	}
+	opts.FreezeOverride = g.overriddenFreezes()
+	plan := printSelected(selected...)
+	if len(g.protected(projects...)) > 0 {
+		if err := g.confirm("upgrade services of", projects, plan); err != nil {
+			return err
+		}
+	} else {
+		plan()
+		if !g.yes && interactive() {
+			if err := confirmYes(fmt.Sprintf("Upgrade these %d services?", len(selected))); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.RuntimeTag, err = promoteRuntimeTag(opts.RuntimeTag, promoteTo); err != nil {
+		return g.recordOverrides(err)
+	}
+
+	services := []rancherClient.Service{}
+	for _, sel := range selected {
+		services = append(services, sel.Service)
+	}
+	fmt.Printf("Upgrading %d services\n", len(services))
+	return g.recordOverrides(client.UpgradeServices(services, opts))
+}
+
+func selectedNames(selected []rancher.SelectedService) string {
+	names := []string{}
+	for _, sel := range selected {
+		names = append(names, sel.Stack+"/"+sel.Service.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Return a plan printing the stack, name and images of the services.
+func printSelected(selected ...rancher.SelectedService) func() error {
+	return func() error {
+		rows := [][]string{}
+		for _, sel := range selected {
+			rows = append(rows, []string{sel.Stack, sel.Service.Name, strings.Join(sel.Images, ",")})
+		}
+		printTable([]string{"STACK", "SERVICE", "IMAGES"}, rows)
+		return nil
+	}
+}
+
+func ServiceHistoryAction(c *cli.Context) error {
+	if c.String("service") == "" {
+		return errors.New("service history requires --service")
+	}
+
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	revisions, err := client.ServiceHistory(c.String("service"))
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(revisions)
+	}
+
+	rows := [][]string{}
+	for _, revision := range revisions {
+		deployed := ""
+		if !revision.Time.IsZero() {
+			deployed = revision.Time.Format(time.RFC3339)
+		}
+		reason := revision.Reason
+		if revision.FreezeOverride != "" {
+			reason = strings.TrimSpace(fmt.Sprintf("overrode freeze %s %s", revision.FreezeOverride, reason))
+		}
+		if revision.RollbackTo != 0 {
+			reason = strings.TrimSpace(fmt.Sprintf("rollback to %d %s", revision.RollbackTo, reason))
+		}
+		rows = append(rows, []string{
+			fmt.Sprint(revision.Number),
+			deployed,
+			revision.Deployer,
+			strings.Join(revision.Images, ","),
+			revision.GitSha,
+			reason,
+		})
+	}
+	printTable([]string{"REVISION", "DEPLOYED", "DEPLOYER", "IMAGES", "GIT SHA", "REASON"}, rows)
+	return nil
+}
+
+func ServiceRollbackAction(c *cli.Context) error {
+	if c.String("service") == "" || c.Int("to") <= 0 {
+		return errors.New("service rollback requires --service and the revision --to roll back to")
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	g, err := newGuard(c, c.GlobalString("context"))
+	if err != nil {
+		return err
+	}
+	finish, err := startReport(c, "service rollback", client)
+	if err != nil {
+		return err
+	}
+	return finish(rollbackNamed(c, client, g))
+}
+
+// Roll the service named by --service back once the user confirms its
+// protected environment.
+func rollbackNamed(c *cli.Context, client *rancher.Client, g *guard) error {
+	selected, err := client.SelectService(c.String("service"))
+	if err != nil {
+		return err
+	}
+	projects, err := serviceProjects(client, []rancher.SelectedService{*selected})
+	if err != nil {
+		return err
+	}
+	if err := g.confirm(fmt.Sprintf("roll back to revision %d services of", c.Int("to")), projects, printSelected(*selected)); err != nil {
+		return err
+	}
+
+	interval := time.Duration(c.Int64("interval")) * time.Second
+	if interval == 0 {
+		interval = defaultUpgradeInterval
+	}
+	service, err := client.RollbackService(c.String("service"), c.Int("to"), config.UpgradeOpts{
+		Interval: interval,
+		Wait:     c.Bool("wait"),
+		LockTTL:  time.Duration(c.Int64("lock-ttl")) * time.Second,
+		Reason:   c.String("reason"),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Service %s is %s\n", service.Name, service.State)
+	return nil
+}
+
+func ServiceScaleAction(c *cli.Context) error {
+	if c.String("service") == "" || c.Int64("scale") < 0 {
+		return errors.New("service scale requires --service and the --scale to set")
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	g, err := newGuard(c, c.GlobalString("context"))
+	if err != nil {
+		return err
+	}
+	selected, err := client.SelectService(c.String("service"))
+	if err != nil {
+		return err
+	}
+	if c.Int64("scale") == 0 {
+		projects, err := serviceProjects(client, []rancher.SelectedService{*selected})
+		if err != nil {
+			return err
+		}
+		if err := g.confirm("scale to 0 services of", projects, printSelected(*selected)); err != nil {
+			return err
+		}
+	}
+
+	service, err := client.ScaleService(&selected.Service, c.Int64("scale"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Service %s is scaled to %d\n", service.Name, service.Scale)
+	return nil
+}
+
+func ServiceListAction(c *cli.Context) error {
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	selector, err := serviceSelector(c)
+	if err != nil {
+		return err
+	}
+
+	services, err := client.ListServices(config.ServiceListOpts{
+		Stack:       c.String("stack"),
+		ServiceLike: c.String("service-like"),
+		State:       c.String("state"),
+		Health:      c.String("health"),
+		Selector:    selector,
+	})
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(services)
+	}
+
+	rows := [][]string{}
+	for _, service := range services {
+		rows = append(rows, []string{
+			service.Stack,
+			service.Name,
+			strings.Join(service.Images, ","),
+			fmt.Sprint(service.Scale),
+			service.State,
+			service.Health,
+			strings.Join(service.Endpoints, ","),
+			strings.Join(service.Links, ","),
+			service.TransitioningMessage,
+		})
+	}
+	printTable([]string{"STACK", "SERVICE", "IMAGES", "SCALE", "STATE", "HEALTH", "ENDPOINTS", "LINKS", "MESSAGE"}, rows)
+	return nil
+}
+
+func ServiceInspectAction(c *cli.Context) error {
+	if c.String("service") == "" {
+		return errors.New("service inspect requires --service")
+	}
+
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	secrets := compose.DefaultSecretPatterns
+	if c.Bool("show-secrets") {
+		secrets = nil
+	}
+
+	service, err := client.InspectService(c.String("service"), secrets)
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(service)
+	}
+
+	rows := [][]string{
+		{"Id", service.Id},
+		{"Stack", service.Stack},
+		{"Name", service.Name},
+		{"Images", strings.Join(service.Images, ", ")},
+		{"Scale", fmt.Sprint(service.Scale)},
+		{"State", service.State},
+		{"Health", service.Health},
+		{"Message", service.TransitioningMessage},
+		{"Endpoints", strings.Join(service.Endpoints, ", ")},
+		{"Links", strings.Join(service.Links, ", ")},
+	}
+	if deployment := service.Deployment; deployment != nil {
+		rows = append(rows,
+			[]string{"Deployed by", deployment.Deployer},
+			[]string{"Deployed at", deployment.Time.Format(time.RFC3339)},
+			[]string{"Deployed with", deployment.Version},
+			[]string{"Reason", deployment.Reason},
+			[]string{"Git sha", deployment.GitSha},
+		)
+	}
+	if lc := service.LaunchConfig; lc != nil {
+		rows = append(rows, []string{"Ports", strings.Join(lc.Ports, ", ")})
+		for _, key := range sortedKeys(lc.Environment) {
+			rows = append(rows, []string{"Env " + key, fmt.Sprint(lc.Environment[key])})
+		}
+		for _, key := range sortedKeys(lc.Labels) {
+			rows = append(rows, []string{"Label " + key, fmt.Sprint(lc.Labels[key])})
+		}
+	}
+	printTable([]string{"FIELD", "VALUE"}, rows)
+	return nil
 }
@@ -0,0 +1,578 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/nowait/rancher-cli/rancher"
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	rancherClient "github.com/rancher/go-rancher/client"
+	"github.com/urfave/cli"
+)
+
+func StackCommand() cli.Command {
+	return cli.Command{
+		Name:  "stack",
+		Usage: "Operations on stacks",
+		Subcommands: []cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the stacks",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "project",
+						Usage: "Name of the environment, defaults to every environment the api keys can access",
+					},
+					formatFlag,
+				},
+				Action: StackListAction,
+			},
+			{
+				Name:  "export",
+				Usage: "Write the compose files of every stack of an environment into a directory",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "project",
+						Usage: "Name of the environment to export",
+					},
+					cli.StringFlag{
+						Name:  "dir",
+						Usage: "Directory to write a folder per stack into",
+						Value: ".",
+					},
+					cli.BoolFlag{
+						Name:  "mask-secrets",
+						Usage: "Replace the values of secret environment variables with ${VAR} placeholders",
+					},
+					cli.StringSliceFlag{
+						Name:  "secret",
+						Usage: "Glob of the environment variables to mask, can be repeated, implies --mask-secrets",
+					},
+					formatFlag,
+				},
+				Action: StackExportAction,
+			},
+			{
+				Name:  "import",
+				Usage: "Create or upgrade the stacks of an environment from a directory written by stack export",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "project",
+						Usage: "Name of the environment to import into",
+					},
+					cli.StringFlag{
+						Name:  "dir",
+						Usage: "Directory with a folder per stack",
+						Value: ".",
+					},
+					cli.StringFlag{
+						Name:  "env-file",
+						Usage: "Path to a .env file with the values of the ${VAR} placeholders, defaults to the environment",
+					},
+					cli.BoolFlag{
+						Name:  "wait",
+						Usage: "Wait for upgraded stacks and finish their upgrade, rolling back the ones that fail",
+					},
+					cli.Int64Flag{
+						Name:  "timeout",
+						Usage: "Seconds to wait for the upgrade of each stack to complete",
+						Value: int64(defaultStackUpgradeTimeout / time.Second),
+					},
+					cli.Int64Flag{
+						Name:  "lock-ttl",
+						Usage: "Seconds the locks taken on the services of upgraded stacks last, 0 upgrades without locking",
+						Value: int64(defaultLockTTL / time.Second),
+					},
+					formatFlag,
+					yesFlag,
+					reportFlag,
+					overrideFreezeFlag,
+					reasonFlag,
+				},
+				Action: StackImportAction,
+			},
+			{
+				Name:  "upgrade",
+				Usage: "Upgrade a stack to new compose files",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "stack",
+						Usage: "Name of the stack to upgrade",
+					},
+					cli.StringSliceFlag{
+						Name:  "file, f",
+						Usage: "Path to the docker-compose.yml followed by the optional rancher-compose.yml",
+					},
+					cli.StringFlag{
+						Name:  "env-file",
+						Usage: "Path to a .env file with the values of the ${VAR} placeholders, defaults to the environment",
+					},
+					cli.BoolFlag{
+						Name:  "wait",
+						Usage: "Wait for the upgrade to complete and finish it, rolling back when it fails",
+					},
+					cli.Int64Flag{
+						Name:  "timeout",
+						Usage: "Seconds to wait for the upgrade to complete",
+						Value: int64(defaultStackUpgradeTimeout / time.Second),
+					},
+					cli.Int64Flag{
+						Name:  "lock-ttl",
+						Usage: "Seconds the locks taken on the services of the stack last, 0 upgrades without locking",
+						Value: int64(defaultLockTTL / time.Second),
+					},
+					cli.BoolFlag{
+						Name:  "skip-scheduling-check",
+						Usage: "Do not check that the upgraded containers can be placed on the hosts",
+					},
+					yesFlag,
+					reportFlag,
+					overrideFreezeFlag,
+					reasonFlag,
+				},
+				Action: StackUpgradeAction,
+			},
+			{
+				Name:  "upgrade-finish",
+				Usage: "Finish the upgrade of a stack",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "stack",
+						Usage: "Name of the stack",
+					},
+				},
+				Action: StackUpgradeFinishAction,
+			},
+			{
+				Name:  "rollback",
+				Usage: "Roll back the upgrade of a stack",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "stack",
+						Usage: "Name of the stack",
+					},
+					yesFlag,
+					reportFlag,
+				},
+				Action: StackRollbackAction,
+			},
+			{
+				Name:  "diff",
+				Usage: "Compare the services of two stacks",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "source-env",
+						Usage: "Name of the environment of the first stack",
+					},
+					cli.StringFlag{
+						Name:  "source-stack",
+						Usage: "Name of the first stack",
+					},
+					cli.StringFlag{
+						Name:  "target-env",
+						Usage: "Name of the environment of the second stack, defaults to --source-env",
+					},
+					cli.StringFlag{
+						Name:  "target-stack",
+						Usage: "Name of the second stack, defaults to --source-stack",
+					},
+					cli.StringFlag{
+						Name:  "source-context",
+						Usage: "Context of the Rancher server of the first stack, defaults to --context",
+					},
+					cli.StringFlag{
+						Name:  "target-context",
+						Usage: "Context of the Rancher server of the second stack, defaults to --context",
+					},
+					formatFlag,
+				},
+				Action: StackDiffAction,
+			},
+		},
+	}
+}
+
+func StackListAction(c *cli.Context) error {
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	projectId := ""
+	if name := c.String("project"); name != "" {
+		project, err := client.ProjectByName(name)
+		if err != nil {
+			return err
+		}
+		projectId = project.Id
+	}
+
+	stacks, err := client.ListStacks(projectId)
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(stacks)
+	}
+
+	rows := [][]string{}
+	for _, stack := range stacks {
+		rows = append(rows, []string{stack.Id, stack.Name, stack.State, stack.Health, fmt.Sprint(stack.Services)})
+	}
+	printTable([]string{"ID", "NAME", "STATE", "HEALTH", "SERVICES"}, rows)
+	return nil
+}
+
+func StackExportAction(c *cli.Context) error {
+	if c.String("project") == "" {
+		return errors.New("stack export requires --project")
+	}
+
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	secrets := c.StringSlice("secret")
+	if c.Bool("mask-secrets") && len(secrets) == 0 {
+		secrets = compose.DefaultSecretPatterns
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	project, err := client.ProjectByName(c.String("project"))
+	if err != nil {
+		return err
+	}
+
+	exports, err := client.ExportStacks(project.Id, c.String("dir"), secrets)
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(exports)
+	}
+
+	rows := [][]string{}
+	for _, export := range exports {
+		rows = append(rows, []string{export.Stack, strings.Join(export.Variables, ",")})
+	}
+	printTable([]string{"STACK", "PLACEHOLDERS"}, rows)
+
+	return nil
+}
+
+func StackImportAction(c *cli.Context) error {
+	if c.String("project") == "" {
+		return errors.New("stack import requires --project")
+	}
+
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	vars, err := importVariables(c.String("env-file"))
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return err
+	}
+
+	project, err := client.ProjectByName(c.String("project"))
+	if err != nil {
+		return err
+	}
+
+	plan, err := client.PlanImport(project.Id, c.String("dir"))
+	if err != nil {
+		return err
+	}
+	g, err := confirmImport(c, project.Name, plan)
+	if err != nil {
+		return err
+	}
+	finish, err := startReport(c, "stack import", client)
+	if err != nil {
+		return err
+	}
+
+	imports, err := client.ImportStacks(project.Id, config.StackImportOpts{
+		Dir:       c.String("dir"),
+		Variables: vars,
+		Wait:      c.Bool("wait"),
+		Timeout:   time.Duration(c.Int64("timeout")) * time.Second,
+		LockTTL:   time.Duration(c.Int64("lock-ttl")) * time.Second,
+	})
+	err = finish(g.recordOverrides(err))
+	if format == FORMAT_JSON {
+		if printErr := printJSON(imports); printErr != nil {
+			return printErr
+		}
+		return err
+	}
+
+	rows := [][]string{}
+	for _, imported := range imports {
+		rows = append(rows, []string{imported.Stack, imported.Action})
+	}
+	printTable([]string{"STACK", "ACTION"}, rows)
+
+	return err
+}
+
+func StackUpgradeAction(c *cli.Context) error {
+	files := c.StringSlice("file")
+	if c.String("stack") == "" || len(files) == 0 || len(files) > 2 {
+		return errors.New("stack upgrade requires --stack and a docker-compose.yml with an optional rancher-compose.yml")
+	}
+
+	dockerCompose, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		return err
+	}
+
+	rancherCompose := []byte{}
+	if len(files) == 2 {
+		if rancherCompose, err = ioutil.ReadFile(files[1]); err != nil {
+			return err
+		}
+	}
+
+	vars, err := importVariables(c.String("env-file"))
+	if err != nil {
+		return err
+	}
+
+	client, stack, err := namedStack(c)
+	if err != nil {
+		return err
+	}
+	g, err := confirmStack(c, client, stack, "upgrade", true)
+	if err != nil {
+		return err
+	}
+	finish, err := startReport(c, "stack upgrade", client)
+	if err != nil {
+		return err
+	}
+
+	stack, err = client.UpgradeStack(config.StackUpgradeOpts{
+		Stack:               c.String("stack"),
+		DockerCompose:       string(dockerCompose),
+		RancherCompose:      string(rancherCompose),
+		Variables:           vars,
+		Wait:                c.Bool("wait"),
+		Timeout:             time.Duration(c.Int64("timeout")) * time.Second,
+		SkipSchedulingCheck: c.Bool("skip-scheduling-check"),
+		LockTTL:             time.Duration(c.Int64("lock-ttl")) * time.Second,
+	})
+	if err := finish(g.recordOverrides(err)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Stack %s is %s\n", stack.Name, stack.State)
+	return nil
+}
+
+func StackUpgradeFinishAction(c *cli.Context) error {
+	client, stack, err := namedStack(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.RancherClient.Environment.ActionFinishupgrade(stack)
+	return err
+}
+
+func StackRollbackAction(c *cli.Context) error {
+	client, stack, err := namedStack(c)
+	if err != nil {
+		return err
+	}
+	if _, err := confirmStack(c, client, stack, "roll back", false); err != nil {
+		return err
+	}
+	finish, err := startReport(c, "stack rollback", client)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.RollbackStack(stack)
+	return finish(err)
+}
+
+func StackDiffAction(c *cli.Context) error {
+	sourceEnv, sourceStack := c.String("source-env"), c.String("source-stack")
+	targetEnv, targetStack := contextOr(c.String("target-env"), sourceEnv), contextOr(c.String("target-stack"), sourceStack)
+	sourceCtx := contextOr(c.String("source-context"), c.GlobalString("context"))
+	targetCtx := contextOr(c.String("target-context"), c.GlobalString("context"))
+
+	if sourceEnv == "" || sourceStack == "" {
+		return errors.New("stack diff requires --source-env and --source-stack")
+	}
+	if sourceCtx == targetCtx && sourceEnv == targetEnv && sourceStack == targetStack {
+		return errors.New("stack diff requires a different --target-env, --target-stack or --target-context")
+	}
+
+	format := c.String("format")
+	if err := validateFormat(format); err != nil {
+		return err
+	}
+
+	source, target, err := cloneClients(c, sourceCtx, targetCtx)
+	if err != nil {
+		return err
+	}
+
+	sourceProject, targetProject, err := diffProjects(source, target, sourceEnv, targetEnv)
+	if err != nil {
+		return err
+	}
+
+	changes, err := source.DiffStacks(target, sourceProject.Id, sourceStack, targetProject.Id, targetStack)
+	if err != nil {
+		return err
+	}
+
+	if format == FORMAT_JSON {
+		return printJSON(changes)
+	}
+
+	if len(changes) == 0 {
+		fmt.Printf("Stacks %s and %s are equal\n", sourceStack, targetStack)
+		return nil
+	}
+	printChanges(changes)
+	return nil
+}
+
+// Look up the projects of the source and target of a diff on their servers.
+func diffProjects(source, target *rancher.Client, sourceEnv, targetEnv string) (*rancherClient.Project, *rancherClient.Project, error) {
+	sourceProject, err := source.ProjectByName(sourceEnv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targetProject, err := target.ProjectByName(targetEnv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sourceProject, targetProject, nil
+}
+
+func printChanges(changes []compose.Change) {
+	rows := [][]string{}
+	for _, change := range changes {
+		rows = append(rows, []string{change.Service, change.Field, change.Key, change.Old, change.New})
+	}
+	printTable([]string{"SERVICE", "FIELD", "KEY", "SOURCE", "TARGET"}, rows)
+}
+
+func namedStack(c *cli.Context) (*rancher.Client, *rancherClient.Environment, error) {
+	if c.String("stack") == "" {
+		return nil, nil, errors.New("--stack is required")
+	}
+
+	client, err := newClient(c, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stack, err := client.StackByName(c.String("stack"))
+	return client, stack, err
+}
+
+// Check the freezes of the environment the stacks are imported into and
+// confirm the import when it is protected, listing what is done to each
+// stack.
+func confirmImport(c *cli.Context, project string, plan []rancher.StackImport) (*guard, error) {
+	g, err := newGuard(c, c.GlobalString("context"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	rows := [][]string{}
+	for _, imported := range plan {
+		names = append(names, imported.Stack)
+		rows = append(rows, []string{imported.Stack, project, imported.Action})
+	}
+	if err := g.checkFreeze("import", "stacks "+strings.Join(names, ","), project); err != nil {
+		return nil, err
+	}
+
+	return g, g.confirm("import stacks into", []string{project}, func() error {
+		printTable([]string{"STACK", "ENVIRONMENT", "ACTION"}, rows)
+		return nil
+	})
+}
+
+// Ask for the name of the environment of the stack when it is protected.
+// Deployments are also refused while the environment is frozen, the returned
+// guard records overridden freezes once the deployment is done.
+func confirmStack(c *cli.Context, client *rancher.Client, stack *rancherClient.Environment, action string, deploy bool) (*guard, error) {
+	g, err := newGuard(c, c.GlobalString("context"))
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := client.ProjectName(stack.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	if deploy {
+		if err := g.checkFreeze(action, "stack "+stack.Name, project); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, g.confirm(action+" stack "+stack.Name+" of", []string{project}, func() error {
+		printTable([]string{"STACK", "ENVIRONMENT", "STATE", "ACTION"}, [][]string{{stack.Name, project, stack.State, action}})
+		return nil
+	})
+}
+
+// Placeholder values come from the environment, overridden by the env file.
+func importVariables(envFile string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, env := range os.Environ() {
+		pieces := strings.SplitN(env, "=", 2)
+		if len(pieces) == 2 {
+			vars[pieces[0]] = pieces[1]
+		}
+	}
+
+	if envFile == "" {
+		return vars, nil
+	}
+
+	fileVars, err := godotenv.Read(envFile)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range fileVars {
+		vars[key] = value
+	}
+	return vars, nil
+}
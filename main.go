@@ -5,6 +5,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/nowait/rancher-cli/cmd"
+	"github.com/nowait/rancher-cli/rancher"
 	"github.com/urfave/cli"
 )
 
@@ -19,13 +20,20 @@ func main() {
 	}
 	log.SetLevel(log.DebugLevel)
 	app.Usage = "The awesome cli you wish existed for Rancher"
+	app.Flags = cmd.GlobalFlags()
 	app.Commands = []cli.Command{
+		cmd.DeployCommand(),
 		cmd.EnvironmentCommand(),
+		cmd.ImageCommand(),
+		cmd.LockCommand(),
+		cmd.NotifyCommand(),
 		cmd.ServiceCommand(),
+		cmd.StackCommand(),
 	}
 	err := app.Run(os.Args)
 
 	if err != nil {
-		log.Fatalf("command exited with error %v", err)
+		log.Errorf("command exited with error %v", err)
+		os.Exit(rancher.ExitCode(err))
 	}
 }
@@ -0,0 +1,101 @@
+package rancher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	AUDIT_FILE = "audit.jsonl"
+
+	AUDIT_ACTION_FREEZE_OVERRIDE = "freeze-override"
+
+	AUDIT_OUTCOME_DEPLOYED = "deployed"
+	AUDIT_OUTCOME_FAILED   = "failed"
+)
+
+// AuditEntry is a line of the audit log of deployments kept in the state dir.
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	Environment string    `json:"environment"`
+	// What was deployed, such as stack/service or a stack name
+	Target string `json:"target"`
+	User   string `json:"user"`
+	Reason string `json:"reason,omitempty"`
+	Freeze string `json:"freeze,omitempty"`
+	// Whether the deployment went through, with its error when it failed
+	Outcome string `json:"outcome,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AppendAudit adds the entry to the audit log in the state dir,
+// creating the dir when it does not exist yet.
+func AppendAudit(dir string, entry AuditEntry) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if entry.User == "" {
+		entry.User = CurrentUser()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, AUDIT_FILE), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// ReadAudit reads the audit log of the state dir, oldest first.
+// A missing audit log is empty.
+func ReadAudit(dir string) ([]AuditEntry, error) {
+	path := filepath.Join(dir, AUDIT_FILE)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid audit log %s at line %d: %v", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// CurrentUser returns the name of the user running the cli.
+func CurrentUser() string {
+	for _, key := range []string{"RANCHER_CLI_USER", "USER", "USERNAME"} {
+		if name := os.Getenv(key); name != "" {
+			return name
+		}
+	}
+	return "unknown"
+}
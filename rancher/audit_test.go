@@ -0,0 +1,45 @@
+package rancher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stateDir := filepath.Join(dir, "state")
+	if entries, err := ReadAudit(stateDir); err != nil || len(entries) != 0 {
+		t.Fatalf("a missing audit log should be empty, received %v %v", entries, err)
+	}
+
+	for _, target := range []string{"backend/api", "backend/worker"} {
+		err := AppendAudit(stateDir, AuditEntry{
+			Action:      AUDIT_ACTION_FREEZE_OVERRIDE,
+			Environment: "production",
+			Target:      target,
+			User:        "jane",
+			Reason:      "hotfix",
+		})
+		if err != nil {
+			t.Fatalf("appending to the audit log failed with: %v", err)
+		}
+	}
+
+	entries, err := ReadAudit(stateDir)
+	if err != nil {
+		t.Fatalf("reading the audit log failed with: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Target != "backend/api" || entries[1].Target != "backend/worker" {
+		t.Fatalf("expected both entries in order, received %+v", entries)
+	}
+	if entries[0].Time.IsZero() || entries[0].User != "jane" || entries[0].Reason != "hotfix" {
+		t.Errorf("entry was not recorded correctly: %+v", entries[0])
+	}
+}
@@ -29,11 +29,34 @@ var (
 type Client struct {
 	RancherClient *client.RancherClient
 	Validators    []config.Validator
+	// Validators run against the launch configs the service is upgraded to
+	UpgradeValidators []config.UpgradeValidator
+	// Project and stack every lookup is limited to
+	Scope Scope
+	// Where the launch configs of upgraded services are kept, and how many
+	History     HistoryStore
+	HistoryKeep int
+	// Version of the cli stamped on deployed services
+	CliVersion string
+	// Where multi-service operations keep their journals, none when empty
+	JournalDir string
+	// Where deployments are announced, none when nil
+	Notifiers *Notifiers
+	// Records the deployed services and stacks for CI, none when nil
+	Report *Report
 }
 
 type UpgradeResult struct {
 	Service *client.Service
 	Error   error
+	// Lock taken on the service, nil when the service was not locked
+	lock *Lock
+	// Whether the upgrade was started, only started upgrades are cancelled
+	upgrading bool
+	// Whether the upgrade completed and is rolled back rather than cancelled
+	rollback bool
+	// When the upgrade of the service started
+	started time.Time
 }
 
 // NewClient grabs config necessary and sets an inited client or returns an error
@@ -61,71 +84,101 @@ func NewClient(cattleURL string, cattleAccessKey string, cattleSecretKey string,
 		return nil, err
 	}
 
+	cli := &Client{
+		RancherClient: apiClient,
+		Validators: []config.Validator{
+			registryValidator,
+		},
+	}
+
 	if envFile != "" {
-		return &Client{
-			RancherClient: apiClient,
-			Validators: []config.Validator{
-				registryValidator,
-				&config.EnvironmentValidator{
-					EnvFilePath: envFile,
-				},
-			},
-		}, nil
-
-	} else {
-		return &Client{
-			RancherClient: apiClient,
-			Validators: []config.Validator{
-				registryValidator,
-			},
-		}, nil
+		cli.Validators = append(cli.Validators, &config.EnvironmentValidator{
+			EnvFilePath: envFile,
+		})
+	}
+
+	cli.UpgradeValidators = []config.UpgradeValidator{
+		NewSchedulingValidator(cli),
 	}
+
+	return cli, nil
 }
 
 func (cli *Client) FinishServiceUpgrade(serviceName string) (*client.Service, error) {
-	filters := make(map[string]interface{})
-	filters["name"] = serviceName
-	services, err := cli.RancherClient.Service.List(&client.ListOpts{
-		Filters: filters,
-	})
+	service, err := cli.ServiceByName(serviceName)
 
 	if err != nil {
 		return nil, err
 	}
 
-	service, err := cli.RancherClient.Service.ActionFinishupgrade(&services.Data[0])
+	finished, err := cli.RancherClient.Service.ActionFinishupgrade(service)
+	if err == nil && finished != nil {
+		cli.notifyUpgrade(config.NOTIFY_UPGRADE_SUCCESS, []UpgradeResult{{Service: finished}}, config.UpgradeOpts{})
+	}
+	return finished, err
+}
 
-	return service, err
+// ScaleService sets the number of containers of the service.
+func (cli *Client) ScaleService(service *client.Service, scale int64) (*client.Service, error) {
+	scaled, err := cli.RancherClient.Service.Update(service, map[string]interface{}{
+		"scale": scale,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to scale service %s", service.Name)
+	}
+	return scaled, nil
 }
 
+// ServiceByName returns the service with the name within the scope of the
+// client.  The name may be addressed as stack/service.
 func (cli *Client) ServiceByName(name string) (*client.Service, error) {
 	filters := make(map[string]interface{})
-	filters["name"] = name
-	services, err := cli.RancherClient.Service.List(&client.ListOpts{
-		Filters: filters,
-	})
+	serviceName, err := cli.serviceAddressFilters(filters, name)
+	if err != nil {
+		return nil, err
+	}
+	filters["name"] = serviceName
+	services, err := cli.listServices(filters)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if len(services.Data) != 1 {
+	found := []client.Service{}
+	for _, service := range services {
+		if service.State != "removed" && service.State != "purged" {
+			found = append(found, service)
+		}
+	}
+
+	if len(found) > 1 {
+		return nil, cli.ambiguousService(name, found)
+	}
+	if len(found) != 1 {
 		return nil, errors.New(fmt.Sprintf("failed to find service with name %s", name))
 	}
-	return &services.Data[0], nil
+	return &found[0], nil
 }
 
+// ServiceLikeName returns the services whose name starts with the prefix
+// within the scope of the client.  The prefix may be addressed as
+// stack/prefix.  Matches in more than one project are refused unless the
+// scope allows all projects.
 func (cli *Client) ServiceLikeName(likeName string) (services *client.ServiceCollection, err error) {
 	filters := make(map[string]interface{})
-	filters["name_like"] = getServiceLikeQuery(likeName)
-	// Do not include service load balancers
-	filters["kind"] = SERVICE_TYPE_SERVICE
-	// TODO: Should filter include single environment.
-	// If all users use environment specific keys that is fine
-	// if they don't it could update multiple environments.
-	services, err = cli.RancherClient.Service.List(&client.ListOpts{
-		Filters: filters,
-	})
+	prefix, err := cli.serviceAddressFilters(filters, likeName)
+	if err != nil {
+		return nil, err
+	}
+	addServiceLikeFilters(filters, prefix)
+	all, err := cli.listServices(filters)
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.checkSingleProject(all); err != nil {
+		return nil, err
+	}
+	services = &client.ServiceCollection{Data: all}
 	fmt.Printf("Upgrading %d services\n", len(services.Data))
 	return
 }
@@ -137,125 +190,153 @@ func (cli *Client) UpgradeService(opts config.UpgradeOpts) (*client.Service, err
 		return service, err
 	}
 
-	if err = cli.ValidateService(service, opts); err != nil {
+	started := time.Now()
+	service, lock, err := cli.lockUpgrade(service, opts.LockTTL)
+	if err != nil {
+		err = NewDeployError(EXIT_UPGRADE, err)
+		cli.reportService(started, service, err)
 		return service, err
 	}
-
-	serviceUpgrade := UpdateLaunchConfig(service, opts)
-	service, err = cli.RancherClient.Service.ActionUpgrade(service, serviceUpgrade)
-
-	return service, err
+	defer cli.unlockUpgrade(service, lock)
+
+	cli.notifyUpgrade(config.NOTIFY_UPGRADE_START, []UpgradeResult{{Service: service}}, opts)
+	upgraded, err := cli.upgradeService(service, opts)
+	err = NewDeployError(EXIT_UPGRADE, err)
+	result := UpgradeResult{Service: upgraded, Error: err}
+	if upgraded == nil {
+		result.Service = service
+	}
+	cli.notifyUpgraded([]UpgradeResult{result}, opts)
+	cli.reportService(started, result.Service, err)
+	return upgraded, err
 }
 
-// Clone the Rancher Project.  A project in rancher's api terms is equivalent to an environment.  And an environment is
-// equivalent to a stack.
-func (cli *Client) CloneProject(opts config.EnvUpgradeOpts) error {
-	projects, err := cli.RancherClient.Project.List(&client.ListOpts{})
+func (cli *Client) upgradeService(service *client.Service, opts config.UpgradeOpts) (*client.Service, error) {
+	var err error
+	if err = cli.ValidateService(service, opts); err != nil {
+		return service, NewDeployError(EXIT_VALIDATION, err)
+	}
 
+	previous := serviceImages(service)
+	// The launch configs are changed in place by UpdateLaunchConfig
+	previousLaunchConfigs, err := copyLaunchConfigs(service)
 	if err != nil {
-		return err
+		return service, err
 	}
+	serviceUpgrade := UpdateLaunchConfig(service, opts)
 
-	log.Debugf("Found %d projects", len(projects.Data))
-
-	projMapping := struct {
-		SourceProjectId string
-		TargetProjectId string
-	}{
-		SourceProjectId: "",
-		TargetProjectId: "",
+	if err = cli.ValidateUpgrade(service, serviceUpgrade, opts); err != nil {
+		return service, NewDeployError(EXIT_VALIDATION, err)
 	}
-	// Use continue after finding matching project so that the opts.SourceProjectId != opts.TargetProjectId
-	for _, project := range projects.Data {
-		if project.Name == opts.SourceEnv {
-			log.Debugf("Matched project %s with Id: %s", project.Name, project.Id)
-			projMapping.SourceProjectId = project.Id
-			continue
-		}
 
-		if project.Name == opts.TargetEnv {
-			log.Debugf("Matched project %s with Id %s", project.Name, project.Id)
-			projMapping.TargetProjectId = project.Id
-			continue
+	if opts.Prepull {
+		images := changedImages(previous, serviceUpgrade)
+		if err = cli.PrepullServiceImages(service, images, opts.PrepullTimeout); err != nil {
+			return service, err
 		}
 	}
 
-	if projMapping.SourceProjectId == "" || projMapping.TargetProjectId == "" {
-		return environmentCloneSourceTargetError
+	if err = cli.RunHooks(config.HOOK_PHASE_PRE, service, opts); err != nil {
+		return service, NewDeployError(EXIT_VALIDATION, err)
 	}
 
-	log.Debugf("Source project id %s target id %s", projMapping.SourceProjectId, projMapping.TargetProjectId)
-
-	// Filter environments by correct project id and ensure they are active
-	filters := make(map[string]interface{})
-	filters["accountId_eq"] = projMapping.SourceProjectId
-	filters["state"] = "active"
-	envs, err := cli.RancherClient.Environment.List(&client.ListOpts{
-		Filters: filters,
-	})
-
+	service, err = cli.RancherClient.Service.ActionUpgrade(service, serviceUpgrade)
 	if err != nil {
-		return errors.Wrap(err, "Failed to find stacks for project")
+		return service, err
 	}
 
-	log.Debugf("Found %d stacks, cloning into environment %s", len(envs.Data), opts.TargetEnv)
-
-	for _, env := range envs.Data {
-		composeConfig, err := cli.RancherClient.Environment.ActionExportconfig(&env, &client.ComposeConfigInput{})
-
-		if err != nil {
-			return err
-		}
+	return cli.recordDeployment(service, previousLaunchConfigs, serviceUpgrade, opts, 0), nil
+}
 
-		_, err = cli.RancherClient.Environment.Create(&client.Environment{
-			AccountId:      projMapping.TargetProjectId,
-			DockerCompose:  composeConfig.DockerComposeConfig,
-			RancherCompose: composeConfig.RancherComposeConfig,
-			Name:           env.Name,
-		})
+// Clone the Rancher Project.  A project in rancher's api terms is equivalent to an environment.  And an environment is
+// equivalent to a stack.
+func (cli *Client) CloneProject(opts config.EnvUpgradeOpts) error {
+	plan, err := cli.PlanClone(opts)
 
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return err
 	}
 
-	return err
+	return cli.CloneStacks(&CloneState{Plan: plan}, opts.KeepPartial)
 }
 
 // TODO: Simplify this method and test it
 func (cli *Client) UpgradeServiceWithNameLike(opts config.UpgradeOpts) error {
-	failed := false
 	services, err := cli.ServiceLikeName(opts.ServiceLike)
 
 	if err != nil {
 		return err
 	}
 
-	serviceCount := len(services.Data)
+	return cli.UpgradeServices(services.Data, opts)
+}
+
+// UpgradeServices upgrades the services at once, cancelling the started
+// upgrades that fail when waiting for them.  The error has the exit code of the worst
+// failure.
+func (cli *Client) UpgradeServices(services []client.Service, opts config.UpgradeOpts) error {
+	worst := 0
+	serviceCount := len(services)
+	if serviceCount == 0 {
+		return nil
+	}
 	upgradeErrs := make(chan UpgradeResult, serviceCount)
+	journal := cli.startUpgradeJournal(services, opts)
+	planned := []UpgradeResult{}
+	for i := range services {
+		planned = append(planned, UpgradeResult{Service: &services[i]})
+	}
+	cli.notifyUpgrade(config.NOTIFY_UPGRADE_START, planned, opts)
+	results := []UpgradeResult{}
+	rolledBack := []UpgradeResult{}
 
-	for _, service := range services.Data {
+	for _, service := range services {
 		go func(srv client.Service, opts config.UpgradeOpts) {
 			opts.Service = srv.Name
-			service, err := cli.UpgradeService(opts)
-
+			started := time.Now()
+			locked, lock, err := cli.lockUpgrade(&srv, opts.LockTTL)
 			if err != nil {
 				upgradeErrs <- UpgradeResult{
 					Service: &srv,
 					Error:   err,
+					started: started,
+				}
+				return
+			}
+
+			// Services of different stacks may share the name
+			service, err := cli.upgradeService(locked, opts)
+
+			if err != nil {
+				upgradeErrs <- UpgradeResult{
+					Service: locked,
+					Error:   err,
+					lock:    lock,
+					started: started,
 				}
 				return
 			}
+			journal.service(JOURNAL_UPGRADING, &srv, nil)
 
+			rollback := false
 			if opts.Wait {
 				err = Wait(cli, service, opts)
+				if err == nil {
+					// The upgrade has completed, failing post hooks roll it back
+					err = cli.RunHooks(config.HOOK_PHASE_POST, service, opts)
+					rollback = err != nil
+				}
 				if err == nil {
 					_, err = cli.RancherClient.Service.ActionFinishupgrade(service)
 				}
 			}
 			upgradeErrs <- UpgradeResult{
-				Service: service,
-				Error:   err,
+				Service:   service,
+				Error:     err,
+				lock:      lock,
+				upgrading: true,
+				rollback:  rollback,
+				started:   started,
 			}
 		}(service, opts)
 	}
@@ -265,20 +346,45 @@ func (cli *Client) UpgradeServiceWithNameLike(opts config.UpgradeOpts) error {
 		case result := <-upgradeErrs:
 			if result.Error != nil {
 				// Rollback upgrade, it failed
-				failed = true
+				result.Error = NewDeployError(EXIT_UPGRADE, result.Error)
 				fmt.Printf("service with name %s failed with: %v\n", result.Service.Name, result.Error)
-				if opts.Wait {
-					_, err := cli.RancherClient.Service.ActionCancelupgrade(result.Service)
+				journal.service(JOURNAL_FAILED, result.Service, result.Error)
+				// Upgrades that never started and services locked by
+				// somebody else are left alone
+				if opts.Wait && result.upgrading && (result.lock != nil || opts.LockTTL <= 0) {
+					var err error
+					if result.rollback {
+						_, err = cli.RancherClient.Service.ActionRollback(result.Service)
+					} else {
+						_, err = cli.RancherClient.Service.ActionCancelupgrade(result.Service)
+					}
 
 					if err != nil {
-						log.Fatalf("rollback failed with error: %v", err)
+						log.Errorf("rollback of service %s failed with error: %v", result.Service.Name, err)
+						result.Error = &DeployError{Code: EXIT_ROLLBACK, Err: fmt.Errorf("%v, rolling back also failed: %v", result.Error, err)}
+					} else {
+						journal.service(JOURNAL_ROLLEDBACK, result.Service, result.Error)
+						rolledBack = append(rolledBack, result)
 					}
 				}
+				if code := ExitCode(result.Error); code > worst {
+					worst = code
+				}
+			} else if opts.Wait {
+				journal.service(JOURNAL_FINISHED, result.Service, nil)
 			}
+			cli.unlockUpgrade(result.Service, result.lock)
+			cli.reportService(result.started, result.Service, result.Error)
+			results = append(results, result)
 			count++
 			if count == serviceCount {
-				if failed {
-					return errors.New("upgrading services failed")
+				cli.notifyUpgrade(config.NOTIFY_ROLLBACK, rolledBack, opts)
+				cli.notifyUpgraded(results, opts)
+				if err := journal.Record(JournalEvent{Event: JOURNAL_DONE}); err != nil {
+					log.Warnf("Failed to write the journal %s: %v", journal.Id, err)
+				}
+				if worst != 0 {
+					return &DeployError{Code: worst, Err: errors.New("upgrading services failed")}
 				}
 				return nil
 			}
@@ -286,7 +392,6 @@ func (cli *Client) UpgradeServiceWithNameLike(opts config.UpgradeOpts) error {
 			<-time.After(50 * time.Millisecond)
 		}
 	}
-	return nil
 }
 
 func UpdateLaunchConfig(service *client.Service, opts config.UpgradeOpts) *client.ServiceUpgrade {
@@ -301,32 +406,12 @@ func UpdateLaunchConfig(service *client.Service, opts config.UpgradeOpts) *clien
 	if opts.CodeTag != "" {
 
 		lcImage := service.SecondaryLaunchConfigs[0].(map[string]interface{})["imageUuid"].(string)
-		refs := strings.Split(opts.CodeTag, ":")
-		image := ""
-		switch len(refs) {
-		case 1:
-			first := strings.Index(lcImage, ":") + 1
-			pos := strings.LastIndex(lcImage, ":") + 1
-			image = lcImage[first:pos] + refs[0]
-		case 2:
-			image = opts.CodeTag
-		}
-		service.SecondaryLaunchConfigs[0].(map[string]interface{})["imageUuid"] = fmt.Sprintf("docker:%s", image)
+		service.SecondaryLaunchConfigs[0].(map[string]interface{})["imageUuid"] = retagImage(lcImage, opts.CodeTag)
 		inSrvStrat.SecondaryLaunchConfigs = service.SecondaryLaunchConfigs
 	}
 	if opts.RuntimeTag != "" {
 
-		refs := strings.Split(opts.RuntimeTag, ":")
-		image := ""
-		switch len(refs) {
-		case 1:
-			first := strings.Index(service.LaunchConfig.ImageUuid, ":") + 1
-			pos := strings.LastIndex(service.LaunchConfig.ImageUuid, ":") + 1
-			image = service.LaunchConfig.ImageUuid[first:pos] + refs[0]
-		case 2:
-			image = opts.RuntimeTag
-		}
-		service.LaunchConfig.ImageUuid = fmt.Sprintf("docker:%s", image)
+		service.LaunchConfig.ImageUuid = retagImage(service.LaunchConfig.ImageUuid, opts.RuntimeTag)
 		inSrvStrat.LaunchConfig = service.LaunchConfig
 	}
 
@@ -344,11 +429,23 @@ func UpdateLaunchConfig(service *client.Service, opts config.UpgradeOpts) *clien
 	}
 }
 
+// Replace the image of the imageUuid with ref.  A ref without a repository is
+// a tag that replaces only the tag of the image, any other ref is a complete
+// image reference whose registry may have a port.
+func retagImage(imageUuid, ref string) string {
+	if strings.ContainsAny(ref, ":/@") {
+		return fmt.Sprintf("docker:%s", ref)
+	}
+
+	repo, _, _ := splitImage(strings.TrimPrefix(imageUuid, "docker:"))
+	return fmt.Sprintf("docker:%s:%s", repo, ref)
+}
+
 func Wait(cli *Client, srv *client.Service, opts config.UpgradeOpts) error {
 	ch := make(chan error)
 	go func() {
 		<-time.After(opts.Interval * 20)
-		ch <- errors.New("finishing upgrade timed out")
+		ch <- &DeployError{Code: EXIT_TIMEOUT, Err: errors.New("finishing upgrade timed out")}
 	}()
 	go func() {
 		for {
@@ -378,6 +475,52 @@ func (cli *Client) ValidateService(service *client.Service, opts config.UpgradeO
 	return nil
 }
 
+func (cli *Client) ValidateUpgrade(service *client.Service, upgrade *client.ServiceUpgrade, opts config.UpgradeOpts) error {
+	for _, val := range cli.UpgradeValidators {
+		if err := val.ValidateUpgrade(service, upgrade, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the name of the project with the given id.
+func (cli *Client) ProjectName(id string) (string, error) {
+	project, err := cli.RancherClient.Project.ById(id)
+
+	if err != nil {
+		return "", err
+	}
+
+	if project == nil {
+		return "", fmt.Errorf("failed to find project with id %s", id)
+	}
+	return project.Name, nil
+}
+
+// Returns the project with the given name.
+func (cli *Client) ProjectByName(name string) (*client.Project, error) {
+	projects, err := cli.listProjects(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, project := range projects {
+		if project.Name == name {
+			return &projects[i], nil
+		}
+	}
+	return nil, fmt.Errorf("failed to find project with name %s", name)
+}
+
+func addServiceLikeFilters(filters map[string]interface{}, likeName string) {
+	filters["name_like"] = getServiceLikeQuery(likeName)
+	// Do not include service load balancers
+	filters["kind"] = SERVICE_TYPE_SERVICE
+}
+
 func getServiceLikeQuery(serviceName string) string {
 	return serviceName + "%"
 }
@@ -425,7 +568,16 @@ func (env *EnvironmentClient) Create(opts *client.Environment) (*client.Environm
 		return nil, errors.New(fmt.Sprintf("Creating environment returned %d response code", res.StatusCode))
 	}
 
-	_, err = ioutil.ReadAll(res.Body)
+	body, err := ioutil.ReadAll(res.Body)
+
+	if err != nil || len(body) == 0 {
+		return nil, err
+	}
+
+	created := &client.Environment{}
+	if err := json.Unmarshal(body, created); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode created environment")
+	}
 
-	return nil, err
+	return created, nil
 }
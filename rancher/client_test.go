@@ -311,6 +311,39 @@ func TestUpgradeServiceFailsWhenSingleValidatorFails(t *testing.T) {
 	}
 }
 
+type FailedUpgradeValidator struct{}
+
+func (val *FailedUpgradeValidator) ValidateUpgrade(service *client.Service, upgrade *client.ServiceUpgrade, opts config.UpgradeOpts) error {
+	if upgrade.InServiceStrategy.LaunchConfig.ImageUuid != fmt.Sprintf("docker:%s", codeTag) {
+		return errors.New("validator should receive the upgraded launch config")
+	}
+	return errors.New("upgrade validation has failed")
+}
+
+func TestUpgradeServiceFailsWhenUpgradeValidationFails(t *testing.T) {
+	cli := Client{
+		RancherClient: &client.RancherClient{
+			Service: &UpgradeServiceService{},
+		},
+		Validators: []config.Validator{
+			&config.NoopValidator{},
+		},
+		UpgradeValidators: []config.UpgradeValidator{
+			&FailedUpgradeValidator{},
+		},
+	}
+
+	opts := config.UpgradeOpts{
+		Service:    serviceName,
+		RuntimeTag: codeTag,
+	}
+	_, err := cli.UpgradeService(opts)
+
+	if err == nil || err.Error() != "upgrade validation has failed" {
+		t.Errorf("service upgrade should have failed validating the upgraded launch config, received %v", err)
+	}
+}
+
 func TestWaitTimesOutWhenUpgradeTakesTooLong(t *testing.T) {
 	orig := upgradePollInterval
 	upgradePollInterval = 10 * time.Second
@@ -427,6 +460,39 @@ func TestUpdateLaunchConfig(t *testing.T) {
 	}
 }
 
+func TestRetagImage(t *testing.T) {
+	tests := []struct {
+		ImageUuid string
+		Ref       string
+		Expected  string
+	}{
+		{"docker:nowait/api:1.0", "2.0", "docker:nowait/api:2.0"},
+		{"docker:registry:5000/nowait/api:1.0", "2.0", "docker:registry:5000/nowait/api:2.0"},
+		{"docker:registry:5000/nowait/api", "2.0", "docker:registry:5000/nowait/api:2.0"},
+		{"docker:nowait/api:1.0", "nowait/web:2.0", "docker:nowait/web:2.0"},
+		{"docker:nowait/api:1.0", "registry:5000/nowait/api:2.0", "docker:registry:5000/nowait/api:2.0"},
+	}
+
+	for _, test := range tests {
+		if image := retagImage(test.ImageUuid, test.Ref); image != test.Expected {
+			t.Errorf("expected %s with %s to be %s but received %s", test.ImageUuid, test.Ref, test.Expected, image)
+		}
+	}
+}
+
+// CloneTargetEnvironments lists no stacks in the target project of a clone so
+// the stacks of the source project do not conflict with it.
+type CloneTargetEnvironments struct {
+	client.EnvironmentOperations
+}
+
+func (env *CloneTargetEnvironments) List(opts *client.ListOpts) (*client.EnvironmentCollection, error) {
+	if opts.Filters["accountId_eq"] == mocks.ProjectTwo.Id {
+		return &client.EnvironmentCollection{Data: []client.Environment{}}, nil
+	}
+	return env.EnvironmentOperations.List(opts)
+}
+
 func TestCloneProject(t *testing.T) {
 	tests := []struct {
 		Description string
@@ -514,7 +580,7 @@ func TestCloneProject(t *testing.T) {
 			Client: Client{
 				RancherClient: &client.RancherClient{
 
-					Environment: &mocks.FailedActionExportconfigEnvironmentOperations{},
+					Environment: &CloneTargetEnvironments{&mocks.FailedActionExportconfigEnvironmentOperations{}},
 					Project:     &mocks.SuccessfulProjectOperations{},
 				},
 			},
@@ -529,7 +595,7 @@ func TestCloneProject(t *testing.T) {
 			Client: Client{
 				RancherClient: &client.RancherClient{
 
-					Environment: &mocks.FailedCreateEnvironmentOperations{},
+					Environment: &CloneTargetEnvironments{&mocks.FailedCreateEnvironmentOperations{}},
 					Project:     &mocks.SuccessfulProjectOperations{},
 				},
 			},
@@ -544,7 +610,7 @@ func TestCloneProject(t *testing.T) {
 			Client: Client{
 				RancherClient: &client.RancherClient{
 
-					Environment: &mocks.SuccessfulEnvironmentOperations{},
+					Environment: &CloneTargetEnvironments{&mocks.SuccessfulEnvironmentOperations{}},
 					Project:     &mocks.SuccessfulProjectOperations{},
 				},
 			},
@@ -615,6 +681,32 @@ func TestEnvironmentCreate(t *testing.T) {
 	}
 }
 
+func TestEnvironmentCreateReturnsCreatedEnvironment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(201)
+		rw.Write([]byte(`{"id": "1e5", "name": "api", "actions": {"remove": "http://rancher/v1/environments/1e5/?action=remove"}}`))
+	}))
+	defer server.Close()
+
+	envClient := EnvironmentClient{
+		accessKey:  accessKey,
+		secretKey:  secretKey,
+		rancherUrl: server.URL,
+	}
+
+	env, err := envClient.Create(&client.Environment{
+		AccountId: accountId,
+		Name:      "api",
+	})
+
+	if err != nil {
+		t.Fatalf("creating the environment failed: %v", err)
+	}
+	if env == nil || env.Id != "1e5" || env.Actions["remove"] == "" {
+		t.Errorf("expected the created environment to be returned, received %+v", env)
+	}
+}
+
 func TestUpgradeServiceWithName(t *testing.T) {
 
 }
@@ -715,3 +807,29 @@ func validateCreateRequest(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(400)
 	}
 }
+
+type ScalingServices struct {
+	client.ServiceOperations
+	Updates []interface{}
+}
+
+func (srv *ScalingServices) Update(existing *client.Service, updates interface{}) (*client.Service, error) {
+	srv.Updates = append(srv.Updates, updates)
+	scaled := *existing
+	scaled.Scale = updates.(map[string]interface{})["scale"].(int64)
+	return &scaled, nil
+}
+
+func TestScaleService(t *testing.T) {
+	services := &ScalingServices{}
+	cli := &Client{RancherClient: &client.RancherClient{Service: services}}
+
+	scaled, err := cli.ScaleService(&client.Service{Name: serviceName, Scale: 3}, 0)
+
+	if err != nil || scaled.Scale != 0 {
+		t.Errorf("expected the service to be scaled to 0 but received %v, %v", scaled, err)
+	}
+	if !reflect.DeepEqual(services.Updates, []interface{}{map[string]interface{}{"scale": int64(0)}}) {
+		t.Errorf("expected only the scale to be updated but received %v", services.Updates)
+	}
+}
@@ -0,0 +1,450 @@
+package rancher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	CLONE_ACTION_CREATE = "create"
+	CLONE_ACTION_SKIP   = "skip"
+	CLONE_ACTION_UPDATE = "update"
+)
+
+// ClonePlan lists what cloning the stacks of one project into another does
+// to every selected stack.
+type ClonePlan struct {
+	SourceProjectId string                `json:"sourceProjectId"`
+	TargetProjectId string                `json:"targetProjectId"`
+	Stacks          []StackClone          `json:"stacks"`
+	Rewrite         *compose.RewriteRules `json:"rewrite,omitempty"`
+	// Contexts of the source and target servers, used to resume the clone
+	SourceContext string `json:"sourceContext,omitempty"`
+	TargetContext string `json:"targetContext,omitempty"`
+}
+
+// StackClone is the planned action for a single source stack.
+type StackClone struct {
+	Source client.Environment `json:"source"`
+	// Name of the stack in the target project, differs from the source name
+	// when the stack is renamed
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	// Existing is the target stack with the same name, if any
+	Existing *client.Environment `json:"existing,omitempty"`
+}
+
+// CloneState records the progress of a clone so a failed clone can be
+// rolled back or resumed from the stack that failed.
+type CloneState struct {
+	Plan *ClonePlan `json:"plan"`
+	// Number of stacks of the plan that have been cloned
+	Completed int                  `json:"completed"`
+	Created   []client.Environment `json:"created"`
+	Upgraded  []client.Environment `json:"upgraded"`
+}
+
+// PlanClone plans a clone between two projects of the same Rancher server.
+func (cli *Client) PlanClone(opts config.EnvUpgradeOpts) (*ClonePlan, error) {
+	return cli.PlanCloneTo(cli, opts)
+}
+
+// PlanCloneTo resolves the source project with this client and the target
+// project with the target client, selects the active source stacks matching
+// the stack filters and decides what to do with each of them without
+// changing anything.
+func (cli *Client) PlanCloneTo(target *Client, opts config.EnvUpgradeOpts) (*ClonePlan, error) {
+	onExists := opts.OnExists
+	if onExists == "" {
+		onExists = config.ON_EXISTS_FAIL
+	}
+	switch onExists {
+	case config.ON_EXISTS_FAIL, config.ON_EXISTS_SKIP, config.ON_EXISTS_UPDATE, config.ON_EXISTS_RENAME:
+	default:
+		return nil, fmt.Errorf("invalid on exists policy %s, expected one of fail, skip, update or rename", onExists)
+	}
+
+	include, err := compileGlobs(opts.Stacks)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compileGlobs(opts.ExcludeStacks)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceId, targetId, err := cli.cloneProjectIds(target, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Source project id %s target id %s", sourceId, targetId)
+
+	// Filter environments by correct project id and ensure they are active
+	filters := make(map[string]interface{})
+	filters["accountId_eq"] = sourceId
+	filters["state"] = "active"
+	envs, err := cli.listStacks(filters)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find stacks for project")
+	}
+
+	existing, err := target.projectStacks(targetId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find stacks for target project")
+	}
+
+	plan := &ClonePlan{
+		SourceProjectId: sourceId,
+		TargetProjectId: targetId,
+		Rewrite:         opts.Rewrite,
+	}
+	taken := make(map[string]bool)
+	for name := range existing {
+		taken[name] = true
+	}
+
+	for _, env := range envs {
+		if (len(include) > 0 && !matchesAny(env.Name, include)) || matchesAny(env.Name, exclude) {
+			log.Debugf("Stack %s does not match the stack filters", env.Name)
+			continue
+		}
+		taken[env.Name] = true
+		plan.Stacks = append(plan.Stacks, StackClone{
+			Source: env,
+			Name:   env.Name,
+			Action: CLONE_ACTION_CREATE,
+		})
+	}
+
+	conflicts := []string{}
+	for i := range plan.Stacks {
+		stack := &plan.Stacks[i]
+		target, ok := existing[stack.Name]
+		if !ok {
+			continue
+		}
+
+		switch onExists {
+		case config.ON_EXISTS_FAIL:
+			conflicts = append(conflicts, stack.Name)
+		case config.ON_EXISTS_SKIP:
+			stack.Action = CLONE_ACTION_SKIP
+			stack.Existing = target
+		case config.ON_EXISTS_UPDATE:
+			stack.Action = CLONE_ACTION_UPDATE
+			stack.Existing = target
+		case config.ON_EXISTS_RENAME:
+			stack.Name = cloneName(stack.Name, taken)
+			taken[stack.Name] = true
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("stacks already exist in environment %s: %s", opts.TargetEnv, strings.Join(conflicts, ", "))
+	}
+
+	return plan, nil
+}
+
+// CloneStacks carries out a plan between two projects of the same Rancher
+// server.
+func (cli *Client) CloneStacks(state *CloneState, keepPartial bool) error {
+	return cli.CloneStacksTo(cli, state, keepPartial)
+}
+
+// CloneStacksTo carries out the remaining stacks of the plan, exporting them
+// with this client and creating them with the target client.  It stops at
+// the first stack that fails, the stacks created or upgraded so far are then
+// removed or rolled back, unless keepPartial is set in which case the state
+// can be saved and the clone resumed later.
+func (cli *Client) CloneStacksTo(target *Client, state *CloneState, keepPartial bool) error {
+	plan := state.Plan
+	log.Debugf("Cloning %d stacks into project %s", len(plan.Stacks)-state.Completed, plan.TargetProjectId)
+
+	for state.Completed < len(plan.Stacks) {
+		stack := plan.Stacks[state.Completed]
+		started := time.Now()
+		err := cli.cloneStack(target, state, stack)
+		if stack.Action == CLONE_ACTION_SKIP {
+			cli.Report.Skip(REPORT_KIND_STACK, stack.Name)
+		} else {
+			cli.reportStack(started, stack.Name, nil, err)
+		}
+
+		if err != nil {
+			if keepPartial {
+				return err
+			}
+			if rollbackErr := target.rollbackClone(state); rollbackErr != nil {
+				return &DeployError{Code: EXIT_ROLLBACK, Err: errors.Wrapf(err, "rolling back the clone also failed: %v", rollbackErr)}
+			}
+			return err
+		}
+		state.Completed++
+	}
+
+	cli.notifyClone(target, plan)
+	return nil
+}
+
+func (cli *Client) cloneStack(target *Client, state *CloneState, stack StackClone) error {
+	if stack.Action == CLONE_ACTION_SKIP {
+		log.Infof("Skipping stack %s, it already exists", stack.Name)
+		return nil
+	}
+
+	_, composeConfig, err := cli.StackCompose(state.Plan, stack)
+
+	if err != nil {
+		return err
+	}
+
+	if stack.Action == CLONE_ACTION_UPDATE {
+		upgraded, err := target.RancherClient.Environment.ActionUpgrade(stack.Existing, &client.EnvironmentUpgrade{
+			DockerCompose:  composeConfig.DockerComposeConfig,
+			RancherCompose: composeConfig.RancherComposeConfig,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Failed to update stack %s", stack.Name)
+		}
+		if upgraded == nil {
+			upgraded = stack.Existing
+		}
+		state.Upgraded = append(state.Upgraded, *upgraded)
+		log.Infof("Upgraded stack %s, finish the upgrade once its services are healthy", stack.Name)
+		return nil
+	}
+
+	created, err := target.RancherClient.Environment.Create(&client.Environment{
+		AccountId:      state.Plan.TargetProjectId,
+		DockerCompose:  composeConfig.DockerComposeConfig,
+		RancherCompose: composeConfig.RancherComposeConfig,
+		Name:           stack.Name,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if created == nil || created.Id == "" {
+		created = &client.Environment{Name: stack.Name}
+	}
+	state.Created = append(state.Created, *created)
+	return nil
+}
+
+// StackCompose exports the compose files of the source stack and returns
+// them along with the compose files the stack is cloned with.
+func (cli *Client) StackCompose(plan *ClonePlan, stack StackClone) (*client.ComposeConfig, *client.ComposeConfig, error) {
+	original, err := cli.RancherClient.Environment.ActionExportconfig(&stack.Source, &client.ComposeConfigInput{})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if plan.Rewrite == nil {
+		return original, original, nil
+	}
+
+	rewritten, err := plan.Rewrite.Rewrite(stack.Source.Name, original)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to rewrite stack %s", stack.Source.Name)
+	}
+	return original, rewritten, nil
+}
+
+// CloneDiff returns the changes the rewrite rules of the plan make to the
+// compose files of the stack.
+func (cli *Client) CloneDiff(plan *ClonePlan, stack StackClone) (string, error) {
+	original, rewritten, err := cli.StackCompose(plan, stack)
+	if err != nil || plan.Rewrite == nil {
+		return "", err
+	}
+
+	normalized, err := compose.Normalize(original)
+	if err != nil {
+		return "", err
+	}
+
+	return compose.Diff("docker-compose.yml", normalized.DockerComposeConfig, rewritten.DockerComposeConfig) +
+		compose.Diff("rancher-compose.yml", normalized.RancherComposeConfig, rewritten.RancherComposeConfig), nil
+}
+
+// Remove the created stacks and roll back the upgraded stacks, newest first.
+// Stacks created without a response are looked up by name.
+func (cli *Client) rollbackClone(state *CloneState) error {
+	failed := []string{}
+
+	for i := len(state.Upgraded) - 1; i >= 0; i-- {
+		stack := state.Upgraded[i]
+		log.Infof("Rolling back stack %s", stack.Name)
+		if _, err := cli.RancherClient.Environment.ActionRollback(&stack); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", stack.Name, err))
+		}
+	}
+
+	var existing map[string]*client.Environment
+	for i := len(state.Created) - 1; i >= 0; i-- {
+		stack := &state.Created[i]
+		if stack.Id == "" {
+			if existing == nil {
+				found, err := cli.projectStacks(state.Plan.TargetProjectId)
+				if err != nil {
+					return err
+				}
+				existing = found
+			}
+			if found, ok := existing[stack.Name]; ok {
+				stack = found
+			}
+		}
+
+		log.Infof("Removing stack %s", stack.Name)
+		if _, err := cli.RancherClient.Environment.ActionRemove(stack); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", stack.Name, err))
+		}
+	}
+
+	state.Created, state.Upgraded, state.Completed = nil, nil, 0
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to roll back stacks %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// SaveCloneState writes the state of a partial clone to path.
+func SaveCloneState(path string, state *CloneState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// LoadCloneState reads the state of a partial clone written by SaveCloneState.
+func LoadCloneState(path string) (*CloneState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &CloneState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid clone resume file %s: %v", path, err)
+	}
+	if state.Plan == nil || state.Completed > len(state.Plan.Stacks) {
+		return nil, fmt.Errorf("invalid clone resume file %s: missing plan", path)
+	}
+	return state, nil
+}
+
+// Use continue after finding matching project so that the source and target
+// project ids differ.  Projects on different servers are looked up
+// separately and may have the same name.
+func (cli *Client) cloneProjectIds(target *Client, opts config.EnvUpgradeOpts) (string, string, error) {
+	projects, err := cli.listProjects(nil)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	log.Debugf("Found %d projects", len(projects))
+
+	targetProjects := projects
+	if target != cli {
+		if targetProjects, err = target.listProjects(nil); err != nil {
+			return "", "", err
+		}
+	}
+
+	sourceId, targetId := "", ""
+	for _, project := range projects {
+		if project.Name == opts.SourceEnv {
+			log.Debugf("Matched project %s with Id: %s", project.Name, project.Id)
+			sourceId = project.Id
+			continue
+		}
+
+		if project.Name == opts.TargetEnv && target == cli {
+			log.Debugf("Matched project %s with Id %s", project.Name, project.Id)
+			targetId = project.Id
+			continue
+		}
+	}
+
+	if target != cli {
+		for _, project := range targetProjects {
+			if project.Name == opts.TargetEnv {
+				log.Debugf("Matched target project %s with Id %s", project.Name, project.Id)
+				targetId = project.Id
+			}
+		}
+	}
+
+	if sourceId == "" || targetId == "" {
+		return "", "", environmentCloneSourceTargetError
+	}
+	return sourceId, targetId, nil
+}
+
+// Return the stacks of the project that have not been removed by name.
+func (cli *Client) projectStacks(projectId string) (map[string]*client.Environment, error) {
+	filters := make(map[string]interface{})
+	filters["accountId_eq"] = projectId
+	envs, err := cli.listStacks(filters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stacks := make(map[string]*client.Environment)
+	for i, env := range envs {
+		if env.State == "removed" || env.State == "purged" {
+			continue
+		}
+		stacks[env.Name] = &envs[i]
+	}
+	return stacks, nil
+}
+
+// Pick the first free name of the form name-clone, name-clone-2, ...
+func cloneName(name string, taken map[string]bool) string {
+	candidate := name + "-clone"
+	for i := 2; taken[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-clone-%d", name, i)
+	}
+	return candidate
+}
+
+func compileGlobs(globs []string) ([]*regexp.Regexp, error) {
+	res := []*regexp.Regexp{}
+	for _, glob := range globs {
+		re, err := compose.GlobToRegexp(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stack pattern %s: %v", glob, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func matchesAny(name string, res []*regexp.Regexp) bool {
+	for _, re := range res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+package rancher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+// CloneEnvironments lists the stacks of each project and records the stacks
+// created, upgraded, removed and rolled back.
+type CloneEnvironments struct {
+	mocks.NoopEnvironmentOperations
+	Stacks     map[string][]client.Environment
+	FailCreate string
+	Compose    *client.ComposeConfig
+	Created    []string
+	Composes   []string
+	Upgraded   []string
+	Removed    []string
+	RolledBack []string
+}
+
+func (env *CloneEnvironments) List(opts *client.ListOpts) (*client.EnvironmentCollection, error) {
+	accountId, _ := opts.Filters["accountId_eq"].(string)
+	return &client.EnvironmentCollection{
+		Data: env.Stacks[accountId],
+	}, nil
+}
+
+func (env *CloneEnvironments) ActionExportconfig(*client.Environment, *client.ComposeConfigInput) (*client.ComposeConfig, error) {
+	if env.Compose != nil {
+		return env.Compose, nil
+	}
+	return &client.ComposeConfig{
+		DockerComposeConfig:  "docker compose",
+		RancherComposeConfig: "rancher compose",
+	}, nil
+}
+
+func (env *CloneEnvironments) Create(opts *client.Environment) (*client.Environment, error) {
+	if opts.Name == env.FailCreate {
+		return nil, mocks.CreateEnvironmentError
+	}
+	env.Created = append(env.Created, opts.Name)
+	env.Composes = append(env.Composes, opts.DockerCompose)
+	return &client.Environment{Resource: client.Resource{Id: "1e-" + opts.Name}, Name: opts.Name}, nil
+}
+
+func (env *CloneEnvironments) ActionRemove(stack *client.Environment) (*client.Environment, error) {
+	env.Removed = append(env.Removed, stack.Id)
+	return stack, nil
+}
+
+func (env *CloneEnvironments) ActionRollback(stack *client.Environment) (*client.Environment, error) {
+	env.RolledBack = append(env.RolledBack, stack.Name)
+	return stack, nil
+}
+
+func (env *CloneEnvironments) ActionUpgrade(existing *client.Environment, upgrade *client.EnvironmentUpgrade) (*client.Environment, error) {
+	env.Upgraded = append(env.Upgraded, existing.Name)
+	return existing, nil
+}
+
+func cloneClient() (*Client, *CloneEnvironments) {
+	envs := &CloneEnvironments{
+		Stacks: map[string][]client.Environment{
+			mocks.ProjectOne.Id: {
+				{Name: "api"},
+				{Name: "api-worker"},
+				{Name: "web"},
+				{Name: "monitoring"},
+			},
+			mocks.ProjectTwo.Id: {
+				{Name: "web"},
+				{Name: "web-clone"},
+				{Name: "monitoring", State: "removed"},
+			},
+		},
+	}
+	return &Client{
+		RancherClient: &client.RancherClient{
+			Environment: envs,
+			Project:     &mocks.SuccessfulProjectOperations{},
+		},
+	}, envs
+}
+
+func TestCloneProjectOptions(t *testing.T) {
+	tests := []struct {
+		Description   string
+		Stacks        []string
+		ExcludeStacks []string
+		OnExists      string
+		ShouldFail    bool
+		Created       []string
+		Upgraded      []string
+	}{
+		{
+			Description: "Existing stacks fail the clone before creating anything",
+			ShouldFail:  true,
+		},
+		{
+			Description: "Invalid on exists policy",
+			OnExists:    "replace",
+			ShouldFail:  true,
+		},
+		{
+			Description: "Existing stacks are skipped",
+			OnExists:    config.ON_EXISTS_SKIP,
+			Created:     []string{"api", "api-worker", "monitoring"},
+		},
+		{
+			Description: "Existing stacks are upgraded",
+			OnExists:    config.ON_EXISTS_UPDATE,
+			Created:     []string{"api", "api-worker", "monitoring"},
+			Upgraded:    []string{"web"},
+		},
+		{
+			Description: "Existing stacks are renamed to a free name",
+			OnExists:    config.ON_EXISTS_RENAME,
+			Created:     []string{"api", "api-worker", "web-clone-2", "monitoring"},
+		},
+		{
+			Description:   "Only stacks matching the filters are cloned",
+			Stacks:        []string{"api*", "web"},
+			ExcludeStacks: []string{"*-worker"},
+			OnExists:      config.ON_EXISTS_SKIP,
+			Created:       []string{"api"},
+		},
+	}
+
+	for _, test := range tests {
+		cli, envs := cloneClient()
+
+		err := cli.CloneProject(config.EnvUpgradeOpts{
+			SourceEnv:     mocks.ProjectOneName,
+			TargetEnv:     mocks.ProjectTwoName,
+			Stacks:        test.Stacks,
+			ExcludeStacks: test.ExcludeStacks,
+			OnExists:      test.OnExists,
+		})
+
+		if test.ShouldFail != (err != nil) {
+			t.Errorf("%s: expected failure %t but received %v", test.Description, test.ShouldFail, err)
+		}
+		if !reflect.DeepEqual(envs.Created, test.Created) {
+			t.Errorf("%s: expected created stacks %v but received %v", test.Description, test.Created, envs.Created)
+		}
+		if !reflect.DeepEqual(envs.Upgraded, test.Upgraded) {
+			t.Errorf("%s: expected upgraded stacks %v but received %v", test.Description, test.Upgraded, envs.Upgraded)
+		}
+	}
+}
+
+func TestPlanCloneDoesNotChangeTarget(t *testing.T) {
+	cli, envs := cloneClient()
+
+	plan, err := cli.PlanClone(config.EnvUpgradeOpts{
+		SourceEnv: mocks.ProjectOneName,
+		TargetEnv: mocks.ProjectTwoName,
+		OnExists:  config.ON_EXISTS_SKIP,
+	})
+
+	if err != nil {
+		t.Fatalf("planning the clone failed: %v", err)
+	}
+	if len(envs.Created) != 0 || len(envs.Upgraded) != 0 {
+		t.Errorf("planning the clone should not change the target, created %v upgraded %v", envs.Created, envs.Upgraded)
+	}
+
+	actions := []string{}
+	for _, stack := range plan.Stacks {
+		actions = append(actions, stack.Action)
+	}
+	expected := []string{CLONE_ACTION_CREATE, CLONE_ACTION_CREATE, CLONE_ACTION_SKIP, CLONE_ACTION_CREATE}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("expected plan actions %v but received %v", expected, actions)
+	}
+}
+
+func TestCloneProjectRollsBackOnFailure(t *testing.T) {
+	cli, envs := cloneClient()
+	envs.FailCreate = "monitoring"
+
+	err := cli.CloneProject(config.EnvUpgradeOpts{
+		SourceEnv: mocks.ProjectOneName,
+		TargetEnv: mocks.ProjectTwoName,
+		OnExists:  config.ON_EXISTS_UPDATE,
+	})
+
+	if errors.Cause(err) != mocks.CreateEnvironmentError {
+		t.Errorf("expected the create error but received %v", err)
+	}
+
+	expected := []string{"1e-api-worker", "1e-api"}
+	if !reflect.DeepEqual(envs.Removed, expected) {
+		t.Errorf("expected removed stacks %v but received %v", expected, envs.Removed)
+	}
+	if !reflect.DeepEqual(envs.RolledBack, []string{"web"}) {
+		t.Errorf("expected the upgraded stack web to be rolled back, received %v", envs.RolledBack)
+	}
+}
+
+func TestCloneResumeFromPartialClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	resumeFile := filepath.Join(dir, "resume.json")
+
+	cli, envs := cloneClient()
+	envs.FailCreate = "web-clone-2"
+
+	plan, err := cli.PlanClone(config.EnvUpgradeOpts{
+		SourceEnv: mocks.ProjectOneName,
+		TargetEnv: mocks.ProjectTwoName,
+		OnExists:  config.ON_EXISTS_RENAME,
+	})
+	if err != nil {
+		t.Fatalf("planning the clone failed: %v", err)
+	}
+
+	state := &CloneState{Plan: plan}
+	if err := cli.CloneStacks(state, true); errors.Cause(err) != mocks.CreateEnvironmentError {
+		t.Fatalf("expected the create error but received %v", err)
+	}
+	if len(envs.Removed) != 0 || state.Completed != 2 {
+		t.Fatalf("partial clone should keep 2 stacks, removed %v completed %d", envs.Removed, state.Completed)
+	}
+
+	if err := SaveCloneState(resumeFile, state); err != nil {
+		t.Fatalf("saving the clone state failed: %v", err)
+	}
+	resumed, err := LoadCloneState(resumeFile)
+	if err != nil {
+		t.Fatalf("loading the clone state failed: %v", err)
+	}
+
+	envs.FailCreate = ""
+	if err := cli.CloneStacks(resumed, true); err != nil {
+		t.Fatalf("resuming the clone failed: %v", err)
+	}
+
+	expected := []string{"api", "api-worker", "web-clone-2", "monitoring"}
+	if !reflect.DeepEqual(envs.Created, expected) {
+		t.Errorf("expected created stacks %v but received %v", expected, envs.Created)
+	}
+	if len(resumed.Created) != 4 {
+		t.Errorf("expected the resumed state to record 4 created stacks, received %d", len(resumed.Created))
+	}
+}
+
+func TestCloneProjectRewritesCompose(t *testing.T) {
+	cli, envs := cloneClient()
+	envs.Compose = &client.ComposeConfig{
+		DockerComposeConfig:  "api:\n  image: nowait/api:1.0\n",
+		RancherComposeConfig: "api:\n  scale: 4\n",
+	}
+	rules := &compose.RewriteRules{
+		Rules: []compose.RewriteRule{{Stack: "api", ImageTag: "staging"}},
+	}
+
+	plan, err := cli.PlanClone(config.EnvUpgradeOpts{
+		SourceEnv: mocks.ProjectOneName,
+		TargetEnv: mocks.ProjectTwoName,
+		Stacks:    []string{"api*"},
+		Rewrite:   rules,
+	})
+	if err != nil {
+		t.Fatalf("planning the clone failed: %v", err)
+	}
+
+	diff, err := cli.CloneDiff(plan, plan.Stacks[0])
+	if err != nil {
+		t.Fatalf("computing the clone diff failed: %v", err)
+	}
+	expected := "--- docker-compose.yml\n+++ docker-compose.yml\n api:\n-  image: nowait/api:1.0\n+  image: nowait/api:staging\n"
+	if diff != expected {
+		t.Errorf("expected diff\n%s\nbut received\n%s", expected, diff)
+	}
+	if len(envs.Created) != 0 {
+		t.Errorf("computing the diff should not create stacks, created %v", envs.Created)
+	}
+
+	if err := cli.CloneStacks(&CloneState{Plan: plan}, false); err != nil {
+		t.Fatalf("cloning failed: %v", err)
+	}
+	expectedComposes := []string{"api:\n  image: nowait/api:staging\n", "api:\n  image: nowait/api:1.0\n"}
+	if !reflect.DeepEqual(envs.Composes, expectedComposes) {
+		t.Errorf("expected the rules to apply to the api stack only, received %v", envs.Composes)
+	}
+}
+
+type TargetProjects struct {
+	mocks.FailedProjectOperations
+}
+
+func (proj *TargetProjects) List(opts *client.ListOpts) (*client.ProjectCollection, error) {
+	return &client.ProjectCollection{
+		Data: []client.Project{
+			{Resource: client.Resource{Id: "1a9"}, Name: mocks.ProjectOneName},
+		},
+	}, nil
+}
+
+func TestCloneProjectAcrossServers(t *testing.T) {
+	source, sourceEnvs := cloneClient()
+	targetEnvs := &CloneEnvironments{
+		Stacks: map[string][]client.Environment{
+			"1a9": {{Name: "web"}},
+		},
+	}
+	target := &Client{
+		RancherClient: &client.RancherClient{
+			Environment: targetEnvs,
+			Project:     &TargetProjects{},
+		},
+	}
+
+	plan, err := source.PlanCloneTo(target, config.EnvUpgradeOpts{
+		SourceEnv: mocks.ProjectOneName,
+		TargetEnv: mocks.ProjectOneName,
+		OnExists:  config.ON_EXISTS_SKIP,
+	})
+	if err != nil {
+		t.Fatalf("planning the clone failed: %v", err)
+	}
+	if plan.TargetProjectId != "1a9" {
+		t.Errorf("expected the target project to be looked up on the target server, received %s", plan.TargetProjectId)
+	}
+
+	if err := source.CloneStacksTo(target, &CloneState{Plan: plan}, false); err != nil {
+		t.Fatalf("cloning failed: %v", err)
+	}
+
+	expected := []string{"api", "api-worker", "monitoring"}
+	if !reflect.DeepEqual(targetEnvs.Created, expected) {
+		t.Errorf("expected stacks %v to be created on the target, received %v", expected, targetEnvs.Created)
+	}
+	if len(sourceEnvs.Created) != 0 {
+		t.Errorf("expected no stacks to be created on the source, received %v", sourceEnvs.Created)
+	}
+}
@@ -0,0 +1,169 @@
+package compose
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	FIELD_SERVICE     = "service"
+	FIELD_IMAGE       = "image"
+	FIELD_ENVIRONMENT = "environment"
+	FIELD_LABELS      = "labels"
+	FIELD_LINKS       = "links"
+	FIELD_SCALE       = "scale"
+	FIELD_PORTS       = "ports"
+
+	// Value shown instead of secret environment values
+	MaskedValue = "(secret)"
+)
+
+// Change is a single difference of a service between two stacks.  Key is
+// the environment variable, label, link or port that changed, and Old or
+// New is empty when it only exists on one side.
+type Change struct {
+	Service string `json:"service"`
+	Field   string `json:"field"`
+	Key     string `json:"key,omitempty"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// Compare returns the differences between the services of two stacks in the
+// images, environment, labels, links, scale and ports they are deployed
+// with, ordered by service.  The values of environment variables matching
+// one of the secret globs are masked.
+func Compare(a, b *Config, secrets []string) ([]Change, error) {
+	patterns := []*regexp.Regexp{}
+	for _, glob := range secrets {
+		re, err := GlobToRegexp(strings.ToUpper(glob))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %s: %v", glob, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	names := append(a.ServiceNames(), b.ServiceNames()...)
+	sort.Strings(names)
+
+	changes := []Change{}
+	for _, name := range uniqueStrings(names) {
+		before, after := a.DockerService(name), b.DockerService(name)
+		switch {
+		case before == nil:
+			changes = append(changes, Change{Service: name, Field: FIELD_SERVICE, New: name})
+			continue
+		case after == nil:
+			changes = append(changes, Change{Service: name, Field: FIELD_SERVICE, Old: name})
+			continue
+		}
+
+		oldImage, _ := before["image"].(string)
+		newImage, _ := after["image"].(string)
+		if oldImage != newImage {
+			changes = append(changes, Change{Service: name, Field: FIELD_IMAGE, Old: oldImage, New: newImage})
+		}
+
+		envChanges := compareValues(name, FIELD_ENVIRONMENT, KeyValues(before, "environment"), KeyValues(after, "environment"))
+		for i, change := range envChanges {
+			if matchesAnyPattern(strings.ToUpper(change.Key), patterns) {
+				envChanges[i].Old, envChanges[i].New = maskValue(change.Old), maskValue(change.New)
+			}
+		}
+		changes = append(changes, envChanges...)
+		changes = append(changes, compareValues(name, FIELD_LABELS, KeyValues(before, "labels"), KeyValues(after, "labels"))...)
+		changes = append(changes, compareSets(name, FIELD_LINKS, links(before), links(after))...)
+
+		if oldScale, newScale := a.scaleOrDefault(name), b.scaleOrDefault(name); oldScale != newScale {
+			changes = append(changes, Change{Service: name, Field: FIELD_SCALE, Old: fmt.Sprint(oldScale), New: fmt.Sprint(newScale)})
+		}
+
+		changes = append(changes, compareSets(name, FIELD_PORTS, listValues(before, "ports"), listValues(after, "ports"))...)
+	}
+
+	return changes, nil
+}
+
+// Rancher starts a single container for services without a scale.
+func (cfg *Config) scaleOrDefault(name string) int64 {
+	if scale, ok := cfg.Scale(name); ok {
+		return scale
+	}
+	return 1
+}
+
+func compareValues(service, field string, before, after map[string]string) []Change {
+	keys := []string{}
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []Change{}
+	for _, key := range keys {
+		oldValue, inBefore := before[key]
+		newValue, inAfter := after[key]
+		if inBefore && inAfter && oldValue == newValue {
+			continue
+		}
+		changes = append(changes, Change{Service: service, Field: field, Key: key, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+func compareSets(service, field string, before, after []string) []Change {
+	in := func(s string, values []string) bool {
+		for _, value := range values {
+			if value == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	changes := []Change{}
+	for _, value := range before {
+		if !in(value, after) {
+			changes = append(changes, Change{Service: service, Field: field, Key: value, Old: value})
+		}
+	}
+	for _, value := range after {
+		if !in(value, before) {
+			changes = append(changes, Change{Service: service, Field: field, Key: value, New: value})
+		}
+	}
+	return changes
+}
+
+// Links and external links of a service, both of the form service:alias.
+func links(service map[interface{}]interface{}) []string {
+	all := append(listValues(service, "links"), listValues(service, "external_links")...)
+	sort.Strings(all)
+	return uniqueStrings(all)
+}
+
+// Return the entries of a list such as ports in sorted order.
+func listValues(service map[interface{}]interface{}, key string) []string {
+	values := []string{}
+	if entries, ok := service[key].([]interface{}); ok {
+		for _, entry := range entries {
+			values = append(values, fmt.Sprint(entry))
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+func maskValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return MaskedValue
+}
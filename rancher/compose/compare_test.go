@@ -0,0 +1,94 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestCompare(t *testing.T) {
+	a, err := Parse(&client.ComposeConfig{
+		DockerComposeConfig: `api:
+  image: nowait/api:1.0
+  environment:
+    MODE: staging
+    DATABASE_PASSWORD: staging
+    DEBUG: "true"
+  labels:
+    tier: api
+  links:
+  - db:db
+  ports:
+  - 8080:80
+db:
+  image: postgres:9.6
+cron:
+  image: nowait/cron
+`,
+		RancherComposeConfig: `api:
+  scale: 1
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Parse(&client.ComposeConfig{
+		DockerComposeConfig: `version: '2'
+services:
+  api:
+    image: nowait/api:1.1
+    environment:
+    - MODE=production
+    - DATABASE_PASSWORD=production
+    - DEBUG=true
+    labels:
+      tier: api
+      canary: "true"
+    external_links:
+    - shared/cache:cache
+    links:
+    - db:db
+    ports:
+    - 80:80
+  db:
+    image: postgres:9.6
+  worker:
+    image: nowait/worker
+`,
+		RancherComposeConfig: `version: '2'
+services:
+  api:
+    scale: 3
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Compare(a, b, DefaultSecretPatterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Change{
+		{Service: "api", Field: FIELD_IMAGE, Old: "nowait/api:1.0", New: "nowait/api:1.1"},
+		{Service: "api", Field: FIELD_ENVIRONMENT, Key: "DATABASE_PASSWORD", Old: MaskedValue, New: MaskedValue},
+		{Service: "api", Field: FIELD_ENVIRONMENT, Key: "MODE", Old: "staging", New: "production"},
+		{Service: "api", Field: FIELD_LABELS, Key: "canary", New: "true"},
+		{Service: "api", Field: FIELD_LINKS, Key: "shared/cache:cache", New: "shared/cache:cache"},
+		{Service: "api", Field: FIELD_SCALE, Old: "1", New: "3"},
+		{Service: "api", Field: FIELD_PORTS, Key: "8080:80", Old: "8080:80"},
+		{Service: "api", Field: FIELD_PORTS, Key: "80:80", New: "80:80"},
+		{Service: "cron", Field: FIELD_SERVICE, Old: "cron"},
+		{Service: "worker", Field: FIELD_SERVICE, New: "worker"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes\n%+v\nreceived\n%+v", expected, changes)
+	}
+
+	if changes, _ := Compare(a, a, nil); len(changes) != 0 {
+		t.Errorf("expected no changes comparing a stack with itself, received %+v", changes)
+	}
+}
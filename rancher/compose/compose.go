@@ -0,0 +1,184 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a parsed docker-compose.yml and rancher-compose.yml pair as
+// exported by Rancher.  Both files are kept as generic yaml maps so keys the
+// cli does not know about survive a round trip.
+type Config struct {
+	Docker  map[interface{}]interface{}
+	Rancher map[interface{}]interface{}
+}
+
+// Parse parses the compose files of an exported stack.
+func Parse(cfg *client.ComposeConfig) (*Config, error) {
+	docker, err := parseYaml(cfg.DockerComposeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker-compose.yml: %v", err)
+	}
+
+	rancher, err := parseYaml(cfg.RancherComposeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rancher-compose.yml: %v", err)
+	}
+
+	return &Config{
+		Docker:  docker,
+		Rancher: rancher,
+	}, nil
+}
+
+func parseYaml(data string) (map[interface{}]interface{}, error) {
+	parsed := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal([]byte(data), &parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// ComposeConfig marshals the compose files.  Map keys are sorted so the
+// output does not depend on the order Rancher exported them in.
+func (cfg *Config) ComposeConfig() (*client.ComposeConfig, error) {
+	docker, err := yaml.Marshal(cfg.Docker)
+	if err != nil {
+		return nil, err
+	}
+
+	rancher, err := yaml.Marshal(cfg.Rancher)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.ComposeConfig{
+		DockerComposeConfig:  string(docker),
+		RancherComposeConfig: string(rancher),
+	}, nil
+}
+
+// Normalize parses and marshals the compose files so they can be compared
+// with rewritten compose files.
+func Normalize(cfg *client.ComposeConfig) (*client.ComposeConfig, error) {
+	parsed, err := Parse(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.ComposeConfig()
+}
+
+// ServiceNames returns the names of the services in the docker-compose.yml in
+// sorted order.
+func (cfg *Config) ServiceNames() []string {
+	names := []string{}
+	for name := range services(cfg.Docker) {
+		if s, ok := name.(string); ok {
+			names = append(names, s)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DockerService returns the docker-compose.yml definition of the service,
+// or nil when it is not defined.
+func (cfg *Config) DockerService(name string) map[interface{}]interface{} {
+	service, _ := services(cfg.Docker)[name].(map[interface{}]interface{})
+	return service
+}
+
+// RancherService returns the rancher-compose.yml definition of the service,
+// creating it when create is set and it does not exist yet.
+func (cfg *Config) RancherService(name string, create bool) map[interface{}]interface{} {
+	all := services(cfg.Rancher)
+	service, ok := all[name].(map[interface{}]interface{})
+	if !ok && create {
+		service = make(map[interface{}]interface{})
+		all[name] = service
+	}
+	return service
+}
+
+// RemoveService removes the service from both compose files.
+func (cfg *Config) RemoveService(name string) {
+	delete(services(cfg.Docker), name)
+	delete(services(cfg.Rancher), name)
+}
+
+// Version 1 compose files define the services at the top level, later
+// versions nest them under services.
+func services(top map[interface{}]interface{}) map[interface{}]interface{} {
+	if _, ok := top["version"]; !ok {
+		return top
+	}
+
+	all, ok := top["services"].(map[interface{}]interface{})
+	if !ok {
+		all = make(map[interface{}]interface{})
+		top["services"] = all
+	}
+	return all
+}
+
+// KeyValues returns the environment or labels of a service, which compose
+// allows to be either a map or a list of key=value strings.
+func KeyValues(service map[interface{}]interface{}, key string) map[string]string {
+	values := make(map[string]string)
+
+	switch entries := service[key].(type) {
+	case map[interface{}]interface{}:
+		for k, v := range entries {
+			if v == nil {
+				values[fmt.Sprint(k)] = ""
+				continue
+			}
+			values[fmt.Sprint(k)] = fmt.Sprint(v)
+		}
+	case []interface{}:
+		for _, entry := range entries {
+			pieces := strings.SplitN(fmt.Sprint(entry), "=", 2)
+			if len(pieces) == 2 {
+				values[pieces[0]] = pieces[1]
+			} else {
+				values[pieces[0]] = ""
+			}
+		}
+	}
+
+	return values
+}
+
+// SetKeyValues replaces the environment or labels of a service, keeping the
+// list form when the service used it.
+func SetKeyValues(service map[interface{}]interface{}, key string, values map[string]string) {
+	if len(values) == 0 {
+		delete(service, key)
+		return
+	}
+
+	keys := []string{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if _, ok := service[key].([]interface{}); ok {
+		entries := []interface{}{}
+		for _, k := range keys {
+			entries = append(entries, k+"="+values[k])
+		}
+		service[key] = entries
+		return
+	}
+
+	entries := make(map[interface{}]interface{})
+	for _, k := range keys {
+		entries[k] = values[k]
+	}
+	service[key] = entries
+}
@@ -0,0 +1,104 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext = 2
+)
+
+// Diff returns a line based diff of a and b in the style of diff -u without
+// hunk headers, or an empty string when they are equal.  Unchanged lines
+// further than a few lines from a change are left out.
+func Diff(name, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", name, name)
+
+	lastPrinted := -1
+	for i, op := range ops {
+		if op.kind == ' ' && !nearChange(ops, i) {
+			continue
+		}
+		if lastPrinted != -1 && i != lastPrinted+1 {
+			buf.WriteString("...\n")
+		}
+		fmt.Fprintf(&buf, "%c%s\n", op.kind, op.line)
+		lastPrinted = i
+	}
+
+	return buf.String()
+}
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func nearChange(ops []diffOp, i int) bool {
+	for j := i - diffContext; j <= i+diffContext; j++ {
+		if j >= 0 && j < len(ops) && ops[j].kind != ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// Compute the diff from the longest common subsequence of the lines, the
+// compose files of a stack are small enough for the quadratic table.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
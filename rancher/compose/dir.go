@@ -0,0 +1,188 @@
+package compose
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	DOCKER_COMPOSE_FILE  = "docker-compose.yml"
+	RANCHER_COMPOSE_FILE = "rancher-compose.yml"
+)
+
+var (
+	// Environment variables whose values are masked by default
+	DefaultSecretPatterns = []string{"*PASSWORD*", "*SECRET*", "*TOKEN*", "*_KEY", "*PRIVATE*", "*CREDENTIALS*"}
+
+	variablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+	// Macros Rancher expands itself, for example in scheduling labels
+	rancherMacros = map[string]bool{
+		"stack_name":     true,
+		"service_name":   true,
+		"container_name": true,
+	}
+)
+
+// Stack is a named pair of compose files stored in a directory.
+type Stack struct {
+	Name   string
+	Config *client.ComposeConfig
+}
+
+// WriteStack writes the compose files of the stack into dir/name.
+func WriteStack(dir string, stack Stack) error {
+	stackDir := filepath.Join(dir, stack.Name)
+	if err := os.MkdirAll(stackDir, 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(stackDir, DOCKER_COMPOSE_FILE), []byte(stack.Config.DockerComposeConfig), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(stackDir, RANCHER_COMPOSE_FILE), []byte(stack.Config.RancherComposeConfig), 0644)
+}
+
+// ReadStacks reads every sub directory of dir that contains a
+// docker-compose.yml as a stack named after the directory.  The
+// rancher-compose.yml is optional.
+func ReadStacks(dir string) ([]Stack, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	stacks := []Stack{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		docker, err := ioutil.ReadFile(filepath.Join(dir, entry.Name(), DOCKER_COMPOSE_FILE))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rancher, err := ioutil.ReadFile(filepath.Join(dir, entry.Name(), RANCHER_COMPOSE_FILE))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		stacks = append(stacks, Stack{
+			Name: entry.Name(),
+			Config: &client.ComposeConfig{
+				DockerComposeConfig:  string(docker),
+				RancherComposeConfig: string(rancher),
+			},
+		})
+	}
+
+	return stacks, nil
+}
+
+// MaskSecrets replaces the values of the environment variables whose names
+// match one of the globs, ignoring case, with ${SERVICE_NAME} placeholders
+// and returns the placeholder names in sorted order.
+func (cfg *Config) MaskSecrets(globs []string) ([]string, error) {
+	patterns := []*regexp.Regexp{}
+	for _, glob := range globs {
+		re, err := GlobToRegexp(strings.ToUpper(glob))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %s: %v", glob, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	variables := []string{}
+	for _, name := range cfg.ServiceNames() {
+		service := cfg.DockerService(name)
+		env := KeyValues(service, "environment")
+		masked := false
+
+		for key, value := range env {
+			if !matchesAnyPattern(strings.ToUpper(key), patterns) || variablePattern.MatchString(value) {
+				continue
+			}
+			variable := placeholderName(name, key)
+			env[key] = "${" + variable + "}"
+			variables = append(variables, variable)
+			masked = true
+		}
+
+		if masked {
+			SetKeyValues(service, "environment", env)
+		}
+	}
+
+	sort.Strings(variables)
+	return variables, nil
+}
+
+// MaskEnvironment returns a copy of the environment with the values of the
+// variables whose names match one of the globs, ignoring case, masked.
+func MaskEnvironment(env map[string]interface{}, globs []string) (map[string]interface{}, error) {
+	patterns := []*regexp.Regexp{}
+	for _, glob := range globs {
+		re, err := GlobToRegexp(strings.ToUpper(glob))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %s: %v", glob, err)
+		}
+		patterns = append(patterns, re)
+	}
+	if env == nil {
+		return nil, nil
+	}
+
+	masked := make(map[string]interface{})
+	for key, value := range env {
+		if matchesAnyPattern(strings.ToUpper(key), patterns) {
+			value = maskValue(fmt.Sprint(value))
+		}
+		masked[key] = value
+	}
+	return masked, nil
+}
+
+// Variables returns the names of the ${VAR} placeholders used in the compose
+// files in sorted order, leaving out the macros Rancher expands itself.
+func Variables(cfg *client.ComposeConfig) []string {
+	names := []string{}
+	for _, data := range []string{cfg.DockerComposeConfig, cfg.RancherComposeConfig} {
+		for _, match := range variablePattern.FindAllStringSubmatch(data, -1) {
+			if !rancherMacros[match[1]] {
+				names = append(names, match[1])
+			}
+		}
+	}
+	sort.Strings(names)
+	return uniqueStrings(names)
+}
+
+func placeholderName(service, key string) string {
+	name := strings.ToUpper(service + "_" + key)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func matchesAnyPattern(s string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
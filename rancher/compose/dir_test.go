@@ -0,0 +1,104 @@
+package compose
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestMaskSecrets(t *testing.T) {
+	cfg, err := Parse(&client.ComposeConfig{
+		DockerComposeConfig: `api:
+  environment:
+    DATABASE_PASSWORD: hunter2
+    database_host: db
+    API_TOKEN: ${ALREADY_MASKED}
+worker-1:
+  environment:
+  - AWS_SECRET_ACCESS_KEY=abc
+  - STRIPE_KEY=def
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables, err := cfg.MaskSecrets(DefaultSecretPatterns)
+	if err != nil {
+		t.Fatalf("masking secrets failed: %v", err)
+	}
+
+	expected := []string{"API_DATABASE_PASSWORD", "WORKER_1_AWS_SECRET_ACCESS_KEY", "WORKER_1_STRIPE_KEY"}
+	if !reflect.DeepEqual(variables, expected) {
+		t.Errorf("expected placeholders %v but received %v", expected, variables)
+	}
+
+	masked, err := cfg.ComposeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCompose := `api:
+  environment:
+    API_TOKEN: ${ALREADY_MASKED}
+    DATABASE_PASSWORD: ${API_DATABASE_PASSWORD}
+    database_host: db
+worker-1:
+  environment:
+  - AWS_SECRET_ACCESS_KEY=${WORKER_1_AWS_SECRET_ACCESS_KEY}
+  - STRIPE_KEY=${WORKER_1_STRIPE_KEY}
+`
+	if masked.DockerComposeConfig != expectedCompose {
+		t.Errorf("expected compose\n%s\nbut received\n%s", expectedCompose, masked.DockerComposeConfig)
+	}
+
+	expectedVariables := []string{"ALREADY_MASKED", "API_DATABASE_PASSWORD", "WORKER_1_AWS_SECRET_ACCESS_KEY", "WORKER_1_STRIPE_KEY"}
+	if found := Variables(masked); !reflect.DeepEqual(found, expectedVariables) {
+		t.Errorf("expected variables %v but received %v", expectedVariables, found)
+	}
+}
+
+func TestVariablesSkipsRancherMacros(t *testing.T) {
+	cfg := &client.ComposeConfig{
+		DockerComposeConfig: `api:
+  image: nowait/api:${API_TAG}
+  labels:
+    io.rancher.scheduler.affinity:container_label_ne: io.rancher.stack_service.name=${stack_name}/${service_name}
+`,
+	}
+
+	if found := Variables(cfg); !reflect.DeepEqual(found, []string{"API_TAG"}) {
+		t.Errorf("expected only API_TAG but received %v", found)
+	}
+}
+
+func TestWriteAndReadStacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stacks := []Stack{
+		{Name: "api", Config: &client.ComposeConfig{DockerComposeConfig: "api:\n  image: nowait/api\n", RancherComposeConfig: "api:\n  scale: 2\n"}},
+		{Name: "web", Config: &client.ComposeConfig{DockerComposeConfig: "web:\n  image: nowait/web\n", RancherComposeConfig: ""}},
+	}
+	for _, stack := range stacks {
+		if err := WriteStack(dir, stack); err != nil {
+			t.Fatalf("writing stack %s failed: %v", stack.Name, err)
+		}
+	}
+	if err := os.Mkdir(dir+"/not-a-stack", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadStacks(dir)
+	if err != nil {
+		t.Fatalf("reading stacks failed: %v", err)
+	}
+	if !reflect.DeepEqual(read, stacks) {
+		t.Errorf("expected stacks %+v but received %+v", stacks, read)
+	}
+}
@@ -0,0 +1,22 @@
+package compose
+
+import (
+	"regexp"
+)
+
+// GlobToRegexp converts a glob, where * matches any text and ? a single
+// character, into an anchored regexp.
+func GlobToRegexp(glob string) (*regexp.Regexp, error) {
+	expr := ""
+	for _, r := range glob {
+		switch r {
+		case '*':
+			expr += ".*"
+		case '?':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(string(r))
+		}
+	}
+	return regexp.Compile("^" + expr + "$")
+}
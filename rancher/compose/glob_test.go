@@ -0,0 +1,30 @@
+package compose
+
+import (
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		Glob    string
+		Name    string
+		Matches bool
+	}{
+		{Glob: "api*", Name: "api-worker", Matches: true},
+		{Glob: "api*", Name: "web-api", Matches: false},
+		{Glob: "api-?", Name: "api-1", Matches: true},
+		{Glob: "api-?", Name: "api-10", Matches: false},
+		{Glob: "nowait/*.io", Name: "nowait/api.io", Matches: true},
+		{Glob: "nowait/*.io", Name: "nowait/apixio", Matches: false},
+	}
+
+	for _, test := range tests {
+		re, err := GlobToRegexp(test.Glob)
+		if err != nil {
+			t.Fatalf("compiling %s failed with: %v", test.Glob, err)
+		}
+		if re.MatchString(test.Name) != test.Matches {
+			t.Errorf("expected %s matching %s to be %v", test.Glob, test.Name, test.Matches)
+		}
+	}
+}
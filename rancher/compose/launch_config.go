@@ -0,0 +1,154 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+// LaunchConfig converts the compose definition of a service into the parts
+// of a launch config the validators look at: image, environment, labels,
+// ports, privileged, memory limit and health check.
+func (cfg *Config) LaunchConfig(name string) (*client.LaunchConfig, error) {
+	service := cfg.DockerService(name)
+	if service == nil {
+		return nil, fmt.Errorf("service %s is not defined in the docker-compose.yml", name)
+	}
+
+	lc := &client.LaunchConfig{
+		Environment: stringMap(KeyValues(service, "environment")),
+		Labels:      stringMap(KeyValues(service, "labels")),
+	}
+
+	if image, ok := service["image"].(string); ok {
+		lc.ImageUuid = "docker:" + image
+	}
+
+	if ports, ok := service["ports"].([]interface{}); ok {
+		for _, port := range ports {
+			lc.Ports = append(lc.Ports, fmt.Sprint(port))
+		}
+	}
+
+	if privileged, ok := service["privileged"].(bool); ok {
+		lc.Privileged = privileged
+	}
+
+	if limit, ok := service["mem_limit"]; ok {
+		memory, err := parseMemory(fmt.Sprint(limit))
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %v", name, err)
+		}
+		lc.Memory = memory
+	}
+
+	if rancher := cfg.RancherService(name, false); rancher != nil {
+		if _, ok := rancher["health_check"]; ok {
+			lc.HealthCheck = &client.InstanceHealthCheck{}
+		}
+	}
+
+	return lc, nil
+}
+
+// Scale returns the scale of the service in the rancher-compose.yml.
+func (cfg *Config) Scale(name string) (int64, bool) {
+	scale, ok := cfg.RancherService(name, false)["scale"].(int)
+	return int64(scale), ok
+}
+
+// UpgradeStrategy returns the batch size and start first setting of the
+// service's upgrade_strategy, defaulting to Rancher's batches of one that
+// stop the old containers first.
+func (cfg *Config) UpgradeStrategy(name string) (int64, bool) {
+	batchSize, startFirst := int64(1), false
+
+	strategy, ok := cfg.RancherService(name, false)["upgrade_strategy"].(map[interface{}]interface{})
+	if !ok {
+		return batchSize, startFirst
+	}
+	if size, ok := strategy["batch_size"].(int); ok && size > 0 {
+		batchSize = int64(size)
+	}
+	if first, ok := strategy["start_first"].(bool); ok {
+		startFirst = first
+	}
+	return batchSize, startFirst
+}
+
+// Sidekicks returns the names of the sidekicks of the service.
+func (cfg *Config) Sidekicks(name string) []string {
+	sidekicks := []string{}
+	for _, sidekick := range strings.Split(KeyValues(cfg.DockerService(name), "labels")[LABEL_SIDEKICKS], ",") {
+		if sidekick = strings.TrimSpace(sidekick); sidekick != "" {
+			sidekicks = append(sidekicks, sidekick)
+		}
+	}
+	return sidekicks
+}
+
+// Parse a compose memory limit such as 512m or 1g into bytes.
+func parseMemory(limit string) (int64, error) {
+	limit = strings.ToLower(strings.TrimSpace(limit))
+	multiplier := int64(1)
+
+	units := map[string]int64{"b": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30}
+	for suffix, unit := range units {
+		if strings.HasSuffix(limit, suffix) {
+			limit = strings.TrimSuffix(limit, suffix)
+			multiplier = unit
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mem_limit %s", limit)
+	}
+	return value * multiplier, nil
+}
+
+func stringMap(values map[string]string) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range values {
+		result[key] = value
+	}
+	return result
+}
+
+// ResolveVariables returns the values of the ${VAR} placeholders used by the
+// compose files, and the placeholders without a value.
+func ResolveVariables(cfg *client.ComposeConfig, vars map[string]string) (map[string]interface{}, []string) {
+	resolved := make(map[string]interface{})
+	missing := []string{}
+
+	for _, variable := range Variables(cfg) {
+		value, ok := vars[variable]
+		if !ok {
+			missing = append(missing, variable)
+			continue
+		}
+		resolved[variable] = value
+	}
+	return resolved, missing
+}
+
+// SubstituteVariables returns the compose files with the ${VAR} placeholders
+// that have a value in vars replaced by it.
+func SubstituteVariables(cfg *client.ComposeConfig, vars map[string]string) *client.ComposeConfig {
+	substitute := func(data string) string {
+		return variablePattern.ReplaceAllStringFunc(data, func(placeholder string) string {
+			if value, ok := vars[variablePattern.FindStringSubmatch(placeholder)[1]]; ok {
+				return value
+			}
+			return placeholder
+		})
+	}
+
+	return &client.ComposeConfig{
+		DockerComposeConfig:  substitute(cfg.DockerComposeConfig),
+		RancherComposeConfig: substitute(cfg.RancherComposeConfig),
+	}
+}
@@ -0,0 +1,111 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestLaunchConfig(t *testing.T) {
+	cfg, err := Parse(&client.ComposeConfig{
+		DockerComposeConfig: `version: '2'
+services:
+  api:
+    image: nowait/api:1.0
+    privileged: true
+    mem_limit: 512m
+    ports:
+    - 8080:80
+    environment:
+    - MODE=production
+    labels:
+      io.rancher.sidekicks: api-code, api-logs
+  api-code:
+    image: nowait/api-code:1.0
+  api-logs:
+    image: nowait/logs
+`,
+		RancherComposeConfig: `version: '2'
+services:
+  api:
+    scale: 2
+    health_check:
+      port: 80
+    upgrade_strategy:
+      batch_size: 2
+      start_first: true
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc, err := cfg.LaunchConfig("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &client.LaunchConfig{
+		ImageUuid:   "docker:nowait/api:1.0",
+		Privileged:  true,
+		Memory:      512 << 20,
+		Ports:       []string{"8080:80"},
+		Environment: map[string]interface{}{"MODE": "production"},
+		Labels:      map[string]interface{}{LABEL_SIDEKICKS: "api-code, api-logs"},
+		HealthCheck: &client.InstanceHealthCheck{},
+	}
+	if !reflect.DeepEqual(lc, expected) {
+		t.Errorf("expected launch config %+v, received %+v", expected, lc)
+	}
+
+	if sidekicks := cfg.Sidekicks("api"); !reflect.DeepEqual(sidekicks, []string{"api-code", "api-logs"}) {
+		t.Errorf("expected the sidekicks of api, received %v", sidekicks)
+	}
+	if scale, ok := cfg.Scale("api"); !ok || scale != 2 {
+		t.Errorf("expected scale 2, received %d", scale)
+	}
+	if _, ok := cfg.Scale("api-code"); ok {
+		t.Errorf("expected services without a scale to report none")
+	}
+	if batch, first := cfg.UpgradeStrategy("api"); batch != 2 || !first {
+		t.Errorf("expected batches of 2 starting first, received %d %t", batch, first)
+	}
+	if batch, first := cfg.UpgradeStrategy("api-code"); batch != 1 || first {
+		t.Errorf("expected the default upgrade strategy, received %d %t", batch, first)
+	}
+	if _, err := cfg.LaunchConfig("db"); err == nil {
+		t.Errorf("expected an error for a service that is not defined")
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := map[string]int64{
+		"1024": 1024,
+		"64k":  64 << 10,
+		"512M": 512 << 20,
+		"2g":   2 << 30,
+		"10b":  10,
+	}
+	for limit, expected := range tests {
+		if memory, err := parseMemory(limit); err != nil || memory != expected {
+			t.Errorf("expected %s to be %d bytes, received %d %v", limit, expected, memory, err)
+		}
+	}
+	if _, err := parseMemory("lots"); err == nil {
+		t.Errorf("expected an invalid mem_limit to fail")
+	}
+}
+
+func TestSubstituteVariables(t *testing.T) {
+	cfg := SubstituteVariables(&client.ComposeConfig{
+		DockerComposeConfig:  "api:\n  image: nowait/api:${API_TAG}\n  labels:\n    name: ${stack_name}/${MISSING}\n",
+		RancherComposeConfig: "api:\n  scale: ${API_SCALE}\n",
+	}, map[string]string{"API_TAG": "1.1", "API_SCALE": "3"})
+
+	if cfg.DockerComposeConfig != "api:\n  image: nowait/api:1.1\n  labels:\n    name: ${stack_name}/${MISSING}\n" {
+		t.Errorf("unexpected docker compose after substitution:\n%s", cfg.DockerComposeConfig)
+	}
+	if cfg.RancherComposeConfig != "api:\n  scale: 3\n" {
+		t.Errorf("unexpected rancher compose after substitution:\n%s", cfg.RancherComposeConfig)
+	}
+}
@@ -0,0 +1,80 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Images returns the images of every service in sorted order.
+func (cfg *Config) Images() []string {
+	images := []string{}
+	for _, name := range cfg.ServiceNames() {
+		if image, ok := cfg.DockerService(name)["image"].(string); ok {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return uniqueStrings(images)
+}
+
+// Certificates returns the names of the certificates the load balancers of
+// the stack use, either at the top level of a service in version 1
+// rancher-compose files or in its lb_config.
+func (cfg *Config) Certificates() []string {
+	certs := []string{}
+	for name := range services(cfg.Rancher) {
+		service, ok := services(cfg.Rancher)[name].(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+
+		certs = append(certs, certificateNames(service)...)
+		if lbConfig, ok := service["lb_config"].(map[interface{}]interface{}); ok {
+			certs = append(certs, certificateNames(lbConfig)...)
+		}
+	}
+	sort.Strings(certs)
+	return uniqueStrings(certs)
+}
+
+func certificateNames(config map[interface{}]interface{}) []string {
+	names := []string{}
+	if cert, ok := config["default_cert"].(string); ok && cert != "" {
+		names = append(names, cert)
+	}
+	if certs, ok := config["certs"].([]interface{}); ok {
+		for _, cert := range certs {
+			names = append(names, fmt.Sprint(cert))
+		}
+	}
+	return names
+}
+
+// ExternalLinks returns the stack/service names the services of the stack
+// link to outside of the stack, without aliases.
+func (cfg *Config) ExternalLinks() []string {
+	links := []string{}
+	for _, name := range cfg.ServiceNames() {
+		entries, ok := cfg.DockerService(name)["external_links"].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, entry := range entries {
+			links = append(links, strings.SplitN(fmt.Sprint(entry), ":", 2)[0])
+		}
+	}
+	sort.Strings(links)
+	return uniqueStrings(links)
+}
+
+// Remove duplicates from a sorted slice.
+func uniqueStrings(sorted []string) []string {
+	unique := []string{}
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
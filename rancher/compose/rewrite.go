@@ -0,0 +1,199 @@
+package compose
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	LABEL_HOST_AFFINITY = "io.rancher.scheduler.affinity:host_label"
+	LABEL_SIDEKICKS     = "io.rancher.sidekicks"
+)
+
+// RewriteRules change the exported compose files of a stack before it is
+// created somewhere else.  Every matching rule is applied in order.
+type RewriteRules struct {
+	Rules []RewriteRule `yaml:"rules" json:"rules"`
+}
+
+// RewriteRule applies to the services matching the stack and service globs,
+// an empty glob matches everything.
+type RewriteRule struct {
+	Stack   string `yaml:"stack" json:"stack,omitempty"`
+	Service string `yaml:"service" json:"service,omitempty"`
+
+	// Env sets environment variables to the given values
+	Env map[string]string `yaml:"env" json:"env,omitempty"`
+	// ReplaceEnv replaces substrings in the values of every environment variable
+	ReplaceEnv map[string]string `yaml:"replace_env" json:"replaceEnv,omitempty"`
+	// ImageTag replaces the tag or digest of the service image
+	ImageTag string `yaml:"image_tag" json:"imageTag,omitempty"`
+	Scale    *int64 `yaml:"scale" json:"scale,omitempty"`
+	// HostAffinity replaces the host labels the service is scheduled on, an
+	// empty map removes the host affinity
+	HostAffinity map[string]string `yaml:"host_affinity" json:"hostAffinity,omitempty"`
+	Drop         bool              `yaml:"drop" json:"drop,omitempty"`
+
+	stack   *regexp.Regexp
+	service *regexp.Regexp
+}
+
+// LoadRewriteRules reads the rewrite rules at path.
+func LoadRewriteRules(path string) (*RewriteRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &RewriteRules{}
+	if err := yaml.Unmarshal(data, rules); err != nil {
+		return nil, fmt.Errorf("invalid rewrite rules %s: %v", path, err)
+	}
+
+	if err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("invalid rewrite rules %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+func (rules *RewriteRules) compile() error {
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.stack != nil {
+			continue
+		}
+
+		var err error
+		if rule.stack, err = globToRegexp(rule.Stack); err != nil {
+			return err
+		}
+		if rule.service, err = globToRegexp(rule.Service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrite applies the rules to the compose files of the stack.
+func (rules *RewriteRules) Rewrite(stack string, cfg *client.ComposeConfig) (*client.ComposeConfig, error) {
+	if err := rules.compile(); err != nil {
+		return nil, err
+	}
+
+	parsed, err := Parse(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules.Rules {
+		if !rule.stack.MatchString(stack) {
+			continue
+		}
+
+		for _, name := range parsed.ServiceNames() {
+			if rule.service.MatchString(name) {
+				rule.apply(parsed, name)
+			}
+		}
+	}
+
+	return parsed.ComposeConfig()
+}
+
+func (rule RewriteRule) apply(cfg *Config, name string) {
+	if rule.Drop {
+		cfg.RemoveService(name)
+		return
+	}
+
+	service := cfg.DockerService(name)
+	if service == nil {
+		return
+	}
+
+	if len(rule.Env) > 0 || len(rule.ReplaceEnv) > 0 {
+		env := KeyValues(service, "environment")
+		for key, value := range env {
+			env[key] = replaceAll(value, rule.ReplaceEnv)
+		}
+		for key, value := range rule.Env {
+			env[key] = value
+		}
+		SetKeyValues(service, "environment", env)
+	}
+
+	if image, ok := service["image"].(string); ok && rule.ImageTag != "" {
+		service["image"] = withTag(image, rule.ImageTag)
+	}
+
+	if rule.Scale != nil {
+		cfg.RancherService(name, true)["scale"] = *rule.Scale
+	}
+
+	if rule.HostAffinity != nil {
+		labels := KeyValues(service, "labels")
+		delete(labels, LABEL_HOST_AFFINITY)
+		if len(rule.HostAffinity) > 0 {
+			labels[LABEL_HOST_AFFINITY] = joinLabels(rule.HostAffinity)
+		}
+		SetKeyValues(service, "labels", labels)
+	}
+}
+
+// Replace longer substrings first so overlapping replacements are stable.
+func replaceAll(value string, replacements map[string]string) string {
+	olds := []string{}
+	for old := range replacements {
+		olds = append(olds, old)
+	}
+	sort.Sort(byLengthDesc(olds))
+
+	for _, old := range olds {
+		value = strings.Replace(value, old, replacements[old], -1)
+	}
+	return value
+}
+
+type byLengthDesc []string
+
+func (s byLengthDesc) Len() int      { return len(s) }
+func (s byLengthDesc) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byLengthDesc) Less(i, j int) bool {
+	if len(s[i]) != len(s[j]) {
+		return len(s[i]) > len(s[j])
+	}
+	return s[i] < s[j]
+}
+
+// Replace the tag or digest of an image, keeping the registry port.
+func withTag(image, tag string) string {
+	if pos := strings.Index(image, "@"); pos != -1 {
+		image = image[:pos]
+	} else if pos := strings.LastIndex(image, ":"); pos > strings.LastIndex(image, "/") {
+		image = image[:pos]
+	}
+	return image + ":" + tag
+}
+
+func joinLabels(labels map[string]string) string {
+	pairs := []string{}
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// An empty glob matches every name.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	if glob == "" {
+		glob = "*"
+	}
+	return GlobToRegexp(glob)
+}
@@ -0,0 +1,158 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	dockerCompose = `version: '2'
+services:
+  api:
+    image: registry.example.com:5000/nowait/api:1.0
+    environment:
+      DATABASE_HOST: db.prod.example.com
+      LOG_LEVEL: info
+    labels:
+      io.rancher.scheduler.affinity:host_label: tier=production
+  api-worker:
+    image: nowait/api@sha256:abc
+    environment:
+    - QUEUE_HOST=queue.prod.example.com
+  web:
+    image: nowait/web:2.0
+    environment:
+      API_HOST: api.prod.example.com
+`
+	rancherCompose = `version: '2'
+services:
+  api:
+    scale: 4
+  web:
+    scale: 2
+`
+)
+
+func TestRewrite(t *testing.T) {
+	rules, err := LoadRewriteRules("../../fixtures/rewrite.yml")
+	if err != nil {
+		t.Fatalf("loading the rewrite rules failed: %v", err)
+	}
+
+	rewritten, err := rules.Rewrite("frontend", &client.ComposeConfig{
+		DockerComposeConfig:  dockerCompose,
+		RancherComposeConfig: rancherCompose,
+	})
+	if err != nil {
+		t.Fatalf("rewriting failed: %v", err)
+	}
+
+	cfg, err := Parse(rewritten)
+	if err != nil {
+		t.Fatalf("parsing the rewritten compose failed: %v", err)
+	}
+
+	api := cfg.DockerService("api")
+	if api["image"] != "registry.example.com:5000/nowait/api:staging" {
+		t.Errorf("expected the api image tag to be replaced, received %v", api["image"])
+	}
+	expectedEnv := map[string]string{"DATABASE_HOST": "db.staging.example.com", "LOG_LEVEL": "debug"}
+	if env := KeyValues(api, "environment"); !reflect.DeepEqual(env, expectedEnv) {
+		t.Errorf("expected api env %v but received %v", expectedEnv, env)
+	}
+	if labels := KeyValues(api, "labels"); labels[LABEL_HOST_AFFINITY] != "tier=staging" {
+		t.Errorf("expected the api host affinity to be replaced, received %v", labels)
+	}
+	if scale := cfg.RancherService("api", false)["scale"]; scale != 1 {
+		t.Errorf("expected the api scale to be 1, received %v", scale)
+	}
+
+	worker := cfg.DockerService("api-worker")
+	if worker == nil {
+		t.Fatal("workers should only be dropped from the backend stack")
+	}
+	if worker["image"] != "nowait/api:staging" {
+		t.Errorf("expected the worker digest to be replaced by the tag, received %v", worker["image"])
+	}
+	expectedList := []interface{}{"LOG_LEVEL=debug", "QUEUE_HOST=queue.staging.example.com"}
+	if !reflect.DeepEqual(worker["environment"], expectedList) {
+		t.Errorf("expected worker env list %v but received %v", expectedList, worker["environment"])
+	}
+	if scale := cfg.RancherService("api-worker", false)["scale"]; scale != 1 {
+		t.Errorf("expected the worker scale to be added, received %v", scale)
+	}
+
+	web := cfg.DockerService("web")
+	if web["image"] != "nowait/web:2.0" || KeyValues(web, "environment")["API_HOST"] != "api.staging.example.com" {
+		t.Errorf("expected only the env of web to be replaced, received %v", web)
+	}
+	if scale := cfg.RancherService("web", false)["scale"]; scale != 2 {
+		t.Errorf("expected the web scale to be kept, received %v", scale)
+	}
+}
+
+func TestRewriteDropsServices(t *testing.T) {
+	rules, err := LoadRewriteRules("../../fixtures/rewrite.yml")
+	if err != nil {
+		t.Fatalf("loading the rewrite rules failed: %v", err)
+	}
+
+	rewritten, err := rules.Rewrite("backend", &client.ComposeConfig{
+		DockerComposeConfig:  dockerCompose,
+		RancherComposeConfig: rancherCompose,
+	})
+	if err != nil {
+		t.Fatalf("rewriting failed: %v", err)
+	}
+
+	cfg, err := Parse(rewritten)
+	if err != nil {
+		t.Fatalf("parsing the rewritten compose failed: %v", err)
+	}
+
+	if names := cfg.ServiceNames(); !reflect.DeepEqual(names, []string{"api", "web"}) {
+		t.Errorf("expected the worker to be dropped, received services %v", names)
+	}
+	if cfg.RancherService("api-worker", false) != nil {
+		t.Error("expected the worker to be dropped from the rancher compose")
+	}
+}
+
+func TestRewriteVersionOneCompose(t *testing.T) {
+	scale := int64(3)
+	rules := &RewriteRules{
+		Rules: []RewriteRule{{Service: "web", Scale: &scale, ImageTag: "3.0"}},
+	}
+
+	rewritten, err := rules.Rewrite("frontend", &client.ComposeConfig{
+		DockerComposeConfig:  "web:\n  image: nowait/web:2.0\n",
+		RancherComposeConfig: "",
+	})
+	if err != nil {
+		t.Fatalf("rewriting failed: %v", err)
+	}
+
+	expected := &client.ComposeConfig{
+		DockerComposeConfig:  "web:\n  image: nowait/web:3.0\n",
+		RancherComposeConfig: "web:\n  scale: 3\n",
+	}
+	if !reflect.DeepEqual(rewritten, expected) {
+		t.Errorf("expected %+v but received %+v", expected, rewritten)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "a: 1\nb: 2\nc: 3\nd: 4\ne: 5\nf: 6\ng: 7\n"
+	b := "a: 1\nb: 2\nc: 3\nd: 40\ne: 5\nf: 6\ng: 7\nh: 8\n"
+
+	expected := "--- file\n+++ file\n b: 2\n c: 3\n-d: 4\n+d: 40\n e: 5\n f: 6\n g: 7\n+h: 8\n"
+	if diff := Diff("file", a, b); diff != expected {
+		t.Errorf("expected diff\n%s\nbut received\n%s", expected, diff)
+	}
+
+	if diff := Diff("file", a, a); diff != "" {
+		t.Errorf("expected no diff for equal files, received %s", diff)
+	}
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DEFAULT_MAX_SERVICES = 10
+)
+
+// CliConfig is the optional configuration file of the cli.  It holds named
+// contexts so a single installation can talk to several Rancher servers or
+// use several API keys.
+type CliConfig struct {
+	Contexts   map[string]Context             `yaml:"contexts"`
+	Registries map[string]RegistryCredentials `yaml:"registries"`
+	// Names of the environments destructive commands must be confirmed for
+	Protected []string `yaml:"protected"`
+	// Number of services a single upgrade may select without --max-services
+	MaxServices int `yaml:"max_services"`
+	// Periods in which nothing may be deployed
+	Freezes []Freeze      `yaml:"freezes"`
+	History HistoryConfig `yaml:"history"`
+	// Where deployments are announced
+	Notifiers []Notifier `yaml:"notifiers"`
+}
+
+// HistoryConfig selects where the launch configs of upgraded services are
+// kept, rancher or local, and how many of them.
+type HistoryConfig struct {
+	Store string `yaml:"store"`
+	Keep  int    `yaml:"keep"`
+}
+
+// Context holds the credentials needed to talk to a Rancher server.
+type Context struct {
+	Url       string `yaml:"url"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// Every environment of a protected context is protected
+	Protected bool `yaml:"protected"`
+}
+
+// RegistryCredentials are used to talk to the docker registry with a given host.
+type RegistryCredentials struct {
+	Url      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// DefaultCliConfigPath returns the path of the cli configuration file, which
+// can be overridden with the RANCHER_CLI_CONFIG environment variable.
+func DefaultCliConfigPath() string {
+	if path := os.Getenv("RANCHER_CLI_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".rancher-cli.yml")
+}
+
+// DefaultStateDir returns the directory the cli keeps its own records in,
+// which can be overridden with the RANCHER_CLI_STATE_DIR environment variable.
+func DefaultStateDir() string {
+	if path := os.Getenv("RANCHER_CLI_STATE_DIR"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".rancher-cli")
+}
+
+// LoadCliConfig reads the cli configuration at path.  A missing file is not
+// an error and results in an empty configuration.
+func LoadCliConfig(path string) (*CliConfig, error) {
+	conf := &CliConfig{
+		Contexts: make(map[string]Context),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("invalid cli config %s: %v", path, err)
+	}
+
+	if conf.Contexts == nil {
+		conf.Contexts = make(map[string]Context)
+	}
+	for _, freeze := range conf.Freezes {
+		if err := freeze.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid cli config %s: %v", path, err)
+		}
+	}
+	// Names must be unique: the parsed templates are keyed by name/event and
+	// notify test redirects each notifier to the stand-in url + "/" + name
+	names := make(map[string]bool)
+	for _, notifier := range conf.Notifiers {
+		if err := notifier.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid cli config %s: %v", path, err)
+		}
+		if names[notifier.Name] {
+			return nil, fmt.Errorf("invalid cli config %s: more than one notifier is named %s", path, notifier.Name)
+		}
+		names[notifier.Name] = true
+	}
+	return conf, nil
+}
+
+// Context returns the context with the given name.
+func (conf *CliConfig) Context(name string) (Context, error) {
+	ctx, ok := conf.Contexts[name]
+	if !ok {
+		return Context{}, fmt.Errorf("context %s is not defined in the cli config", name)
+	}
+	return ctx, nil
+}
+
+// ContextNames returns the names of all configured contexts in sorted order.
+func (conf *CliConfig) ContextNames() []string {
+	names := []string{}
+	for name := range conf.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsProtected reports whether the environment is protected, either by name
+// or because the named context is.  An empty context stands for the CATTLE_*
+// environment variables.
+func (conf *CliConfig) IsProtected(context, project string) bool {
+	if ctx, ok := conf.Contexts[context]; ok && context != "" && ctx.Protected {
+		return true
+	}
+	for _, name := range conf.Protected {
+		if name == project {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceLimit returns the number of services a single upgrade may select
+// without passing --max-services.
+func (conf *CliConfig) ServiceLimit() int {
+	if conf.MaxServices > 0 {
+		return conf.MaxServices
+	}
+	return DEFAULT_MAX_SERVICES
+}
+
+// RegistryCredentials returns the credentials for the registry host.  The
+// DOCKER_REGISTRY_* environment variables are used for the host of
+// DOCKER_REGISTRY_URL, or Docker Hub when it is not set, any other host not in
+// the config is used anonymously.
+func (conf *CliConfig) RegistryCredentials(host string) RegistryCredentials {
+	if creds, ok := conf.Registries[host]; ok {
+		if creds.Url == "" {
+			creds.Url = "https://" + host
+		}
+		return creds
+	}
+
+	if host == environmentRegistryHost() {
+		creds := RegistryCredentials{
+			Url:      registryUrl,
+			Username: username,
+			Password: password,
+		}
+		if creds.Url == "" {
+			creds.Url = DOCKER_HUB_REGISTRY
+		}
+		return creds
+	}
+
+	if host == DOCKER_HUB_HOST {
+		return RegistryCredentials{Url: DOCKER_HUB_REGISTRY}
+	}
+	return RegistryCredentials{
+		Url: "https://" + host,
+	}
+}
+
+// The registry host the DOCKER_REGISTRY_* environment variables belong to.
+func environmentRegistryHost() string {
+	if registryUrl == "" {
+		return DOCKER_HUB_HOST
+	}
+
+	u, err := url.Parse(registryUrl)
+	if err != nil {
+		return ""
+	}
+	switch u.Host {
+	case "index.docker.io", "registry-1.docker.io", DOCKER_HUB_HOST:
+		return DOCKER_HUB_HOST
+	}
+	return u.Host
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadCliConfig(t *testing.T) {
+	conf, err := LoadCliConfig("../../fixtures/rancher-cli.yml")
+
+	if err != nil {
+		t.Fatalf("loading cli config failed with: %v", err)
+	}
+
+	if names := conf.ContextNames(); !reflect.DeepEqual(names, []string{"old", "production"}) {
+		t.Errorf("expected contexts old and production but received %v", names)
+	}
+
+	ctx, err := conf.Context("production")
+
+	if err != nil || ctx.Url != "https://rancher.example.com/v1" || ctx.AccessKey != "production-access-key" || ctx.SecretKey != "production-secret-key" {
+		t.Errorf("production context was not read correctly: %#v", ctx)
+	}
+
+	if _, err := conf.Context("missing"); err == nil {
+		t.Errorf("retrieving an undefined context should fail")
+	}
+}
+
+func TestLoadCliConfigMissingFile(t *testing.T) {
+	conf, err := LoadCliConfig("../../fixtures/does-not-exist.yml")
+
+	if err != nil {
+		t.Errorf("a missing cli config should not be an error: %v", err)
+	}
+
+	if len(conf.ContextNames()) != 0 {
+		t.Errorf("a missing cli config should not define any contexts")
+	}
+}
+
+func TestCliConfigProtection(t *testing.T) {
+	conf, err := LoadCliConfig("../../fixtures/rancher-cli.yml")
+
+	if err != nil {
+		t.Fatalf("loading cli config failed with: %v", err)
+	}
+
+	tests := []struct {
+		Context   string
+		Project   string
+		Protected bool
+	}{
+		{Context: "production", Project: "Default", Protected: true},
+		{Context: "old", Project: "Default", Protected: false},
+		{Context: "old", Project: "Live", Protected: true},
+		{Context: "", Project: "Live", Protected: true},
+		{Context: "", Project: "Staging", Protected: false},
+	}
+
+	for _, test := range tests {
+		if protected := conf.IsProtected(test.Context, test.Project); protected != test.Protected {
+			t.Errorf("expected environment %s of context %q to be protected %v", test.Project, test.Context, test.Protected)
+		}
+	}
+
+	if limit := conf.ServiceLimit(); limit != 5 {
+		t.Errorf("expected a service limit of 5 but received %d", limit)
+	}
+	if limit := (&CliConfig{}).ServiceLimit(); limit != DEFAULT_MAX_SERVICES {
+		t.Errorf("expected the default service limit but received %d", limit)
+	}
+}
+
+func TestCliConfigRegistryCredentials(t *testing.T) {
+	defer func(url, user, pass string) {
+		registryUrl, username, password = url, user, pass
+	}(registryUrl, username, password)
+	registryUrl, username, password = "https://registry.example.com:5000", "deployer", "secret"
+
+	conf := &CliConfig{
+		Registries: map[string]RegistryCredentials{
+			"quay.io": {Username: "robot", Password: "token"},
+		},
+	}
+
+	tests := []struct {
+		Host     string
+		Expected RegistryCredentials
+	}{
+		{"quay.io", RegistryCredentials{Url: "https://quay.io", Username: "robot", Password: "token"}},
+		{"registry.example.com:5000", RegistryCredentials{Url: "https://registry.example.com:5000", Username: "deployer", Password: "secret"}},
+		{DOCKER_HUB_HOST, RegistryCredentials{Url: DOCKER_HUB_REGISTRY}},
+		{"other.example.com", RegistryCredentials{Url: "https://other.example.com"}},
+	}
+
+	for _, test := range tests {
+		if creds := conf.RegistryCredentials(test.Host); creds != test.Expected {
+			t.Errorf("expected %#v for %s but received %#v", test.Expected, test.Host, creds)
+		}
+	}
+
+	registryUrl = ""
+	expected := RegistryCredentials{Url: DOCKER_HUB_REGISTRY, Username: "deployer", Password: "secret"}
+	if creds := conf.RegistryCredentials(DOCKER_HUB_HOST); creds != expected {
+		t.Errorf("expected the environment credentials for Docker Hub without DOCKER_REGISTRY_URL but received %#v", creds)
+	}
+}
@@ -3,9 +3,17 @@ package config
 import (
 	"time"
 
+	"github.com/nowait/rancher-cli/rancher/compose"
 	"github.com/rancher/go-rancher/client"
 )
 
+const (
+	ON_EXISTS_FAIL   = "fail"
+	ON_EXISTS_SKIP   = "skip"
+	ON_EXISTS_UPDATE = "update"
+	ON_EXISTS_RENAME = "rename"
+)
+
 type Validator interface {
 	Validate(service *client.Service, opts UpgradeOpts) error
 }
@@ -24,9 +32,70 @@ type UpgradeOpts struct {
 	CodeTag     string
 	RuntimeTag  string
 	Interval    time.Duration
+	// Pull the new images onto the service's hosts before upgrading
+	Prepull        bool
+	PrepullTimeout time.Duration
+	// Skip simulating the placement of the upgraded containers
+	SkipSchedulingCheck bool
+	// Narrows down the services matched by ServiceLike
+	Selector *ServiceSelector
+	// How long the lock taken on each upgraded service lasts, no lock is
+	// taken when zero
+	LockTTL time.Duration
+	// Why and from which commit the services are deployed, stamped on them
+	Reason string
+	GitSha string
+	// Freezes overridden to deploy, recorded with the deployment
+	FreezeOverride string
+	// Run around the upgrade of every service
+	Hooks *Hooks
 }
 
 type EnvUpgradeOpts struct {
 	SourceEnv string
 	TargetEnv string
+	// Glob patterns of the stack names to clone or leave out
+	Stacks        []string
+	ExcludeStacks []string
+	// What to do with stacks whose name already exists in the target
+	OnExists string
+	// Leave the stacks of a failed clone in place instead of removing them
+	KeepPartial bool
+	// Rules applied to the exported compose files before creating the stacks
+	Rewrite *compose.RewriteRules
+}
+
+type StackUpgradeOpts struct {
+	Stack          string
+	DockerCompose  string
+	RancherCompose string
+	// Values of the ${VAR} placeholders in the compose files
+	Variables map[string]string
+	Wait      bool
+	Timeout   time.Duration
+	// Skip simulating the placement of the upgraded containers
+	SkipSchedulingCheck bool
+	// TTL of the locks taken on the services of the stack, 0 takes none
+	LockTTL time.Duration
+}
+
+type StackImportOpts struct {
+	// Directory with a folder per stack as written by stack export
+	Dir string
+	// Values of the ${VAR} placeholders in the compose files
+	Variables map[string]string
+	// Wait for upgraded stacks and finish their upgrade
+	Wait    bool
+	Timeout time.Duration
+	// TTL of the locks taken on the services of upgraded stacks, 0 takes none
+	LockTTL time.Duration
+}
+
+type ServiceListOpts struct {
+	// Name of the stack the services belong to
+	Stack       string
+	ServiceLike string
+	State       string
+	Health      string
+	Selector    *ServiceSelector
 }
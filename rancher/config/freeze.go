@@ -0,0 +1,216 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	FREEZE_DATE_LAYOUT     = "2006-01-02"
+	FREEZE_DATETIME_LAYOUT = "2006-01-02 15:04"
+	FREEZE_CLOCK_LAYOUT    = "15:04"
+)
+
+// Freeze is a period in which nothing may be deployed to the environments it
+// applies to.  It is either a date range or a weekly window, such as fridays
+// after 15:00.
+type Freeze struct {
+	Name   string `yaml:"name"`
+	Reason string `yaml:"reason"`
+	// Environments the freeze applies to, every environment when empty
+	Environments []string `yaml:"environments"`
+	// Date range as 2006-01-02 or 2006-01-02 15:04, a date until is inclusive
+	From  string `yaml:"from"`
+	Until string `yaml:"until"`
+	// Weekly window on the days, between the times of the day as 15:04.  A
+	// window with after later than before starts on the days and ends the
+	// next day, such as after 22:00 and before 06:00
+	Days   []string `yaml:"days"`
+	After  string   `yaml:"after"`
+	Before string   `yaml:"before"`
+	// Location of the dates and times, defaults to the local time zone
+	Timezone string `yaml:"timezone"`
+}
+
+// Validate checks that the dates, days and times of the freeze can be parsed.
+func (f Freeze) Validate() error {
+	loc, err := f.location()
+	if err != nil {
+		return err
+	}
+
+	weekly := len(f.Days) > 0 || f.After != "" || f.Before != ""
+	dated := f.From != "" || f.Until != ""
+	if weekly == dated {
+		return fmt.Errorf("freeze %s needs either from and until or days", f.Name)
+	}
+
+	if dated {
+		if f.From == "" || f.Until == "" {
+			return fmt.Errorf("freeze %s needs both from and until", f.Name)
+		}
+		from, _, err := parseFreezeDate(f.From, loc)
+		if err != nil {
+			return fmt.Errorf("freeze %s: %v", f.Name, err)
+		}
+		until, _, err := parseFreezeDate(f.Until, loc)
+		if err != nil {
+			return fmt.Errorf("freeze %s: %v", f.Name, err)
+		}
+		if until.Before(from) {
+			return fmt.Errorf("freeze %s ends before it starts", f.Name)
+		}
+		return nil
+	}
+
+	if len(f.Days) == 0 {
+		return fmt.Errorf("freeze %s needs the days of its weekly window", f.Name)
+	}
+	for _, day := range f.Days {
+		if _, err := parseWeekday(day); err != nil {
+			return fmt.Errorf("freeze %s: %v", f.Name, err)
+		}
+	}
+	after, err := parseClock(f.After, 0)
+	if err != nil {
+		return fmt.Errorf("freeze %s: %v", f.Name, err)
+	}
+	before, err := parseClock(f.Before, 24*time.Hour)
+	if err != nil {
+		return fmt.Errorf("freeze %s: %v", f.Name, err)
+	}
+	if after == before {
+		return fmt.Errorf("freeze %s starts and ends at %s", f.Name, f.After)
+	}
+	return nil
+}
+
+// Active reports whether the freeze applies to the environment at the time.
+// The freeze is expected to be valid.
+func (f Freeze) Active(project string, now time.Time) bool {
+	if !f.appliesTo(project) {
+		return false
+	}
+
+	loc, err := f.location()
+	if err != nil {
+		return false
+	}
+	now = now.In(loc)
+
+	if f.From != "" {
+		from, _, err := parseFreezeDate(f.From, loc)
+		if err != nil {
+			return false
+		}
+		until, dateOnly, err := parseFreezeDate(f.Until, loc)
+		if err != nil {
+			return false
+		}
+		if dateOnly {
+			until = until.AddDate(0, 0, 1)
+		}
+		return !now.Before(from) && now.Before(until)
+	}
+
+	after, _ := parseClock(f.After, 0)
+	before, _ := parseClock(f.Before, 24*time.Hour)
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+
+	if after < before {
+		return f.onDay(now.Weekday()) && clock >= after && clock < before
+	}
+	// The window wraps around midnight into the day after the listed one
+	yesterday := (now.Weekday() + 6) % 7
+	return (f.onDay(now.Weekday()) && clock >= after) || (f.onDay(yesterday) && clock < before)
+}
+
+func (f Freeze) onDay(weekday time.Weekday) bool {
+	for _, day := range f.Days {
+		if parsed, err := parseWeekday(day); err == nil && parsed == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the freeze for error messages.
+func (f Freeze) String() string {
+	description := "freeze " + f.Name
+	if f.Reason != "" {
+		description += " (" + f.Reason + ")"
+	}
+	return description
+}
+
+func (f Freeze) appliesTo(project string) bool {
+	if len(f.Environments) == 0 {
+		return true
+	}
+	for _, name := range f.Environments {
+		if name == project {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Freeze) location() (*time.Location, error) {
+	if f.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("freeze %s has an invalid timezone %s: %v", f.Name, f.Timezone, err)
+	}
+	return loc, nil
+}
+
+// ActiveFreeze returns the first freeze of the cli config that applies to
+// the environment at the time, or nil.
+func (conf *CliConfig) ActiveFreeze(project string, now time.Time) *Freeze {
+	for i := range conf.Freezes {
+		if conf.Freezes[i].Active(project, now) {
+			return &conf.Freezes[i]
+		}
+	}
+	return nil
+}
+
+// Parse a date or date and time, reporting whether only the date was given.
+func parseFreezeDate(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(FREEZE_DATETIME_LAYOUT, value, loc); err == nil {
+		return t, false, nil
+	}
+	t, err := time.ParseInLocation(FREEZE_DATE_LAYOUT, value, loc)
+	if err != nil {
+		return t, false, fmt.Errorf("invalid date %s, expected %s or %s", value, FREEZE_DATE_LAYOUT, FREEZE_DATETIME_LAYOUT)
+	}
+	return t, true, nil
+}
+
+// Parse a time of the day into the duration since midnight.
+func parseClock(value string, empty time.Duration) (time.Duration, error) {
+	if value == "" {
+		return empty, nil
+	}
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse(FREEZE_CLOCK_LAYOUT, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of the day %s, expected %s", value, FREEZE_CLOCK_LAYOUT)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if lower := strings.ToLower(day); lower == name || lower == name[:3] {
+			return weekday, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %s", day)
+}
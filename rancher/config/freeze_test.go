@@ -0,0 +1,120 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFreezeActive(t *testing.T) {
+	conf, err := LoadCliConfig("../../fixtures/rancher-cli.yml")
+	if err != nil {
+		t.Fatalf("loading cli config failed with: %v", err)
+	}
+
+	tests := []struct {
+		Description string
+		Project     string
+		Time        string
+		Freeze      string
+	}{
+		{Description: "first day of the range", Project: "Staging", Time: "2016-12-20T00:00:00", Freeze: "end-of-year"},
+		{Description: "last day of the range is inclusive", Project: "Staging", Time: "2017-01-02T23:59:00", Freeze: "end-of-year"},
+		{Description: "after the range", Project: "Staging", Time: "2017-01-03T00:00:00"},
+		{Description: "friday afternoon", Project: "Live", Time: "2017-01-06T15:00:00Z", Freeze: "friday"},
+		{Description: "friday morning", Project: "Live", Time: "2017-01-06T14:59:00Z"},
+		{Description: "thursday afternoon", Project: "Live", Time: "2017-01-05T16:00:00Z"},
+		{Description: "other environment on friday", Project: "Staging", Time: "2017-01-06T16:00:00Z"},
+	}
+
+	for _, test := range tests {
+		now, err := time.ParseInLocation("2006-01-02T15:04:05", test.Time, time.Local)
+		if err != nil {
+			now, err = time.Parse(time.RFC3339, test.Time)
+		}
+		if err != nil {
+			t.Fatalf("%s: invalid time %s", test.Description, test.Time)
+		}
+
+		freeze := conf.ActiveFreeze(test.Project, now)
+		name := ""
+		if freeze != nil {
+			name = freeze.Name
+		}
+		if name != test.Freeze {
+			t.Errorf("%s: expected freeze %q, received %q", test.Description, test.Freeze, name)
+		}
+	}
+}
+
+func TestFreezeActiveAcrossMidnight(t *testing.T) {
+	freeze := Freeze{Name: "nights", Days: []string{"friday"}, After: "22:00", Before: "06:00", Timezone: "UTC"}
+
+	tests := []struct {
+		Description string
+		Time        string
+		Active      bool
+	}{
+		{Description: "friday evening", Time: "2017-01-06T21:59:00Z"},
+		{Description: "friday night", Time: "2017-01-06T22:00:00Z", Active: true},
+		{Description: "saturday early morning", Time: "2017-01-07T05:59:00Z", Active: true},
+		{Description: "saturday morning", Time: "2017-01-07T06:00:00Z"},
+		{Description: "saturday night", Time: "2017-01-07T23:00:00Z"},
+		{Description: "friday early morning", Time: "2017-01-06T01:00:00Z"},
+	}
+
+	for _, test := range tests {
+		now, err := time.Parse(time.RFC3339, test.Time)
+		if err != nil {
+			t.Fatalf("%s: invalid time %s", test.Description, test.Time)
+		}
+		if active := freeze.Active("Live", now); active != test.Active {
+			t.Errorf("%s: expected active %v, received %v", test.Description, test.Active, active)
+		}
+	}
+}
+
+func TestFreezeValidate(t *testing.T) {
+	tests := []struct {
+		Description string
+		Freeze      Freeze
+		Valid       bool
+	}{
+		{Description: "date range", Freeze: Freeze{From: "2016-12-20", Until: "2016-12-24 12:00"}, Valid: true},
+		{Description: "weekly window", Freeze: Freeze{Days: []string{"Fri", "saturday"}, After: "15:00", Before: "24:00"}, Valid: true},
+		{Description: "no period", Freeze: Freeze{}},
+		{Description: "range and window", Freeze: Freeze{From: "2016-12-20", Until: "2016-12-24", Days: []string{"friday"}}},
+		{Description: "open range", Freeze: Freeze{From: "2016-12-20"}},
+		{Description: "range ending before it starts", Freeze: Freeze{From: "2016-12-20", Until: "2016-12-19"}},
+		{Description: "invalid date", Freeze: Freeze{From: "20/12/2016", Until: "2016-12-24"}},
+		{Description: "window without days", Freeze: Freeze{After: "15:00"}},
+		{Description: "invalid day", Freeze: Freeze{Days: []string{"someday"}}},
+		{Description: "window across midnight", Freeze: Freeze{Days: []string{"friday"}, After: "22:00", Before: "06:00"}, Valid: true},
+		{Description: "empty window", Freeze: Freeze{Days: []string{"friday"}, After: "15:00", Before: "15:00"}},
+		{Description: "invalid time", Freeze: Freeze{Days: []string{"friday"}, After: "3pm"}},
+		{Description: "invalid timezone", Freeze: Freeze{Days: []string{"friday"}, Timezone: "Nowhere/City"}},
+	}
+
+	for _, test := range tests {
+		test.Freeze.Name = "test"
+		if err := test.Freeze.Validate(); (err == nil) != test.Valid {
+			t.Errorf("%s: expected valid %v, received %v", test.Description, test.Valid, err)
+		}
+	}
+}
+
+func TestLoadCliConfigInvalidFreeze(t *testing.T) {
+	file, err := ioutil.TempFile("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("freezes:\n  - name: broken\n    days: [someday]\n")
+	file.Close()
+
+	if _, err := LoadCliConfig(file.Name()); err == nil {
+		t.Errorf("loading a cli config with an invalid freeze should fail")
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	HOOK_PHASE_PRE  = "pre"
+	HOOK_PHASE_POST = "post"
+
+	DEFAULT_HOOK_TIMEOUT     = time.Minute
+	DEFAULT_HOOK_STATUS      = 200
+	DEFAULT_HOOK_RETRY_DELAY = 5 * time.Second
+)
+
+// Hooks run around the upgrade of every service: pre hooks before the
+// upgrade is started, failing it, and post hooks once it completed, rolling
+// it back when they fail.
+type Hooks struct {
+	Pre  []Hook `yaml:"pre"`
+	Post []Hook `yaml:"post"`
+	// Absolute path of the file the hooks were loaded from
+	Path string `yaml:"-"`
+}
+
+// Hook is a local command, an http check or a command executed in the
+// containers of the upgraded service.
+type Hook struct {
+	Name string `yaml:"name"`
+	// Patterns of the services the hook runs for, as matched by --match,
+	// every service when empty
+	Services []string `yaml:"services"`
+
+	// Local command run with the deployment in RANCHER_CLI_ variables
+	Command []string `yaml:"command"`
+	// Http check of a url
+	Http *HttpCheck `yaml:"http"`
+	// Command executed in every running container of the service
+	Exec []string `yaml:"exec"`
+
+	// How long the hook may run, as a duration such as 30s
+	Timeout string `yaml:"timeout"`
+
+	selector *ServiceSelector
+}
+
+// HttpCheck requests the url until it responds with the status and a body
+// matching the regular expression, or the retries are used up.
+type HttpCheck struct {
+	Url     string `yaml:"url"`
+	Status  int    `yaml:"status"`
+	Body    string `yaml:"body"`
+	Retries int    `yaml:"retries"`
+	// Delay between attempts, as a duration such as 5s
+	Interval string `yaml:"interval"`
+}
+
+// LoadHooks reads and validates the hooks file at path.
+func LoadHooks(path string) (*Hooks, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := &Hooks{}
+	if hooks.Path, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, hooks); err != nil {
+		return nil, fmt.Errorf("invalid hooks %s: %v", path, err)
+	}
+	for _, phase := range [][]Hook{hooks.Pre, hooks.Post} {
+		for i := range phase {
+			if err := phase[i].Validate(); err != nil {
+				return nil, fmt.Errorf("invalid hooks %s: %v", path, err)
+			}
+		}
+	}
+	return hooks, nil
+}
+
+// Phase returns the hooks of the phase, pre or post.  Nil hooks have none.
+func (hooks *Hooks) Phase(phase string) []Hook {
+	if hooks == nil {
+		return nil
+	}
+	if phase == HOOK_PHASE_PRE {
+		return hooks.Pre
+	}
+	return hooks.Post
+}
+
+// Validate checks the hook does exactly one thing and that its durations,
+// patterns and url can be parsed.
+func (h *Hook) Validate() error {
+	kinds := 0
+	for _, set := range []bool{len(h.Command) > 0, h.Http != nil, len(h.Exec) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("hook %s needs exactly one of command, http or exec", h.Name)
+	}
+
+	if _, err := parseHookDuration(h.Timeout, DEFAULT_HOOK_TIMEOUT); err != nil {
+		return fmt.Errorf("hook %s: invalid timeout: %v", h.Name, err)
+	}
+
+	selector, err := NewServiceSelector(h.Services, "", nil)
+	if err != nil {
+		return fmt.Errorf("hook %s: %v", h.Name, err)
+	}
+	h.selector = selector
+
+	if h.Http != nil {
+		// Variables are only known when the hook runs
+		placeholder := func(string) string { return "variable" }
+		if _, err := url.ParseRequestURI(os.Expand(h.Http.Url, placeholder)); err != nil {
+			return fmt.Errorf("hook %s: invalid url: %v", h.Name, err)
+		}
+		if _, err := regexp.Compile(h.Http.Body); err != nil {
+			return fmt.Errorf("hook %s: invalid body: %v", h.Name, err)
+		}
+		if _, err := parseHookDuration(h.Http.Interval, DEFAULT_HOOK_RETRY_DELAY); err != nil {
+			return fmt.Errorf("hook %s: invalid interval: %v", h.Name, err)
+		}
+		if h.Http.Retries < 0 {
+			return fmt.Errorf("hook %s: retries can not be negative", h.Name)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the hook runs for the service of the stack.
+func (h *Hook) Matches(stack string, service *client.Service) bool {
+	return h.selector.Matches(stack, service)
+}
+
+// TimeoutDuration returns how long the hook may run.
+func (h *Hook) TimeoutDuration() time.Duration {
+	timeout, _ := parseHookDuration(h.Timeout, DEFAULT_HOOK_TIMEOUT)
+	return timeout
+}
+
+// ExpectedStatus returns the status the check expects.
+func (check *HttpCheck) ExpectedStatus() int {
+	if check.Status == 0 {
+		return DEFAULT_HOOK_STATUS
+	}
+	return check.Status
+}
+
+// IntervalDuration returns the delay between attempts of the check.
+func (check *HttpCheck) IntervalDuration() time.Duration {
+	interval, _ := parseHookDuration(check.Interval, DEFAULT_HOOK_RETRY_DELAY)
+	return interval
+}
+
+func parseHookDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
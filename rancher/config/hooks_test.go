@@ -0,0 +1,72 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestLoadHooks(t *testing.T) {
+	hooks, err := LoadHooks("../../fixtures/hooks.yml")
+	if err != nil {
+		t.Fatalf("loading hooks failed with: %v", err)
+	}
+
+	pre := hooks.Phase(HOOK_PHASE_PRE)
+	if len(pre) != 1 || pre[0].Command[0] != "./scripts/announce.sh" || pre[0].TimeoutDuration() != 10*time.Second {
+		t.Errorf("unexpected pre hooks %v", pre)
+	}
+
+	post := hooks.Phase(HOOK_PHASE_POST)
+	if len(post) != 2 {
+		t.Fatalf("expected 2 post hooks, received %v", post)
+	}
+	check := post[0].Http
+	if check.ExpectedStatus() != 200 || check.Retries != 5 || check.IntervalDuration() != 2*time.Second {
+		t.Errorf("unexpected http check %v", check)
+	}
+	if post[1].TimeoutDuration() != DEFAULT_HOOK_TIMEOUT {
+		t.Errorf("expected the default timeout, received %v", post[1].TimeoutDuration())
+	}
+
+	api := &client.Service{Name: "api"}
+	if !post[0].Matches("backend", api) || post[0].Matches("frontend", api) {
+		t.Errorf("expected the health check to run for backend/api only")
+	}
+	if !post[1].Matches("frontend", api) {
+		t.Errorf("expected a hook without services to run for every service")
+	}
+
+	var none *Hooks
+	if len(none.Phase(HOOK_PHASE_POST)) != 0 {
+		t.Errorf("expected nil hooks to have no hooks")
+	}
+}
+
+func TestHookValidate(t *testing.T) {
+	tests := []struct {
+		Description string
+		Hook        Hook
+		Valid       bool
+	}{
+		{Description: "command", Hook: Hook{Command: []string{"true"}}, Valid: true},
+		{Description: "http check", Hook: Hook{Http: &HttpCheck{Url: "http://localhost/health"}}, Valid: true},
+		{Description: "exec", Hook: Hook{Exec: []string{"true"}, Services: []string{"/^api/"}}, Valid: true},
+		{Description: "nothing to do", Hook: Hook{}},
+		{Description: "command and exec", Hook: Hook{Command: []string{"true"}, Exec: []string{"true"}}},
+		{Description: "invalid timeout", Hook: Hook{Command: []string{"true"}, Timeout: "soon"}},
+		{Description: "invalid pattern", Hook: Hook{Command: []string{"true"}, Services: []string{"/[/"}}},
+		{Description: "relative url", Hook: Hook{Http: &HttpCheck{Url: "health"}}},
+		{Description: "invalid body", Hook: Hook{Http: &HttpCheck{Url: "http://localhost/health", Body: "("}}},
+		{Description: "invalid interval", Hook: Hook{Http: &HttpCheck{Url: "http://localhost/health", Interval: "5"}}},
+		{Description: "negative retries", Hook: Hook{Http: &HttpCheck{Url: "http://localhost/health", Retries: -1}}},
+	}
+
+	for _, test := range tests {
+		test.Hook.Name = "test"
+		if err := test.Hook.Validate(); (err == nil) != test.Valid {
+			t.Errorf("%s: expected valid %v, received %v", test.Description, test.Valid, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"text/template"
+)
+
+const (
+	// Kinds of notifiers
+	NOTIFIER_WEBHOOK = "webhook"
+	NOTIFIER_SLACK   = "slack"
+	NOTIFIER_EMAIL   = "email"
+
+	// Events notifiers are fired on
+	NOTIFY_UPGRADE_START   = "upgrade-start"
+	NOTIFY_UPGRADE_SUCCESS = "upgrade-success"
+	NOTIFY_UPGRADE_FAILURE = "upgrade-failure"
+	NOTIFY_ROLLBACK        = "rollback"
+	NOTIFY_CLONE_COMPLETE  = "clone-complete"
+)
+
+// NotifyEvents are the events notifiers can be fired on.
+var NotifyEvents = []string{
+	NOTIFY_UPGRADE_START,
+	NOTIFY_UPGRADE_SUCCESS,
+	NOTIFY_UPGRADE_FAILURE,
+	NOTIFY_ROLLBACK,
+	NOTIFY_CLONE_COMPLETE,
+}
+
+// Notifier announces deployments to a generic JSON webhook, a Slack
+// compatible incoming webhook or by email.
+type Notifier struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Url of the webhook
+	Url string `yaml:"url"`
+	// Events the notifier is fired on, every event when empty
+	Events []string `yaml:"events"`
+	// Text templates of the message per event, a summary by default
+	Templates map[string]string `yaml:"templates"`
+	// Times a failed notification is retried
+	Retries int `yaml:"retries"`
+
+	// Server as host:port, credentials and addresses of email notifiers
+	Smtp     string   `yaml:"smtp"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// Validate checks the notifier has what its type needs and that its events
+// and templates are valid.
+func (n Notifier) Validate() error {
+	if n.Name == "" {
+		return fmt.Errorf("notifier of type %s needs a name", n.Type)
+	}
+
+	switch n.Type {
+	case NOTIFIER_WEBHOOK, NOTIFIER_SLACK:
+		if n.Url == "" {
+			return fmt.Errorf("notifier %s needs a url", n.Name)
+		}
+		if _, err := url.ParseRequestURI(n.Url); err != nil {
+			return fmt.Errorf("notifier %s: invalid url: %v", n.Name, err)
+		}
+	case NOTIFIER_EMAIL:
+		if _, _, err := net.SplitHostPort(n.Smtp); err != nil {
+			return fmt.Errorf("notifier %s needs the smtp server as host:port: %v", n.Name, err)
+		}
+		if n.From == "" || len(n.To) == 0 {
+			return fmt.Errorf("notifier %s needs from and to addresses", n.Name)
+		}
+	default:
+		return fmt.Errorf("notifier %s has unknown type %q, expected %s, %s or %s", n.Name, n.Type, NOTIFIER_WEBHOOK, NOTIFIER_SLACK, NOTIFIER_EMAIL)
+	}
+
+	if n.Retries < 0 {
+		return fmt.Errorf("notifier %s: retries can not be negative", n.Name)
+	}
+	for _, event := range n.Events {
+		if !knownNotifyEvent(event) {
+			return fmt.Errorf("notifier %s: unknown event %q", n.Name, event)
+		}
+	}
+	for event, text := range n.Templates {
+		if !knownNotifyEvent(event) {
+			return fmt.Errorf("notifier %s: template of unknown event %q", n.Name, event)
+		}
+		if _, err := template.New(event).Parse(text); err != nil {
+			return fmt.Errorf("notifier %s: invalid template of %s: %v", n.Name, event, err)
+		}
+	}
+	return nil
+}
+
+// Fires reports whether the notifier is fired on the event.
+func (n Notifier) Fires(event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func knownNotifyEvent(event string) bool {
+	for _, e := range NotifyEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCliConfigNotifiers(t *testing.T) {
+	conf, err := LoadCliConfig("../../fixtures/rancher-cli.yml")
+	if err != nil {
+		t.Fatalf("loading cli config failed with: %v", err)
+	}
+
+	if len(conf.Notifiers) != 2 {
+		t.Fatalf("expected 2 notifiers, received %v", conf.Notifiers)
+	}
+	slack := conf.Notifiers[0]
+	if slack.Type != NOTIFIER_SLACK || slack.Retries != 2 || slack.Templates[NOTIFY_UPGRADE_FAILURE] == "" {
+		t.Errorf("unexpected slack notifier %v", slack)
+	}
+	if !slack.Fires(NOTIFY_ROLLBACK) || slack.Fires(NOTIFY_UPGRADE_START) {
+		t.Errorf("expected the slack notifier to fire on its events only")
+	}
+	if !conf.Notifiers[1].Fires(NOTIFY_CLONE_COMPLETE) {
+		t.Errorf("expected a notifier without events to fire on every event")
+	}
+}
+
+func TestNotifierValidate(t *testing.T) {
+	tests := []struct {
+		Description string
+		Notifier    Notifier
+		Valid       bool
+	}{
+		{Description: "webhook", Notifier: Notifier{Type: NOTIFIER_WEBHOOK, Url: "https://example.com/hook"}, Valid: true},
+		{Description: "email", Notifier: Notifier{Type: NOTIFIER_EMAIL, Smtp: "localhost:25", From: "a@example.com", To: []string{"b@example.com"}}, Valid: true},
+		{Description: "unknown type", Notifier: Notifier{Type: "pager", Url: "https://example.com/hook"}},
+		{Description: "webhook without url", Notifier: Notifier{Type: NOTIFIER_SLACK}},
+		{Description: "relative url", Notifier: Notifier{Type: NOTIFIER_SLACK, Url: "hook"}},
+		{Description: "email without port", Notifier: Notifier{Type: NOTIFIER_EMAIL, Smtp: "localhost", From: "a@example.com", To: []string{"b@example.com"}}},
+		{Description: "email without recipients", Notifier: Notifier{Type: NOTIFIER_EMAIL, Smtp: "localhost:25", From: "a@example.com"}},
+		{Description: "unknown event", Notifier: Notifier{Type: NOTIFIER_WEBHOOK, Url: "https://example.com/hook", Events: []string{"deploy"}}},
+		{Description: "template of unknown event", Notifier: Notifier{Type: NOTIFIER_WEBHOOK, Url: "https://example.com/hook", Templates: map[string]string{"deploy": "text"}}},
+		{Description: "invalid template", Notifier: Notifier{Type: NOTIFIER_WEBHOOK, Url: "https://example.com/hook", Templates: map[string]string{NOTIFY_ROLLBACK: "{{.User"}}},
+		{Description: "negative retries", Notifier: Notifier{Type: NOTIFIER_WEBHOOK, Url: "https://example.com/hook", Retries: -1}},
+	}
+
+	for _, test := range tests {
+		test.Notifier.Name = "test"
+		if err := test.Notifier.Validate(); (err == nil) != test.Valid {
+			t.Errorf("%s: expected valid %v, received %v", test.Description, test.Valid, err)
+		}
+	}
+}
+
+func TestLoadCliConfigDuplicateNotifiers(t *testing.T) {
+	file, err := ioutil.TempFile("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("notifiers:\n  - name: deploys\n    type: webhook\n    url: https://example.com/one\n  - name: deploys\n    type: webhook\n    url: https://example.com/two\n")
+	file.Close()
+
+	if _, err := LoadCliConfig(file.Name()); err == nil || !strings.Contains(err.Error(), "more than one notifier is named deploys") {
+		t.Errorf("loading a cli config with notifiers of the same name should fail, received %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	SEVERITY_WARN = "warn"
+	SEVERITY_DENY = "deny"
+)
+
+// UpgradeValidator validates the launch configs a service is about to be
+// upgraded to, after the upgrade options have been applied.
+type UpgradeValidator interface {
+	ValidateUpgrade(service *client.Service, upgrade *client.ServiceUpgrade, opts UpgradeOpts) error
+}
+
+// Policy is a set of built-in rules loaded from a policy file.  Violations
+// either fail the upgrade or are only logged depending on the severity of the
+// project the service belongs to.
+type Policy struct {
+	Severity string                   `yaml:"severity"`
+	Projects map[string]ProjectPolicy `yaml:"projects"`
+	Rules    PolicyRules              `yaml:"rules"`
+
+	// ProjectName resolves the name of the project with the given id so
+	// projects can be configured by name.
+	ProjectName func(id string) (string, error) `yaml:"-"`
+}
+
+type ProjectPolicy struct {
+	Severity string `yaml:"severity"`
+}
+
+type PolicyRules struct {
+	ForbidLatestTag    bool     `yaml:"forbid_latest_tag"`
+	AllowedRegistries  []string `yaml:"allowed_registries"`
+	RequiredLabels     []string `yaml:"required_labels"`
+	RequireHealthCheck bool     `yaml:"require_health_check"`
+	RequireMemoryLimit bool     `yaml:"require_memory_limit"`
+	ForbidPrivileged   bool     `yaml:"forbid_privileged"`
+	ForbiddenEnv       []string `yaml:"forbidden_env"`
+}
+
+type namedLaunchConfig struct {
+	Name string
+	*client.LaunchConfig
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %v", path, err)
+	}
+
+	if policy.Severity == "" {
+		policy.Severity = SEVERITY_DENY
+	}
+	if err := validSeverity(policy.Severity); err != nil {
+		return nil, err
+	}
+	for _, project := range policy.Projects {
+		if err := validSeverity(project.Severity); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+func validSeverity(severity string) error {
+	if severity != SEVERITY_WARN && severity != SEVERITY_DENY {
+		return fmt.Errorf("invalid policy severity %s, expected %s or %s", severity, SEVERITY_WARN, SEVERITY_DENY)
+	}
+	return nil
+}
+
+func (policy *Policy) ValidateUpgrade(service *client.Service, upgrade *client.ServiceUpgrade, opts UpgradeOpts) error {
+	violations := []string{}
+
+	lcs, err := upgradeLaunchConfigs(upgrade)
+	if err != nil {
+		// A launch config that can not be read can not be checked either
+		return fmt.Errorf("policy: service %s: %v", service.Name, err)
+	}
+	for _, lc := range lcs {
+		for _, violation := range policy.Rules.check(lc) {
+			violations = append(violations, fmt.Sprintf("%s: %s", lc.Name, violation))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("policy: service %s violates %s", service.Name, strings.Join(violations, "; "))
+	if policy.projectSeverity(service.AccountId) == SEVERITY_WARN {
+		log.Warn(msg)
+		return nil
+	}
+	return errors.New(msg)
+}
+
+// Projects can be configured by id or by name, a project without
+// configuration uses the default severity.
+func (policy *Policy) projectSeverity(projectId string) string {
+	if project, ok := policy.Projects[projectId]; ok {
+		return project.Severity
+	}
+
+	if policy.ProjectName != nil {
+		name, err := policy.ProjectName(projectId)
+		if err != nil {
+			log.Warnf("policy: could not resolve project %s: %v", projectId, err)
+		}
+		if project, ok := policy.Projects[name]; ok && err == nil {
+			return project.Severity
+		}
+	}
+
+	return policy.Severity
+}
+
+func (rules PolicyRules) check(lc namedLaunchConfig) []string {
+	violations := []string{}
+	image := strings.TrimPrefix(lc.ImageUuid, "docker:")
+
+	if rules.ForbidLatestTag && usesLatestTag(image) {
+		violations = append(violations, fmt.Sprintf("image %s uses the latest tag", image))
+	}
+
+	if len(rules.AllowedRegistries) > 0 {
+		host := ImageRegistryHost(image)
+		if !containsTag(host, rules.AllowedRegistries) {
+			violations = append(violations, fmt.Sprintf("image %s is not from an allowed registry", image))
+		}
+	}
+
+	for _, label := range rules.RequiredLabels {
+		if _, ok := lc.Labels[label]; !ok {
+			violations = append(violations, fmt.Sprintf("missing label %s", label))
+		}
+	}
+
+	if rules.RequireHealthCheck && lc.HealthCheck == nil {
+		violations = append(violations, "missing health check")
+	}
+
+	if rules.RequireMemoryLimit && lc.Memory == 0 && lc.MemoryMb == 0 {
+		violations = append(violations, "missing memory limit")
+	}
+
+	if rules.ForbidPrivileged && lc.Privileged {
+		violations = append(violations, "runs privileged")
+	}
+
+	for _, key := range rules.ForbiddenEnv {
+		if _, ok := lc.Environment[key]; ok {
+			violations = append(violations, fmt.Sprintf("forbidden env %s", key))
+		}
+	}
+
+	return violations
+}
+
+// An image without a tag or digest implicitly uses the latest tag.
+func usesLatestTag(image string) bool {
+	if strings.Contains(image, "@") {
+		return false
+	}
+	pos := strings.LastIndex(image, ":")
+	return pos == -1 || pos < strings.LastIndex(image, "/") || image[pos+1:] == "latest"
+}
+
+// ImageRegistryHost returns the host of the registry the image is pulled from.
+func ImageRegistryHost(image string) string {
+	pieces := strings.SplitN(image, "/", 2)
+	if len(pieces) == 2 && isRegistryHost(pieces[0]) {
+		return pieces[0]
+	}
+	return DOCKER_HUB_HOST
+}
+
+// Return the primary and secondary launch configs of the upgrade.  Secondary
+// launch configs are untyped maps in the api client so they are converted
+// through their json representation, failing when one can not be.
+func upgradeLaunchConfigs(upgrade *client.ServiceUpgrade) ([]namedLaunchConfig, error) {
+	lcs := []namedLaunchConfig{}
+	strategy := upgrade.InServiceStrategy
+
+	if strategy.LaunchConfig != nil {
+		lcs = append(lcs, namedLaunchConfig{
+			Name:         "main",
+			LaunchConfig: strategy.LaunchConfig,
+		})
+	}
+
+	for i, slc := range strategy.SecondaryLaunchConfigs {
+		name := ""
+		if slcMap, ok := slc.(map[string]interface{}); ok {
+			name, _ = slcMap["name"].(string)
+		}
+		if name == "" {
+			name = fmt.Sprintf("secondary launch config %d", i+1)
+		}
+
+		data, err := json.Marshal(slc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", name, err)
+		}
+
+		lc := &client.LaunchConfig{}
+		if err := json.Unmarshal(data, lc); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", name, err)
+		}
+
+		lcs = append(lcs, namedLaunchConfig{
+			Name:         name,
+			LaunchConfig: lc,
+		})
+	}
+
+	return lcs, nil
+}
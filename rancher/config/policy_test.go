@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func compliantLaunchConfig() *client.LaunchConfig {
+	return &client.LaunchConfig{
+		ImageUuid:   "docker:registry.example.com/nowait/api:1.0",
+		Labels:      map[string]interface{}{"io.nowait.team": "platform"},
+		HealthCheck: &client.InstanceHealthCheck{Port: 80},
+		Memory:      512 * 1024 * 1024,
+		Environment: map[string]interface{}{"ENVIRONMENT": "prod"},
+	}
+}
+
+func policyUpgrade(lc *client.LaunchConfig, slcs ...interface{}) *client.ServiceUpgrade {
+	return &client.ServiceUpgrade{
+		InServiceStrategy: &client.InServiceUpgradeStrategy{
+			LaunchConfig:           lc,
+			SecondaryLaunchConfigs: slcs,
+		},
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	if _, err := LoadPolicy("../../fixtures/policy.yml"); err != nil {
+		t.Errorf("loading policy failed with: %v", err)
+	}
+
+	if _, err := LoadPolicy("../../fixtures/does-not-exist.yml"); err == nil {
+		t.Errorf("loading a missing policy should fail")
+	}
+}
+
+func TestPolicyRules(t *testing.T) {
+	tests := []struct {
+		Description string
+		Modify      func(lc *client.LaunchConfig)
+		Error       string
+	}{
+		{
+			Description: "compliant launch config",
+			Modify:      func(lc *client.LaunchConfig) {},
+		},
+		{
+			Description: "latest tag",
+			Modify:      func(lc *client.LaunchConfig) { lc.ImageUuid = "docker:registry.example.com/nowait/api:latest" },
+			Error:       "policy: service api violates main: image registry.example.com/nowait/api:latest uses the latest tag",
+		},
+		{
+			Description: "missing tag",
+			Modify:      func(lc *client.LaunchConfig) { lc.ImageUuid = "docker:registry.example.com:5000/nowait/api" },
+			Error:       "policy: service api violates main: image registry.example.com:5000/nowait/api uses the latest tag; main: image registry.example.com:5000/nowait/api is not from an allowed registry",
+		},
+		{
+			Description: "registry not allowed",
+			Modify:      func(lc *client.LaunchConfig) { lc.ImageUuid = "docker:quay.io/nowait/api:1.0" },
+			Error:       "policy: service api violates main: image quay.io/nowait/api:1.0 is not from an allowed registry",
+		},
+		{
+			Description: "missing label, health check and memory limit",
+			Modify: func(lc *client.LaunchConfig) {
+				lc.Labels = nil
+				lc.HealthCheck = nil
+				lc.Memory = 0
+			},
+			Error: "policy: service api violates main: missing label io.nowait.team; main: missing health check; main: missing memory limit",
+		},
+		{
+			Description: "privileged with forbidden env",
+			Modify: func(lc *client.LaunchConfig) {
+				lc.Privileged = true
+				lc.Environment["AWS_SECRET_ACCESS_KEY"] = "secret"
+			},
+			Error: "policy: service api violates main: runs privileged; main: forbidden env AWS_SECRET_ACCESS_KEY",
+		},
+	}
+
+	policy, _ := LoadPolicy("../../fixtures/policy.yml")
+	service := &client.Service{Name: "api", AccountId: "1a5"}
+
+	for _, test := range tests {
+		lc := compliantLaunchConfig()
+		test.Modify(lc)
+
+		err := policy.ValidateUpgrade(service, policyUpgrade(lc), UpgradeOpts{})
+
+		if test.Error == "" && err != nil {
+			t.Errorf("%s: expected no violations but received %v", test.Description, err)
+		}
+		if test.Error != "" && (err == nil || err.Error() != test.Error) {
+			t.Errorf("%s: expected `%s` but received `%v`", test.Description, test.Error, err)
+		}
+	}
+}
+
+func TestPolicyChecksSidekicks(t *testing.T) {
+	policy, _ := LoadPolicy("../../fixtures/policy.yml")
+	service := &client.Service{Name: "api", AccountId: "1a5"}
+
+	slc := map[string]interface{}{
+		"name":       "code",
+		"imageUuid":  "docker:nowait/api-code:1.0",
+		"labels":     map[string]interface{}{"io.nowait.team": "platform"},
+		"memory":     1024,
+		"privileged": true,
+		"healthCheck": map[string]interface{}{
+			"port": 80,
+		},
+	}
+
+	err := policy.ValidateUpgrade(service, policyUpgrade(compliantLaunchConfig(), slc), UpgradeOpts{})
+
+	if err == nil || err.Error() != "policy: service api violates code: runs privileged" {
+		t.Errorf("expected sidekick violation but received %v", err)
+	}
+}
+
+func TestPolicyRejectsMalformedSidekicks(t *testing.T) {
+	policy, _ := LoadPolicy("../../fixtures/policy.yml")
+	service := &client.Service{Name: "api", AccountId: "1a5"}
+
+	slc := map[string]interface{}{
+		"name":       "code",
+		"imageUuid":  "docker:nowait/api-code:1.0",
+		"memory":     "lots",
+		"privileged": true,
+	}
+
+	err := policy.ValidateUpgrade(service, policyUpgrade(compliantLaunchConfig(), slc), UpgradeOpts{})
+
+	if err == nil || !strings.HasPrefix(err.Error(), "policy: service api: invalid code:") {
+		t.Errorf("expected the malformed sidekick to be rejected but received %v", err)
+	}
+}
+
+func TestPolicyProjectSeverity(t *testing.T) {
+	policy, _ := LoadPolicy("../../fixtures/policy.yml")
+	lc := compliantLaunchConfig()
+	lc.Privileged = true
+
+	tests := []struct {
+		ProjectName func(id string) (string, error)
+		Fails       bool
+	}{
+		{
+			ProjectName: func(id string) (string, error) { return "staging", nil },
+			Fails:       false,
+		},
+		{
+			ProjectName: func(id string) (string, error) { return "production", nil },
+			Fails:       true,
+		},
+		{
+			ProjectName: func(id string) (string, error) { return "", errors.New("not found") },
+			Fails:       true,
+		},
+	}
+
+	for index, test := range tests {
+		policy.ProjectName = test.ProjectName
+		err := policy.ValidateUpgrade(&client.Service{Name: "api", AccountId: "1a5"}, policyUpgrade(lc), UpgradeOpts{})
+
+		if test.Fails != (err != nil) {
+			t.Errorf("test case %d: expected failure %v but received %v", index, test.Fails, err)
+		}
+	}
+}
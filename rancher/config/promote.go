@@ -0,0 +1,190 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	manifest "github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/libtrust"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+const (
+	DOCKER_HUB_HOST     = "docker.io"
+	DOCKER_HUB_REGISTRY = "https://registry-1.docker.io"
+)
+
+// PromotionRegistry is the part of the registry client needed to copy an
+// image between repositories.
+type PromotionRegistry interface {
+	Manifest(repository, reference string) (*manifest.SignedManifest, error)
+	PutManifest(repository, reference string, signedManifest *manifest.SignedManifest) error
+	HasLayer(repository string, digest digest.Digest) (bool, error)
+	DownloadLayer(repository string, digest digest.Digest) (io.ReadCloser, error)
+	UploadLayer(repository string, digest digest.Digest, content io.Reader) error
+}
+
+// ImageRef is an image split into the registry host, the repository within
+// that registry and the tag.
+type ImageRef struct {
+	Host       string
+	Repository string
+	Tag        string
+}
+
+func (ref ImageRef) String() string {
+	name := ref.Repository
+	if ref.Host != DOCKER_HUB_HOST {
+		name = ref.Host + "/" + name
+	}
+	return name + ":" + ref.Tag
+}
+
+// ParseImageRef parses an image of the form [host/]repository:tag.  The first
+// path component is a registry host if it contains a dot or a port or is
+// localhost, otherwise the image is on Docker Hub.
+func ParseImageRef(image string) (ImageRef, error) {
+	image = strings.TrimPrefix(image, "docker:")
+	ref := ImageRef{
+		Host: DOCKER_HUB_HOST,
+	}
+
+	pos := strings.LastIndex(image, ":")
+	if pos == -1 || pos < strings.LastIndex(image, "/") {
+		return ref, fmt.Errorf("invalid image %s: expected repository:tag", image)
+	}
+	ref.Repository, ref.Tag = image[:pos], image[pos+1:]
+
+	if pieces := strings.SplitN(ref.Repository, "/", 2); len(pieces) == 2 && isRegistryHost(pieces[0]) {
+		ref.Host, ref.Repository = pieces[0], pieces[1]
+	}
+
+	if ref.Host == DOCKER_HUB_HOST && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+
+	return ref, nil
+}
+
+func isRegistryHost(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// ImagePromoter copies images between repositories and registries without
+// pulling them through a docker daemon.
+type ImagePromoter struct {
+	// Registry returns the registry client for a registry host
+	Registry func(host string) (PromotionRegistry, error)
+}
+
+// NewImagePromoter creates a promoter that uses the registries defined in the
+// cli config, falling back to the DOCKER_REGISTRY_* environment variables.
+func NewImagePromoter(conf *CliConfig) *ImagePromoter {
+	clients := make(map[string]PromotionRegistry)
+
+	return &ImagePromoter{
+		Registry: func(host string) (PromotionRegistry, error) {
+			if client, ok := clients[host]; ok {
+				return client, nil
+			}
+
+			creds := conf.RegistryCredentials(host)
+			client, err := registry.New(creds.Url, creds.Username, creds.Password)
+			if err != nil {
+				return nil, err
+			}
+
+			clients[host] = client
+			return client, nil
+		},
+	}
+}
+
+// Promote copies the manifest of src and any layers missing from the
+// destination repository to dst.
+func (promoter *ImagePromoter) Promote(src, dst ImageRef) error {
+	srcRegistry, err := promoter.Registry(src.Host)
+	if err != nil {
+		return err
+	}
+
+	dstRegistry, err := promoter.Registry(dst.Host)
+	if err != nil {
+		return err
+	}
+
+	srcManifest, err := srcRegistry.Manifest(src.Repository, src.Tag)
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range uniqueLayers(srcManifest) {
+		exists, err := dstRegistry.HasLayer(dst.Repository, layer)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			log.Debugf("Layer %s already exists in %s", layer, dst)
+			continue
+		}
+
+		log.Debugf("Copying layer %s from %s to %s", layer, src, dst)
+		if err := copyLayer(srcRegistry, src, dstRegistry, dst, layer); err != nil {
+			return err
+		}
+	}
+
+	dstManifest := srcManifest
+	if srcManifest.Name != dst.Repository || srcManifest.Tag != dst.Tag {
+		if dstManifest, err = resignManifest(srcManifest, dst); err != nil {
+			return err
+		}
+	}
+
+	return dstRegistry.PutManifest(dst.Repository, dst.Tag, dstManifest)
+}
+
+func copyLayer(srcRegistry PromotionRegistry, src ImageRef, dstRegistry PromotionRegistry, dst ImageRef, layer digest.Digest) error {
+	content, err := srcRegistry.DownloadLayer(src.Repository, layer)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return dstRegistry.UploadLayer(dst.Repository, layer, content)
+}
+
+// Schema1 manifests list a layer once for every history entry, including
+// empty layers that are shared, so only copy each digest once.
+func uniqueLayers(signed *manifest.SignedManifest) []digest.Digest {
+	seen := make(map[digest.Digest]bool)
+	layers := []digest.Digest{}
+
+	for _, layer := range signed.FSLayers {
+		if seen[layer.BlobSum] {
+			continue
+		}
+		seen[layer.BlobSum] = true
+		layers = append(layers, layer.BlobSum)
+	}
+	return layers
+}
+
+// Schema1 manifests embed the repository name and tag and are signed, so the
+// manifest has to be re-signed when the image is pushed under a new name.
+func resignManifest(signed *manifest.SignedManifest, dst ImageRef) (*manifest.SignedManifest, error) {
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	m := signed.Manifest
+	m.Name = dst.Repository
+	m.Tag = dst.Tag
+
+	return manifest.Sign(&m, key)
+}
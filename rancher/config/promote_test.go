@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/libtrust"
+)
+
+var (
+	layerOne = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	layerTwo = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+)
+
+// In memory registry keeping manifests by repository:tag and layers by repository
+type memoryRegistry struct {
+	manifests map[string]*schema1.SignedManifest
+	layers    map[string]map[digest.Digest][]byte
+	uploads   int
+}
+
+func newMemoryRegistry() *memoryRegistry {
+	return &memoryRegistry{
+		manifests: make(map[string]*schema1.SignedManifest),
+		layers:    make(map[string]map[digest.Digest][]byte),
+	}
+}
+
+func (reg *memoryRegistry) Manifest(repository, reference string) (*schema1.SignedManifest, error) {
+	m, ok := reg.manifests[repository+":"+reference]
+	if !ok {
+		return nil, errors.New("manifest unknown")
+	}
+	return m, nil
+}
+
+func (reg *memoryRegistry) PutManifest(repository, reference string, signed *schema1.SignedManifest) error {
+	if signed.Name != repository || signed.Tag != reference {
+		return errors.New("manifest name or tag invalid")
+	}
+	if _, err := schema1.Verify(signed); err != nil {
+		return err
+	}
+	for _, layer := range signed.FSLayers {
+		if ok, _ := reg.HasLayer(repository, layer.BlobSum); !ok {
+			return errors.New("manifest blob unknown")
+		}
+	}
+	reg.manifests[repository+":"+reference] = signed
+	return nil
+}
+
+func (reg *memoryRegistry) HasLayer(repository string, dgst digest.Digest) (bool, error) {
+	_, ok := reg.layers[repository][dgst]
+	return ok, nil
+}
+
+func (reg *memoryRegistry) DownloadLayer(repository string, dgst digest.Digest) (io.ReadCloser, error) {
+	content, ok := reg.layers[repository][dgst]
+	if !ok {
+		return nil, errors.New("blob unknown")
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (reg *memoryRegistry) UploadLayer(repository string, dgst digest.Digest, content io.Reader) error {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	if _, ok := reg.layers[repository]; !ok {
+		reg.layers[repository] = make(map[digest.Digest][]byte)
+	}
+	reg.layers[repository][dgst] = data
+	reg.uploads++
+	return nil
+}
+
+func (reg *memoryRegistry) push(t *testing.T, repository, tag string, layers ...digest.Digest) {
+	m := &schema1.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 1},
+		Name:      repository,
+		Tag:       tag,
+	}
+	for _, layer := range layers {
+		m.FSLayers = append(m.FSLayers, schema1.FSLayer{BlobSum: layer})
+		m.History = append(m.History, schema1.History{V1Compatibility: "{}"})
+		reg.UploadLayer(repository, layer, bytes.NewBufferString(string(layer)))
+	}
+
+	key, _ := libtrust.GenerateECP256PrivateKey()
+	signed, err := schema1.Sign(m, key)
+	if err != nil {
+		t.Fatalf("failed to sign manifest: %v", err)
+	}
+	reg.manifests[repository+":"+tag] = signed
+	reg.uploads = 0
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		Image    string
+		Expected ImageRef
+		Error    bool
+	}{
+		{"nowait/api:1.0", ImageRef{DOCKER_HUB_HOST, "nowait/api", "1.0"}, false},
+		{"redis:3.2", ImageRef{DOCKER_HUB_HOST, "library/redis", "3.2"}, false},
+		{"docker:nowait/api:1.0", ImageRef{DOCKER_HUB_HOST, "nowait/api", "1.0"}, false},
+		{"registry.example.com/nowait/api:1.0", ImageRef{"registry.example.com", "nowait/api", "1.0"}, false},
+		{"localhost:5000/api:1.0", ImageRef{"localhost:5000", "api", "1.0"}, false},
+		{"nowait/api", ImageRef{}, true},
+		{"localhost:5000/api", ImageRef{}, true},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseImageRef(test.Image)
+
+		if test.Error {
+			if err == nil {
+				t.Errorf("parsing %s should have failed", test.Image)
+			}
+			continue
+		}
+
+		if err != nil || ref != test.Expected {
+			t.Errorf("parsing %s returned %#v, %v but expected %#v", test.Image, ref, err, test.Expected)
+		}
+	}
+}
+
+func TestPromoteBetweenRegistries(t *testing.T) {
+	staging := newMemoryRegistry()
+	production := newMemoryRegistry()
+	staging.push(t, "nowait/api", "1.0", layerOne, layerTwo, layerOne)
+	production.push(t, "nowait/api", "0.9", layerOne)
+
+	promoter := &ImagePromoter{
+		Registry: func(host string) (PromotionRegistry, error) {
+			if host == "staging.example.com" {
+				return staging, nil
+			}
+			return production, nil
+		},
+	}
+
+	src, _ := ParseImageRef("staging.example.com/nowait/api:1.0")
+	dst, _ := ParseImageRef("production.example.com/nowait/api:1.0")
+
+	if err := promoter.Promote(src, dst); err != nil {
+		t.Fatalf("promotion failed with: %v", err)
+	}
+
+	if production.uploads != 1 {
+		t.Errorf("only the missing layer should have been uploaded, uploaded %d", production.uploads)
+	}
+
+	if _, err := production.Manifest("nowait/api", "1.0"); err != nil {
+		t.Errorf("promoted manifest should exist in the destination registry")
+	}
+}
+
+func TestPromoteToNewRepositoryAndTag(t *testing.T) {
+	registry := newMemoryRegistry()
+	registry.push(t, "nowait/api-staging", "build-12", layerOne, layerTwo)
+
+	promoter := &ImagePromoter{
+		Registry: func(host string) (PromotionRegistry, error) {
+			return registry, nil
+		},
+	}
+
+	src, _ := ParseImageRef("nowait/api-staging:build-12")
+	dst, _ := ParseImageRef("nowait/api:1.0")
+
+	if err := promoter.Promote(src, dst); err != nil {
+		t.Fatalf("promotion failed with: %v", err)
+	}
+
+	m, err := registry.Manifest("nowait/api", "1.0")
+	if err != nil || m.Name != "nowait/api" || m.Tag != "1.0" {
+		t.Errorf("promoted manifest should be re-signed with the new repository and tag")
+	}
+}
+
+func TestPromoteFailsWhenSourceIsMissing(t *testing.T) {
+	registry := newMemoryRegistry()
+	promoter := &ImagePromoter{
+		Registry: func(host string) (PromotionRegistry, error) {
+			return registry, nil
+		},
+	}
+
+	src, _ := ParseImageRef("nowait/api:1.0")
+	dst, _ := ParseImageRef("nowait/api-prod:1.0")
+
+	if err := promoter.Promote(src, dst); err == nil {
+		t.Errorf("promoting a missing image should fail")
+	}
+}
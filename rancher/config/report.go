@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	REPORT_JUNIT = "junit"
+	REPORT_JSON  = "json"
+)
+
+// ReportTarget is a file a deployment report is written to in a format.
+type ReportTarget struct {
+	Format string
+	Path   string
+}
+
+// ParseReportTargets parses --report values given as format=path.
+func ParseReportTargets(values []string) ([]ReportTarget, error) {
+	targets := []ReportTarget{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid report %q, expected %s=path or %s=path", value, REPORT_JUNIT, REPORT_JSON)
+		}
+		if parts[0] != REPORT_JUNIT && parts[0] != REPORT_JSON {
+			return nil, fmt.Errorf("invalid report format %q, expected %s or %s", parts[0], REPORT_JUNIT, REPORT_JSON)
+		}
+		targets = append(targets, ReportTarget{Format: parts[0], Path: parts[1]})
+	}
+	return targets, nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseReportTargets(t *testing.T) {
+	targets, err := ParseReportTargets([]string{"junit=reports/deploy.xml", "json=deploy.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ReportTarget{{Format: REPORT_JUNIT, Path: "reports/deploy.xml"}, {Format: REPORT_JSON, Path: "deploy.json"}}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("expected %v, received %v", expected, targets)
+	}
+
+	for _, value := range []string{"junit", "junit=", "html=report.html"} {
+		if _, err := ParseReportTargets([]string{value}); err == nil {
+			t.Errorf("expected report %q to be invalid", value)
+		}
+	}
+}
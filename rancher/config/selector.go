@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/rancher/go-rancher/client"
+)
+
+// ServiceSelector picks services by name patterns and labels.  Patterns are
+// globs, or regular expressions when enclosed in slashes.  Patterns that
+// contain a slash otherwise are matched against stack/service.
+type ServiceSelector struct {
+	Patterns []*regexp.Regexp
+	// Patterns matched against stack/service instead of the service name
+	StackPatterns []bool
+	Labels        []LabelRequirement
+	Exclude       []*regexp.Regexp
+	ExcludeStack  []bool
+}
+
+// LabelRequirement is a single term of a label selector: key=value,
+// key!=value, key or !key.
+type LabelRequirement struct {
+	Key    string
+	Value  string
+	Negate bool
+	// Only the presence of the key is checked
+	Exists bool
+}
+
+// NewServiceSelector parses the patterns, label selector and exclusions.
+func NewServiceSelector(patterns []string, labels string, exclude []string) (*ServiceSelector, error) {
+	sel := &ServiceSelector{}
+
+	for _, pattern := range patterns {
+		re, err := compileNamePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		sel.Patterns = append(sel.Patterns, re)
+		sel.StackPatterns = append(sel.StackPatterns, addressesStack(pattern))
+	}
+
+	for _, pattern := range exclude {
+		re, err := compileNamePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		sel.Exclude = append(sel.Exclude, re)
+		sel.ExcludeStack = append(sel.ExcludeStack, addressesStack(pattern))
+	}
+
+	requirements, err := ParseLabelSelector(labels)
+	if err != nil {
+		return nil, err
+	}
+	sel.Labels = requirements
+
+	return sel, nil
+}
+
+// ParseLabelSelector parses a comma separated label selector such as
+// tier=api,!canary,env!=prod.
+func ParseLabelSelector(selector string) ([]LabelRequirement, error) {
+	requirements := []LabelRequirement{}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		req := LabelRequirement{}
+		switch {
+		case strings.Contains(term, "!="):
+			pieces := strings.SplitN(term, "!=", 2)
+			req.Key, req.Value, req.Negate = pieces[0], pieces[1], true
+		case strings.Contains(term, "="):
+			pieces := strings.SplitN(term, "=", 2)
+			req.Key, req.Value = pieces[0], pieces[1]
+		case strings.HasPrefix(term, "!"):
+			req.Key, req.Exists, req.Negate = term[1:], true, true
+		default:
+			req.Key, req.Exists = term, true
+		}
+
+		req.Key = strings.TrimSpace(req.Key)
+		req.Value = strings.TrimSpace(req.Value)
+		if req.Key == "" {
+			return nil, fmt.Errorf("invalid label selector term %s", term)
+		}
+		requirements = append(requirements, req)
+	}
+	return requirements, nil
+}
+
+// Empty reports whether the selector has no patterns, labels or exclusions.
+func (sel *ServiceSelector) Empty() bool {
+	return sel == nil || (len(sel.Patterns) == 0 && len(sel.Labels) == 0 && len(sel.Exclude) == 0)
+}
+
+// Selective reports whether the selector picks services by itself, rather
+// than only narrowing down services matched otherwise.
+func (sel *ServiceSelector) Selective() bool {
+	return sel != nil && (len(sel.Patterns) > 0 || len(sel.Labels) > 0)
+}
+
+// Matches reports whether the service of the named stack matches any of the
+// patterns, every label requirement and none of the exclusions.
+func (sel *ServiceSelector) Matches(stack string, service *client.Service) bool {
+	if sel == nil {
+		return true
+	}
+
+	if len(sel.Patterns) > 0 && !matchesName(sel.Patterns, sel.StackPatterns, stack, service.Name) {
+		return false
+	}
+
+	labels := map[string]interface{}{}
+	if service.LaunchConfig != nil && service.LaunchConfig.Labels != nil {
+		labels = service.LaunchConfig.Labels
+	}
+	for _, req := range sel.Labels {
+		if !req.Matches(labels) {
+			return false
+		}
+	}
+
+	return !matchesName(sel.Exclude, sel.ExcludeStack, stack, service.Name)
+}
+
+// Matches reports whether the labels satisfy the requirement.
+func (req LabelRequirement) Matches(labels map[string]interface{}) bool {
+	value, ok := labels[req.Key]
+	if req.Exists {
+		return ok != req.Negate
+	}
+	equal := ok && fmt.Sprint(value) == req.Value
+	return equal != req.Negate
+}
+
+func matchesName(patterns []*regexp.Regexp, stackPatterns []bool, stack, name string) bool {
+	for i, re := range patterns {
+		subject := name
+		if stackPatterns[i] {
+			subject = stack + "/" + name
+		}
+		if re.MatchString(subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRegexPattern(pattern string) bool {
+	return len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+func addressesStack(pattern string) bool {
+	if isRegexPattern(pattern) {
+		return strings.Contains(pattern[1:len(pattern)-1], "/")
+	}
+	return strings.Contains(pattern, "/")
+}
+
+// Compile a glob, where * matches any text and ? a single character, or a
+// regular expression enclosed in slashes.
+func compileNamePattern(pattern string) (*regexp.Regexp, error) {
+	if isRegexPattern(pattern) {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s: %v", pattern, err)
+		}
+		return re, nil
+	}
+	return compose.GlobToRegexp(pattern)
+}
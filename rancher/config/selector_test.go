@@ -0,0 +1,85 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func labeledService(name string, labels map[string]interface{}) *client.Service {
+	return &client.Service{
+		Name:         name,
+		LaunchConfig: &client.LaunchConfig{Labels: labels},
+	}
+}
+
+func TestServiceSelectorMatches(t *testing.T) {
+	api := labeledService("api", map[string]interface{}{"tier": "api"})
+	canary := labeledService("api-canary", map[string]interface{}{"tier": "api", "canary": "true"})
+	worker := labeledService("worker", map[string]interface{}{"tier": "jobs"})
+
+	tests := []struct {
+		Description string
+		Patterns    []string
+		Labels      string
+		Exclude     []string
+		Stack       string
+		Service     *client.Service
+		Matches     bool
+	}{
+		{Description: "empty selector", Service: worker, Matches: true},
+		{Description: "glob", Patterns: []string{"api*"}, Service: canary, Matches: true},
+		{Description: "glob is anchored", Patterns: []string{"pi*"}, Service: api, Matches: false},
+		{Description: "single character glob", Patterns: []string{"ap?"}, Service: api, Matches: true},
+		{Description: "any of the patterns", Patterns: []string{"api", "work*"}, Service: worker, Matches: true},
+		{Description: "regular expression", Patterns: []string{"/^(api|worker)$/"}, Service: worker, Matches: true},
+		{Description: "regular expression is not anchored", Patterns: []string{"/can/"}, Service: canary, Matches: true},
+		{Description: "stack pattern", Patterns: []string{"backend/*"}, Stack: "backend", Service: api, Matches: true},
+		{Description: "stack pattern of another stack", Patterns: []string{"backend/*"}, Stack: "jobs", Service: worker, Matches: false},
+		{Description: "label value", Labels: "tier=api", Service: api, Matches: true},
+		{Description: "other label value", Labels: "tier=api", Service: worker, Matches: false},
+		{Description: "negated label value", Labels: "tier!=api", Service: worker, Matches: true},
+		{Description: "missing label", Labels: "tier=api,!canary", Service: canary, Matches: false},
+		{Description: "label present", Labels: "canary", Service: canary, Matches: true},
+		{Description: "service without labels", Labels: "tier", Service: &client.Service{Name: "bare"}, Matches: false},
+		{Description: "excluded glob", Patterns: []string{"api*"}, Exclude: []string{"*-canary"}, Service: canary, Matches: false},
+		{Description: "excluded regular expression", Exclude: []string{"/^work/"}, Service: worker, Matches: false},
+		{Description: "not excluded", Exclude: []string{"*-canary"}, Service: api, Matches: true},
+	}
+
+	for _, test := range tests {
+		sel, err := NewServiceSelector(test.Patterns, test.Labels, test.Exclude)
+		if err != nil {
+			t.Fatalf("%s: parsing the selector failed: %v", test.Description, err)
+		}
+		if matches := sel.Matches(test.Stack, test.Service); matches != test.Matches {
+			t.Errorf("%s: expected match %v, received %v", test.Description, test.Matches, matches)
+		}
+	}
+}
+
+func TestNewServiceSelectorErrors(t *testing.T) {
+	if _, err := NewServiceSelector([]string{"/api(/"}, "", nil); err == nil {
+		t.Errorf("an invalid regular expression should fail")
+	}
+	if _, err := NewServiceSelector(nil, "tier=api,=canary", nil); err == nil {
+		t.Errorf("a label term without a key should fail")
+	}
+}
+
+func TestServiceSelectorSelective(t *testing.T) {
+	var none *ServiceSelector
+	if !none.Empty() || none.Selective() {
+		t.Errorf("a missing selector should be empty")
+	}
+
+	exclude, _ := NewServiceSelector(nil, "", []string{"api"})
+	if exclude.Empty() || exclude.Selective() {
+		t.Errorf("exclusions alone should not select services")
+	}
+
+	labels, _ := NewServiceSelector(nil, "tier=api", nil)
+	if !labels.Selective() {
+		t.Errorf("a label selector should select services")
+	}
+}
@@ -0,0 +1,68 @@
+package rancher
+
+import (
+	"github.com/rancher/go-rancher/client"
+)
+
+// Retrieve the containers of a service through its service expose maps.
+func (cli *Client) ServiceContainers(service *client.Service) ([]client.Container, error) {
+	filters := make(map[string]interface{})
+	filters["serviceId"] = service.Id
+	maps, err := cli.listServiceExposeMaps(filters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []client.Container{}
+	for _, exposeMap := range maps {
+		if exposeMap.InstanceId == "" || exposeMap.State == "removed" {
+			continue
+		}
+
+		container, err := cli.RancherClient.Container.ById(exposeMap.InstanceId)
+
+		if err != nil {
+			return nil, err
+		}
+		// Purged since the expose maps were listed
+		if container == nil {
+			continue
+		}
+
+		containers = append(containers, *container)
+	}
+
+	return containers, nil
+}
+
+// Retrieve the hosts the containers of a service are running on.
+func (cli *Client) ServiceHosts(service *client.Service) ([]client.Host, error) {
+	containers, err := cli.ServiceContainers(service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	hosts := []client.Host{}
+	for _, container := range containers {
+		if container.HostId == "" || seen[container.HostId] {
+			continue
+		}
+		seen[container.HostId] = true
+
+		host, err := cli.RancherClient.Host.ById(container.HostId)
+
+		if err != nil {
+			return nil, err
+		}
+		if host == nil {
+			continue
+		}
+
+		hosts = append(hosts, *host)
+	}
+
+	return hosts, nil
+}
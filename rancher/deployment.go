@@ -0,0 +1,397 @@
+package rancher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	// Key of the stamp of the last deployment in the metadata of a service
+	DEPLOYMENT_METADATA_KEY = "io.nowait.rancher-cli.deployment"
+	// Key of the revisions kept by RancherHistoryStore
+	HISTORY_METADATA_KEY = "io.nowait.rancher-cli.history"
+
+	HISTORY_STORE_RANCHER = "rancher"
+	HISTORY_STORE_LOCAL   = "local"
+
+	DEFAULT_HISTORY_KEEP = 10
+)
+
+// Deployment describes who deployed what to a service and why.
+type Deployment struct {
+	Deployer       string    `json:"deployer"`
+	Time           time.Time `json:"time"`
+	Version        string    `json:"version"`
+	PreviousImages []string  `json:"previousImages"`
+	Images         []string  `json:"images"`
+	Reason         string    `json:"reason,omitempty"`
+	GitSha         string    `json:"gitSha,omitempty"`
+	// Freezes overridden to deploy, the reason tells why
+	FreezeOverride string `json:"freezeOverride,omitempty"`
+	// Revision whose launch configs were applied again
+	RollbackTo int `json:"rollbackTo,omitempty"`
+}
+
+// Revision is a launch config of a service kept in the history along with
+// the deployment that applied it.
+type Revision struct {
+	Number int `json:"number"`
+	Deployment
+	LaunchConfig           *client.LaunchConfig `json:"launchConfig"`
+	SecondaryLaunchConfigs []interface{}        `json:"secondaryLaunchConfigs,omitempty"`
+}
+
+// HistoryStore keeps the revisions of services by service id, oldest first.
+type HistoryStore interface {
+	Load(serviceId string) ([]Revision, error)
+	Save(serviceId string, revisions []Revision) error
+}
+
+// LocalHistoryStore keeps the revisions in a file per service in a directory.
+type LocalHistoryStore struct {
+	Dir string
+}
+
+func (store *LocalHistoryStore) path(serviceId string) string {
+	return filepath.Join(store.Dir, serviceId+".json")
+}
+
+func (store *LocalHistoryStore) Load(serviceId string) ([]Revision, error) {
+	data, err := ioutil.ReadFile(store.path(serviceId))
+	if os.IsNotExist(err) {
+		return []Revision{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []Revision{}
+	if err := json.Unmarshal(data, &revisions); err != nil {
+		return nil, fmt.Errorf("invalid service history %s: %v", store.path(serviceId), err)
+	}
+	return revisions, nil
+}
+
+func (store *LocalHistoryStore) Save(serviceId string, revisions []Revision) error {
+	if err := os.MkdirAll(store.Dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(revisions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path(serviceId), data, 0600)
+}
+
+// RancherHistoryStore keeps the revisions in the metadata of the service so
+// everybody deploying the service sees them.  The metadata is readable from
+// every container through the metadata service, environment values included.
+type RancherHistoryStore struct {
+	Client *Client
+}
+
+func (store *RancherHistoryStore) Load(serviceId string) ([]Revision, error) {
+	service, err := store.Client.RancherClient.Service.ById(serviceId)
+	if err != nil {
+		return nil, err
+	}
+	if service == nil {
+		return nil, fmt.Errorf("failed to find service with id %s", serviceId)
+	}
+
+	revisions := []Revision{}
+	if err := decodeMetadata(service, HISTORY_METADATA_KEY, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (store *RancherHistoryStore) Save(serviceId string, revisions []Revision) error {
+	service, err := store.Client.RancherClient.Service.ById(serviceId)
+	if err != nil {
+		return err
+	}
+	if service == nil {
+		return fmt.Errorf("failed to find service with id %s", serviceId)
+	}
+
+	_, err = store.Client.setServiceMetadata(service, HISTORY_METADATA_KEY, revisions)
+	return err
+}
+
+// NewHistoryStore returns the named kind of history store, local by default.
+// Local stores keep their files in the services folder of the state dir.
+func NewHistoryStore(kind string, cli *Client, stateDir string) (HistoryStore, error) {
+	switch kind {
+	case HISTORY_STORE_RANCHER:
+		return &RancherHistoryStore{Client: cli}, nil
+	case HISTORY_STORE_LOCAL, "":
+		return &LocalHistoryStore{Dir: filepath.Join(stateDir, "services")}, nil
+	}
+	return nil, fmt.Errorf("invalid history store %s, expected %s or %s", kind, HISTORY_STORE_RANCHER, HISTORY_STORE_LOCAL)
+}
+
+// ServiceDeployment returns the stamp of the last deployment of the service,
+// or nil when it was never deployed by the cli.
+func ServiceDeployment(service *client.Service) (*Deployment, error) {
+	if _, ok := service.Metadata[DEPLOYMENT_METADATA_KEY]; !ok {
+		return nil, nil
+	}
+	deployment := &Deployment{}
+	if err := decodeMetadata(service, DEPLOYMENT_METADATA_KEY, deployment); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// ServiceHistory returns the revisions of the named service, oldest first.
+func (cli *Client) ServiceHistory(name string) ([]Revision, error) {
+	if cli.History == nil {
+		return nil, errors.New("no history store is configured")
+	}
+
+	service, err := cli.ServiceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return cli.History.Load(service.Id)
+}
+
+// RollbackService upgrades the named service to the launch configs of a
+// revision of its history.  With Wait the rollback is finished once it
+// completes and cancelled when it fails.
+func (cli *Client) RollbackService(name string, to int, opts config.UpgradeOpts) (*client.Service, error) {
+	started := time.Now()
+	service, err := cli.rollbackService(name, to, opts)
+	cli.reportService(started, service, err)
+	return service, err
+}
+
+func (cli *Client) rollbackService(name string, to int, opts config.UpgradeOpts) (*client.Service, error) {
+	if cli.History == nil {
+		return nil, errors.New("no history store is configured")
+	}
+
+	service, err := cli.ServiceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := cli.History.Load(service.Id)
+	if err != nil {
+		return service, err
+	}
+	var revision *Revision
+	for i := range revisions {
+		if revisions[i].Number == to {
+			revision = &revisions[i]
+		}
+	}
+	if revision == nil {
+		return service, fmt.Errorf("service %s has no revision %d, see service history", service.Name, to)
+	}
+	if revision.LaunchConfig == nil {
+		return service, fmt.Errorf("revision %d of service %s has no launch config", to, service.Name)
+	}
+
+	service, lock, err := cli.lockUpgrade(service, opts.LockTTL)
+	if err != nil {
+		return service, err
+	}
+	defer cli.unlockUpgrade(service, lock)
+
+	previous, err := copyLaunchConfigs(service)
+	if err != nil {
+		return service, err
+	}
+
+	upgrade := &client.ServiceUpgrade{
+		InServiceStrategy: &client.InServiceUpgradeStrategy{
+			BatchSize:              1,
+			IntervalMillis:         int64(opts.Interval) / (int64(math.Pow10(6))),
+			StartFirst:             true,
+			LaunchConfig:           revision.LaunchConfig,
+			SecondaryLaunchConfigs: revision.SecondaryLaunchConfigs,
+		},
+	}
+	if err := cli.ValidateUpgrade(service, upgrade, opts); err != nil {
+		return service, err
+	}
+
+	upgraded, err := cli.RancherClient.Service.ActionUpgrade(service, upgrade)
+	if err != nil {
+		return service, errors.Wrapf(err, "Failed to roll back service %s", service.Name)
+	}
+	upgraded = cli.recordDeployment(upgraded, previous, upgrade, opts, to)
+	if upgraded != nil {
+		cli.notifyUpgrade(config.NOTIFY_ROLLBACK, []UpgradeResult{{Service: upgraded}}, opts)
+	}
+
+	if !opts.Wait {
+		return upgraded, nil
+	}
+
+	if err := Wait(cli, upgraded, opts); err != nil {
+		if _, cancelErr := cli.RancherClient.Service.ActionCancelupgrade(upgraded); cancelErr != nil {
+			return upgraded, fmt.Errorf("%v, cancelling the rollback also failed: %v", err, cancelErr)
+		}
+		return upgraded, err
+	}
+	return cli.RancherClient.Service.ActionFinishupgrade(upgraded)
+}
+
+// Stamp the service with the deployment and record the launch configs it
+// was upgraded to in the history.  The upgrade has started at this point, so
+// failing to record it is only logged.
+func (cli *Client) recordDeployment(service *client.Service, previous *client.Service, upgrade *client.ServiceUpgrade, opts config.UpgradeOpts, rollbackTo int) *client.Service {
+	if service == nil {
+		return nil
+	}
+
+	upgraded := &client.Service{
+		LaunchConfig:           upgrade.InServiceStrategy.LaunchConfig,
+		SecondaryLaunchConfigs: upgrade.InServiceStrategy.SecondaryLaunchConfigs,
+	}
+	deployment := Deployment{
+		Deployer:       CurrentUser(),
+		Time:           time.Now().UTC().Truncate(time.Second),
+		Version:        cli.CliVersion,
+		PreviousImages: serviceImageNames(previous),
+		Images:         serviceImageNames(upgraded),
+		Reason:         opts.Reason,
+		GitSha:         opts.GitSha,
+		FreezeOverride: opts.FreezeOverride,
+		RollbackTo:     rollbackTo,
+	}
+
+	if stamped, err := cli.setServiceMetadata(service, DEPLOYMENT_METADATA_KEY, deployment); err != nil {
+		log.Warnf("Failed to record the deployment on service %s: %v", service.Name, err)
+	} else if stamped != nil {
+		service = stamped
+	}
+
+	if cli.History == nil {
+		return service
+	}
+
+	current, err := copyLaunchConfigs(upgraded)
+	if err == nil {
+		err = cli.addRevision(service.Id, previous, Revision{
+			Deployment:             deployment,
+			LaunchConfig:           current.LaunchConfig,
+			SecondaryLaunchConfigs: current.SecondaryLaunchConfigs,
+		})
+	}
+	if err != nil {
+		log.Warnf("Failed to record the launch config of service %s in the history: %v", service.Name, err)
+	}
+
+	// The history may have been stored in the metadata of the service
+	if reloaded, err := cli.RancherClient.Service.ById(service.Id); err == nil && reloaded != nil {
+		return reloaded
+	}
+	return service
+}
+
+// Append the revision to the history of the service, keeping the newest
+// HistoryKeep revisions.  The first revision recorded for a service is
+// preceded by its launch configs before the upgrade, so it can be rolled
+// back to them.
+func (cli *Client) addRevision(serviceId string, previous *client.Service, revision Revision) error {
+	revisions, err := cli.History.Load(serviceId)
+	if err != nil {
+		return err
+	}
+
+	if len(revisions) == 0 && previous.LaunchConfig != nil {
+		revisions = append(revisions, Revision{
+			Number: 1,
+			Deployment: Deployment{
+				Images: serviceImageNames(previous),
+			},
+			LaunchConfig:           previous.LaunchConfig,
+			SecondaryLaunchConfigs: previous.SecondaryLaunchConfigs,
+		})
+	}
+
+	revision.Number = 1
+	if len(revisions) > 0 {
+		revision.Number = revisions[len(revisions)-1].Number + 1
+	}
+	revisions = append(revisions, revision)
+
+	keep := cli.HistoryKeep
+	if keep <= 0 {
+		keep = DEFAULT_HISTORY_KEEP
+	}
+	if len(revisions) > keep {
+		revisions = revisions[len(revisions)-keep:]
+	}
+
+	return cli.History.Save(serviceId, revisions)
+}
+
+// Write the value under the key of the metadata of the service, removing the
+// key when the value is nil.  The metadata is replaced as a whole so the
+// other keys are sent along.
+func (cli *Client) setServiceMetadata(service *client.Service, key string, value interface{}) (*client.Service, error) {
+	metadata := make(map[string]interface{})
+	for existing, v := range service.Metadata {
+		if existing != key {
+			metadata[existing] = v
+		}
+	}
+	if value != nil {
+		metadata[key] = value
+	}
+
+	return cli.RancherClient.Service.Update(service, map[string]interface{}{
+		"metadata": metadata,
+	})
+}
+
+// Decode the value under the key of the metadata of the service.  The
+// metadata is decoded into maps, so it is encoded again to read it.
+func decodeMetadata(service *client.Service, key string, value interface{}) error {
+	raw, ok := service.Metadata[key]
+	if !ok || raw == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("invalid %s in the metadata of service %s: %v", key, service.Name, err)
+	}
+	return nil
+}
+
+// Return a copy of the launch configs of the service, which are changed in
+// place when preparing an upgrade.
+func copyLaunchConfigs(service *client.Service) (*client.Service, error) {
+	data, err := json.Marshal(struct {
+		LaunchConfig           *client.LaunchConfig `json:"launchConfig"`
+		SecondaryLaunchConfigs []interface{}        `json:"secondaryLaunchConfigs"`
+	}{service.LaunchConfig, service.SecondaryLaunchConfigs})
+	if err != nil {
+		return nil, err
+	}
+
+	copied := &client.Service{}
+	if err := json.Unmarshal(data, copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
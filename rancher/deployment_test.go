@@ -0,0 +1,136 @@
+package rancher
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/rancher/go-rancher/client"
+)
+
+// DeployedServices applies upgrades to the launch config of the service.
+type DeployedServices struct {
+	LockingServices
+	Upgrades []*client.ServiceUpgrade
+}
+
+func (srv *DeployedServices) ActionUpgrade(service *client.Service, upgrade *client.ServiceUpgrade) (*client.Service, error) {
+	srv.Upgrades = append(srv.Upgrades, upgrade)
+	current := srv.Services[service.Id]
+	current.LaunchConfig = upgrade.InServiceStrategy.LaunchConfig
+	return srv.ById(service.Id)
+}
+
+func deployedClient(t *testing.T, store string) (*Client, *DeployedServices, func()) {
+	dir, err := ioutil.TempDir("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli, locking := lockingClient(nil)
+	services := &DeployedServices{LockingServices: *locking}
+	services.Services["1s1"].LaunchConfig = &client.LaunchConfig{ImageUuid: "docker:nowait/api:1.0"}
+	cli.RancherClient.Service = services
+	cli.CliVersion = "1.2.3"
+	cli.HistoryKeep = 3
+	if cli.History, err = NewHistoryStore(store, cli, dir); err != nil {
+		t.Fatal(err)
+	}
+	return cli, services, func() { os.RemoveAll(dir) }
+}
+
+func TestUpgradeRecordsDeployment(t *testing.T) {
+	for _, store := range []string{HISTORY_STORE_RANCHER, HISTORY_STORE_LOCAL} {
+		cli, services, cleanup := deployedClient(t, store)
+		defer cleanup()
+
+		for _, tag := range []string{"1.1", "1.2", "1.3"} {
+			_, err := cli.UpgradeService(config.UpgradeOpts{
+				Service:    "backend/api",
+				RuntimeTag: tag,
+				Reason:     "release " + tag,
+				GitSha:     "abc123",
+				LockTTL:    time.Minute,
+
+				FreezeOverride: "friday",
+			})
+			if err != nil {
+				t.Fatalf("%s: upgrading to %s failed with: %v", store, tag, err)
+			}
+		}
+
+		deployment, err := ServiceDeployment(services.Services["1s1"])
+		if err != nil || deployment == nil {
+			t.Fatalf("%s: expected a deployment stamp, received %v %v", store, deployment, err)
+		}
+		if deployment.Version != "1.2.3" || deployment.Reason != "release 1.3" || deployment.GitSha != "abc123" ||
+			deployment.PreviousImages[0] != "nowait/api:1.2" || deployment.Images[0] != "nowait/api:1.3" || deployment.Deployer == "" {
+			t.Errorf("%s: deployment was not stamped correctly: %+v", store, deployment)
+		}
+		if lock, _ := ServiceLock(services.Services["1s1"]); lock != nil {
+			t.Errorf("%s: the lock should be released after the upgrade, found %v", store, lock)
+		}
+
+		revisions, err := cli.ServiceHistory("backend/api")
+		if err != nil {
+			t.Fatalf("%s: reading the history failed with: %v", store, err)
+		}
+		// The launch config before the first upgrade was revision 1
+		if len(revisions) != 3 || revisions[0].Number != 2 || revisions[2].Number != 4 {
+			t.Fatalf("%s: expected revisions 2 to 4, received %+v", store, revisions)
+		}
+		if image := revisions[0].LaunchConfig.ImageUuid; image != "docker:nowait/api:1.1" {
+			t.Errorf("%s: expected revision 2 to keep image 1.1, received %s", store, image)
+		}
+		if revisions[2].FreezeOverride != "friday" || revisions[2].Reason != "release 1.3" {
+			t.Errorf("%s: expected the freeze override recorded with its reason, received %+v", store, revisions[2].Deployment)
+		}
+	}
+}
+
+func TestRollbackService(t *testing.T) {
+	cli, services, cleanup := deployedClient(t, HISTORY_STORE_LOCAL)
+	defer cleanup()
+
+	for _, tag := range []string{"1.1", "1.2"} {
+		if _, err := cli.UpgradeService(config.UpgradeOpts{Service: "backend/api", RuntimeTag: tag}); err != nil {
+			t.Fatalf("upgrading to %s failed with: %v", tag, err)
+		}
+	}
+
+	if _, err := cli.RollbackService("backend/api", 7, config.UpgradeOpts{}); err == nil {
+		t.Errorf("rolling back to a missing revision should fail")
+	}
+
+	if _, err := cli.RollbackService("backend/api", 1, config.UpgradeOpts{Reason: "broken"}); err != nil {
+		t.Fatalf("rolling back failed with: %v", err)
+	}
+
+	upgrade := services.Upgrades[len(services.Upgrades)-1]
+	if image := upgrade.InServiceStrategy.LaunchConfig.ImageUuid; image != "docker:nowait/api:1.0" {
+		t.Errorf("expected the rollback to apply image 1.0, received %s", image)
+	}
+
+	revisions, err := cli.ServiceHistory("backend/api")
+	if err != nil {
+		t.Fatalf("reading the history failed with: %v", err)
+	}
+	last := revisions[len(revisions)-1]
+	if last.Number != 4 || last.RollbackTo != 1 || last.Reason != "broken" || last.PreviousImages[0] != "nowait/api:1.2" {
+		t.Errorf("expected the rollback to be recorded as revision 4, received %+v", last)
+	}
+}
+
+func TestNewHistoryStoreDefaultsToLocal(t *testing.T) {
+	store, err := NewHistoryStore("", &Client{}, "/tmp/state")
+
+	local, ok := store.(*LocalHistoryStore)
+	if err != nil || !ok || local.Dir != "/tmp/state/services" {
+		t.Errorf("expected a local store in the state dir, received %#v, %v", store, err)
+	}
+	if _, err := NewHistoryStore("elsewhere", &Client{}, "/tmp/state"); err == nil {
+		t.Errorf("expected an unknown store to be refused")
+	}
+}
@@ -0,0 +1,130 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	STACK_DIFF_CHANGED     = "changed"
+	STACK_DIFF_EQUAL       = "equal"
+	STACK_DIFF_ONLY_SOURCE = "only in source"
+	STACK_DIFF_ONLY_TARGET = "only in target"
+)
+
+// StackDiff is the comparison of the stacks with the same name in two
+// projects.
+type StackDiff struct {
+	Stack   string           `json:"stack"`
+	Status  string           `json:"status"`
+	Changes []compose.Change `json:"changes"`
+}
+
+// DiffStacks compares a stack of a project with a stack of a project of the
+// target server, which may be the same client, service by service.
+func (cli *Client) DiffStacks(target *Client, sourceProjectId, sourceStack, targetProjectId, targetStack string) ([]compose.Change, error) {
+	source, err := cli.projectStack(sourceProjectId, sourceStack)
+	if err != nil {
+		return nil, err
+	}
+
+	other, err := target.projectStack(targetProjectId, targetStack)
+	if err != nil {
+		return nil, err
+	}
+
+	return cli.compareStacks(target, source, other)
+}
+
+// DiffProjects compares every stack of a project with the stack of the same
+// name in the project of the target server, ordered by stack name.
+func (cli *Client) DiffProjects(target *Client, sourceProjectId, targetProjectId string) ([]StackDiff, error) {
+	sources, err := cli.projectStacks(sourceProjectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find stacks for source project")
+	}
+
+	targets, err := target.projectStacks(targetProjectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find stacks for target project")
+	}
+
+	names := sortedStackNames(sources)
+	for name := range targets {
+		if _, ok := sources[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := []StackDiff{}
+	for _, name := range names {
+		source, inSource := sources[name]
+		other, inTarget := targets[name]
+
+		diff := StackDiff{Stack: name, Changes: []compose.Change{}}
+		switch {
+		case !inTarget:
+			diff.Status = STACK_DIFF_ONLY_SOURCE
+		case !inSource:
+			diff.Status = STACK_DIFF_ONLY_TARGET
+		default:
+			changes, err := cli.compareStacks(target, source, other)
+			if err != nil {
+				return nil, err
+			}
+			diff.Changes = changes
+			diff.Status = STACK_DIFF_EQUAL
+			if len(changes) > 0 {
+				diff.Status = STACK_DIFF_CHANGED
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+
+	return diffs, nil
+}
+
+func (cli *Client) compareStacks(target *Client, source, other *client.Environment) ([]compose.Change, error) {
+	sourceConfig, err := cli.exportStack(source)
+	if err != nil {
+		return nil, err
+	}
+
+	targetConfig, err := target.exportStack(other)
+	if err != nil {
+		return nil, err
+	}
+
+	return compose.Compare(sourceConfig, targetConfig, compose.DefaultSecretPatterns)
+}
+
+func (cli *Client) exportStack(stack *client.Environment) (*compose.Config, error) {
+	exported, err := cli.RancherClient.Environment.ActionExportconfig(stack, &client.ComposeConfigInput{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to export stack %s", stack.Name)
+	}
+
+	cfg, err := compose.Parse(exported)
+	if err != nil {
+		return nil, fmt.Errorf("stack %s: %v", stack.Name, err)
+	}
+	return cfg, nil
+}
+
+func (cli *Client) projectStack(projectId, name string) (*client.Environment, error) {
+	stacks, err := cli.projectStacks(projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	stack, ok := stacks[name]
+	if !ok {
+		return nil, fmt.Errorf("failed to find stack with name %s", name)
+	}
+	return stack, nil
+}
@@ -0,0 +1,88 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+// DiffEnvironments exports the compose files of each stack by id.
+type DiffEnvironments struct {
+	CloneEnvironments
+	Composes map[string]string
+}
+
+func (env *DiffEnvironments) ActionExportconfig(stack *client.Environment, input *client.ComposeConfigInput) (*client.ComposeConfig, error) {
+	return &client.ComposeConfig{DockerComposeConfig: env.Composes[stack.Id]}, nil
+}
+
+func diffClient() *Client {
+	envs := &DiffEnvironments{
+		CloneEnvironments: CloneEnvironments{
+			Stacks: map[string][]client.Environment{
+				mocks.ProjectOne.Id: {
+					{Resource: client.Resource{Id: "1e1"}, Name: "api"},
+					{Resource: client.Resource{Id: "1e2"}, Name: "web"},
+					{Resource: client.Resource{Id: "1e3"}, Name: "monitoring"},
+				},
+				mocks.ProjectTwo.Id: {
+					{Resource: client.Resource{Id: "1e4"}, Name: "api"},
+					{Resource: client.Resource{Id: "1e5"}, Name: "web"},
+					{Resource: client.Resource{Id: "1e6"}, Name: "logging"},
+					{Resource: client.Resource{Id: "1e7"}, Name: "monitoring", State: "removed"},
+				},
+			},
+		},
+		Composes: map[string]string{
+			"1e1": "api:\n  image: nowait/api:1.0\n",
+			"1e2": "web:\n  image: nowait/web:1.0\n",
+			"1e4": "api:\n  image: nowait/api:1.1\n",
+			"1e5": "web:\n  image: nowait/web:1.0\n",
+		},
+	}
+	return &Client{
+		RancherClient: &client.RancherClient{
+			Environment: envs,
+		},
+	}
+}
+
+func TestDiffProjects(t *testing.T) {
+	cli := diffClient()
+
+	diffs, err := cli.DiffProjects(cli, mocks.ProjectOne.Id, mocks.ProjectTwo.Id)
+	if err != nil {
+		t.Fatalf("diffing the projects failed: %v", err)
+	}
+
+	expected := []StackDiff{
+		{Stack: "api", Status: STACK_DIFF_CHANGED, Changes: []compose.Change{
+			{Service: "api", Field: compose.FIELD_IMAGE, Old: "nowait/api:1.0", New: "nowait/api:1.1"},
+		}},
+		{Stack: "logging", Status: STACK_DIFF_ONLY_TARGET, Changes: []compose.Change{}},
+		{Stack: "monitoring", Status: STACK_DIFF_ONLY_SOURCE, Changes: []compose.Change{}},
+		{Stack: "web", Status: STACK_DIFF_EQUAL, Changes: []compose.Change{}},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expected diffs\n%+v\nreceived\n%+v", expected, diffs)
+	}
+}
+
+func TestDiffStacks(t *testing.T) {
+	source, target := diffClient(), diffClient()
+
+	changes, err := source.DiffStacks(target, mocks.ProjectOne.Id, "api", mocks.ProjectOne.Id, "web")
+	if err != nil {
+		t.Fatalf("diffing the stacks failed: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Field != compose.FIELD_SERVICE || changes[1].Field != compose.FIELD_SERVICE {
+		t.Errorf("expected the services of both stacks to differ, received %+v", changes)
+	}
+
+	if _, err := source.DiffStacks(target, mocks.ProjectOne.Id, "api", mocks.ProjectTwo.Id, "monitoring"); err == nil {
+		t.Errorf("expected diffing a removed stack to fail")
+	}
+}
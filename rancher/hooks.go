@@ -0,0 +1,246 @@
+package rancher
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	// Printed after a command executed in a container with its exit code,
+	// since the exec api does not report it
+	EXEC_EXIT_MARKER = "__RANCHER_CLI_EXIT__="
+)
+
+// RunHooks runs the hooks of the phase that match the service, stopping at
+// the first that fails.  Local commands get the deployment in RANCHER_CLI_
+// variables, which can also be used as ${RANCHER_CLI_SERVICE} in the url of
+// http checks and the commands executed in containers.
+func (cli *Client) RunHooks(phase string, service *client.Service, opts config.UpgradeOpts) error {
+	hooks := opts.Hooks.Phase(phase)
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	stack, err := cli.newServiceNames(nil).stack(service.EnvironmentId)
+	if err != nil {
+		return err
+	}
+	env := cli.hookEnv(phase, stack, service, opts)
+
+	for i := range hooks {
+		hook := &hooks[i]
+		if !hook.Matches(stack, service) {
+			continue
+		}
+
+		log.Infof("Running %s hook %s of service %s/%s", phase, hook.Name, stack, service.Name)
+		if err := cli.runHook(hook, service, env); err != nil {
+			return errors.Wrapf(err, "%s hook %s of service %s/%s failed", phase, hook.Name, stack, service.Name)
+		}
+	}
+	return nil
+}
+
+func (cli *Client) hookEnv(phase, stack string, service *client.Service, opts config.UpgradeOpts) map[string]string {
+	project, err := cli.ProjectName(service.AccountId)
+	if err != nil {
+		project = service.AccountId
+	}
+	return map[string]string{
+		"RANCHER_CLI_PHASE":       phase,
+		"RANCHER_CLI_ENVIRONMENT": project,
+		"RANCHER_CLI_STACK":       stack,
+		"RANCHER_CLI_SERVICE":     service.Name,
+		"RANCHER_CLI_SERVICE_ID":  service.Id,
+		"RANCHER_CLI_IMAGES":      strings.Join(serviceImageNames(service), ","),
+		"RANCHER_CLI_CODE_TAG":    opts.CodeTag,
+		"RANCHER_CLI_RUNTIME_TAG": opts.RuntimeTag,
+		"RANCHER_CLI_USER":        CurrentUser(),
+		"RANCHER_CLI_REASON":      opts.Reason,
+		"RANCHER_CLI_GIT_SHA":     opts.GitSha,
+	}
+}
+
+func (cli *Client) runHook(hook *config.Hook, service *client.Service, env map[string]string) error {
+	expand := func(value string) string {
+		return os.Expand(value, func(key string) string {
+			if value, ok := env[key]; ok {
+				return value
+			}
+			return os.Getenv(key)
+		})
+	}
+
+	switch {
+	case len(hook.Command) > 0:
+		return runHookCommand(hook.Command, env, hook.TimeoutDuration())
+	case hook.Http != nil:
+		return runHttpCheck(hook.Http, expand(hook.Http.Url), hook.TimeoutDuration())
+	default:
+		command := []string{}
+		for _, arg := range hook.Exec {
+			command = append(command, expand(arg))
+		}
+		return cli.execInService(service, command, hook.TimeoutDuration())
+	}
+}
+
+func runHookCommand(command []string, env map[string]string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s timed out after %s", command[0], timeout)
+		}
+		return err
+	}
+	return nil
+}
+
+// Request the url until it responds as expected or the retries are used up.
+func runHttpCheck(check *config.HttpCheck, url string, timeout time.Duration) error {
+	httpClient := &http.Client{Timeout: timeout}
+	body := regexp.MustCompile(check.Body)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = checkUrl(httpClient, url, check.ExpectedStatus(), body); err == nil {
+			return nil
+		}
+		if attempt >= check.Retries {
+			return err
+		}
+		log.Debugf("Check of %s failed, retrying: %v", url, err)
+		time.Sleep(check.IntervalDuration())
+	}
+}
+
+func checkUrl(httpClient *http.Client, url string, status int, body *regexp.Regexp) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != status {
+		return fmt.Errorf("%s responded with %s, expected %d", url, resp.Status, status)
+	}
+	if !body.Match(data) {
+		return fmt.Errorf("the body of %s does not match %s", url, body)
+	}
+	return nil
+}
+
+// Execute the command in every running container of the service.
+func (cli *Client) execInService(service *client.Service, command []string, timeout time.Duration) error {
+	containers, err := cli.ServiceContainers(service)
+	if err != nil {
+		return err
+	}
+
+	ran := 0
+	for i := range containers {
+		container := &containers[i]
+		if container.State != "running" {
+			continue
+		}
+		ran++
+
+		output, code, err := cli.ExecInContainer(container, command, timeout)
+		if output != "" {
+			fmt.Printf("%s: %s\n", container.Name, strings.TrimRight(output, "\n"))
+		}
+		if err != nil {
+			return errors.Wrapf(err, "executing in container %s failed", container.Name)
+		}
+		if code != 0 {
+			return fmt.Errorf("%s exited with %d in container %s", command[0], code, container.Name)
+		}
+	}
+
+	if ran == 0 {
+		return fmt.Errorf("service %s has no running containers", service.Name)
+	}
+	return nil
+}
+
+// ExecInContainer executes the command in the container through the exec
+// api and returns its output and exit code.  The api reports no exit code, so
+// the command is run by sh, which the image must provide, and its exit code
+// printed after it.
+func (cli *Client) ExecInContainer(container *client.Container, command []string, timeout time.Duration) (string, int, error) {
+	quoted := []string{}
+	for _, arg := range command {
+		quoted = append(quoted, "'"+strings.Replace(arg, "'", `'\''`, -1)+"'")
+	}
+	wrapped := []string{"sh", "-c", strings.Join(quoted, " ") + " 2>&1; echo " + EXEC_EXIT_MARKER + "$?"}
+
+	access, err := cli.RancherClient.Container.ActionExecute(container, &client.ContainerExec{
+		AttachStdout: true,
+		Command:      wrapped,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(access.Url+"?token="+access.Token, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	output := ""
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) || strings.Contains(output, EXEC_EXIT_MARKER) {
+				break
+			}
+			return output, 0, err
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(message))
+		if err != nil {
+			decoded = message
+		}
+		output += string(decoded)
+	}
+
+	index := strings.LastIndex(output, EXEC_EXIT_MARKER)
+	if index < 0 {
+		return output, 0, errors.New("the exit code of the command is missing from its output")
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(output[index+len(EXEC_EXIT_MARKER):]))
+	if err != nil {
+		return output[:index], 0, errors.Wrap(err, "invalid exit code")
+	}
+	return output[:index], code, nil
+}
@@ -0,0 +1,204 @@
+package rancher
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+// HookedServices records how upgrades end.
+type HookedServices struct {
+	DeployedServices
+	Finished   []string
+	RolledBack []string
+	Cancelled  []string
+	// Upgrades are finished by concurrent goroutines
+	lock sync.Mutex
+}
+
+func (srv *HookedServices) ActionFinishupgrade(service *client.Service) (*client.Service, error) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	srv.Finished = append(srv.Finished, service.Id)
+	return service, nil
+}
+
+func (srv *HookedServices) ActionRollback(service *client.Service) (*client.Service, error) {
+	srv.RolledBack = append(srv.RolledBack, service.Id)
+	return service, nil
+}
+
+func (srv *HookedServices) ActionCancelupgrade(service *client.Service) (*client.Service, error) {
+	srv.Cancelled = append(srv.Cancelled, service.Id)
+	return service, nil
+}
+
+// ExecContainers execute commands through the websocket of the url.
+type ExecContainers struct {
+	mocks.ContainerOperations
+	Url      string
+	Commands [][]string
+}
+
+func (ops *ExecContainers) ActionExecute(container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	ops.Commands = append(ops.Commands, exec.Command)
+	return &client.HostAccess{Url: ops.Url, Token: "token"}, nil
+}
+
+func hookedClient(t *testing.T) (*Client, *HookedServices, func()) {
+	cli, deployed, cleanup := deployedClient(t, HISTORY_STORE_LOCAL)
+	services := &HookedServices{DeployedServices: *deployed}
+	cli.RancherClient.Service = services
+	return cli, services, cleanup
+}
+
+func TestRunHooksCommand(t *testing.T) {
+	cli, services, cleanup := hookedClient(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "env")
+
+	opts := config.UpgradeOpts{
+		RuntimeTag: "1.1",
+		Reason:     "release",
+		Hooks: &config.Hooks{
+			Pre: []config.Hook{
+				{Name: "env", Command: []string{"sh", "-c", `echo "$RANCHER_CLI_PHASE $RANCHER_CLI_STACK/$RANCHER_CLI_SERVICE $RANCHER_CLI_IMAGES $RANCHER_CLI_REASON" > ` + out}},
+			},
+		},
+	}
+	if err := cli.RunHooks(config.HOOK_PHASE_PRE, services.Services["1s1"], opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "pre backend/api nowait/api:1.0 release\n" {
+		t.Errorf("Unexpected hook environment %q", data)
+	}
+
+	opts.Hooks.Pre = []config.Hook{{Name: "fail", Command: []string{"false"}}}
+	if err := cli.RunHooks(config.HOOK_PHASE_PRE, services.Services["1s1"], opts); err == nil || !strings.Contains(err.Error(), "pre hook fail of service backend/api failed") {
+		t.Errorf("Expected the failing hook to fail, got %v", err)
+	}
+
+	opts.Hooks.Pre = []config.Hook{{Name: "slow", Command: []string{"sleep", "5"}, Timeout: "50ms"}}
+	if err := cli.RunHooks(config.HOOK_PHASE_PRE, services.Services["1s1"], opts); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the slow hook to time out, got %v", err)
+	}
+}
+
+func TestRunHooksHttpCheck(t *testing.T) {
+	cli, services, cleanup := hookedClient(t)
+	defer cleanup()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/api/health" {
+			http.NotFound(w, r)
+			return
+		}
+		if requests < 3 {
+			w.Write([]byte(`{"status": "starting"}`))
+			return
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	check := &config.HttpCheck{Url: server.URL + "/${RANCHER_CLI_SERVICE}/health", Body: `"status":\s*"ok"`, Retries: 2, Interval: "1ms"}
+	opts := config.UpgradeOpts{Hooks: &config.Hooks{Post: []config.Hook{{Name: "health", Http: check}}}}
+	if err := cli.RunHooks(config.HOOK_PHASE_POST, services.Services["1s1"], opts); err != nil {
+		t.Errorf("Expected the check to pass on its last retry, got %v", err)
+	}
+
+	requests = 0
+	check.Retries = 1
+	if err := cli.RunHooks(config.HOOK_PHASE_POST, services.Services["1s1"], opts); err == nil {
+		t.Error("Expected the check to fail once its retries are used up")
+	}
+}
+
+func TestExecInContainer(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, chunk := range []string{"migrations ", "pending\n" + EXEC_EXIT_MARKER + "3\n"} {
+			conn.WriteMessage(websocket.TextMessage, []byte(base64.StdEncoding.EncodeToString([]byte(chunk))))
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer server.Close()
+
+	containers := &ExecContainers{Url: "ws" + strings.TrimPrefix(server.URL, "http")}
+	cli := &Client{RancherClient: &client.RancherClient{Container: containers}}
+
+	output, code, err := cli.ExecInContainer(&client.Container{Name: "api-1"}, []string{"check", "it's"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "migrations pending\n" || code != 3 {
+		t.Errorf("Unexpected output %q and exit code %d", output, code)
+	}
+	expected := []string{"sh", "-c", `'check' 'it'\''s' 2>&1; echo ` + EXEC_EXIT_MARKER + "$?"}
+	if !reflect.DeepEqual(containers.Commands[0], expected) {
+		t.Errorf("Expected the command %v, got %v", expected, containers.Commands[0])
+	}
+}
+
+func TestFailingPostHookRollsBack(t *testing.T) {
+	cli, services, cleanup := hookedClient(t)
+	defer cleanup()
+	services.Services["1s2"].LaunchConfig = &client.LaunchConfig{ImageUuid: "docker:nowait/worker:1.0"}
+
+	opts := config.UpgradeOpts{
+		RuntimeTag: "1.1",
+		Wait:       true,
+		Interval:   time.Second,
+		Hooks: &config.Hooks{
+			Post: []config.Hook{{Name: "smoke", Command: []string{"false"}, Services: []string{"api"}}},
+		},
+	}
+	if err := opts.Hooks.Post[0].Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded := []client.Service{*services.Services["1s1"], *services.Services["1s2"]}
+	if err := cli.UpgradeServices(upgraded, opts); err == nil {
+		t.Error("Expected the upgrade to fail")
+	}
+	if !reflect.DeepEqual(services.RolledBack, []string{"1s1"}) || len(services.Cancelled) != 0 {
+		t.Errorf("Expected api to be rolled back, got rolled back %v and cancelled %v", services.RolledBack, services.Cancelled)
+	}
+	if !reflect.DeepEqual(services.Finished, []string{"1s2"}) {
+		t.Errorf("Expected worker to be finished, got %v", services.Finished)
+	}
+}
@@ -0,0 +1,190 @@
+package rancher
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	MAIN_CONTAINER_ROLE = "main"
+)
+
+var (
+	dockerHubPrefixes = []string{"docker.io/", "index.docker.io/", "registry-1.docker.io/"}
+)
+
+// ImageUsage describes a single container of a service that runs an image.
+type ImageUsage struct {
+	Project string `json:"project"`
+	Stack   string `json:"stack"`
+	Service string `json:"service"`
+	// Role is either main for the primary launch config or the name of the sidekick
+	Role  string `json:"role"`
+	Image string `json:"image"`
+}
+
+// ImagePattern matches images of the form repo[:tag|@digest] where repo may
+// contain * wildcards.  An empty tag or digest matches any tag or digest.
+type ImagePattern struct {
+	Repo   string
+	Tag    string
+	Digest string
+
+	repo *regexp.Regexp
+}
+
+func ParseImagePattern(pattern string) (*ImagePattern, error) {
+	repo, tag, digest := splitImage(pattern)
+	re, err := compose.GlobToRegexp(normalizeRepo(repo))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImagePattern{
+		Repo:   repo,
+		Tag:    tag,
+		Digest: digest,
+		repo:   re,
+	}, nil
+}
+
+// Matches reports whether the image, with or without the docker: prefix
+// Rancher uses for ImageUuid, matches the pattern.
+func (pattern *ImagePattern) Matches(image string) bool {
+	repo, tag, digest := splitImage(strings.TrimPrefix(image, "docker:"))
+
+	if !pattern.repo.MatchString(normalizeRepo(repo)) {
+		return false
+	}
+	if pattern.Tag != "" && pattern.Tag != tag {
+		return false
+	}
+	if pattern.Digest != "" && pattern.Digest != digest {
+		return false
+	}
+	return true
+}
+
+// Find every container of every service visible to the client whose image matches the pattern.
+func (cli *Client) ImageUsages(pattern *ImagePattern) ([]ImageUsage, error) {
+	projects, err := cli.listProjects(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	projectNames := make(map[string]string)
+	for _, project := range projects {
+		projectNames[project.Id] = project.Name
+	}
+
+	stacks, err := cli.listStacks(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stackNames := make(map[string]string)
+	for _, stack := range stacks {
+		stackNames[stack.Id] = stack.Name
+	}
+
+	services, err := cli.listServices(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	usages := []ImageUsage{}
+	for _, service := range services {
+		if service.State == "removed" || service.State == "purged" {
+			continue
+		}
+
+		for _, container := range serviceImages(&service) {
+			if !pattern.Matches(container.Image) {
+				continue
+			}
+			usages = append(usages, ImageUsage{
+				Project: nameOrId(projectNames, service.AccountId),
+				Stack:   nameOrId(stackNames, service.EnvironmentId),
+				Service: service.Name,
+				Role:    container.Role,
+				Image:   strings.TrimPrefix(container.Image, "docker:"),
+			})
+		}
+	}
+
+	return usages, nil
+}
+
+type containerImage struct {
+	Role  string
+	Image string
+}
+
+// Returns the images of the service with their container role, main for the
+// primary launch config and the sidekick name for secondary launch configs.
+func serviceImages(service *client.Service) []containerImage {
+	images := []containerImage{}
+
+	if service.LaunchConfig != nil && service.LaunchConfig.ImageUuid != "" {
+		images = append(images, containerImage{
+			Role:  MAIN_CONTAINER_ROLE,
+			Image: service.LaunchConfig.ImageUuid,
+		})
+	}
+
+	for _, slc := range service.SecondaryLaunchConfigs {
+		lc, ok := slc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		image, _ := lc["imageUuid"].(string)
+		name, _ := lc["name"].(string)
+		if image == "" {
+			continue
+		}
+		images = append(images, containerImage{
+			Role:  name,
+			Image: image,
+		})
+	}
+
+	return images
+}
+
+// Split an image of the form repo[:tag][@digest] into its pieces.  The
+// registry host may contain a port so only a colon after the last slash
+// separates the tag.
+func splitImage(image string) (repo, tag, digest string) {
+	if pos := strings.Index(image, "@"); pos != -1 {
+		image, digest = image[:pos], image[pos+1:]
+	}
+
+	repo = image
+	if pos := strings.LastIndex(image, ":"); pos > strings.LastIndex(image, "/") {
+		repo, tag = image[:pos], image[pos+1:]
+	}
+	return
+}
+
+// Images on Docker Hub can be referenced with or without the registry host
+// and the library namespace, strip both so they compare equal.
+func normalizeRepo(repo string) string {
+	for _, prefix := range dockerHubPrefixes {
+		repo = strings.TrimPrefix(repo, prefix)
+	}
+	return strings.TrimPrefix(repo, "library/")
+}
+
+func nameOrId(names map[string]string, id string) string {
+	if name, ok := names[id]; ok && name != "" {
+		return name
+	}
+	return id
+}
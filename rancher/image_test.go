@@ -0,0 +1,158 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+type ImageService struct {
+	NoopService
+}
+
+func (srv *ImageService) List(opts *client.ListOpts) (*client.ServiceCollection, error) {
+	sidekick := make(map[string]interface{})
+	sidekick["name"] = "code"
+	sidekick["imageUuid"] = "docker:nowait/api-code:2.0"
+
+	return &client.ServiceCollection{
+		Data: []client.Service{
+			client.Service{
+				Name:          "api",
+				AccountId:     mocks.ProjectOneName,
+				EnvironmentId: "1e1",
+				LaunchConfig: &client.LaunchConfig{
+					ImageUuid: "docker:nowait/api:1.0",
+				},
+				SecondaryLaunchConfigs: []interface{}{
+					sidekick,
+				},
+			},
+			client.Service{
+				Name:          "worker",
+				AccountId:     mocks.ProjectTwoName,
+				EnvironmentId: "1e2",
+				LaunchConfig: &client.LaunchConfig{
+					ImageUuid: "docker:docker.io/library/redis:3.2",
+				},
+			},
+			client.Service{
+				Name:          "removed",
+				AccountId:     mocks.ProjectTwoName,
+				EnvironmentId: "1e2",
+				State:         "removed",
+				LaunchConfig: &client.LaunchConfig{
+					ImageUuid: "docker:nowait/api:1.0",
+				},
+			},
+		},
+	}, nil
+}
+
+type ImageStacks struct {
+	mocks.NoopEnvironmentOperations
+}
+
+func (env *ImageStacks) List(opts *client.ListOpts) (*client.EnvironmentCollection, error) {
+	return &client.EnvironmentCollection{
+		Data: []client.Environment{
+			client.Environment{Resource: client.Resource{Id: "1e1"}, Name: "backend"},
+			client.Environment{Resource: client.Resource{Id: "1e2"}, Name: "cache"},
+		},
+	}, nil
+}
+
+func TestImagePatternMatches(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Image   string
+		Matches bool
+	}{
+		{"nowait/api", "docker:nowait/api:1.0", true},
+		{"nowait/api:1.0", "docker:nowait/api:1.0", true},
+		{"nowait/api:2.0", "docker:nowait/api:1.0", false},
+		{"nowait/*", "docker:nowait/api-code:2.0", true},
+		{"nowait/*", "docker:other/api:2.0", false},
+		{"redis", "docker:docker.io/library/redis:3.2", true},
+		{"redis:3.2", "redis:3.2", true},
+		{"registry.example.com:5000/api", "docker:registry.example.com:5000/api:1.0", true},
+		{"registry.example.com:5000/api:5000", "docker:registry.example.com:5000/api:1.0", false},
+		{"nowait/api@sha256:abc", "docker:nowait/api@sha256:abc", true},
+		{"nowait/api@sha256:abc", "docker:nowait/api@sha256:def", false},
+	}
+
+	for _, test := range tests {
+		pattern, err := ParseImagePattern(test.Pattern)
+
+		if err != nil {
+			t.Fatalf("parsing pattern %s failed with: %v", test.Pattern, err)
+		}
+
+		if pattern.Matches(test.Image) != test.Matches {
+			t.Errorf("pattern %s matching image %s should be %v", test.Pattern, test.Image, test.Matches)
+		}
+	}
+}
+
+func TestImageUsages(t *testing.T) {
+	cli := Client{
+		RancherClient: &client.RancherClient{
+			Service:     &ImageService{},
+			Environment: &ImageStacks{},
+			Project:     &mocks.SuccessfulProjectOperations{},
+		},
+	}
+
+	tests := []struct {
+		Pattern  string
+		Expected []ImageUsage
+	}{
+		{
+			Pattern: "nowait/*",
+			Expected: []ImageUsage{
+				{Project: mocks.ProjectOneName, Stack: "backend", Service: "api", Role: MAIN_CONTAINER_ROLE, Image: "nowait/api:1.0"},
+				{Project: mocks.ProjectOneName, Stack: "backend", Service: "api", Role: "code", Image: "nowait/api-code:2.0"},
+			},
+		},
+		{
+			Pattern: "redis",
+			Expected: []ImageUsage{
+				{Project: mocks.ProjectTwoName, Stack: "cache", Service: "worker", Role: MAIN_CONTAINER_ROLE, Image: "docker.io/library/redis:3.2"},
+			},
+		},
+		{
+			Pattern:  "nowait/api:3.0",
+			Expected: []ImageUsage{},
+		},
+	}
+
+	for _, test := range tests {
+		pattern, _ := ParseImagePattern(test.Pattern)
+		usages, err := cli.ImageUsages(pattern)
+
+		if err != nil {
+			t.Errorf("finding usages of %s failed with: %v", test.Pattern, err)
+		}
+
+		if !reflect.DeepEqual(usages, test.Expected) {
+			t.Errorf("usages of %s were %#v but expected %#v", test.Pattern, usages, test.Expected)
+		}
+	}
+}
+
+func TestImageUsagesFailsWhenListingProjectsFails(t *testing.T) {
+	cli := Client{
+		RancherClient: &client.RancherClient{
+			Service:     &ImageService{},
+			Environment: &ImageStacks{},
+			Project:     &mocks.FailedProjectOperations{},
+		},
+	}
+
+	pattern, _ := ParseImagePattern("nowait/api")
+	if _, err := cli.ImageUsages(pattern); err != mocks.ProjectListError {
+		t.Errorf("expected project list error but received %v", err)
+	}
+}
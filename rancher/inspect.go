@@ -0,0 +1,382 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+// StackSummary describes a stack as listed by stack list.
+type StackSummary struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Health   string `json:"health"`
+	Services int    `json:"services"`
+}
+
+// ServiceSummary describes a service as listed by service list.  The launch
+// configs and deployment are only filled in by InspectService.
+type ServiceSummary struct {
+	Id                   string   `json:"id"`
+	Name                 string   `json:"name"`
+	Stack                string   `json:"stack"`
+	Images               []string `json:"images"`
+	Scale                int64    `json:"scale"`
+	State                string   `json:"state"`
+	Health               string   `json:"health"`
+	TransitioningMessage string   `json:"transitioningMessage,omitempty"`
+	Endpoints            []string `json:"endpoints"`
+	// Linked services as stack/service:alias
+	Links                  []string             `json:"links"`
+	LaunchConfig           *client.LaunchConfig `json:"launchConfig,omitempty"`
+	SecondaryLaunchConfigs []interface{}        `json:"secondaryLaunchConfigs,omitempty"`
+	// Last deployment by the cli, only filled in by InspectService
+	Deployment *Deployment `json:"deployment,omitempty"`
+}
+
+// ListStacks lists the stacks that have not been removed with the number of
+// services in each, ordered by name.  An empty projectId lists the stacks of
+// every project the api keys can access.
+func (cli *Client) ListStacks(projectId string) ([]StackSummary, error) {
+	filters := cli.scopeFilters(make(map[string]interface{}), true)
+	if projectId != "" {
+		filters["accountId"] = projectId
+	}
+	stacks, err := cli.listStacks(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := cli.listServices(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list services")
+	}
+
+	counts := make(map[string]int)
+	for _, service := range services {
+		if !removed(service.State) {
+			counts[service.EnvironmentId]++
+		}
+	}
+
+	summaries := []StackSummary{}
+	for _, stack := range stacks {
+		if removed(stack.State) {
+			continue
+		}
+		summaries = append(summaries, StackSummary{
+			Id:       stack.Id,
+			Name:     stack.Name,
+			State:    stack.State,
+			Health:   stack.HealthState,
+			Services: counts[stack.Id],
+		})
+	}
+	sort.Sort(stackSummariesByName(summaries))
+	return summaries, nil
+}
+
+// ListServices lists the services matching the options ordered by stack and
+// name.  Removed services are only listed when asked for by state.
+func (cli *Client) ListServices(opts config.ServiceListOpts) ([]ServiceSummary, error) {
+	filters := make(map[string]interface{})
+	prefix, err := cli.serviceAddressFilters(filters, opts.ServiceLike)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		addServiceLikeFilters(filters, prefix)
+	}
+	if opts.Stack != "" {
+		stack, err := cli.StackByName(opts.Stack)
+		if err != nil {
+			return nil, err
+		}
+		filters["environmentId"] = stack.Id
+	}
+	if opts.State != "" {
+		filters["state"] = opts.State
+	}
+	if opts.Health != "" {
+		filters["healthState"] = opts.Health
+	}
+
+	services, err := cli.listServices(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	names := cli.newServiceNames(nil)
+	listed := []client.Service{}
+	for _, service := range services {
+		if opts.State == "" && removed(service.State) {
+			continue
+		}
+		if !opts.Selector.Empty() {
+			stack, err := names.stack(service.EnvironmentId)
+			if err != nil {
+				return nil, err
+			}
+			if !opts.Selector.Matches(stack, &service) {
+				continue
+			}
+		}
+		listed = append(listed, service)
+	}
+	for i := range listed {
+		names.services[listed[i].Id] = &listed[i]
+	}
+
+	links, err := cli.serviceLinks(names)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []ServiceSummary{}
+	for i := range listed {
+		summary, err := summarizeService(&listed[i], names, links)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Sort(serviceSummariesByName(summaries))
+	return summaries, nil
+}
+
+// InspectService describes the named service including its launch configs.
+// The values of environment variables matching one of the secret globs are
+// masked.
+func (cli *Client) InspectService(name string, secrets []string) (*ServiceSummary, error) {
+	service, err := cli.ServiceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	names := cli.newServiceNames([]client.Service{*service})
+	links, err := cli.serviceLinks(names)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := summarizeService(service, names, links)
+	if err != nil {
+		return nil, err
+	}
+	if summary.LaunchConfig, summary.SecondaryLaunchConfigs, err = maskLaunchConfigs(service, secrets); err != nil {
+		return nil, err
+	}
+	if summary.Deployment, err = ServiceDeployment(service); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// Return copies of the launch configs of the service with the secret
+// environment values masked.
+func maskLaunchConfigs(service *client.Service, secrets []string) (*client.LaunchConfig, []interface{}, error) {
+	var lc *client.LaunchConfig
+	if service.LaunchConfig != nil {
+		masked := *service.LaunchConfig
+		env, err := compose.MaskEnvironment(masked.Environment, secrets)
+		if err != nil {
+			return nil, nil, err
+		}
+		masked.Environment = env
+		lc = &masked
+	}
+
+	var secondaries []interface{}
+	for _, slc := range service.SecondaryLaunchConfigs {
+		slcMap, ok := slc.(map[string]interface{})
+		if !ok {
+			secondaries = append(secondaries, slc)
+			continue
+		}
+		masked := make(map[string]interface{})
+		for key, value := range slcMap {
+			masked[key] = value
+		}
+		if env, ok := slcMap["environment"].(map[string]interface{}); ok {
+			var err error
+			if masked["environment"], err = compose.MaskEnvironment(env, secrets); err != nil {
+				return nil, nil, err
+			}
+		}
+		secondaries = append(secondaries, masked)
+	}
+	return lc, secondaries, nil
+}
+
+func summarizeService(service *client.Service, names *serviceNames, links map[string][]string) (ServiceSummary, error) {
+	stack, err := names.stack(service.EnvironmentId)
+	if err != nil {
+		return ServiceSummary{}, err
+	}
+
+	summary := ServiceSummary{
+		Id:                   service.Id,
+		Name:                 service.Name,
+		Stack:                stack,
+		Images:               serviceImageNames(service),
+		Scale:                service.Scale,
+		State:                service.State,
+		Health:               service.HealthState,
+		TransitioningMessage: service.TransitioningMessage,
+		Endpoints:            publicEndpoints(service),
+		Links:                links[service.Id],
+	}
+	if summary.Links == nil {
+		summary.Links = []string{}
+	}
+	return summary, nil
+}
+
+// Images of the primary and sidekick launch configs.
+func serviceImageNames(service *client.Service) []string {
+	images := []string{}
+	if service.LaunchConfig != nil && service.LaunchConfig.ImageUuid != "" {
+		images = append(images, strings.TrimPrefix(service.LaunchConfig.ImageUuid, "docker:"))
+	}
+	for _, lc := range service.SecondaryLaunchConfigs {
+		if secondary, ok := lc.(map[string]interface{}); ok {
+			if image, ok := secondary["imageUuid"].(string); ok && image != "" {
+				images = append(images, strings.TrimPrefix(image, "docker:"))
+			}
+		}
+	}
+	return images
+}
+
+// Public endpoints as ip:port, ordered so the output is stable.
+func publicEndpoints(service *client.Service) []string {
+	endpoints := []string{}
+	for _, endpoint := range service.PublicEndpoints {
+		if values, ok := endpoint.(map[string]interface{}); ok {
+			endpoints = append(endpoints, fmt.Sprintf("%v:%v", values["ipAddress"], values["port"]))
+		}
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+// Return the links of the services by service id as stack/service:alias.
+func (cli *Client) serviceLinks(names *serviceNames) (map[string][]string, error) {
+	maps, err := cli.listServiceConsumeMaps(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list service links")
+	}
+
+	// Services looked up while resolving links are not listed themselves
+	listed := make(map[string]bool)
+	for id := range names.services {
+		listed[id] = true
+	}
+
+	links := make(map[string][]string)
+	for _, link := range maps {
+		if !listed[link.ServiceId] || removed(link.State) {
+			continue
+		}
+
+		consumed, err := names.service(link.ConsumedServiceId)
+		if err != nil {
+			return nil, err
+		}
+		if link.Name != "" {
+			consumed += ":" + link.Name
+		}
+		links[link.ServiceId] = append(links[link.ServiceId], consumed)
+	}
+
+	for id := range links {
+		sort.Strings(links[id])
+	}
+	return links, nil
+}
+
+// serviceNames resolves the names of stacks and services by id, looking up
+// the ones that were not listed once.
+type serviceNames struct {
+	cli      *Client
+	services map[string]*client.Service
+	stacks   map[string]string
+}
+
+func (cli *Client) newServiceNames(services []client.Service) *serviceNames {
+	names := &serviceNames{
+		cli:      cli,
+		services: make(map[string]*client.Service),
+		stacks:   make(map[string]string),
+	}
+	for i := range services {
+		names.services[services[i].Id] = &services[i]
+	}
+	return names
+}
+
+func (names *serviceNames) service(id string) (string, error) {
+	service, ok := names.services[id]
+	if !ok {
+		found, err := names.cli.RancherClient.Service.ById(id)
+		if err != nil {
+			return "", err
+		}
+		if found == nil {
+			return id, nil
+		}
+		service = found
+		names.services[id] = service
+	}
+
+	stack, err := names.stack(service.EnvironmentId)
+	if err != nil {
+		return "", err
+	}
+	return stack + "/" + service.Name, nil
+}
+
+func (names *serviceNames) stack(id string) (string, error) {
+	if name, ok := names.stacks[id]; ok {
+		return name, nil
+	}
+
+	stack, err := names.cli.RancherClient.Environment.ById(id)
+	if err != nil {
+		return "", err
+	}
+
+	name := id
+	if stack != nil {
+		name = stack.Name
+	}
+	names.stacks[id] = name
+	return name, nil
+}
+
+func removed(state string) bool {
+	return state == "removed" || state == "purged"
+}
+
+type stackSummariesByName []StackSummary
+
+func (s stackSummariesByName) Len() int           { return len(s) }
+func (s stackSummariesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s stackSummariesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+type serviceSummariesByName []ServiceSummary
+
+func (s serviceSummariesByName) Len() int      { return len(s) }
+func (s serviceSummariesByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s serviceSummariesByName) Less(i, j int) bool {
+	if s[i].Stack != s[j].Stack {
+		return s[i].Stack < s[j].Stack
+	}
+	return s[i].Name < s[j].Name
+}
@@ -0,0 +1,197 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+type InspectStacks struct {
+	mocks.NoopEnvironmentOperations
+}
+
+func (env *InspectStacks) List(opts *client.ListOpts) (*client.EnvironmentCollection, error) {
+	return &client.EnvironmentCollection{
+		Data: []client.Environment{
+			{Resource: client.Resource{Id: "1e2"}, Name: "web", State: "active", HealthState: "healthy"},
+			{Resource: client.Resource{Id: "1e1"}, Name: "backend", State: "active", HealthState: "degraded"},
+			{Resource: client.Resource{Id: "1e3"}, Name: "old", State: "removed"},
+		},
+	}, nil
+}
+
+func (env *InspectStacks) ById(id string) (*client.Environment, error) {
+	names := map[string]string{"1e1": "backend", "1e2": "web", "1e9": "shared"}
+	return &client.Environment{Resource: client.Resource{Id: id}, Name: names[id]}, nil
+}
+
+// InspectServices records the filters of the last list.
+type InspectServices struct {
+	client.ServiceOperations
+	Filters map[string]interface{}
+}
+
+func (srv *InspectServices) List(opts *client.ListOpts) (*client.ServiceCollection, error) {
+	srv.Filters = opts.Filters
+	return &client.ServiceCollection{
+		Data: []client.Service{
+			{
+				Resource:      client.Resource{Id: "1s2"},
+				Name:          "web",
+				EnvironmentId: "1e2",
+				Scale:         2,
+				State:         "active",
+				HealthState:   "healthy",
+				LaunchConfig:  &client.LaunchConfig{ImageUuid: "docker:nowait/web:1.0"},
+				PublicEndpoints: []interface{}{
+					map[string]interface{}{"ipAddress": "10.0.0.2", "port": 80},
+					map[string]interface{}{"ipAddress": "10.0.0.1", "port": 80},
+				},
+			},
+			{
+				Resource:             client.Resource{Id: "1s1"},
+				Name:                 "api",
+				EnvironmentId:        "1e1",
+				Scale:                3,
+				State:                "upgrading",
+				HealthState:          "degraded",
+				TransitioningMessage: "Upgrading",
+				LaunchConfig: &client.LaunchConfig{
+					ImageUuid:   "docker:nowait/api:1.1",
+					Environment: map[string]interface{}{"DB_PASSWORD": "hunter2", "DB_HOST": "db"},
+				},
+				SecondaryLaunchConfigs: []interface{}{
+					map[string]interface{}{
+						"name":        "api-code",
+						"imageUuid":   "docker:nowait/api-code:1.1",
+						"environment": map[string]interface{}{"API_TOKEN": "abc"},
+					},
+				},
+			},
+			{Resource: client.Resource{Id: "1s3"}, Name: "gone", EnvironmentId: "1e1", State: "removed"},
+		},
+	}, nil
+}
+
+func (srv *InspectServices) ById(id string) (*client.Service, error) {
+	return &client.Service{Resource: client.Resource{Id: id}, Name: "cache", EnvironmentId: "1e9"}, nil
+}
+
+type InspectLinks struct {
+	client.ServiceConsumeMapOperations
+}
+
+func (links *InspectLinks) List(opts *client.ListOpts) (*client.ServiceConsumeMapCollection, error) {
+	return &client.ServiceConsumeMapCollection{
+		Data: []client.ServiceConsumeMap{
+			{ServiceId: "1s2", ConsumedServiceId: "1s1", Name: "api"},
+			{ServiceId: "1s1", ConsumedServiceId: "1s9"},
+			{ServiceId: "1s2", ConsumedServiceId: "1s1", Name: "old", State: "removed"},
+			{ServiceId: "1s8", ConsumedServiceId: "1s1"},
+		},
+	}, nil
+}
+
+func inspectClient() (*Client, *InspectServices) {
+	services := &InspectServices{}
+	return &Client{
+		RancherClient: &client.RancherClient{
+			Environment:       &InspectStacks{},
+			Service:           services,
+			ServiceConsumeMap: &InspectLinks{},
+		},
+	}, services
+}
+
+func TestListStacks(t *testing.T) {
+	cli, _ := inspectClient()
+
+	stacks, err := cli.ListStacks("")
+	if err != nil {
+		t.Fatalf("listing stacks failed: %v", err)
+	}
+
+	expected := []StackSummary{
+		{Id: "1e1", Name: "backend", State: "active", Health: "degraded", Services: 1},
+		{Id: "1e2", Name: "web", State: "active", Health: "healthy", Services: 1},
+	}
+	if !reflect.DeepEqual(stacks, expected) {
+		t.Errorf("expected stacks %+v, received %+v", expected, stacks)
+	}
+}
+
+func TestListServices(t *testing.T) {
+	cli, services := inspectClient()
+
+	summaries, err := cli.ListServices(config.ServiceListOpts{ServiceLike: "a", Health: "degraded"})
+	if err != nil {
+		t.Fatalf("listing services failed: %v", err)
+	}
+
+	expectedFilters := map[string]interface{}{"name_like": "a%", "kind": SERVICE_TYPE_SERVICE, "healthState": "degraded", "limit": pageLimit}
+	if !reflect.DeepEqual(services.Filters, expectedFilters) {
+		t.Errorf("expected filters %v, received %v", expectedFilters, services.Filters)
+	}
+
+	expected := []ServiceSummary{
+		{
+			Id:                   "1s1",
+			Name:                 "api",
+			Stack:                "backend",
+			Images:               []string{"nowait/api:1.1", "nowait/api-code:1.1"},
+			Scale:                3,
+			State:                "upgrading",
+			Health:               "degraded",
+			TransitioningMessage: "Upgrading",
+			Endpoints:            []string{},
+			Links:                []string{"shared/cache"},
+		},
+		{
+			Id:        "1s2",
+			Name:      "web",
+			Stack:     "web",
+			Images:    []string{"nowait/web:1.0"},
+			Scale:     2,
+			State:     "active",
+			Health:    "healthy",
+			Endpoints: []string{"10.0.0.1:80", "10.0.0.2:80"},
+			Links:     []string{"backend/api:api"},
+		},
+	}
+	if !reflect.DeepEqual(summaries, expected) {
+		t.Errorf("expected services\n%+v\nreceived\n%+v", expected, summaries)
+	}
+}
+
+func TestMaskLaunchConfigs(t *testing.T) {
+	_, services := inspectClient()
+	listed, _ := services.List(&client.ListOpts{})
+	service := &listed.Data[1]
+
+	lc, secondaries, err := maskLaunchConfigs(service, compose.DefaultSecretPatterns)
+	if err != nil {
+		t.Fatalf("masking the launch configs failed: %v", err)
+	}
+
+	expected := map[string]interface{}{"DB_PASSWORD": compose.MaskedValue, "DB_HOST": "db"}
+	if !reflect.DeepEqual(lc.Environment, expected) {
+		t.Errorf("expected environment %v, received %v", expected, lc.Environment)
+	}
+	sidekick := secondaries[0].(map[string]interface{})
+	if env := sidekick["environment"].(map[string]interface{}); env["API_TOKEN"] != compose.MaskedValue || sidekick["name"] != "api-code" {
+		t.Errorf("expected the sidekick token to be masked, received %v", sidekick)
+	}
+
+	if service.LaunchConfig.Environment["DB_PASSWORD"] != "hunter2" {
+		t.Errorf("masking should not change the service")
+	}
+
+	lc, _, err = maskLaunchConfigs(service, nil)
+	if err != nil || lc.Environment["DB_PASSWORD"] != "hunter2" {
+		t.Errorf("expected secrets to be shown without patterns, received %v, %v", lc, err)
+	}
+}
@@ -0,0 +1,425 @@
+package rancher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	// Events of a deployment journal
+	JOURNAL_PLAN       = "plan"
+	JOURNAL_UPGRADING  = "upgrading"
+	JOURNAL_FINISHED   = "finished"
+	JOURNAL_FAILED     = "failed"
+	JOURNAL_ROLLEDBACK = "rolled-back"
+	JOURNAL_SKIPPED    = "skipped"
+	JOURNAL_DONE       = "done"
+)
+
+// JournalPlan is what a multi-service operation set out to do.
+type JournalPlan struct {
+	Operation string           `json:"operation"`
+	Services  []JournalService `json:"services"`
+	Wait      bool             `json:"wait"`
+	Interval  time.Duration    `json:"interval"`
+	// Hooks file whose post hooks run before an upgrade is finished
+	Hooks   string        `json:"hooks,omitempty"`
+	LockTTL time.Duration `json:"lockTTL,omitempty"`
+	// What the services were upgraded with, passed to the hooks
+	CodeTag    string `json:"codeTag,omitempty"`
+	RuntimeTag string `json:"runtimeTag,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	GitSha     string `json:"gitSha,omitempty"`
+	// Freezes overridden to deploy, recorded on the resumed upgrades
+	FreezeOverride string `json:"freezeOverride,omitempty"`
+}
+
+// JournalService is a service of the plan of a journal.
+type JournalService struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// JournalEvent is a line of a journal.
+type JournalEvent struct {
+	Time    time.Time    `json:"time"`
+	Event   string       `json:"event"`
+	Service string       `json:"service,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Plan    *JournalPlan `json:"plan,omitempty"`
+}
+
+// JournalSummary describes a journal as listed by deploy list.
+type JournalSummary struct {
+	Id        string    `json:"id"`
+	Operation string    `json:"operation"`
+	Started   time.Time `json:"started"`
+	Services  int       `json:"services"`
+	Done      bool      `json:"done"`
+}
+
+// Journal is the append-only record of a multi-service operation, kept so an
+// operation interrupted by the cli dying can be resumed.
+type Journal struct {
+	Id   string
+	path string
+	lock sync.Mutex
+}
+
+// NewJournal starts a journal in the directory, recording the plan.
+func NewJournal(dir string, plan JournalPlan) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405"), os.Getpid())
+	journal := &Journal{
+		Id:   id,
+		path: journalPath(dir, id),
+	}
+	return journal, journal.Record(JournalEvent{Event: JOURNAL_PLAN, Plan: &plan})
+}
+
+// JournalDir returns the directory of the journals in the state dir.
+func JournalDir(stateDir string) string {
+	return filepath.Join(stateDir, "journals")
+}
+
+func journalPath(dir, id string) string {
+	return filepath.Join(dir, id+".jsonl")
+}
+
+// Record appends the event to the journal.  A nil journal records nothing.
+func (journal *Journal) Record(event JournalEvent) error {
+	if journal == nil {
+		return nil
+	}
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// Record the event of the service, logging rather than failing the
+// operation when the journal can not be written.
+func (journal *Journal) service(event string, service *client.Service, err error) {
+	if journal == nil || service == nil {
+		return
+	}
+	recorded := JournalEvent{Event: event, Service: service.Id}
+	if err != nil {
+		recorded.Error = err.Error()
+	}
+	if writeErr := journal.Record(recorded); writeErr != nil {
+		log.Warnf("Failed to write the journal %s: %v", journal.Id, writeErr)
+	}
+}
+
+// ReadJournal reads the events of the journal with the id.
+func ReadJournal(dir, id string) ([]JournalEvent, error) {
+	path := journalPath(dir, id)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no journal with id %s in %s", id, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []JournalEvent{}
+	scanner := bufio.NewScanner(file)
+	// Plans of many services make for long lines
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		event := JournalEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// The last line may have been cut short when the cli died
+			log.Warnf("Ignoring line %d of journal %s: %v", line, path, err)
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 || events[0].Plan == nil {
+		return nil, fmt.Errorf("invalid journal %s: missing plan", path)
+	}
+	return events, nil
+}
+
+// ListJournals lists the journals in the directory, newest first.
+func ListJournals(dir string) ([]JournalSummary, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []JournalSummary{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []JournalSummary{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".jsonl") {
+			continue
+		}
+		id := strings.TrimSuffix(file.Name(), ".jsonl")
+		events, err := ReadJournal(dir, id)
+		if err != nil {
+			log.Warnf("Skipping journal %s: %v", id, err)
+			continue
+		}
+		summaries = append(summaries, JournalSummary{
+			Id:        id,
+			Operation: events[0].Plan.Operation,
+			Started:   events[0].Time,
+			Services:  len(events[0].Plan.Services),
+			Done:      events[len(events)-1].Event == JOURNAL_DONE,
+		})
+	}
+	sort.Sort(journalsByStart(summaries))
+	return summaries, nil
+}
+
+// ResumeDeployment continues the operation of the journal with the id.  The
+// live state of every service the journal does not record an outcome for is
+// queried again: upgrades in progress are waited for, checked by the post
+// hooks and finished, or rolled back when they fail, when the operation
+// waited for them.  Services whose upgrade was never started are skipped.
+// The locks left by the interrupted operation are taken over and released.
+func (cli *Client) ResumeDeployment(dir, id string) error {
+	events, err := ReadJournal(dir, id)
+	if err != nil {
+		return err
+	}
+	if events[len(events)-1].Event == JOURNAL_DONE {
+		return fmt.Errorf("the operation of journal %s has completed", id)
+	}
+
+	plan := events[0].Plan
+	last := make(map[string]string)
+	for _, event := range events[1:] {
+		if event.Service != "" {
+			last[event.Service] = event.Event
+		}
+	}
+
+	journal := &Journal{Id: id, path: journalPath(dir, id)}
+	opts := config.UpgradeOpts{
+		Wait:       plan.Wait,
+		Interval:   plan.Interval,
+		LockTTL:    plan.LockTTL,
+		CodeTag:    plan.CodeTag,
+		RuntimeTag: plan.RuntimeTag,
+		Reason:     plan.Reason,
+		GitSha:     plan.GitSha,
+
+		FreezeOverride: plan.FreezeOverride,
+	}
+	if plan.Hooks != "" {
+		if opts.Hooks, err = config.LoadHooks(plan.Hooks); err != nil {
+			return errors.Wrapf(err, "Failed to load the hooks of journal %s", id)
+		}
+	}
+
+	failed := false
+	for _, planned := range plan.Services {
+		event := last[planned.Id]
+		if event == JOURNAL_FINISHED || event == JOURNAL_ROLLEDBACK || event == JOURNAL_SKIPPED {
+			continue
+		}
+
+		service, err := cli.RancherClient.Service.ById(planned.Id)
+		if err != nil {
+			return err
+		}
+		if service == nil {
+			log.Warnf("Service %s has been removed", planned.Name)
+			journal.service(JOURNAL_SKIPPED, &client.Service{Resource: client.Resource{Id: planned.Id}}, errors.New("removed"))
+			continue
+		}
+
+		if !cli.resumeService(journal, planned, service, event, opts) {
+			failed = true
+		}
+	}
+
+	if err := journal.Record(JournalEvent{Event: JOURNAL_DONE}); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("upgrading services failed")
+	}
+	return nil
+}
+
+// Continue the upgrade of the service whose last event in the journal is
+// event, holding its lock meanwhile.  Reports whether the upgrade did not fail.
+func (cli *Client) resumeService(journal *Journal, planned JournalService, service *client.Service, event string, opts config.UpgradeOpts) bool {
+	service, lock, err := cli.resumeLock(service, opts.LockTTL)
+	if err != nil {
+		log.Errorf("Not resuming service %s: %v", planned.Name, err)
+		journal.service(JOURNAL_FAILED, service, err)
+		return false
+	}
+	defer cli.unlockUpgrade(service, lock)
+
+	if service.State != "upgrading" && service.State != "upgraded" {
+		if event == "" || event == JOURNAL_FAILED {
+			log.Warnf("The upgrade of service %s was never started, upgrade it again", planned.Name)
+			journal.service(JOURNAL_SKIPPED, service, errors.New("never started"))
+		} else {
+			log.Infof("Service %s is %s", planned.Name, service.State)
+			journal.service(JOURNAL_FINISHED, service, nil)
+		}
+		return true
+	}
+
+	if !opts.Wait {
+		log.Infof("Service %s is %s, finish it with service upgrade-finish", planned.Name, service.State)
+		return true
+	}
+
+	if event == JOURNAL_FAILED {
+		cli.rollbackResumed(journal, planned.Name, service, errors.New("failed before the cli stopped"), false)
+		return false
+	}
+
+	log.Infof("Waiting for service %s to be upgraded", planned.Name)
+	rollback := false
+	err = Wait(cli, service, opts)
+	if err == nil {
+		// The upgrade has completed, failing post hooks roll it back
+		err = cli.RunHooks(config.HOOK_PHASE_POST, service, opts)
+		rollback = err != nil
+	}
+	if err == nil {
+		_, err = cli.RancherClient.Service.ActionFinishupgrade(service)
+	}
+	if err != nil {
+		cli.rollbackResumed(journal, planned.Name, service, err, rollback)
+		return false
+	}
+	journal.service(JOURNAL_FINISHED, service, nil)
+	return true
+}
+
+// Take the lock of the service for resuming its upgrade.  A lock left by the
+// interrupted operation of the current user on this host is taken over.
+func (cli *Client) resumeLock(service *client.Service, ttl time.Duration) (*client.Service, *Lock, error) {
+	if ttl <= 0 {
+		return service, nil, nil
+	}
+
+	existing, err := ServiceLock(service)
+	if err != nil {
+		return service, nil, err
+	}
+	if existing != nil && existing.Held() {
+		if err := cli.UnlockService(service.Id, existing); err != nil {
+			return service, nil, err
+		}
+		reloaded, err := cli.RancherClient.Service.ById(service.Id)
+		if err != nil {
+			return service, nil, err
+		}
+		if reloaded == nil {
+			return service, nil, fmt.Errorf("service %s was removed while resuming", service.Name)
+		}
+		service = reloaded
+	}
+	return cli.lockUpgrade(service, ttl)
+}
+
+// Roll the upgrade of the service back, after it completed when rollback or
+// by cancelling it otherwise.
+func (cli *Client) rollbackResumed(journal *Journal, name string, service *client.Service, cause error, rollback bool) {
+	fmt.Printf("service with name %s failed with: %v\n", name, cause)
+	var err error
+	if rollback {
+		_, err = cli.RancherClient.Service.ActionRollback(service)
+	} else {
+		_, err = cli.RancherClient.Service.ActionCancelupgrade(service)
+	}
+	if err != nil {
+		log.Errorf("rollback of service %s failed with error: %v", name, err)
+		journal.service(JOURNAL_FAILED, service, err)
+		return
+	}
+	journal.service(JOURNAL_ROLLEDBACK, service, cause)
+}
+
+// Start the journal of an upgrade of the services, logging how to resume it.
+func (cli *Client) startUpgradeJournal(services []client.Service, opts config.UpgradeOpts) *Journal {
+	if cli.JournalDir == "" {
+		return nil
+	}
+
+	names := cli.newServiceNames(services)
+	plan := JournalPlan{
+		Operation:  "service upgrade",
+		Wait:       opts.Wait,
+		Interval:   opts.Interval,
+		LockTTL:    opts.LockTTL,
+		CodeTag:    opts.CodeTag,
+		RuntimeTag: opts.RuntimeTag,
+		Reason:     opts.Reason,
+		GitSha:     opts.GitSha,
+
+		FreezeOverride: opts.FreezeOverride,
+	}
+	if opts.Hooks != nil {
+		plan.Hooks = opts.Hooks.Path
+	}
+	for _, service := range services {
+		name, err := names.service(service.Id)
+		if err != nil {
+			name = service.Name
+		}
+		plan.Services = append(plan.Services, JournalService{Id: service.Id, Name: name})
+	}
+
+	journal, err := NewJournal(cli.JournalDir, plan)
+	if err != nil {
+		log.Warnf("Failed to start a journal, the upgrade can not be resumed: %v", err)
+		return nil
+	}
+	log.Infof("Recording the upgrade in journal %s, continue with deploy resume %s when interrupted", journal.Id, journal.Id)
+	return journal
+}
+
+type journalsByStart []JournalSummary
+
+func (s journalsByStart) Len() int           { return len(s) }
+func (s journalsByStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s journalsByStart) Less(i, j int) bool { return s[i].Started.After(s[j].Started) }
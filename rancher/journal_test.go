@@ -0,0 +1,258 @@
+package rancher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/rancher/go-rancher/client"
+)
+
+// ResumingServices reports the live state of the services of a journal and
+// records how their upgrades are finished or cancelled.
+type ResumingServices struct {
+	client.ServiceOperations
+	Services  map[string]*client.Service
+	Finished  []string
+	Cancelled []string
+}
+
+func (srv *ResumingServices) ById(id string) (*client.Service, error) {
+	service, ok := srv.Services[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *service
+	return &copied, nil
+}
+
+func (srv *ResumingServices) ActionFinishupgrade(service *client.Service) (*client.Service, error) {
+	srv.Finished = append(srv.Finished, service.Id)
+	return service, nil
+}
+
+func (srv *ResumingServices) ActionCancelupgrade(service *client.Service) (*client.Service, error) {
+	srv.Cancelled = append(srv.Cancelled, service.Id)
+	return service, nil
+}
+
+func journalDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "journals")
+}
+
+func journalPlan(wait bool, ids ...string) JournalPlan {
+	plan := JournalPlan{Operation: "service upgrade", Wait: wait, Interval: time.Second}
+	for _, id := range ids {
+		plan.Services = append(plan.Services, JournalService{Id: id, Name: "backend/" + id})
+	}
+	return plan
+}
+
+func TestJournal(t *testing.T) {
+	dir := journalDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	journal, err := NewJournal(dir, journalPlan(true, "1s1", "1s2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.service(JOURNAL_UPGRADING, &client.Service{Resource: client.Resource{Id: "1s1"}}, nil)
+	journal.service(JOURNAL_FAILED, &client.Service{Resource: client.Resource{Id: "1s2"}}, os.ErrPermission)
+
+	// A line cut short by the cli dying is ignored
+	file, err := os.OpenFile(journalPath(dir, journal.Id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"time":"2017-`)
+	file.Close()
+
+	events, err := ReadJournal(dir, journal.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %v", events)
+	}
+	if !reflect.DeepEqual(events[0].Plan, &JournalPlan{
+		Operation: "service upgrade",
+		Services:  []JournalService{{Id: "1s1", Name: "backend/1s1"}, {Id: "1s2", Name: "backend/1s2"}},
+		Wait:      true,
+		Interval:  time.Second,
+	}) {
+		t.Errorf("Unexpected plan %v", events[0].Plan)
+	}
+	if events[1].Event != JOURNAL_UPGRADING || events[1].Service != "1s1" {
+		t.Errorf("Unexpected event %v", events[1])
+	}
+	if events[2].Event != JOURNAL_FAILED || events[2].Error != os.ErrPermission.Error() {
+		t.Errorf("Unexpected event %v", events[2])
+	}
+
+	journals, err := ListJournals(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journals) != 1 || journals[0].Id != journal.Id || journals[0].Services != 2 || journals[0].Done {
+		t.Errorf("Unexpected journals %v", journals)
+	}
+
+	if _, err := ReadJournal(dir, "missing"); err == nil {
+		t.Error("Expected reading a missing journal to fail")
+	}
+}
+
+func TestListJournalsMissingDir(t *testing.T) {
+	journals, err := ListJournals(filepath.Join(os.TempDir(), "rancher-cli-missing", "journals"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journals) != 0 {
+		t.Errorf("Expected no journals, got %v", journals)
+	}
+}
+
+func TestResumeDeployment(t *testing.T) {
+	dir := journalDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	journal, err := NewJournal(dir, journalPlan(true, "1s1", "1s2", "1s3", "1s4", "1s5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded := func(id string) *client.Service {
+		return &client.Service{Resource: client.Resource{Id: id}, State: "upgraded", Transitioning: "no"}
+	}
+	// 1s1 was finished before the cli died, 1s2 is still upgrading, 1s3
+	// failed without being rolled back and 1s4 was never started
+	journal.service(JOURNAL_UPGRADING, upgraded("1s1"), nil)
+	journal.service(JOURNAL_FINISHED, upgraded("1s1"), nil)
+	journal.service(JOURNAL_UPGRADING, upgraded("1s2"), nil)
+	journal.service(JOURNAL_UPGRADING, upgraded("1s3"), nil)
+	journal.service(JOURNAL_FAILED, upgraded("1s3"), os.ErrInvalid)
+
+	services := &ResumingServices{
+		Services: map[string]*client.Service{
+			"1s1": {Resource: client.Resource{Id: "1s1"}, State: "active"},
+			"1s2": upgraded("1s2"),
+			"1s3": upgraded("1s3"),
+			"1s4": {Resource: client.Resource{Id: "1s4"}, State: "active"},
+		},
+	}
+	cli := scopedClient()
+	cli.RancherClient.Service = services
+
+	if err := cli.ResumeDeployment(dir, journal.Id); err == nil {
+		t.Error("Expected resuming a failed upgrade to fail")
+	}
+	if !reflect.DeepEqual(services.Finished, []string{"1s2"}) {
+		t.Errorf("Expected 1s2 to be finished, got %v", services.Finished)
+	}
+	if !reflect.DeepEqual(services.Cancelled, []string{"1s3"}) {
+		t.Errorf("Expected 1s3 to be rolled back, got %v", services.Cancelled)
+	}
+
+	events, err := ReadJournal(dir, journal.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := make(map[string]string)
+	for _, event := range events[1:] {
+		outcomes[event.Service] = event.Event
+	}
+	expected := map[string]string{
+		"":    JOURNAL_DONE,
+		"1s1": JOURNAL_FINISHED,
+		"1s2": JOURNAL_FINISHED,
+		"1s3": JOURNAL_ROLLEDBACK,
+		"1s4": JOURNAL_SKIPPED,
+		"1s5": JOURNAL_SKIPPED,
+	}
+	if !reflect.DeepEqual(outcomes, expected) {
+		t.Errorf("Expected outcomes %v, got %v", expected, outcomes)
+	}
+
+	if err := cli.ResumeDeployment(dir, journal.Id); err == nil {
+		t.Error("Expected resuming a completed deployment to fail")
+	}
+}
+
+// ResumingLockedServices keeps the locks of the services in their metadata
+// and records how their upgrades are finished or rolled back.
+type ResumingLockedServices struct {
+	LockingServices
+	Finished   []string
+	RolledBack []string
+}
+
+func (srv *ResumingLockedServices) ActionFinishupgrade(service *client.Service) (*client.Service, error) {
+	srv.Finished = append(srv.Finished, service.Id)
+	return service, nil
+}
+
+func (srv *ResumingLockedServices) ActionRollback(service *client.Service) (*client.Service, error) {
+	srv.RolledBack = append(srv.RolledBack, service.Id)
+	return service, nil
+}
+
+func TestResumeDeploymentRunsPostHooksAndReleasesLocks(t *testing.T) {
+	dir := journalDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	hooksPath := filepath.Join(filepath.Dir(dir), "hooks.yml")
+	if err := ioutil.WriteFile(hooksPath, []byte("post:\n  - name: check\n    services: [worker]\n    command: [\"false\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hooks, err := config.LoadHooks(hooksPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli, locking := lockingClient(nil)
+	// The locks of the cli that died while upgrading
+	left := NewLock(time.Minute)
+	left.Started = left.Started.Add(-time.Minute)
+	for _, service := range locking.Services {
+		service.State = "upgraded"
+		service.Transitioning = "no"
+		service.Metadata = map[string]interface{}{LOCK_METADATA_KEY: left}
+	}
+	services := &ResumingLockedServices{LockingServices: *locking}
+	cli.RancherClient.Service = services
+	cli.JournalDir = dir
+
+	journal := cli.startUpgradeJournal([]client.Service{*locking.Services["1s1"], *locking.Services["1s2"]}, config.UpgradeOpts{
+		Wait:     true,
+		Interval: time.Second,
+		LockTTL:  time.Minute,
+		Hooks:    hooks,
+	})
+	if journal == nil {
+		t.Fatal("expected a journal to be started")
+	}
+	journal.service(JOURNAL_UPGRADING, locking.Services["1s1"], nil)
+	journal.service(JOURNAL_UPGRADING, locking.Services["1s2"], nil)
+
+	if err := cli.ResumeDeployment(dir, journal.Id); err == nil {
+		t.Error("Expected resuming an upgrade with a failing post hook to fail")
+	}
+	if !reflect.DeepEqual(services.Finished, []string{"1s1"}) {
+		t.Errorf("Expected 1s1 to be finished, got %v", services.Finished)
+	}
+	if !reflect.DeepEqual(services.RolledBack, []string{"1s2"}) {
+		t.Errorf("Expected 1s2 to be rolled back after its post hook failed, got %v", services.RolledBack)
+	}
+	for _, id := range []string{"1s1", "1s2"} {
+		if lock, _ := ServiceLock(services.Services[id]); lock != nil {
+			t.Errorf("Expected the lock of %s to be released, got %v", id, lock)
+		}
+	}
+}
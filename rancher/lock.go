@@ -0,0 +1,243 @@
+package rancher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	// Key of the lock in the metadata of a service
+	LOCK_METADATA_KEY = "io.nowait.rancher-cli.lock"
+)
+
+// Lock is an advisory lock on a service, taken for the duration of an
+// upgrade so two people do not upgrade the same service at once.  Locks are
+// stored in the metadata of the service and expire after their TTL in case
+// the cli holding them dies.
+type Lock struct {
+	Owner   string    `json:"owner"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	// Seconds after Started the lock expires
+	TTL int64 `json:"ttl"`
+}
+
+// LockedService is a service with a lock as listed by lock list.
+type LockedService struct {
+	Stack   string `json:"stack"`
+	Service string `json:"service"`
+	Lock    Lock   `json:"lock"`
+	Expired bool   `json:"expired"`
+}
+
+// NewLock returns a lock of the current user and host starting now.
+func NewLock(ttl time.Duration) *Lock {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Lock{
+		Owner:   CurrentUser(),
+		Host:    host,
+		Started: time.Now().UTC().Truncate(time.Second),
+		TTL:     int64(ttl / time.Second),
+	}
+}
+
+// Expires returns the time the lock expires.
+func (lock *Lock) Expires() time.Time {
+	return lock.Started.Add(time.Duration(lock.TTL) * time.Second)
+}
+
+// Expired reports whether the lock has expired at the time.
+func (lock *Lock) Expired(now time.Time) bool {
+	return !now.Before(lock.Expires())
+}
+
+// Held reports whether the lock was taken by the current user on this host.
+func (lock *Lock) Held() bool {
+	current := NewLock(0)
+	return lock.Owner == current.Owner && lock.Host == current.Host
+}
+
+func (lock *Lock) String() string {
+	return fmt.Sprintf("%s on %s since %s until %s", lock.Owner, lock.Host,
+		lock.Started.Format(time.RFC3339), lock.Expires().Format(time.RFC3339))
+}
+
+func (lock *Lock) same(other *Lock) bool {
+	return other != nil && lock.Owner == other.Owner && lock.Host == other.Host && lock.Started.Equal(other.Started)
+}
+
+// ServiceLock returns the lock of the service, or nil when it is not locked.
+func ServiceLock(service *client.Service) (*Lock, error) {
+	if value, ok := service.Metadata[LOCK_METADATA_KEY]; !ok || value == nil {
+		return nil, nil
+	}
+	lock := &Lock{}
+	if err := decodeMetadata(service, LOCK_METADATA_KEY, lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// LockService takes the lock on the service, refusing when somebody else
+// holds a lock that has not expired.  The lock is read back after writing it
+// so a lock taken at the same time by somebody else is noticed.
+func (cli *Client) LockService(service *client.Service, lock *Lock) (*client.Service, error) {
+	existing, err := ServiceLock(service)
+	if err != nil {
+		return service, err
+	}
+	if existing != nil && !existing.Expired(time.Now()) && !existing.same(lock) {
+		return service, fmt.Errorf("service %s is locked by %s, wait for the other deployment to finish or release the lock with lock release --force", service.Name, existing)
+	}
+
+	updated, err := cli.setServiceLock(service, lock)
+	if err != nil {
+		return service, errors.Wrapf(err, "Failed to lock service %s", service.Name)
+	}
+
+	current, err := cli.RancherClient.Service.ById(updated.Id)
+	if err != nil {
+		return updated, err
+	}
+	if current == nil {
+		return updated, fmt.Errorf("service %s was removed while it was locked", service.Name)
+	}
+	if found, err := ServiceLock(current); err != nil || !lock.same(found) {
+		return current, fmt.Errorf("service %s was locked by somebody else at the same time", service.Name)
+	}
+	return current, nil
+}
+
+// UnlockService releases the lock on the service with the id.  A nil lock
+// releases whatever lock the service has, otherwise a lock that has been
+// taken over by somebody else is left in place.
+func (cli *Client) UnlockService(id string, lock *Lock) error {
+	service, err := cli.RancherClient.Service.ById(id)
+	if err != nil || service == nil {
+		return err
+	}
+
+	existing, err := ServiceLock(service)
+	if err != nil || existing == nil {
+		return err
+	}
+	if lock != nil && !lock.same(existing) {
+		return nil
+	}
+
+	_, err = cli.setServiceLock(service, nil)
+	return errors.Wrapf(err, "Failed to unlock service %s", service.Name)
+}
+
+// ListLocks lists the locked services within the scope of the client,
+// ordered by stack and name.
+func (cli *Client) ListLocks() ([]LockedService, error) {
+	filters := cli.scopeFilters(make(map[string]interface{}), false)
+	filters["kind"] = SERVICE_TYPE_SERVICE
+	services, err := cli.listServices(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	names := cli.newServiceNames(nil)
+	now := time.Now()
+	locked := []LockedService{}
+	for i := range services {
+		service := &services[i]
+		lock, err := ServiceLock(service)
+		if err != nil {
+			return nil, err
+		}
+		if lock == nil || removed(service.State) {
+			continue
+		}
+
+		stack, err := names.stack(service.EnvironmentId)
+		if err != nil {
+			return nil, err
+		}
+		locked = append(locked, LockedService{
+			Stack:   stack,
+			Service: service.Name,
+			Lock:    *lock,
+			Expired: lock.Expired(now),
+		})
+	}
+	sort.Sort(lockedByName(locked))
+	return locked, nil
+}
+
+// ReleaseLock releases the lock on the named service.  Locks held by somebody
+// else that have not expired are only released with force.
+func (cli *Client) ReleaseLock(name string, force bool) error {
+	service, err := cli.ServiceByName(name)
+	if err != nil {
+		return err
+	}
+
+	lock, err := ServiceLock(service)
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return fmt.Errorf("service %s is not locked", service.Name)
+	}
+	if !force && !lock.Held() && !lock.Expired(time.Now()) {
+		return fmt.Errorf("service %s is locked by %s, pass --force to release it anyway", service.Name, lock)
+	}
+
+	return cli.UnlockService(service.Id, nil)
+}
+
+// Take the lock for an upgrade with the options, when they ask for one.
+func (cli *Client) lockUpgrade(service *client.Service, ttl time.Duration) (*client.Service, *Lock, error) {
+	if ttl <= 0 {
+		return service, nil, nil
+	}
+
+	lock := NewLock(ttl)
+	service, err := cli.LockService(service, lock)
+	if err != nil {
+		return service, nil, err
+	}
+	return service, lock, nil
+}
+
+// Release the lock of an upgrade, logging rather than failing since the lock
+// expires anyway.
+func (cli *Client) unlockUpgrade(service *client.Service, lock *Lock) {
+	if lock == nil {
+		return
+	}
+	if err := cli.UnlockService(service.Id, lock); err != nil {
+		log.Warnf("Failed to release the lock on service %s, it expires at %s: %v", service.Name, lock.Expires().Format(time.RFC3339), err)
+	}
+}
+
+// Write the lock into the metadata of the service, removing it when nil.
+func (cli *Client) setServiceLock(service *client.Service, lock *Lock) (*client.Service, error) {
+	if lock == nil {
+		return cli.setServiceMetadata(service, LOCK_METADATA_KEY, nil)
+	}
+	return cli.setServiceMetadata(service, LOCK_METADATA_KEY, lock)
+}
+
+type lockedByName []LockedService
+
+func (s lockedByName) Len() int      { return len(s) }
+func (s lockedByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s lockedByName) Less(i, j int) bool {
+	if s[i].Stack != s[j].Stack {
+		return s[i].Stack < s[j].Stack
+	}
+	return s[i].Service < s[j].Service
+}
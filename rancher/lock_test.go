@@ -0,0 +1,187 @@
+package rancher
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+// LockingServices stores the metadata updates of the services the way
+// Rancher returns it, decoded from json.
+type LockingServices struct {
+	client.ServiceOperations
+	Services map[string]*client.Service
+	// Lock written by somebody else right after every update
+	Racer *Lock
+}
+
+func (srv *LockingServices) ById(id string) (*client.Service, error) {
+	service, ok := srv.Services[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *service
+	return &copied, nil
+}
+
+func (srv *LockingServices) Update(existing *client.Service, updates interface{}) (*client.Service, error) {
+	data, err := json.Marshal(updates)
+	if err != nil {
+		return nil, err
+	}
+	decoded := struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	service := srv.Services[existing.Id]
+	service.Metadata = decoded.Metadata
+	if srv.Racer != nil {
+		service.Metadata[LOCK_METADATA_KEY] = srv.Racer
+	}
+	return srv.ById(existing.Id)
+}
+
+func (srv *LockingServices) List(opts *client.ListOpts) (*client.ServiceCollection, error) {
+	services := []client.Service{}
+	for _, id := range []string{"1s1", "1s2"} {
+		if service := srv.Services[id]; matchesFilter(opts, "name", service.Name) {
+			services = append(services, *service)
+		}
+	}
+	return &client.ServiceCollection{Data: services}, nil
+}
+
+func lockingClient(metadata map[string]interface{}) (*Client, *LockingServices) {
+	services := &LockingServices{
+		Services: map[string]*client.Service{
+			"1s1": {Resource: client.Resource{Id: "1s1"}, Name: "api", EnvironmentId: "1e1", Metadata: metadata},
+			"1s2": {Resource: client.Resource{Id: "1s2"}, Name: "worker", EnvironmentId: "1e1"},
+		},
+	}
+	cli := scopedClient()
+	cli.Scope = Scope{ProjectId: mocks.ProjectOne.Id, Project: mocks.ProjectOneName}
+	cli.RancherClient.Service = services
+	return cli, services
+}
+
+func otherLock(started time.Time) *Lock {
+	return &Lock{Owner: "somebody-else", Host: "elsewhere", Started: started.UTC().Truncate(time.Second), TTL: 600}
+}
+
+func TestLockService(t *testing.T) {
+	cli, services := lockingClient(map[string]interface{}{"team": "platform"})
+	lock := NewLock(10 * time.Minute)
+
+	service, err := cli.LockService(services.Services["1s1"], lock)
+	if err != nil {
+		t.Fatalf("locking failed with: %v", err)
+	}
+	if found, err := ServiceLock(service); err != nil || !lock.same(found) {
+		t.Errorf("expected the lock in the metadata, received %v %v", found, err)
+	}
+	if service.Metadata["team"] != "platform" {
+		t.Errorf("locking should keep the other metadata, received %v", service.Metadata)
+	}
+
+	again := NewLock(time.Minute)
+	again.Started = lock.Started.Add(time.Second)
+	if _, err := cli.LockService(service, again); err == nil {
+		t.Errorf("locking a locked service again should fail")
+	}
+
+	if err := cli.UnlockService(service.Id, lock); err != nil {
+		t.Fatalf("unlocking failed with: %v", err)
+	}
+	if found, _ := ServiceLock(services.Services["1s1"]); found != nil {
+		t.Errorf("expected the lock to be released, received %v", found)
+	}
+	if services.Services["1s1"].Metadata["team"] != "platform" {
+		t.Errorf("unlocking should keep the other metadata, received %v", services.Services["1s1"].Metadata)
+	}
+}
+
+func TestLockServiceConflicts(t *testing.T) {
+	tests := []struct {
+		Description string
+		Existing    *Lock
+		Racer       *Lock
+		Error       string
+	}{
+		{
+			Description: "Lock held by somebody else",
+			Existing:    otherLock(time.Now()),
+			Error:       "locked by somebody-else on elsewhere",
+		},
+		{
+			Description: "Expired lock is taken over",
+			Existing:    otherLock(time.Now().Add(-time.Hour)),
+		},
+		{
+			Description: "Lock taken at the same time",
+			Racer:       otherLock(time.Now()),
+			Error:       "locked by somebody else at the same time",
+		},
+	}
+
+	for _, test := range tests {
+		metadata := map[string]interface{}{}
+		if test.Existing != nil {
+			metadata[LOCK_METADATA_KEY] = test.Existing
+		}
+		cli, services := lockingClient(metadata)
+		services.Racer = test.Racer
+
+		_, err := cli.LockService(services.Services["1s1"], NewLock(time.Minute))
+		if test.Error == "" && err != nil {
+			t.Errorf("%s: locking failed with: %v", test.Description, err)
+		}
+		if test.Error != "" && (err == nil || !strings.Contains(err.Error(), test.Error)) {
+			t.Errorf("%s: expected error %s, received %v", test.Description, test.Error, err)
+		}
+	}
+}
+
+func TestUnlockLeavesLockOfSomebodyElse(t *testing.T) {
+	other := otherLock(time.Now())
+	cli, services := lockingClient(map[string]interface{}{LOCK_METADATA_KEY: other})
+
+	if err := cli.UnlockService("1s1", NewLock(time.Minute)); err != nil {
+		t.Fatalf("unlocking failed with: %v", err)
+	}
+	if found, _ := ServiceLock(services.Services["1s1"]); !other.same(found) {
+		t.Errorf("the lock of somebody else should be left in place, received %v", found)
+	}
+}
+
+func TestListAndReleaseLocks(t *testing.T) {
+	other := otherLock(time.Now())
+	cli, services := lockingClient(map[string]interface{}{LOCK_METADATA_KEY: other})
+
+	locks, err := cli.ListLocks()
+	if err != nil {
+		t.Fatalf("listing locks failed with: %v", err)
+	}
+	if len(locks) != 1 || locks[0].Stack != "backend" || locks[0].Service != "api" || locks[0].Expired {
+		t.Errorf("expected the lock on backend/api, received %+v", locks)
+	}
+
+	if err := cli.ReleaseLock("backend/worker", false); err == nil {
+		t.Errorf("releasing a service without a lock should fail")
+	}
+	if err := cli.ReleaseLock("backend/api", false); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("releasing the lock of somebody else should require force, received %v", err)
+	}
+	if err := cli.ReleaseLock("backend/api", true); err != nil {
+		t.Fatalf("forcing the release failed with: %v", err)
+	}
+	if found, _ := ServiceLock(services.Services["1s1"]); found != nil {
+		t.Errorf("expected the lock to be released, received %v", found)
+	}
+}
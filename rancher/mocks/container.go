@@ -0,0 +1,100 @@
+package mocks
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+// Mocks embed the operations interface so only the methods used by the cli
+// need to be implemented, calling any other method panics.
+
+type ServiceExposeMapOperations struct {
+	client.ServiceExposeMapOperations
+	Maps []client.ServiceExposeMap
+}
+
+func (ops *ServiceExposeMapOperations) List(opts *client.ListOpts) (*client.ServiceExposeMapCollection, error) {
+	maps := []client.ServiceExposeMap{}
+	for _, exposeMap := range ops.Maps {
+		if opts.Filters["serviceId"] == exposeMap.ServiceId {
+			maps = append(maps, exposeMap)
+		}
+	}
+	return &client.ServiceExposeMapCollection{
+		Data: maps,
+	}, nil
+}
+
+type ContainerOperations struct {
+	client.ContainerOperations
+	Containers []client.Container
+}
+
+func (ops *ContainerOperations) ById(id string) (*client.Container, error) {
+	for _, container := range ops.Containers {
+		if container.Id == id {
+			return &container, nil
+		}
+	}
+	// Like go-rancher on a 404
+	return nil, nil
+}
+
+func (ops *ContainerOperations) List(opts *client.ListOpts) (*client.ContainerCollection, error) {
+	return &client.ContainerCollection{
+		Data: ops.Containers,
+	}, nil
+}
+
+type HostOperations struct {
+	client.HostOperations
+	Hosts []client.Host
+}
+
+func (ops *HostOperations) ById(id string) (*client.Host, error) {
+	for _, host := range ops.Hosts {
+		if host.Id == id {
+			return &host, nil
+		}
+	}
+	return nil, nil
+}
+
+func (ops *HostOperations) List(opts *client.ListOpts) (*client.HostCollection, error) {
+	return &client.HostCollection{
+		Data: ops.Hosts,
+	}, nil
+}
+
+// Pull tasks are created transitioning and complete the first time they are
+// reloaded, except for FailImage which errors.
+type PullTaskOperations struct {
+	client.PullTaskOperations
+	Created   []client.PullTask
+	FailImage string
+}
+
+func (ops *PullTaskOperations) Create(task *client.PullTask) (*client.PullTask, error) {
+	created := *task
+	created.Id = fmt.Sprintf("1pt%d", len(ops.Created))
+	created.Transitioning = "yes"
+	ops.Created = append(ops.Created, created)
+	return &created, nil
+}
+
+func (ops *PullTaskOperations) ById(id string) (*client.PullTask, error) {
+	for _, task := range ops.Created {
+		if task.Id != id {
+			continue
+		}
+		task.Transitioning = "no"
+		if task.Image == ops.FailImage {
+			task.Transitioning = "error"
+			task.TransitioningMessage = "image not found"
+		}
+		return &task, nil
+	}
+	return nil, errors.New("Pull task not found")
+}
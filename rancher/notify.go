@@ -0,0 +1,315 @@
+package rancher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	// Message of events without a template of their own
+	DEFAULT_NOTIFY_TEMPLATE = "{{.Summary}}"
+
+	notifyTimeout    = 10 * time.Second
+	notifyRetryDelay = time.Second
+)
+
+// Notification is what notifiers are sent about a deployment event.
+type Notification struct {
+	Event   string           `json:"event"`
+	Time    time.Time        `json:"time"`
+	User    string           `json:"user"`
+	Summary string           `json:"summary"`
+	Reason  string           `json:"reason,omitempty"`
+	Results []NotifiedResult `json:"results,omitempty"`
+	// Stacks cloned and the environment they were cloned into
+	Stacks      []string `json:"stacks,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+}
+
+// NotifiedResult is the UpgradeResult of a service as sent to notifiers.
+type NotifiedResult struct {
+	ServiceId string   `json:"serviceId"`
+	Service   string   `json:"service"`
+	State     string   `json:"state,omitempty"`
+	Images    []string `json:"images,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Notifiers announce deployment events following the notifiers of the cli
+// config.  Failing to notify never fails a deployment.
+type Notifiers struct {
+	notifiers []config.Notifier
+	templates map[string]*template.Template
+	http      *http.Client
+	// Delay before the first retry, doubled for every further one
+	retryDelay time.Duration
+}
+
+// NewNotifiers returns the notifiers of the configs with their templates
+// parsed, or nil when there are none.
+func NewNotifiers(notifiers []config.Notifier) (*Notifiers, error) {
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+
+	n := &Notifiers{
+		notifiers:  notifiers,
+		templates:  make(map[string]*template.Template),
+		http:       &http.Client{Timeout: notifyTimeout},
+		retryDelay: notifyRetryDelay,
+	}
+	for _, notifier := range notifiers {
+		for _, event := range config.NotifyEvents {
+			text, ok := notifier.Templates[event]
+			if !ok {
+				text = DEFAULT_NOTIFY_TEMPLATE
+			}
+			tmpl, err := template.New(event).Parse(text)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid template of %s of notifier %s", event, notifier.Name)
+			}
+			n.templates[notifier.Name+"/"+event] = tmpl
+		}
+	}
+	return n, nil
+}
+
+// Redirect sends every notification to the stand-in instead, without the
+// smtp credentials.
+func (n *Notifiers) Redirect(standIn *StandIn) {
+	for i := range n.notifiers {
+		notifier := &n.notifiers[i]
+		switch notifier.Type {
+		case config.NOTIFIER_EMAIL:
+			notifier.Smtp = standIn.SmtpAddr
+			notifier.Username = ""
+			notifier.Password = ""
+		default:
+			notifier.Url = standIn.Url + "/" + notifier.Name
+		}
+	}
+	n.retryDelay = 0
+}
+
+// Notify sends the notification to every notifier fired on its event,
+// retrying failed notifiers.  The notifiers that still fail are returned in
+// the error.
+func (n *Notifiers) Notify(notification Notification) error {
+	if n == nil {
+		return nil
+	}
+	if notification.Time.IsZero() {
+		notification.Time = time.Now().UTC()
+	}
+	if notification.User == "" {
+		notification.User = CurrentUser()
+	}
+
+	failures := []string{}
+	for _, notifier := range n.notifiers {
+		if !notifier.Fires(notification.Event) {
+			continue
+		}
+
+		var err error
+		for attempt := 0; ; attempt++ {
+			if err = n.send(notifier, notification); err == nil || attempt >= notifier.Retries {
+				break
+			}
+			log.Debugf("Notifier %s failed, retrying: %v", notifier.Name, err)
+			time.Sleep(n.retryDelay << uint(attempt))
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", notifier.Name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("notifiers failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (n *Notifiers) send(notifier config.Notifier, notification Notification) error {
+	tmpl, ok := n.templates[notifier.Name+"/"+notification.Event]
+	if !ok {
+		return fmt.Errorf("unknown event %q", notification.Event)
+	}
+	message := &bytes.Buffer{}
+	if err := tmpl.Execute(message, notification); err != nil {
+		return err
+	}
+
+	switch notifier.Type {
+	case config.NOTIFIER_SLACK:
+		return n.post(notifier.Url, map[string]string{"text": message.String()})
+	case config.NOTIFIER_EMAIL:
+		return sendEmail(notifier, notification, message.String())
+	default:
+		return n.post(notifier.Url, struct {
+			Notification
+			Message string `json:"message"`
+		}{notification, message.String()})
+	}
+}
+
+func (n *Notifiers) post(url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	return nil
+}
+
+func sendEmail(notifier config.Notifier, notification Notification, message string) error {
+	var auth smtp.Auth
+	if notifier.Username != "" {
+		host := strings.Split(notifier.Smtp, ":")[0]
+		auth = smtp.PlainAuth("", notifier.Username, notifier.Password, host)
+	}
+
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "From: %s\r\n", notifier.From)
+	fmt.Fprintf(body, "To: %s\r\n", strings.Join(notifier.To, ", "))
+	fmt.Fprintf(body, "Subject: [rancher-cli] %s\r\n", strings.Replace(notification.Summary, "\n", " ", -1))
+	fmt.Fprintf(body, "Date: %s\r\n", notification.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(strings.Replace(message, "\n", "\r\n", -1))
+	body.WriteString("\r\n")
+
+	return smtp.SendMail(notifier.Smtp, auth, notifier.From, notifier.To, body.Bytes())
+}
+
+// Send the notification, logging rather than failing when notifiers fail.
+func (cli *Client) notify(notification Notification) {
+	if cli.Notifiers == nil {
+		return
+	}
+	if err := cli.Notifiers.Notify(notification); err != nil {
+		log.Warnf("Failed to notify %s: %v", notification.Event, err)
+	}
+}
+
+// Announce the event of an upgrade of the services of the results.
+func (cli *Client) notifyUpgrade(event string, results []UpgradeResult, opts config.UpgradeOpts) {
+	if cli.Notifiers == nil || len(results) == 0 {
+		return
+	}
+
+	services := []client.Service{}
+	for _, result := range results {
+		if result.Service != nil {
+			services = append(services, *result.Service)
+		}
+	}
+	names := cli.newServiceNames(services)
+
+	notified := []NotifiedResult{}
+	described := []string{}
+	failed := []string{}
+	for _, result := range results {
+		if result.Service == nil {
+			continue
+		}
+		name, err := names.service(result.Service.Id)
+		if err != nil {
+			name = result.Service.Name
+		}
+		current := NotifiedResult{
+			ServiceId: result.Service.Id,
+			Service:   name,
+			State:     result.Service.State,
+			Images:    serviceImageNames(result.Service),
+		}
+		described = append(described, name)
+		if result.Error != nil {
+			current.Error = result.Error.Error()
+			failed = append(failed, name)
+		}
+		notified = append(notified, current)
+	}
+
+	user := CurrentUser()
+	summary := ""
+	switch event {
+	case config.NOTIFY_UPGRADE_START:
+		summary = fmt.Sprintf("%s started upgrading %d services: %s", user, len(described), strings.Join(described, ", "))
+	case config.NOTIFY_UPGRADE_SUCCESS:
+		summary = fmt.Sprintf("%s upgraded %d services: %s", user, len(described), strings.Join(described, ", "))
+	case config.NOTIFY_UPGRADE_FAILURE:
+		summary = fmt.Sprintf("%s failed to upgrade %d of %d services: %s", user, len(failed), len(described), strings.Join(failed, ", "))
+	case config.NOTIFY_ROLLBACK:
+		summary = fmt.Sprintf("%s rolled back %d services: %s", user, len(described), strings.Join(described, ", "))
+	}
+
+	cli.notify(Notification{
+		Event:   event,
+		User:    user,
+		Summary: summary,
+		Reason:  opts.Reason,
+		Results: notified,
+	})
+}
+
+// Announce the success or failure of an upgrade with the results.  Success
+// is only announced once the upgrades are finished, which is left to service
+// upgrade-finish without opts.Wait.
+func (cli *Client) notifyUpgraded(results []UpgradeResult, opts config.UpgradeOpts) {
+	for _, result := range results {
+		if result.Error != nil {
+			cli.notifyUpgrade(config.NOTIFY_UPGRADE_FAILURE, results, opts)
+			return
+		}
+	}
+	if opts.Wait {
+		cli.notifyUpgrade(config.NOTIFY_UPGRADE_SUCCESS, results, opts)
+	}
+}
+
+// Announce that the stacks of the plan have been cloned.
+func (cli *Client) notifyClone(target *Client, plan *ClonePlan) {
+	if cli.Notifiers == nil {
+		return
+	}
+
+	stacks := []string{}
+	for _, stack := range plan.Stacks {
+		if stack.Action != CLONE_ACTION_SKIP {
+			stacks = append(stacks, stack.Name)
+		}
+	}
+	environment, err := target.ProjectName(plan.TargetProjectId)
+	if err != nil {
+		environment = plan.TargetProjectId
+	}
+
+	user := CurrentUser()
+	cli.notify(Notification{
+		Event:       config.NOTIFY_CLONE_COMPLETE,
+		User:        user,
+		Summary:     fmt.Sprintf("%s cloned %d stacks into environment %s: %s", user, len(stacks), environment, strings.Join(stacks, ", ")),
+		Stacks:      stacks,
+		Environment: environment,
+	})
+}
@@ -0,0 +1,164 @@
+package rancher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/rancher/go-rancher/client"
+)
+
+func standInNotifiers(t *testing.T, notifiers ...config.Notifier) (*Notifiers, *StandIn) {
+	n, err := NewNotifiers(notifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standIn, err := NewStandIn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Redirect(standIn)
+	return n, standIn
+}
+
+func TestNotify(t *testing.T) {
+	n, standIn := standInNotifiers(t,
+		config.Notifier{Name: "hook", Type: config.NOTIFIER_WEBHOOK, Url: "https://example.com/hook"},
+		config.Notifier{
+			Name:      "chat",
+			Type:      config.NOTIFIER_SLACK,
+			Url:       "https://example.com/chat",
+			Events:    []string{config.NOTIFY_UPGRADE_FAILURE},
+			Templates: map[string]string{config.NOTIFY_UPGRADE_FAILURE: "{{.User}} broke {{range .Results}}{{.Service}}{{end}}"},
+		},
+		config.Notifier{
+			Name:     "mail",
+			Type:     config.NOTIFIER_EMAIL,
+			Smtp:     "smtp.example.com:587",
+			Username: "user",
+			Password: "secret",
+			From:     "cli@example.com",
+			To:       []string{"team@example.com"},
+		},
+	)
+	defer standIn.Close()
+
+	notification := Notification{
+		Event:   config.NOTIFY_UPGRADE_FAILURE,
+		User:    "alice",
+		Summary: "alice failed to upgrade 1 of 1 services: backend/api",
+		Results: []NotifiedResult{{ServiceId: "1s1", Service: "backend/api", Error: "timed out"}},
+	}
+	if err := n.Notify(notification); err != nil {
+		t.Fatal(err)
+	}
+	notification.Event = config.NOTIFY_UPGRADE_SUCCESS
+	if err := n.Notify(notification); err != nil {
+		t.Fatal(err)
+	}
+
+	received := map[string][]string{}
+	for _, message := range standIn.Received() {
+		received[message.Target] = append(received[message.Target], message.Body)
+	}
+
+	if len(received["/hook"]) != 2 {
+		t.Fatalf("Expected the webhook to receive both events, got %v", received["/hook"])
+	}
+	hooked := struct {
+		Notification
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal([]byte(received["/hook"][0]), &hooked); err != nil {
+		t.Fatal(err)
+	}
+	if hooked.Event != config.NOTIFY_UPGRADE_FAILURE || hooked.Message != notification.Summary || hooked.Results[0].Error != "timed out" {
+		t.Errorf("Unexpected webhook notification %v", hooked)
+	}
+
+	if len(received["/chat"]) != 1 || received["/chat"][0] != `{"text":"alice broke backend/api"}` {
+		t.Errorf("Expected the failure in the chat only, got %v", received["/chat"])
+	}
+
+	mails := received["team@example.com"]
+	if len(mails) != 2 {
+		t.Fatalf("Expected 2 emails, got %v", mails)
+	}
+	if !strings.Contains(mails[0], "Subject: [rancher-cli] "+notification.Summary) || !strings.HasSuffix(mails[0], notification.Summary) {
+		t.Errorf("Unexpected email %s", mails[0])
+	}
+}
+
+func TestNotifyRetries(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	n, err := NewNotifiers([]config.Notifier{{Name: "hook", Type: config.NOTIFIER_WEBHOOK, Url: server.URL, Retries: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.retryDelay = time.Millisecond
+
+	if err := n.Notify(Notification{Event: config.NOTIFY_ROLLBACK}); err != nil {
+		t.Errorf("Expected the third attempt to succeed, got %v", err)
+	}
+
+	attempts = -10
+	if err := n.Notify(Notification{Event: config.NOTIFY_ROLLBACK}); err == nil || !strings.Contains(err.Error(), "hook") {
+		t.Errorf("Expected the notifier to fail after its retries, got %v", err)
+	}
+}
+
+// FinishedServices finishes upgrades right away.
+type FinishedServices struct {
+	*DeployedServices
+}
+
+func (srv *FinishedServices) ActionFinishupgrade(service *client.Service) (*client.Service, error) {
+	return service, nil
+}
+
+func TestUpgradeNotifies(t *testing.T) {
+	cli, services, cleanup := deployedClient(t, HISTORY_STORE_LOCAL)
+	defer cleanup()
+	cli.RancherClient.Service = &FinishedServices{services}
+
+	var standIn *StandIn
+	cli.Notifiers, standIn = standInNotifiers(t, config.Notifier{Name: "hook", Type: config.NOTIFIER_WEBHOOK, Url: "https://example.com/hook"})
+	defer standIn.Close()
+
+	if _, err := cli.UpgradeService(config.UpgradeOpts{Service: "backend/api", RuntimeTag: "1.1", Reason: "release"}); err != nil {
+		t.Fatal(err)
+	}
+	// Success is only announced once the upgrade is finished
+	if received := standIn.Received(); len(received) != 1 {
+		t.Fatalf("Expected only a start notification before the upgrade is finished, got %v", received)
+	}
+	if _, err := cli.FinishServiceUpgrade("backend/api"); err != nil {
+		t.Fatal(err)
+	}
+
+	received := standIn.Received()
+	if len(received) != 2 {
+		t.Fatalf("Expected a start and success notification, got %v", received)
+	}
+	for i, event := range []string{config.NOTIFY_UPGRADE_START, config.NOTIFY_UPGRADE_SUCCESS} {
+		notification := Notification{}
+		if err := json.Unmarshal([]byte(received[i].Body), &notification); err != nil {
+			t.Fatal(err)
+		}
+		if notification.Event != event || len(notification.Results) != 1 || notification.Results[0].Service != "backend/api" {
+			t.Errorf("Unexpected notification %v", notification)
+		}
+	}
+}
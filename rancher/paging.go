@@ -0,0 +1,212 @@
+package rancher
+
+import (
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+var (
+	// Number of resources requested per page
+	pageLimit = 100
+)
+
+// eachPage calls list with the filters for the first page and then follows
+// the next links of the returned collections until the last page.  The next
+// link carries the filters, limit and marker of the following page as its
+// query, which become the filters of the next call.
+func eachPage(filters map[string]interface{}, list func(*client.ListOpts) (*client.Collection, error)) error {
+	opts := &client.ListOpts{
+		Filters: make(map[string]interface{}),
+	}
+	for key, value := range filters {
+		opts.Filters[key] = value
+	}
+	if _, ok := opts.Filters["limit"]; !ok {
+		opts.Filters["limit"] = pageLimit
+	}
+
+	seen := make(map[string]bool)
+	for {
+		collection, err := list(opts)
+		if err != nil {
+			return err
+		}
+
+		if collection == nil || collection.Pagination == nil || collection.Pagination.Next == "" {
+			return nil
+		}
+
+		next := collection.Pagination.Next
+		if seen[next] {
+			return errors.Errorf("pagination returned the page %s twice", next)
+		}
+		seen[next] = true
+
+		opts, err = nextPageOpts(next)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func nextPageOpts(next string) (*client.ListOpts, error) {
+	u, err := url.Parse(next)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid next page %s", next)
+	}
+
+	opts := &client.ListOpts{
+		Filters: make(map[string]interface{}),
+	}
+	for key, values := range u.Query() {
+		if len(values) > 0 {
+			opts.Filters[key] = values[0]
+		}
+	}
+	return opts, nil
+}
+
+func (cli *Client) listServices(filters map[string]interface{}) ([]client.Service, error) {
+	all := []client.Service{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.Service.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listStacks(filters map[string]interface{}) ([]client.Environment, error) {
+	all := []client.Environment{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.Environment.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listProjects(filters map[string]interface{}) ([]client.Project, error) {
+	all := []client.Project{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.Project.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listProjectMembers(filters map[string]interface{}) ([]client.ProjectMember, error) {
+	all := []client.ProjectMember{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.ProjectMember.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listHosts(filters map[string]interface{}) ([]client.Host, error) {
+	all := []client.Host{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.Host.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listContainers(filters map[string]interface{}) ([]client.Container, error) {
+	all := []client.Container{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.Container.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listServiceExposeMaps(filters map[string]interface{}) ([]client.ServiceExposeMap, error) {
+	all := []client.ServiceExposeMap{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.ServiceExposeMap.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listServiceConsumeMaps(filters map[string]interface{}) ([]client.ServiceConsumeMap, error) {
+	all := []client.ServiceConsumeMap{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.ServiceConsumeMap.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listCertificates(filters map[string]interface{}) ([]client.Certificate, error) {
+	all := []client.Certificate{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.Certificate.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listRegistries(filters map[string]interface{}) ([]client.Registry, error) {
+	all := []client.Registry{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.Registry.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
+
+func (cli *Client) listRegistryCredentials(filters map[string]interface{}) ([]client.RegistryCredential, error) {
+	all := []client.RegistryCredential{}
+	err := eachPage(filters, func(opts *client.ListOpts) (*client.Collection, error) {
+		page, err := cli.RancherClient.RegistryCredential.List(opts)
+		if err != nil || page == nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		return &page.Collection, nil
+	})
+	return all, err
+}
@@ -0,0 +1,121 @@
+package rancher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+// pagedServer serves the services in pages of the requested limit, linking
+// every page but the last to the next one.
+type pagedServer struct {
+	*httptest.Server
+	Services []string
+	Queries  []string
+	// Always link to the first page
+	Loop bool
+}
+
+func newPagedServer(services []string) *pagedServer {
+	srv := &pagedServer{Services: services}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-API-Schemas", srv.URL+"/schemas")
+		fmt.Fprint(w, "{}")
+	})
+	mux.HandleFunc("/schemas", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(client.Schemas{
+			Data: []client.Schema{
+				{
+					Resource:          client.Resource{Id: "service", Links: map[string]string{"collection": srv.URL + "/services"}},
+					CollectionMethods: []string{"GET"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/services", srv.services)
+	srv.Server = httptest.NewServer(mux)
+	return srv
+}
+
+func (srv *pagedServer) services(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	srv.Queries = append(srv.Queries, r.URL.RawQuery)
+
+	limit, start := 0, 0
+	fmt.Sscan(query.Get("limit"), &limit)
+	fmt.Sscan(query.Get("marker"), &start)
+	end := start + limit
+	if end > len(srv.Services) {
+		end = len(srv.Services)
+	}
+
+	page := client.ServiceCollection{}
+	for _, name := range srv.Services[start:end] {
+		page.Data = append(page.Data, client.Service{Name: name})
+	}
+	if end < len(srv.Services) {
+		if srv.Loop {
+			end = 0
+		}
+		query.Set("marker", fmt.Sprint(end))
+		page.Pagination = &client.Pagination{Next: srv.URL + "/services?" + query.Encode()}
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func pagedClient(t *testing.T, srv *pagedServer) *Client {
+	apiClient, err := client.NewRancherClient(&client.ClientOpts{Url: srv.URL})
+	if err != nil {
+		t.Fatalf("creating the client failed: %v", err)
+	}
+	return &Client{RancherClient: apiClient}
+}
+
+func TestListFollowsPagination(t *testing.T) {
+	pageLimit = 2
+	defer func() { pageLimit = 100 }()
+
+	srv := newPagedServer([]string{"api-1", "api-2", "api-3", "api-4", "api-5"})
+	defer srv.Close()
+
+	services, err := pagedClient(t, srv).ServiceLikeName("api")
+	if err != nil {
+		t.Fatalf("listing the services failed: %v", err)
+	}
+
+	names := []string{}
+	for _, service := range services.Data {
+		names = append(names, service.Name)
+	}
+	if !reflect.DeepEqual(names, srv.Services) {
+		t.Errorf("expected services from every page %v, received %v", srv.Services, names)
+	}
+
+	expected := []string{
+		"kind=service&limit=2&name_like=api%25",
+		"kind=service&limit=2&marker=2&name_like=api%25",
+		"kind=service&limit=2&marker=4&name_like=api%25",
+	}
+	if !reflect.DeepEqual(srv.Queries, expected) {
+		t.Errorf("expected the filters and limit on every page %v, received %v", expected, srv.Queries)
+	}
+}
+
+func TestListStopsOnRepeatedPage(t *testing.T) {
+	pageLimit = 2
+	defer func() { pageLimit = 100 }()
+
+	srv := newPagedServer([]string{"api-1", "api-2", "api-3"})
+	srv.Loop = true
+	defer srv.Close()
+
+	if _, err := pagedClient(t, srv).ServiceByName("api-1"); err == nil {
+		t.Errorf("expected a next link pointing back to a previous page to fail")
+	}
+}
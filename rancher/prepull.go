@@ -0,0 +1,211 @@
+package rancher
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	PULL_TASK_MODE_ALL = "all"
+)
+
+var (
+	pullTaskPollInterval = 2 * time.Second
+)
+
+// Pull the images onto the hosts running the service and wait until every pull
+// task has completed.  Pull tasks select hosts by label so the labels shared
+// by all of the service's hosts are used when they select no other host,
+// otherwise every host is selected by its own labels.  Pulling fails when a
+// host of the service can not be told apart from the other hosts by its
+// labels.  A service without containers pulls onto every host.
+func (cli *Client) PrepullServiceImages(service *client.Service, images []string, timeout time.Duration) error {
+	hosts, err := cli.ServiceHosts(service)
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to find hosts running service")
+	}
+
+	if len(hosts) == 0 {
+		return cli.PrepullImages(images, map[string]interface{}{}, timeout)
+	}
+
+	all, err := cli.listHosts(map[string]interface{}{})
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to list hosts")
+	}
+
+	selectors, err := hostSelectors(hosts, all)
+
+	if err != nil {
+		return err
+	}
+
+	return cli.prepullImages(images, selectors, timeout)
+}
+
+// Create a pull task for each image on the hosts matching the labels and wait
+// until all of them have completed.
+func (cli *Client) PrepullImages(images []string, labels map[string]interface{}, timeout time.Duration) error {
+	return cli.prepullImages(images, []map[string]interface{}{labels}, timeout)
+}
+
+func (cli *Client) prepullImages(images []string, selectors []map[string]interface{}, timeout time.Duration) error {
+	tasks := []*client.PullTask{}
+
+	for _, image := range images {
+		if !strings.HasPrefix(image, "docker:") {
+			image = "docker:" + image
+		}
+
+		for _, labels := range selectors {
+			log.Debugf("Pulling %s onto hosts with labels %v", image, labels)
+			task, err := cli.RancherClient.PullTask.Create(&client.PullTask{
+				Image:  image,
+				Labels: labels,
+				Mode:   PULL_TASK_MODE_ALL,
+			})
+
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create pull task for %s", image)
+			}
+
+			tasks = append(tasks, task)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for _, task := range tasks {
+		if err := cli.waitForPullTask(task, deadline); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cli *Client) waitForPullTask(task *client.PullTask, deadline time.Time) error {
+	for {
+		switch task.Transitioning {
+		case "error":
+			return fmt.Errorf("pulling %s failed: %s", task.Image, task.TransitioningMessage)
+		case "yes":
+		default:
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("pulling %s timed out", task.Image)
+		}
+
+		time.Sleep(pullTaskPollInterval)
+
+		reloaded, err := cli.RancherClient.PullTask.ById(task.Id)
+
+		if err != nil {
+			return err
+		}
+		task = reloaded
+	}
+}
+
+// Return the label selectors that together match exactly the hosts out of
+// all hosts.  The labels the hosts have in common are used when they match no
+// other host, otherwise each host is selected by all of its labels.
+func hostSelectors(hosts, all []client.Host) ([]map[string]interface{}, error) {
+	targets := make(map[string]bool)
+	for _, host := range hosts {
+		targets[host.Id] = true
+	}
+
+	selects := func(labels map[string]interface{}) bool {
+		if len(labels) == 0 {
+			return false
+		}
+		for _, host := range all {
+			if !targets[host.Id] && hasLabels(host, labels) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if common := commonHostLabels(hosts); selects(common) {
+		return []map[string]interface{}{common}, nil
+	}
+
+	selectors := []map[string]interface{}{}
+	for _, host := range hosts {
+		if !selects(host.Labels) {
+			return nil, fmt.Errorf("host %s can not be told apart from hosts not running the service by its labels", hostName(host))
+		}
+		selectors = append(selectors, host.Labels)
+	}
+	return selectors, nil
+}
+
+func hasLabels(host client.Host, labels map[string]interface{}) bool {
+	for key, value := range labels {
+		if host.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func hostName(host client.Host) string {
+	if host.Hostname != "" {
+		return host.Hostname
+	}
+	return host.Id
+}
+
+// Return the labels every host has in common.
+func commonHostLabels(hosts []client.Host) map[string]interface{} {
+	labels := make(map[string]interface{})
+
+	if len(hosts) == 0 {
+		return labels
+	}
+
+	for key, value := range hosts[0].Labels {
+		labels[key] = value
+	}
+
+	for _, host := range hosts[1:] {
+		for key, value := range labels {
+			if host.Labels[key] != value {
+				delete(labels, key)
+			}
+		}
+	}
+
+	return labels
+}
+
+// Return the images of the upgraded launch configs that differ from the images the service runs today.
+func changedImages(previous []containerImage, upgrade *client.ServiceUpgrade) []string {
+	current := make(map[string]bool)
+	for _, container := range previous {
+		current[container.Image] = true
+	}
+
+	upgraded := serviceImages(&client.Service{
+		LaunchConfig:           upgrade.InServiceStrategy.LaunchConfig,
+		SecondaryLaunchConfigs: upgrade.InServiceStrategy.SecondaryLaunchConfigs,
+	})
+
+	images := []string{}
+	for _, container := range upgraded {
+		if !current[container.Image] {
+			images = append(images, container.Image)
+		}
+	}
+	return images
+}
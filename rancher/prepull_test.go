@@ -0,0 +1,184 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+func prepullClient(pullTasks *mocks.PullTaskOperations) *Client {
+	return &Client{
+		RancherClient: &client.RancherClient{
+			Service: &UpgradeServiceService{},
+			ServiceExposeMap: &mocks.ServiceExposeMapOperations{
+				Maps: []client.ServiceExposeMap{
+					{ServiceId: "1s1", InstanceId: "1i1"},
+					{ServiceId: "1s1", InstanceId: "1i2"},
+					{ServiceId: "1s2", InstanceId: "1i3"},
+				},
+			},
+			Container: &mocks.ContainerOperations{
+				Containers: []client.Container{
+					{Resource: client.Resource{Id: "1i1"}, HostId: "1h1"},
+					{Resource: client.Resource{Id: "1i2"}, HostId: "1h2"},
+					{Resource: client.Resource{Id: "1i3"}, HostId: "1h3"},
+				},
+			},
+			Host: &mocks.HostOperations{
+				Hosts: []client.Host{
+					{Resource: client.Resource{Id: "1h1"}, Labels: map[string]interface{}{"tier": "api", "zone": "a"}},
+					{Resource: client.Resource{Id: "1h2"}, Labels: map[string]interface{}{"tier": "api", "zone": "b"}},
+					{Resource: client.Resource{Id: "1h3"}, Labels: map[string]interface{}{"tier": "db"}},
+				},
+			},
+			PullTask: pullTasks,
+		},
+		Validators: []config.Validator{
+			&config.NoopValidator{},
+		},
+	}
+}
+
+func TestServiceHosts(t *testing.T) {
+	cli := prepullClient(&mocks.PullTaskOperations{})
+
+	hosts, err := cli.ServiceHosts(&client.Service{Resource: client.Resource{Id: "1s1"}})
+
+	if err != nil || len(hosts) != 2 || hosts[0].Id != "1h1" || hosts[1].Id != "1h2" {
+		t.Errorf("expected hosts 1h1 and 1h2 but received %v, %v", hosts, err)
+	}
+}
+
+func TestServiceHostsSkipsPurgedContainersAndHosts(t *testing.T) {
+	cli := prepullClient(&mocks.PullTaskOperations{})
+	exposeMaps := cli.RancherClient.ServiceExposeMap.(*mocks.ServiceExposeMapOperations)
+	exposeMaps.Maps = append(exposeMaps.Maps,
+		client.ServiceExposeMap{ServiceId: "1s1", InstanceId: "1i8"},
+		client.ServiceExposeMap{ServiceId: "1s1", InstanceId: "1i9"},
+	)
+	containers := cli.RancherClient.Container.(*mocks.ContainerOperations)
+	containers.Containers = append(containers.Containers, client.Container{Resource: client.Resource{Id: "1i9"}, HostId: "1h9"})
+
+	hosts, err := cli.ServiceHosts(&client.Service{Resource: client.Resource{Id: "1s1"}})
+
+	if err != nil || len(hosts) != 2 || hosts[0].Id != "1h1" || hosts[1].Id != "1h2" {
+		t.Errorf("expected the purged container 1i8 and host 1h9 to be skipped but received %v, %v", hosts, err)
+	}
+}
+
+func TestPrepullServiceImagesUsesCommonHostLabels(t *testing.T) {
+	orig := pullTaskPollInterval
+	pullTaskPollInterval = time.Millisecond
+	defer func() { pullTaskPollInterval = orig }()
+
+	pullTasks := &mocks.PullTaskOperations{}
+	cli := prepullClient(pullTasks)
+
+	err := cli.PrepullServiceImages(&client.Service{Resource: client.Resource{Id: "1s1"}}, []string{"nowait/api:2.0", "docker:nowait/code:2.0"}, time.Second)
+
+	if err != nil {
+		t.Fatalf("prepull failed with: %v", err)
+	}
+
+	if len(pullTasks.Created) != 2 {
+		t.Fatalf("expected a pull task per image but %d were created", len(pullTasks.Created))
+	}
+
+	expectedLabels := map[string]interface{}{"tier": "api"}
+	for index, image := range []string{"docker:nowait/api:2.0", "docker:nowait/code:2.0"} {
+		task := pullTasks.Created[index]
+		if task.Image != image || task.Mode != PULL_TASK_MODE_ALL || !reflect.DeepEqual(task.Labels, expectedLabels) {
+			t.Errorf("unexpected pull task %#v", task)
+		}
+	}
+}
+
+func TestHostSelectors(t *testing.T) {
+	api := client.Host{Resource: client.Resource{Id: "1h1"}, Labels: map[string]interface{}{"tier": "api", "zone": "a"}}
+	web := client.Host{Resource: client.Resource{Id: "1h2"}, Labels: map[string]interface{}{"tier": "web", "zone": "b"}}
+	db := client.Host{Resource: client.Resource{Id: "1h3"}, Labels: map[string]interface{}{"tier": "db", "zone": "a"}}
+	apiB := client.Host{Resource: client.Resource{Id: "1h4"}, Labels: map[string]interface{}{"tier": "api", "zone": "b"}}
+	bare := client.Host{Resource: client.Resource{Id: "1h5"}, Hostname: "bare"}
+
+	tests := []struct {
+		Hosts     []client.Host
+		All       []client.Host
+		Selectors []map[string]interface{}
+		Error     string
+	}{
+		{
+			Hosts:     []client.Host{api, apiB},
+			All:       []client.Host{api, web, db, apiB},
+			Selectors: []map[string]interface{}{{"tier": "api"}},
+		},
+		{
+			Hosts:     []client.Host{api, web},
+			All:       []client.Host{api, web, db, apiB},
+			Selectors: []map[string]interface{}{api.Labels, web.Labels},
+		},
+		{
+			Hosts: []client.Host{api, bare},
+			All:   []client.Host{api, web, bare},
+			Error: "host bare can not be told apart from hosts not running the service by its labels",
+		},
+	}
+
+	for index, test := range tests {
+		selectors, err := hostSelectors(test.Hosts, test.All)
+
+		if test.Error != "" {
+			if err == nil || err.Error() != test.Error {
+				t.Errorf("test case %d: expected error %q but received %v", index, test.Error, err)
+			}
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(selectors, test.Selectors) {
+			t.Errorf("test case %d: expected selectors %v but received %v, %v", index, test.Selectors, selectors, err)
+		}
+	}
+}
+
+func TestPrepullImagesFailsWhenPullFails(t *testing.T) {
+	orig := pullTaskPollInterval
+	pullTaskPollInterval = time.Millisecond
+	defer func() { pullTaskPollInterval = orig }()
+
+	cli := prepullClient(&mocks.PullTaskOperations{
+		FailImage: "docker:nowait/api:3.0",
+	})
+
+	err := cli.PrepullImages([]string{"nowait/api:3.0"}, map[string]interface{}{}, time.Second)
+
+	if err == nil || err.Error() != "pulling docker:nowait/api:3.0 failed: image not found" {
+		t.Errorf("expected pull failure but received %v", err)
+	}
+}
+
+func TestUpgradeServicePrepullsChangedImages(t *testing.T) {
+	orig := pullTaskPollInterval
+	pullTaskPollInterval = time.Millisecond
+	defer func() { pullTaskPollInterval = orig }()
+
+	pullTasks := &mocks.PullTaskOperations{}
+	cli := prepullClient(pullTasks)
+
+	_, err := cli.UpgradeService(config.UpgradeOpts{
+		Service:        serviceName,
+		RuntimeTag:     codeTag,
+		Prepull:        true,
+		PrepullTimeout: time.Second,
+	})
+
+	if err != nil {
+		t.Fatalf("upgrade failed with: %v", err)
+	}
+
+	if len(pullTasks.Created) != 1 || pullTasks.Created[0].Image != "docker:"+codeTag {
+		t.Errorf("only the upgraded runtime image should have been pulled, pulled %v", pullTasks.Created)
+	}
+}
@@ -0,0 +1,289 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	ORCHESTRATION_CATTLE     = "cattle"
+	ORCHESTRATION_KUBERNETES = "kubernetes"
+	ORCHESTRATION_MESOS      = "mesos"
+	ORCHESTRATION_SWARM      = "swarm"
+
+	MEMBER_ROLE_OWNER      = "owner"
+	MEMBER_ROLE_MEMBER     = "member"
+	MEMBER_ROLE_READONLY   = "readonly"
+	MEMBER_ROLE_RESTRICTED = "restricted"
+)
+
+var (
+	projectPollInterval = 2 * time.Second
+)
+
+// ProjectSummary describes a project as listed by env list.
+type ProjectSummary struct {
+	Id            string `json:"id"`
+	Name          string `json:"name"`
+	Orchestration string `json:"orchestration"`
+	State         string `json:"state"`
+	Stacks        int    `json:"stacks"`
+}
+
+// ProjectMember is a user or group with access to a project.
+type ProjectMember struct {
+	ExternalId     string `json:"externalId"`
+	ExternalIdType string `json:"externalIdType"`
+	Role           string `json:"role"`
+	Name           string `json:"name,omitempty"`
+}
+
+// ListProjects lists the projects with the number of stacks in each, ordered
+// by name.
+func (cli *Client) ListProjects() ([]ProjectSummary, error) {
+	projects, err := cli.listProjects(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stacks, err := cli.listStacks(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list stacks")
+	}
+
+	counts := make(map[string]int)
+	for _, stack := range stacks {
+		if stack.State != "removed" && stack.State != "purged" {
+			counts[stack.AccountId]++
+		}
+	}
+
+	summaries := []ProjectSummary{}
+	for _, project := range projects {
+		summaries = append(summaries, ProjectSummary{
+			Id:            project.Id,
+			Name:          project.Name,
+			Orchestration: Orchestration(&project),
+			State:         project.State,
+			Stacks:        counts[project.Id],
+		})
+	}
+	sort.Sort(summariesByName(summaries))
+	return summaries, nil
+}
+
+// Orchestration returns the container orchestration the project uses.
+func Orchestration(project *client.Project) string {
+	switch {
+	case project.Kubernetes:
+		return ORCHESTRATION_KUBERNETES
+	case project.Mesos:
+		return ORCHESTRATION_MESOS
+	case project.Swarm:
+		return ORCHESTRATION_SWARM
+	}
+	return ORCHESTRATION_CATTLE
+}
+
+// CreateProject creates a project with the given orchestration and waits up
+// to timeout for it to become active.
+func (cli *Client) CreateProject(name, description, orchestration string, timeout time.Duration) (*client.Project, error) {
+	if _, err := cli.ProjectByName(name); err == nil {
+		return nil, fmt.Errorf("environment %s already exists", name)
+	}
+
+	project := &client.Project{
+		Name:        name,
+		Description: description,
+	}
+	switch orchestration {
+	case ORCHESTRATION_CATTLE, "":
+	case ORCHESTRATION_KUBERNETES:
+		project.Kubernetes = true
+	case ORCHESTRATION_MESOS:
+		project.Mesos = true
+	case ORCHESTRATION_SWARM:
+		project.Swarm = true
+	default:
+		return nil, fmt.Errorf("invalid orchestration %s, expected one of %s, %s, %s or %s", orchestration,
+			ORCHESTRATION_CATTLE, ORCHESTRATION_KUBERNETES, ORCHESTRATION_MESOS, ORCHESTRATION_SWARM)
+	}
+
+	created, err := cli.RancherClient.Project.Create(project)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create environment %s", name)
+	}
+
+	return cli.waitProjectActive(created, timeout)
+}
+
+func (cli *Client) waitProjectActive(project *client.Project, timeout time.Duration) (*client.Project, error) {
+	deadline := time.Now().Add(timeout)
+
+	for project.State != "active" {
+		if time.Now().After(deadline) {
+			return project, fmt.Errorf("environment %s is still %s", project.Name, project.State)
+		}
+		time.Sleep(projectPollInterval)
+
+		current, err := cli.RancherClient.Project.ById(project.Id)
+		if err != nil {
+			return project, err
+		}
+		if current == nil {
+			return project, fmt.Errorf("environment %s was removed while it was created", project.Name)
+		}
+		project = current
+	}
+	return project, nil
+}
+
+// DeactivateProject deactivates the project, stopping its stacks.
+func (cli *Client) DeactivateProject(project *client.Project) error {
+	_, err := cli.RancherClient.Project.ActionDeactivate(project)
+	return errors.Wrapf(err, "Failed to deactivate environment %s", project.Name)
+}
+
+// RemoveProject removes the project, deactivating it first when it is active
+// since Rancher only removes inactive projects.
+func (cli *Client) RemoveProject(project *client.Project) error {
+	if project.State == "active" {
+		if err := cli.DeactivateProject(project); err != nil {
+			return err
+		}
+	}
+
+	_, err := cli.RancherClient.Project.ActionRemove(project)
+	return errors.Wrapf(err, "Failed to remove environment %s", project.Name)
+}
+
+// ProjectMembers lists the members of the project.
+func (cli *Client) ProjectMembers(projectId string) ([]ProjectMember, error) {
+	filters := make(map[string]interface{})
+	filters["projectId"] = projectId
+	members, err := cli.listProjectMembers(filters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ProjectMember{}
+	for _, member := range members {
+		if member.State == "removed" || member.State == "purged" {
+			continue
+		}
+		result = append(result, ProjectMember{
+			ExternalId:     member.ExternalId,
+			ExternalIdType: member.ExternalIdType,
+			Role:           member.Role,
+			Name:           member.Name,
+		})
+	}
+	return result, nil
+}
+
+// AddProjectMember adds the member to the project, or changes its role when
+// it already is a member.  Rancher replaces the members of a project as a
+// whole so the existing members are sent along.  Demoting the last owner is
+// refused since nobody could manage the project afterwards.
+func (cli *Client) AddProjectMember(project *client.Project, member ProjectMember) error {
+	if err := validateRole(member.Role); err != nil {
+		return err
+	}
+
+	members, err := cli.ProjectMembers(project.Id)
+	if err != nil {
+		return err
+	}
+
+	updated := []ProjectMember{}
+	for _, existing := range members {
+		if !sameMember(existing, member) {
+			updated = append(updated, existing)
+		}
+	}
+	updated = append(updated, member)
+
+	if countOwners(members) > 0 && countOwners(updated) == 0 {
+		return fmt.Errorf("refusing to demote the last owner of environment %s", project.Name)
+	}
+
+	return cli.setProjectMembers(project, updated)
+}
+
+// RemoveProjectMember removes the member from the project.  Removing the
+// last owner is refused since nobody could manage the project afterwards.
+func (cli *Client) RemoveProjectMember(project *client.Project, member ProjectMember) error {
+	members, err := cli.ProjectMembers(project.Id)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	updated := []ProjectMember{}
+	for _, existing := range members {
+		if sameMember(existing, member) {
+			found = true
+			continue
+		}
+		updated = append(updated, existing)
+	}
+
+	if !found {
+		return fmt.Errorf("%s %s is not a member of environment %s", member.ExternalIdType, member.ExternalId, project.Name)
+	}
+	if countOwners(members) > 0 && countOwners(updated) == 0 {
+		return fmt.Errorf("refusing to remove the last owner of environment %s", project.Name)
+	}
+
+	return cli.setProjectMembers(project, updated)
+}
+
+func (cli *Client) setProjectMembers(project *client.Project, members []ProjectMember) error {
+	input := &client.SetProjectMembersInput{
+		Members: []interface{}{},
+	}
+	for _, member := range members {
+		input.Members = append(input.Members, map[string]interface{}{
+			"externalId":     member.ExternalId,
+			"externalIdType": member.ExternalIdType,
+			"role":           member.Role,
+		})
+	}
+
+	_, err := cli.RancherClient.Project.ActionSetmembers(project, input)
+	return errors.Wrapf(err, "Failed to set the members of environment %s", project.Name)
+}
+
+func countOwners(members []ProjectMember) int {
+	owners := 0
+	for _, member := range members {
+		if member.Role == MEMBER_ROLE_OWNER {
+			owners++
+		}
+	}
+	return owners
+}
+
+func sameMember(a, b ProjectMember) bool {
+	return a.ExternalId == b.ExternalId && a.ExternalIdType == b.ExternalIdType
+}
+
+func validateRole(role string) error {
+	switch role {
+	case MEMBER_ROLE_OWNER, MEMBER_ROLE_MEMBER, MEMBER_ROLE_READONLY, MEMBER_ROLE_RESTRICTED:
+		return nil
+	}
+	return fmt.Errorf("invalid role %s, expected one of %s, %s, %s or %s", role,
+		MEMBER_ROLE_OWNER, MEMBER_ROLE_MEMBER, MEMBER_ROLE_READONLY, MEMBER_ROLE_RESTRICTED)
+}
+
+type summariesByName []ProjectSummary
+
+func (s summariesByName) Len() int           { return len(s) }
+func (s summariesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s summariesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
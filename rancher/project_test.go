@@ -0,0 +1,240 @@
+package rancher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+// ManagedProjects records the actions taken on projects.  Created projects
+// become active on the first reload.
+type ManagedProjects struct {
+	mocks.SuccessfulProjectOperations
+	Created     *client.Project
+	Deactivated []string
+	Removed     []string
+	Members     []interface{}
+}
+
+func (proj *ManagedProjects) Create(opts *client.Project) (*client.Project, error) {
+	proj.Created = opts
+	created := *opts
+	created.Id = "1a10"
+	created.State = "registering"
+	return &created, nil
+}
+
+func (proj *ManagedProjects) ById(id string) (*client.Project, error) {
+	active := *proj.Created
+	active.Id = id
+	active.State = "active"
+	return &active, nil
+}
+
+func (proj *ManagedProjects) ActionDeactivate(project *client.Project) (*client.Account, error) {
+	proj.Deactivated = append(proj.Deactivated, project.Name)
+	return &client.Account{}, nil
+}
+
+func (proj *ManagedProjects) ActionRemove(project *client.Project) (*client.Account, error) {
+	proj.Removed = append(proj.Removed, project.Name)
+	return &client.Account{}, nil
+}
+
+func (proj *ManagedProjects) ActionSetmembers(project *client.Project, input *client.SetProjectMembersInput) (*client.SetProjectMembersInput, error) {
+	proj.Members = input.Members
+	return input, nil
+}
+
+type Members struct {
+	client.ProjectMemberOperations
+}
+
+func (members *Members) List(opts *client.ListOpts) (*client.ProjectMemberCollection, error) {
+	// The second project has lost its owners
+	if opts.Filters["projectId"] == mocks.ProjectTwo.Id {
+		return &client.ProjectMemberCollection{
+			Data: []client.ProjectMember{
+				{ExternalId: "2", ExternalIdType: "github_user", Role: MEMBER_ROLE_MEMBER, Name: "bob"},
+				{ExternalId: "4", ExternalIdType: "github_user", Role: MEMBER_ROLE_READONLY, Name: "carol"},
+			},
+		}, nil
+	}
+	return &client.ProjectMemberCollection{
+		Data: []client.ProjectMember{
+			{ExternalId: "1", ExternalIdType: "github_user", Role: MEMBER_ROLE_OWNER, Name: "alice"},
+			{ExternalId: "2", ExternalIdType: "github_user", Role: MEMBER_ROLE_MEMBER, Name: "bob"},
+			{ExternalId: "3", ExternalIdType: "github_user", Role: MEMBER_ROLE_MEMBER, State: "removed"},
+		},
+	}, nil
+}
+
+func projectClient() (*Client, *ManagedProjects) {
+	projectPollInterval = time.Millisecond
+	projects := &ManagedProjects{}
+	envs := &CloneEnvironments{
+		Stacks: map[string][]client.Environment{
+			"": {
+				{AccountId: mocks.ProjectOne.Id, Name: "api"},
+				{AccountId: mocks.ProjectOne.Id, Name: "web"},
+				{AccountId: mocks.ProjectTwo.Id, Name: "web"},
+				{AccountId: mocks.ProjectTwo.Id, Name: "old", State: "removed"},
+			},
+		},
+	}
+	return &Client{
+		RancherClient: &client.RancherClient{
+			Environment:   envs,
+			Project:       projects,
+			ProjectMember: &Members{},
+		},
+	}, projects
+}
+
+func TestListProjects(t *testing.T) {
+	cli, _ := projectClient()
+
+	summaries, err := cli.ListProjects()
+	if err != nil {
+		t.Fatalf("listing projects failed: %v", err)
+	}
+
+	expected := []ProjectSummary{
+		{Id: mocks.ProjectOne.Id, Name: mocks.ProjectOneName, Orchestration: ORCHESTRATION_CATTLE, Stacks: 2},
+		{Id: mocks.ProjectTwo.Id, Name: mocks.ProjectTwoName, Orchestration: ORCHESTRATION_CATTLE, Stacks: 1},
+	}
+	if !reflect.DeepEqual(summaries, expected) {
+		t.Errorf("expected projects %+v, received %+v", expected, summaries)
+	}
+}
+
+func TestCreateProject(t *testing.T) {
+	cli, projects := projectClient()
+
+	project, err := cli.CreateProject("three", "", ORCHESTRATION_KUBERNETES, time.Second)
+	if err != nil {
+		t.Fatalf("creating the project failed: %v", err)
+	}
+	if !projects.Created.Kubernetes || project.State != "active" || project.Id != "1a10" {
+		t.Errorf("expected an active kubernetes project, received %+v", project)
+	}
+
+	if _, err := cli.CreateProject(mocks.ProjectOneName, "", ORCHESTRATION_CATTLE, time.Second); err == nil {
+		t.Errorf("expected creating an existing project to fail")
+	}
+	if _, err := cli.CreateProject("four", "", "nomad", time.Second); err == nil {
+		t.Errorf("expected an invalid orchestration to fail")
+	}
+}
+
+func TestRemoveProjectDeactivatesActiveProjects(t *testing.T) {
+	cli, projects := projectClient()
+
+	if err := cli.RemoveProject(&client.Project{Name: "active", State: "active"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.RemoveProject(&client.Project{Name: "inactive", State: "inactive"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(projects.Deactivated, []string{"active"}) || !reflect.DeepEqual(projects.Removed, []string{"active", "inactive"}) {
+		t.Errorf("expected only the active project to be deactivated, deactivated %v removed %v", projects.Deactivated, projects.Removed)
+	}
+}
+
+func TestProjectMembers(t *testing.T) {
+	member := func(id, role string) map[string]interface{} {
+		return map[string]interface{}{"externalId": id, "externalIdType": "github_user", "role": role}
+	}
+
+	tests := []struct {
+		Description string
+		Project     *client.Project
+		Add         bool
+		Member      ProjectMember
+		Members     []interface{}
+		Error       string
+	}{
+		{
+			Description: "Adding a member keeps the existing members",
+			Add:         true,
+			Member:      ProjectMember{ExternalId: "4", ExternalIdType: "github_user", Role: MEMBER_ROLE_READONLY},
+			Members:     []interface{}{member("1", MEMBER_ROLE_OWNER), member("2", MEMBER_ROLE_MEMBER), member("4", MEMBER_ROLE_READONLY)},
+		},
+		{
+			Description: "Adding an existing member changes its role",
+			Add:         true,
+			Member:      ProjectMember{ExternalId: "2", ExternalIdType: "github_user", Role: MEMBER_ROLE_OWNER},
+			Members:     []interface{}{member("1", MEMBER_ROLE_OWNER), member("2", MEMBER_ROLE_OWNER)},
+		},
+		{
+			Description: "Invalid role",
+			Add:         true,
+			Member:      ProjectMember{ExternalId: "4", ExternalIdType: "github_user", Role: "admin"},
+			Error:       "invalid role admin",
+		},
+		{
+			Description: "Removing a member",
+			Member:      ProjectMember{ExternalId: "2", ExternalIdType: "github_user"},
+			Members:     []interface{}{member("1", MEMBER_ROLE_OWNER)},
+		},
+		{
+			Description: "Removing the last owner",
+			Member:      ProjectMember{ExternalId: "1", ExternalIdType: "github_user"},
+			Error:       "last owner",
+		},
+		{
+			Description: "Demoting the last owner",
+			Add:         true,
+			Member:      ProjectMember{ExternalId: "1", ExternalIdType: "github_user", Role: MEMBER_ROLE_MEMBER},
+			Error:       "last owner",
+		},
+		{
+			Description: "Removing a member of a project without owners",
+			Project:     mocks.ProjectTwo,
+			Member:      ProjectMember{ExternalId: "4", ExternalIdType: "github_user"},
+			Members:     []interface{}{member("2", MEMBER_ROLE_MEMBER)},
+		},
+		{
+			Description: "Removing a member that was removed before",
+			Member:      ProjectMember{ExternalId: "3", ExternalIdType: "github_user"},
+			Error:       "is not a member",
+		},
+	}
+
+	for _, test := range tests {
+		cli, projects := projectClient()
+		project := test.Project
+		if project == nil {
+			project = mocks.ProjectOne
+		}
+
+		var err error
+		if test.Add {
+			err = cli.AddProjectMember(project, test.Member)
+		} else {
+			err = cli.RemoveProjectMember(project, test.Member)
+		}
+
+		if test.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Error) {
+				t.Errorf("%s: expected error %s, received %v", test.Description, test.Error, err)
+			}
+			if projects.Members != nil {
+				t.Errorf("%s: expected the members not to change", test.Description)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.Description, err)
+		}
+		if !reflect.DeepEqual(projects.Members, test.Members) {
+			t.Errorf("%s: expected members %v, received %v", test.Description, test.Members, projects.Members)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/rancher/go-rancher/client"
+)
+
+// MissingReference is something a cloned stack refers to by name that does
+// not exist in the target project.
+type MissingReference struct {
+	Stack string `json:"stack"`
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+}
+
+func (ref MissingReference) String() string {
+	return fmt.Sprintf("stack %s uses %s %s", ref.Stack, ref.Kind, ref.Name)
+}
+
+// MissingCloneReferences returns the certificates, registries and external
+// links used by the stacks of the plan that do not exist in the target
+// project.  Certificates and registries are matched by name and server
+// address since their secrets can not be copied between servers.
+func (cli *Client) MissingCloneReferences(target *Client, plan *ClonePlan) ([]MissingReference, error) {
+	filters := map[string]interface{}{
+		"accountId": plan.TargetProjectId,
+	}
+
+	certs, err := target.listCertificates(filters)
+	if err != nil {
+		return nil, err
+	}
+	certNames := make(map[string]bool)
+	for _, cert := range certs {
+		certNames[cert.Name] = true
+	}
+
+	registryHosts, err := target.credentialedRegistries(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	targetServices, err := target.projectServiceNames(plan.TargetProjectId)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]*compose.Config)
+	for _, stack := range plan.Stacks {
+		if stack.Action == CLONE_ACTION_SKIP {
+			continue
+		}
+
+		_, rewritten, err := cli.StackCompose(plan, stack)
+		if err != nil {
+			return nil, err
+		}
+
+		if configs[stack.Name], err = compose.Parse(rewritten); err != nil {
+			return nil, fmt.Errorf("stack %s: %v", stack.Source.Name, err)
+		}
+
+		// Links between the cloned stacks resolve once they are created
+		for _, service := range configs[stack.Name].ServiceNames() {
+			targetServices[stack.Name+"/"+service] = true
+		}
+	}
+
+	missing := []MissingReference{}
+	for _, stack := range plan.Stacks {
+		cfg, ok := configs[stack.Name]
+		if !ok {
+			continue
+		}
+
+		for _, cert := range cfg.Certificates() {
+			if !certNames[cert] {
+				missing = append(missing, MissingReference{stack.Name, "certificate", cert})
+			}
+		}
+
+		for _, image := range cfg.Images() {
+			host := config.ImageRegistryHost(image)
+			if host != config.DOCKER_HUB_HOST && !registryHosts[host] {
+				missing = append(missing, MissingReference{stack.Name, "registry", host})
+			}
+		}
+
+		for _, link := range cfg.ExternalLinks() {
+			if !targetServices[link] {
+				missing = append(missing, MissingReference{stack.Name, "external service", link})
+			}
+		}
+	}
+
+	return uniqueReferences(missing), nil
+}
+
+// CarryCloneReferences creates the missing registries and certificates in the
+// target project and returns the references that are still missing.  Rancher
+// never returns the secret of a registry credential, so a registry is created
+// with the credentials returned for its host, typically those of the cli config.
+// Certificates are copied from the source project when the source server
+// returns their private key.
+func (cli *Client) CarryCloneReferences(target *Client, plan *ClonePlan, missing []MissingReference, credentials func(host string) config.RegistryCredentials) ([]MissingReference, error) {
+	var sourceCerts map[string]client.Certificate
+	carried := make(map[string]bool)
+	remaining := []MissingReference{}
+
+	for _, ref := range missing {
+		key := ref.Kind + "/" + ref.Name
+		if carried[key] {
+			continue
+		}
+
+		switch ref.Kind {
+		case "registry":
+			creds := credentials(ref.Name)
+			if creds.Username == "" {
+				break
+			}
+			if err := target.createRegistry(plan.TargetProjectId, ref.Name, creds); err != nil {
+				return nil, err
+			}
+			log.Infof("Created registry %s in the target environment", ref.Name)
+			carried[key] = true
+			continue
+		case "certificate":
+			if sourceCerts == nil {
+				var err error
+				if sourceCerts, err = cli.projectCertificates(plan.SourceProjectId); err != nil {
+					return nil, err
+				}
+			}
+			cert, ok := sourceCerts[ref.Name]
+			if !ok || cert.Key == "" {
+				break
+			}
+			if _, err := target.RancherClient.Certificate.Create(&client.Certificate{
+				AccountId:   plan.TargetProjectId,
+				Name:        cert.Name,
+				Description: cert.Description,
+				Cert:        cert.Cert,
+				CertChain:   cert.CertChain,
+				Key:         cert.Key,
+			}); err != nil {
+				return nil, fmt.Errorf("Failed to copy certificate %s: %v", ref.Name, err)
+			}
+			log.Infof("Copied certificate %s to the target environment", ref.Name)
+			carried[key] = true
+			continue
+		}
+
+		remaining = append(remaining, ref)
+	}
+
+	return remaining, nil
+}
+
+// Create a registry with credentials in the project, reusing the registry
+// when the project has one for the host without credentials.
+func (cli *Client) createRegistry(projectId, host string, creds config.RegistryCredentials) error {
+	registries, err := cli.listRegistries(map[string]interface{}{"accountId": projectId})
+	if err != nil {
+		return err
+	}
+
+	var registry *client.Registry
+	for i := range registries {
+		if registries[i].ServerAddress == host {
+			registry = &registries[i]
+		}
+	}
+
+	if registry == nil {
+		if registry, err = cli.RancherClient.Registry.Create(&client.Registry{
+			AccountId:     projectId,
+			Name:          host,
+			ServerAddress: host,
+		}); err != nil {
+			return fmt.Errorf("Failed to create registry %s: %v", host, err)
+		}
+	}
+
+	if _, err := cli.RancherClient.RegistryCredential.Create(&client.RegistryCredential{
+		AccountId:   projectId,
+		RegistryId:  registry.Id,
+		PublicValue: creds.Username,
+		SecretValue: creds.Password,
+	}); err != nil {
+		return fmt.Errorf("Failed to create the credentials of registry %s: %v", host, err)
+	}
+	return nil
+}
+
+// Return the certificates of the project by name.
+func (cli *Client) projectCertificates(projectId string) (map[string]client.Certificate, error) {
+	certs, err := cli.listCertificates(map[string]interface{}{"accountId": projectId})
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]client.Certificate)
+	for _, cert := range certs {
+		byName[cert.Name] = cert
+	}
+	return byName, nil
+}
+
+// Return the server addresses of the registries with credentials.
+func (cli *Client) credentialedRegistries(filters map[string]interface{}) (map[string]bool, error) {
+	registries, err := cli.listRegistries(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := cli.listRegistryCredentials(filters)
+	if err != nil {
+		return nil, err
+	}
+	credentialed := make(map[string]bool)
+	for _, cred := range creds {
+		if cred.State == "active" {
+			credentialed[cred.RegistryId] = true
+		}
+	}
+
+	hosts := make(map[string]bool)
+	for _, registry := range registries {
+		if credentialed[registry.Id] {
+			hosts[registry.ServerAddress] = true
+		}
+	}
+	return hosts, nil
+}
+
+// Return the services of the project as stack/service names.
+func (cli *Client) projectServiceNames(projectId string) (map[string]bool, error) {
+	stacks, err := cli.projectStacks(projectId)
+	if err != nil {
+		return nil, err
+	}
+	stackNames := make(map[string]string)
+	for name, stack := range stacks {
+		stackNames[stack.Id] = name
+	}
+
+	filters := map[string]interface{}{
+		"accountId": projectId,
+	}
+	services, err := cli.listServices(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, service := range services {
+		if stack, ok := stackNames[service.EnvironmentId]; ok && service.State != "removed" && service.State != "purged" {
+			names[stack+"/"+service.Name] = true
+		}
+	}
+	return names, nil
+}
+
+func uniqueReferences(refs []MissingReference) []MissingReference {
+	seen := make(map[string]bool)
+	unique := []MissingReference{}
+	for _, ref := range refs {
+		if key := ref.String(); !seen[key] {
+			seen[key] = true
+			unique = append(unique, ref)
+		}
+	}
+	sort.Sort(byReference(unique))
+	return unique
+}
+
+type byReference []MissingReference
+
+func (refs byReference) Len() int           { return len(refs) }
+func (refs byReference) Swap(i, j int)      { refs[i], refs[j] = refs[j], refs[i] }
+func (refs byReference) Less(i, j int) bool { return refs[i].String() < refs[j].String() }
+
+// FormatReferences joins the references for a single log line.
+func FormatReferences(refs []MissingReference) string {
+	lines := []string{}
+	for _, ref := range refs {
+		lines = append(lines, ref.String())
+	}
+	return strings.Join(lines, "; ")
+}
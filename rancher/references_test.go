@@ -0,0 +1,206 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+type ReferenceCertificates struct {
+	client.CertificateOperations
+}
+
+func (ops *ReferenceCertificates) List(opts *client.ListOpts) (*client.CertificateCollection, error) {
+	return &client.CertificateCollection{
+		Data: []client.Certificate{{Name: "example.com"}},
+	}, nil
+}
+
+type ReferenceRegistries struct {
+	client.RegistryOperations
+}
+
+func (ops *ReferenceRegistries) List(opts *client.ListOpts) (*client.RegistryCollection, error) {
+	return &client.RegistryCollection{
+		Data: []client.Registry{
+			{Resource: client.Resource{Id: "1sp1"}, ServerAddress: "registry.example.com"},
+			{Resource: client.Resource{Id: "1sp2"}, ServerAddress: "quay.io"},
+		},
+	}, nil
+}
+
+type ReferenceRegistryCredentials struct {
+	client.RegistryCredentialOperations
+}
+
+func (ops *ReferenceRegistryCredentials) List(opts *client.ListOpts) (*client.RegistryCredentialCollection, error) {
+	return &client.RegistryCredentialCollection{
+		Data: []client.RegistryCredential{{RegistryId: "1sp1", State: "active"}},
+	}, nil
+}
+
+type ReferenceServices struct {
+	client.ServiceOperations
+}
+
+func (ops *ReferenceServices) List(opts *client.ListOpts) (*client.ServiceCollection, error) {
+	return &client.ServiceCollection{
+		Data: []client.Service{
+			{Name: "db", EnvironmentId: "1e1", State: "active"},
+			{Name: "cache", EnvironmentId: "1e1", State: "removed"},
+		},
+	}, nil
+}
+
+func TestMissingCloneReferences(t *testing.T) {
+	cli, envs := cloneClient()
+	envs.Stacks[mocks.ProjectTwo.Id] = []client.Environment{
+		{Resource: client.Resource{Id: "1e1"}, Name: "database"},
+	}
+	envs.Compose = &client.ComposeConfig{
+		DockerComposeConfig: `version: '2'
+services:
+  api:
+    image: registry.example.com/nowait/api:1.0
+    external_links:
+    - database/db:db
+    - database/cache
+    - web/web
+  proxy:
+    image: quay.io/nowait/proxy:1.0
+  lb:
+    image: rancher/lb-service-haproxy
+`,
+		RancherComposeConfig: `version: '2'
+services:
+  lb:
+    lb_config:
+      default_cert: example.com
+      certs:
+      - staging.example.com
+`,
+	}
+	cli.RancherClient.Certificate = &ReferenceCertificates{}
+	cli.RancherClient.Registry = &ReferenceRegistries{}
+	cli.RancherClient.RegistryCredential = &ReferenceRegistryCredentials{}
+	cli.RancherClient.Service = &ReferenceServices{}
+
+	plan := &ClonePlan{
+		TargetProjectId: mocks.ProjectTwo.Id,
+		Stacks: []StackClone{
+			{Source: client.Environment{Name: "api"}, Name: "api", Action: CLONE_ACTION_CREATE},
+		},
+	}
+
+	missing, err := cli.MissingCloneReferences(cli, plan)
+	if err != nil {
+		t.Fatalf("finding missing references failed: %v", err)
+	}
+
+	expected := []MissingReference{
+		{"api", "certificate", "staging.example.com"},
+		{"api", "external service", "database/cache"},
+		{"api", "external service", "web/web"},
+		{"api", "registry", "quay.io"},
+	}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("expected missing references %v but received %v", expected, missing)
+	}
+}
+
+type CarriedCertificates struct {
+	client.CertificateOperations
+	Created []client.Certificate
+}
+
+func (ops *CarriedCertificates) List(opts *client.ListOpts) (*client.CertificateCollection, error) {
+	return &client.CertificateCollection{
+		Data: []client.Certificate{
+			{Name: "staging.example.com", Cert: "cert", Key: "key"},
+			{Name: "keyless.example.com", Cert: "cert"},
+		},
+	}, nil
+}
+
+func (ops *CarriedCertificates) Create(cert *client.Certificate) (*client.Certificate, error) {
+	ops.Created = append(ops.Created, *cert)
+	return cert, nil
+}
+
+type CarriedRegistries struct {
+	ReferenceRegistries
+	Created []client.Registry
+}
+
+func (ops *CarriedRegistries) Create(registry *client.Registry) (*client.Registry, error) {
+	ops.Created = append(ops.Created, *registry)
+	registry.Id = "1sp3"
+	return registry, nil
+}
+
+type CarriedRegistryCredentials struct {
+	ReferenceRegistryCredentials
+	Created []client.RegistryCredential
+}
+
+func (ops *CarriedRegistryCredentials) Create(cred *client.RegistryCredential) (*client.RegistryCredential, error) {
+	ops.Created = append(ops.Created, *cred)
+	return cred, nil
+}
+
+func TestCarryCloneReferences(t *testing.T) {
+	certs := &CarriedCertificates{}
+	registries := &CarriedRegistries{}
+	creds := &CarriedRegistryCredentials{}
+	cli := &Client{
+		RancherClient: &client.RancherClient{
+			Certificate:        certs,
+			Registry:           registries,
+			RegistryCredential: creds,
+		},
+	}
+
+	plan := &ClonePlan{SourceProjectId: mocks.ProjectOne.Id, TargetProjectId: mocks.ProjectTwo.Id}
+	missing := []MissingReference{
+		{"api", "certificate", "keyless.example.com"},
+		{"api", "certificate", "staging.example.com"},
+		{"api", "external service", "web/web"},
+		{"api", "registry", "gcr.io"},
+		{"api", "registry", "quay.io"},
+		{"web", "registry", "quay.io"},
+	}
+	credentials := func(host string) config.RegistryCredentials {
+		if host == "gcr.io" {
+			return config.RegistryCredentials{Url: "https://gcr.io"}
+		}
+		return config.RegistryCredentials{Url: "https://" + host, Username: "robot", Password: "token"}
+	}
+
+	remaining, err := cli.CarryCloneReferences(cli, plan, missing, credentials)
+	if err != nil {
+		t.Fatalf("carrying references failed: %v", err)
+	}
+
+	expected := []MissingReference{
+		{"api", "certificate", "keyless.example.com"},
+		{"api", "external service", "web/web"},
+		{"api", "registry", "gcr.io"},
+	}
+	if !reflect.DeepEqual(remaining, expected) {
+		t.Errorf("expected remaining references %v but received %v", expected, remaining)
+	}
+
+	if len(certs.Created) != 1 || certs.Created[0].Name != "staging.example.com" || certs.Created[0].Key != "key" || certs.Created[0].AccountId != mocks.ProjectTwo.Id {
+		t.Errorf("expected certificate staging.example.com to be copied, created %v", certs.Created)
+	}
+
+	if len(registries.Created) != 0 {
+		t.Errorf("the existing quay.io registry should have been reused, created %v", registries.Created)
+	}
+	if len(creds.Created) != 1 || creds.Created[0].RegistryId != "1sp2" || creds.Created[0].PublicValue != "robot" || creds.Created[0].SecretValue != "token" {
+		t.Errorf("expected credentials for registry quay.io, created %v", creds.Created)
+	}
+}
@@ -0,0 +1,276 @@
+package rancher
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	// Exit codes of failed deployments, other errors exit with 1
+	EXIT_VALIDATION = 2
+	EXIT_UPGRADE    = 3
+	EXIT_TIMEOUT    = 4
+	EXIT_ROLLBACK   = 5
+
+	REPORT_KIND_SERVICE = "service"
+	REPORT_KIND_STACK   = "stack"
+	// Command that failed before deploying anything
+	REPORT_KIND_COMMAND = "command"
+)
+
+// DeployError is a failed deployment of a kind with its own exit code.
+type DeployError struct {
+	Code int
+	Err  error
+}
+
+func (err *DeployError) Error() string {
+	return err.Err.Error()
+}
+
+// NewDeployError classifies the error as a failure of the kind of the code,
+// keeping the kind of errors that already have one.
+func NewDeployError(code int, err error) error {
+	if err == nil || ExitCode(err) != 1 {
+		return err
+	}
+	return &DeployError{Code: code, Err: err}
+}
+
+// ExitCode returns the exit code of the error: one of the EXIT_ codes for
+// failed deployments, 1 otherwise.
+func ExitCode(err error) int {
+	for err != nil {
+		if deploy, ok := err.(*DeployError); ok {
+			return deploy.Code
+		}
+		cause, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return 1
+}
+
+func failureKind(code int) string {
+	switch code {
+	case EXIT_VALIDATION:
+		return "validation"
+	case EXIT_TIMEOUT:
+		return "timeout"
+	case EXIT_ROLLBACK:
+		return "rollback"
+	default:
+		return "upgrade"
+	}
+}
+
+// Report records the services and stacks of a deployment as test cases, so
+// CI systems can display it.
+type Report struct {
+	Name    string       `json:"name"`
+	Started time.Time    `json:"started"`
+	Cases   []ReportCase `json:"cases"`
+	lock    sync.Mutex
+}
+
+// ReportCase is the outcome of deploying a service or stack.
+type ReportCase struct {
+	Name    string    `json:"name"`
+	Kind    string    `json:"kind"`
+	Started time.Time `json:"started"`
+	Seconds float64   `json:"seconds"`
+	State   string    `json:"state,omitempty"`
+	Skipped bool      `json:"skipped,omitempty"`
+	// Kind of the failure: validation, upgrade, timeout or rollback
+	Failure              string `json:"failure,omitempty"`
+	Error                string `json:"error,omitempty"`
+	TransitioningMessage string `json:"transitioningMessage,omitempty"`
+}
+
+// NewReport starts the report of the named deployment.
+func NewReport(name string) *Report {
+	return &Report{Name: name, Started: time.Now().UTC(), Cases: []ReportCase{}}
+}
+
+// Add records the outcome of a service or stack deployed since started.  A
+// nil report records nothing.
+func (report *Report) Add(kind, name string, started time.Time, state, transitioning string, err error) {
+	if report == nil {
+		return
+	}
+
+	reported := ReportCase{
+		Name:                 name,
+		Kind:                 kind,
+		Started:              started.UTC(),
+		Seconds:              time.Since(started).Seconds(),
+		State:                state,
+		TransitioningMessage: transitioning,
+	}
+	if err != nil {
+		reported.Failure = failureKind(ExitCode(err))
+		reported.Error = err.Error()
+	}
+
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	report.Cases = append(report.Cases, reported)
+}
+
+// Skip records a service or stack that was left alone.
+func (report *Report) Skip(kind, name string) {
+	if report == nil {
+		return
+	}
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	report.Cases = append(report.Cases, ReportCase{Name: name, Kind: kind, Started: time.Now().UTC(), Skipped: true})
+}
+
+// WriteJSON writes the report as json.
+func (report *Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as a junit test suite with a test case per
+// service or stack.
+func (report *Report) WriteJUnit(w io.Writer) error {
+	suite := junitSuite{
+		Name:      report.Name,
+		Tests:     len(report.Cases),
+		Time:      fmt.Sprintf("%.3f", time.Since(report.Started).Seconds()),
+		Timestamp: report.Started.Format(time.RFC3339),
+	}
+	for _, reported := range report.Cases {
+		tested := junitCase{
+			ClassName: reported.Kind,
+			Name:      reported.Name,
+			Time:      fmt.Sprintf("%.3f", reported.Seconds),
+		}
+		if reported.State != "" {
+			tested.SystemOut = "state: " + reported.State
+		}
+		switch {
+		case reported.Skipped:
+			suite.Skipped++
+			tested.Skipped = &struct{}{}
+		case reported.Failure != "":
+			suite.Failures++
+			tested.Failure = &junitFailure{
+				Type:    reported.Failure,
+				Message: reported.Error,
+				Text:    reported.TransitioningMessage,
+			}
+		}
+		suite.Cases = append(suite.Cases, tested)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Write writes the report to every target, creating their directories.
+func (report *Report) Write(targets []config.ReportTarget) error {
+	for _, target := range targets {
+		if err := os.MkdirAll(filepath.Dir(target.Path), 0755); err != nil {
+			return err
+		}
+		file, err := os.Create(target.Path)
+		if err != nil {
+			return err
+		}
+
+		if target.Format == config.REPORT_JUNIT {
+			err = report.WriteJUnit(file)
+		} else {
+			err = report.WriteJSON(file)
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("writing the report %s failed: %v", target.Path, err)
+		}
+	}
+	return nil
+}
+
+// Record the outcome of the upgrade of the service in the report.
+func (cli *Client) reportService(started time.Time, service *client.Service, err error) {
+	if cli.Report == nil || service == nil {
+		return
+	}
+	name, nameErr := cli.newServiceNames([]client.Service{*service}).service(service.Id)
+	if nameErr != nil {
+		name = service.Name
+	}
+	cli.Report.Add(REPORT_KIND_SERVICE, name, started, service.State, service.TransitioningMessage, err)
+}
+
+// Record the outcome of the deployment of the stack in the report.
+func (cli *Client) reportStack(started time.Time, name string, stack *client.Environment, err error) {
+	if cli.Report == nil {
+		return
+	}
+	state, transitioning := "", ""
+	if stack != nil {
+		state, transitioning = stack.State, stack.TransitioningMessage
+	}
+	cli.Report.Add(REPORT_KIND_STACK, name, started, state, transitioning, err)
+}
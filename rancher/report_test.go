@@ -0,0 +1,138 @@
+package rancher
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestExitCode(t *testing.T) {
+	timeout := &DeployError{Code: EXIT_TIMEOUT, Err: errors.New("timed out")}
+
+	tests := []struct {
+		Description string
+		Err         error
+		Code        int
+	}{
+		{Description: "plain error", Err: errors.New("failed"), Code: 1},
+		{Description: "deploy error", Err: timeout, Code: EXIT_TIMEOUT},
+		{Description: "wrapped deploy error", Err: errors.Wrap(errors.Wrap(timeout, "inner"), "outer"), Code: EXIT_TIMEOUT},
+		{Description: "classified error keeps its kind", Err: NewDeployError(EXIT_UPGRADE, timeout), Code: EXIT_TIMEOUT},
+		{Description: "classified plain error", Err: NewDeployError(EXIT_VALIDATION, errors.New("invalid")), Code: EXIT_VALIDATION},
+	}
+
+	for _, test := range tests {
+		if code := ExitCode(test.Err); code != test.Code {
+			t.Errorf("%s: expected exit code %d, got %d", test.Description, test.Code, code)
+		}
+	}
+	if NewDeployError(EXIT_UPGRADE, nil) != nil {
+		t.Error("Expected classifying no error to return nil")
+	}
+}
+
+func testReport() *Report {
+	report := NewReport("service upgrade")
+	started := time.Now().Add(-2 * time.Second)
+	report.Add(REPORT_KIND_SERVICE, "backend/api", started, "active", "", nil)
+	report.Add(REPORT_KIND_SERVICE, "backend/worker", started, "upgraded", "Unhealthy containers", &DeployError{Code: EXIT_TIMEOUT, Err: errors.New("finishing upgrade timed out")})
+	report.Skip(REPORT_KIND_STACK, "frontend")
+	return report
+}
+
+func TestReportJUnit(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := testReport().WriteJUnit(out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<testsuite name="service upgrade" tests="3" failures="1" skipped="1"`,
+		`<testcase classname="service" name="backend/api" time="2.`,
+		`<system-out>state: active</system-out>`,
+		`<failure type="timeout" message="finishing upgrade timed out">Unhealthy containers</failure>`,
+		`<testcase classname="stack" name="frontend" time="0.000">`,
+		`<skipped></skipped>`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the junit report to contain %s, got %s", expected, out)
+		}
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rancher-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	targets := []config.ReportTarget{
+		{Format: config.REPORT_JSON, Path: filepath.Join(dir, "reports", "deploy.json")},
+		{Format: config.REPORT_JUNIT, Path: filepath.Join(dir, "deploy.xml")},
+	}
+	if err := testReport().Write(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(targets[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Report{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Cases) != 3 || report.Cases[1].Failure != "timeout" || report.Cases[1].TransitioningMessage != "Unhealthy containers" || report.Cases[0].Seconds < 2 {
+		t.Errorf("Unexpected json report %s", data)
+	}
+
+	if _, err := os.Stat(targets[1].Path); err != nil {
+		t.Errorf("Expected the junit report to be written: %v", err)
+	}
+}
+
+func TestUpgradeServicesReport(t *testing.T) {
+	cli, services, cleanup := hookedClient(t)
+	defer cleanup()
+	services.Services["1s2"].LaunchConfig = &client.LaunchConfig{ImageUuid: "docker:nowait/worker:1.0"}
+	cli.Report = NewReport("service upgrade")
+
+	opts := config.UpgradeOpts{
+		RuntimeTag: "1.1",
+		Wait:       true,
+		Interval:   time.Second,
+		Hooks: &config.Hooks{
+			Pre: []config.Hook{{Name: "check", Command: []string{"false"}, Services: []string{"worker"}}},
+		},
+	}
+	if err := opts.Hooks.Pre[0].Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded := []client.Service{*services.Services["1s1"], *services.Services["1s2"]}
+	err := cli.UpgradeServices(upgraded, opts)
+	if ExitCode(err) != EXIT_VALIDATION {
+		t.Errorf("Expected the failed pre hook to exit with %d, got %d: %v", EXIT_VALIDATION, ExitCode(err), err)
+	}
+	if len(services.Cancelled) != 0 || len(services.RolledBack) != 0 {
+		t.Errorf("Expected the upgrade that never started to be left alone, cancelled %v rolled back %v", services.Cancelled, services.RolledBack)
+	}
+
+	failures := make(map[string]string)
+	for _, reported := range cli.Report.Cases {
+		failures[reported.Name] = reported.Failure
+	}
+	if len(failures) != 2 || failures["backend/api"] != "" || failures["backend/worker"] != "validation" {
+		t.Errorf("Unexpected report cases %v", cli.Report.Cases)
+	}
+}
@@ -0,0 +1,459 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	LABEL_HOST_AFFINITY          = "io.rancher.scheduler.affinity:host_label"
+	LABEL_HOST_ANTI_AFFINITY     = "io.rancher.scheduler.affinity:host_label_ne"
+	LABEL_CONTAINER_AFFINITY     = "io.rancher.scheduler.affinity:container_label"
+	LABEL_CONTAINER_ANTIAFFINITY = "io.rancher.scheduler.affinity:container_label_ne"
+	LABEL_GLOBAL                 = "io.rancher.scheduler.global"
+	LABEL_STACK_SERVICE_NAME     = "io.rancher.stack_service.name"
+	LABEL_DEPLOYMENT_UNIT        = "io.rancher.service.deployment.unit"
+)
+
+// SchedulingValidator fails an upgrade when the upgraded containers can not be
+// placed on any host, instead of letting the upgrade hang in Rancher.  It
+// simulates the in service upgrade batch by batch against the hosts of the
+// project, their labels and the host ports already in use.
+type SchedulingValidator struct {
+	Client *Client
+}
+
+// A host as seen by the placement simulation.
+type schedHost struct {
+	Id         string
+	Name       string
+	Labels     map[string]string
+	Containers []*schedContainer
+}
+
+// A container occupying ports on a host.
+type schedContainer struct {
+	Id     string
+	Labels map[string]string
+	Ports  []string
+}
+
+// The scheduling constraints of the upgraded launch config.
+type schedConstraints struct {
+	HostLabels        map[string]string
+	HostLabelsNe      map[string]string
+	ContainerLabels   map[string]string
+	ContainerLabelsNe map[string]string
+	Global            bool
+	// Public ports of all launch configs as port/protocol
+	Ports []string
+	// Labels of the new containers
+	Labels map[string]string
+	// Ids of the containers being replaced by the upgrade, grouped by
+	// deployment unit so a primary is stopped along with its sidekicks
+	ReplacedUnits [][]string
+	Scale         int64
+	BatchSize     int64
+	StartFirst    bool
+}
+
+func NewSchedulingValidator(cli *Client) *SchedulingValidator {
+	return &SchedulingValidator{
+		Client: cli,
+	}
+}
+
+func (val *SchedulingValidator) ValidateUpgrade(service *client.Service, upgrade *client.ServiceUpgrade, opts config.UpgradeOpts) error {
+	if opts.SkipSchedulingCheck {
+		return nil
+	}
+
+	hosts, err := val.hosts(service.AccountId)
+	if err != nil {
+		return err
+	}
+
+	replaced, err := val.Client.ServiceContainers(service)
+	if err != nil {
+		return err
+	}
+
+	stackName := ""
+	if stack, err := val.Client.RancherClient.Environment.ById(service.EnvironmentId); err == nil && stack != nil {
+		stackName = stack.Name
+	}
+
+	constraints := newSchedConstraints(service, stackName, upgrade)
+	constraints.ReplacedUnits = deploymentUnits(replaced)
+
+	if err := simulatePlacement(hosts, constraints); err != nil {
+		return fmt.Errorf("scheduling: upgrading %s can not be placed: %v", service.Name, err)
+	}
+	return nil
+}
+
+// Retrieve the active hosts of the project with the running containers on each host.
+func (val *SchedulingValidator) hosts(projectId string) ([]*schedHost, error) {
+	filters := make(map[string]interface{})
+	filters["accountId"] = projectId
+	hosts, err := val.Client.listHosts(filters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	containerFilters := make(map[string]interface{})
+	containerFilters["accountId"] = projectId
+	containerFilters["state"] = "running"
+	containers, err := val.Client.listContainers(containerFilters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]*schedHost)
+	result := []*schedHost{}
+	for _, host := range hosts {
+		if host.State != "" && host.State != "active" {
+			continue
+		}
+		name := host.Hostname
+		if host.Name != "" {
+			name = host.Name
+		}
+		sh := &schedHost{
+			Id:     host.Id,
+			Name:   name,
+			Labels: stringLabels(host.Labels),
+		}
+		byId[host.Id] = sh
+		result = append(result, sh)
+	}
+
+	for _, container := range containers {
+		host, ok := byId[container.HostId]
+		if !ok {
+			continue
+		}
+		host.Containers = append(host.Containers, &schedContainer{
+			Id:     container.Id,
+			Labels: stringLabels(container.Labels),
+			Ports:  publicPorts(container.Ports),
+		})
+	}
+
+	return result, nil
+}
+
+func newSchedConstraints(service *client.Service, stackName string, upgrade *client.ServiceUpgrade) *schedConstraints {
+	strategy := upgrade.InServiceStrategy
+	lc := strategy.LaunchConfig
+	if lc == nil {
+		lc = &client.LaunchConfig{}
+	}
+
+	expand := func(value string) string {
+		value = strings.Replace(value, "${stack_name}", stackName, -1)
+		return strings.Replace(value, "${service_name}", service.Name, -1)
+	}
+
+	labels := stringLabels(lc.Labels)
+	constraints := &schedConstraints{
+		HostLabels:        parseLabelList(expand(labels[LABEL_HOST_AFFINITY])),
+		HostLabelsNe:      parseLabelList(expand(labels[LABEL_HOST_ANTI_AFFINITY])),
+		ContainerLabels:   parseLabelList(expand(labels[LABEL_CONTAINER_AFFINITY])),
+		ContainerLabelsNe: parseLabelList(expand(labels[LABEL_CONTAINER_ANTIAFFINITY])),
+		Global:            labels[LABEL_GLOBAL] == "true",
+		Ports:             publicPorts(lc.Ports),
+		Labels:            labels,
+		Scale:             service.Scale,
+		BatchSize:         strategy.BatchSize,
+		StartFirst:        strategy.StartFirst,
+	}
+	constraints.Labels[LABEL_STACK_SERVICE_NAME] = stackName + "/" + service.Name
+
+	// Sidekicks are always placed on the same host so their ports count too
+	for _, slc := range strategy.SecondaryLaunchConfigs {
+		slcMap, ok := slc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ports, _ := slcMap["ports"].([]interface{})
+		for _, port := range ports {
+			if p, ok := port.(string); ok {
+				constraints.Ports = append(constraints.Ports, publicPorts([]string{p})...)
+			}
+		}
+	}
+
+	if constraints.BatchSize < 1 {
+		constraints.BatchSize = 1
+	}
+	return constraints
+}
+
+// Simulate the in service upgrade.  Each batch starts BatchSize new containers
+// and stops BatchSize of the replaced containers, either before (stop first)
+// or after (start first) the new containers are placed.
+func simulatePlacement(hosts []*schedHost, constraints *schedConstraints) error {
+	if constraints.Global {
+		return simulateGlobalPlacement(hosts, constraints)
+	}
+
+	replaced := append([][]string{}, constraints.ReplacedUnits...)
+
+	placed := int64(0)
+	for placed < constraints.Scale {
+		batch := constraints.BatchSize
+		if remaining := constraints.Scale - placed; remaining < batch {
+			batch = remaining
+		}
+
+		if !constraints.StartFirst {
+			replaced = removeContainers(hosts, replaced, batch)
+		}
+
+		for i := int64(0); i < batch; i++ {
+			if err := placeContainer(hosts, constraints, placed+1); err != nil {
+				return err
+			}
+			placed++
+		}
+
+		if constraints.StartFirst {
+			replaced = removeContainers(hosts, replaced, batch)
+		}
+	}
+
+	return nil
+}
+
+// Global services run a container on every host matching the host labels.
+func simulateGlobalPlacement(hosts []*schedHost, constraints *schedConstraints) error {
+	replaced := make(map[string]bool)
+	for _, unit := range constraints.ReplacedUnits {
+		for _, id := range unit {
+			replaced[id] = true
+		}
+	}
+
+	eligible := 0
+	for _, host := range hosts {
+		if reason := hostLabelMismatch(host, constraints); reason != "" {
+			continue
+		}
+		eligible++
+
+		if !constraints.StartFirst {
+			removeHostContainers(host, replaced)
+		}
+
+		if reason := containerConflict(host, constraints); reason != "" {
+			return fmt.Errorf("global container on host %s: %s", host.Name, reason)
+		}
+		host.Containers = append(host.Containers, newSchedContainer(constraints, eligible))
+	}
+
+	if eligible == 0 {
+		return fmt.Errorf("no host matches %v", constraints.HostLabels)
+	}
+	return nil
+}
+
+// Place a single container on the eligible host running the fewest
+// containers, explaining why every host was rejected when none is eligible.
+func placeContainer(hosts []*schedHost, constraints *schedConstraints, index int64) error {
+	reasons := []string{}
+	var best *schedHost
+
+	for _, host := range hosts {
+		reason := hostLabelMismatch(host, constraints)
+		if reason == "" {
+			reason = containerConflict(host, constraints)
+		}
+		if reason != "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", host.Name, reason))
+			continue
+		}
+		if best == nil || len(host.Containers) < len(best.Containers) {
+			best = host
+		}
+	}
+
+	if best == nil {
+		if len(hosts) == 0 {
+			return fmt.Errorf("container %d of %d: no active hosts", index, constraints.Scale)
+		}
+		return fmt.Errorf("container %d of %d: %s", index, constraints.Scale, strings.Join(reasons, "; "))
+	}
+
+	best.Containers = append(best.Containers, newSchedContainer(constraints, int(index)))
+	return nil
+}
+
+func newSchedContainer(constraints *schedConstraints, index int) *schedContainer {
+	return &schedContainer{
+		Id:     fmt.Sprintf("new-%d", index),
+		Labels: constraints.Labels,
+		Ports:  constraints.Ports,
+	}
+}
+
+// Returns why the host labels do not satisfy the constraints or an empty string.
+func hostLabelMismatch(host *schedHost, constraints *schedConstraints) string {
+	for _, key := range sortedKeys(constraints.HostLabels) {
+		if host.Labels[key] != constraints.HostLabels[key] {
+			return fmt.Sprintf("missing host label %s=%s", key, constraints.HostLabels[key])
+		}
+	}
+	for _, key := range sortedKeys(constraints.HostLabelsNe) {
+		if value, ok := host.Labels[key]; ok && value == constraints.HostLabelsNe[key] {
+			return fmt.Sprintf("has host label %s=%s", key, value)
+		}
+	}
+	return ""
+}
+
+// Returns why the containers on the host conflict with the constraints or an empty string.
+func containerConflict(host *schedHost, constraints *schedConstraints) string {
+	used := make(map[string]bool)
+	for _, container := range host.Containers {
+		for _, port := range container.Ports {
+			used[port] = true
+		}
+	}
+	for _, port := range constraints.Ports {
+		if used[port] {
+			return fmt.Sprintf("port %s in use", port)
+		}
+	}
+
+	for _, key := range sortedKeys(constraints.ContainerLabelsNe) {
+		for _, container := range host.Containers {
+			if container.Labels[key] == constraints.ContainerLabelsNe[key] {
+				return fmt.Sprintf("runs a container with label %s=%s", key, constraints.ContainerLabelsNe[key])
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(constraints.ContainerLabels) {
+		found := false
+		for _, container := range host.Containers {
+			if container.Labels[key] == constraints.ContainerLabels[key] {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Sprintf("runs no container with label %s=%s", key, constraints.ContainerLabels[key])
+		}
+	}
+
+	return ""
+}
+
+// Group the containers of a service by deployment unit, the primary container
+// and its sidekicks, in the order the units are first seen.
+func deploymentUnits(containers []client.Container) [][]string {
+	units := [][]string{}
+	index := make(map[string]int)
+	for _, container := range containers {
+		unit, _ := container.Labels[LABEL_DEPLOYMENT_UNIT].(string)
+		if unit == "" {
+			unit = container.Id
+		}
+		if i, ok := index[unit]; ok {
+			units[i] = append(units[i], container.Id)
+			continue
+		}
+		index[unit] = len(units)
+		units = append(units, []string{container.Id})
+	}
+	return units
+}
+
+// Remove count of the replaced deployment units from their hosts and return
+// the ones still running.
+func removeContainers(hosts []*schedHost, replaced [][]string, count int64) [][]string {
+	if int64(len(replaced)) < count {
+		count = int64(len(replaced))
+	}
+
+	remove := make(map[string]bool)
+	for _, unit := range replaced[:count] {
+		for _, id := range unit {
+			remove[id] = true
+		}
+	}
+	for _, host := range hosts {
+		removeHostContainers(host, remove)
+	}
+	return replaced[count:]
+}
+
+func removeHostContainers(host *schedHost, remove map[string]bool) {
+	containers := []*schedContainer{}
+	for _, container := range host.Containers {
+		if !remove[container.Id] {
+			containers = append(containers, container)
+		}
+	}
+	host.Containers = containers
+}
+
+// Parse a comma separated list of key=value labels as used by the scheduler labels.
+func parseLabelList(value string) map[string]string {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		pieces := strings.SplitN(pair, "=", 2)
+		if len(pieces) == 1 {
+			labels[pieces[0]] = ""
+			continue
+		}
+		labels[pieces[0]] = pieces[1]
+	}
+	return labels
+}
+
+// Return the public ports of port specs of the form [ip:]public:private[/protocol]
+// as public/protocol.  Ports without a public port do not occupy a host port.
+func publicPorts(specs []string) []string {
+	ports := []string{}
+	for _, spec := range specs {
+		protocol := "tcp"
+		if pos := strings.Index(spec, "/"); pos != -1 {
+			spec, protocol = spec[:pos], spec[pos+1:]
+		}
+
+		pieces := strings.Split(spec, ":")
+		if len(pieces) < 2 {
+			continue
+		}
+		ports = append(ports, pieces[len(pieces)-2]+"/"+protocol)
+	}
+	return ports
+}
+
+func stringLabels(labels map[string]interface{}) map[string]string {
+	result := make(map[string]string)
+	for key, value := range labels {
+		result[key] = fmt.Sprintf("%v", value)
+	}
+	return result
+}
+
+func sortedKeys(labels map[string]string) []string {
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,225 @@
+package rancher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+type SchedulingStacks struct {
+	mocks.NoopEnvironmentOperations
+	mocks.SuccessfulList
+}
+
+func (env *SchedulingStacks) ById(id string) (*client.Environment, error) {
+	return &client.Environment{Resource: client.Resource{Id: id}, Name: "backend"}, nil
+}
+
+func schedHosts(labels ...map[string]string) []*schedHost {
+	hosts := []*schedHost{}
+	for index, hostLabels := range labels {
+		hosts = append(hosts, &schedHost{
+			Id:     string(rune('a' + index)),
+			Name:   "host-" + string(rune('a'+index)),
+			Labels: hostLabels,
+		})
+	}
+	return hosts
+}
+
+func TestPublicPorts(t *testing.T) {
+	ports := publicPorts([]string{"8080:80/tcp", "80", "0.0.0.0:53:53/udp", "9000:9000"})
+	expected := []string{"8080/tcp", "53/udp", "9000/tcp"}
+
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("expected public ports %v but received %v", expected, ports)
+	}
+}
+
+func TestSimulatePlacement(t *testing.T) {
+	tests := []struct {
+		Description string
+		Hosts       []*schedHost
+		Constraints *schedConstraints
+		Error       string
+	}{
+		{
+			Description: "containers without constraints spread over hosts",
+			Hosts:       schedHosts(map[string]string{}, map[string]string{}),
+			Constraints: &schedConstraints{Scale: 4, BatchSize: 1, StartFirst: true},
+		},
+		{
+			Description: "no host has the required host label",
+			Hosts:       schedHosts(map[string]string{"tier": "db"}, map[string]string{}),
+			Constraints: &schedConstraints{Scale: 1, BatchSize: 1, HostLabels: map[string]string{"tier": "api"}},
+			Error:       "container 1 of 1: host-a: missing host label tier=api; host-b: missing host label tier=api",
+		},
+		{
+			Description: "host anti affinity excludes the only host",
+			Hosts:       schedHosts(map[string]string{"tier": "db"}),
+			Constraints: &schedConstraints{Scale: 1, BatchSize: 1, HostLabelsNe: map[string]string{"tier": "db"}},
+			Error:       "container 1 of 1: host-a: has host label tier=db",
+		},
+		{
+			Description: "more containers with a host port than hosts",
+			Hosts:       schedHosts(map[string]string{}, map[string]string{}),
+			Constraints: &schedConstraints{Scale: 3, BatchSize: 1, Ports: []string{"80/tcp"}},
+			Error:       "container 3 of 3: host-a: port 80/tcp in use; host-b: port 80/tcp in use",
+		},
+		{
+			Description: "start first can not reuse the host port of the replaced container",
+			Hosts: []*schedHost{
+				{Id: "a", Name: "host-a", Containers: []*schedContainer{{Id: "old", Ports: []string{"80/tcp"}}}},
+			},
+			Constraints: &schedConstraints{Scale: 1, BatchSize: 1, StartFirst: true, Ports: []string{"80/tcp"}, ReplacedUnits: [][]string{{"old"}}},
+			Error:       "container 1 of 1: host-a: port 80/tcp in use",
+		},
+		{
+			Description: "stop first frees the host port of the replaced container",
+			Hosts: []*schedHost{
+				{Id: "a", Name: "host-a", Containers: []*schedContainer{{Id: "old", Ports: []string{"80/tcp"}}}},
+			},
+			Constraints: &schedConstraints{Scale: 1, BatchSize: 1, StartFirst: false, Ports: []string{"80/tcp"}, ReplacedUnits: [][]string{{"old"}}},
+		},
+		{
+			Description: "start first with a spare host rolls through every host",
+			Hosts: []*schedHost{
+				{Id: "a", Name: "host-a", Containers: []*schedContainer{{Id: "old-1", Ports: []string{"80/tcp"}}}},
+				{Id: "b", Name: "host-b", Containers: []*schedContainer{{Id: "old-2", Ports: []string{"80/tcp"}}}},
+				{Id: "c", Name: "host-c"},
+			},
+			Constraints: &schedConstraints{Scale: 2, BatchSize: 1, StartFirst: true, Ports: []string{"80/tcp"}, ReplacedUnits: [][]string{{"old-1"}, {"old-2"}}},
+		},
+		{
+			Description: "stop first stops the sidekicks of the replaced container along with it",
+			Hosts: []*schedHost{
+				{Id: "a", Name: "host-a", Containers: []*schedContainer{
+					{Id: "old-sidekick", Ports: []string{"81/tcp"}},
+					{Id: "old", Ports: []string{"80/tcp"}},
+				}},
+			},
+			Constraints: &schedConstraints{Scale: 1, BatchSize: 1, StartFirst: false, Ports: []string{"80/tcp", "81/tcp"}, ReplacedUnits: [][]string{{"old-sidekick", "old"}}},
+		},
+		{
+			Description: "container anti affinity against itself",
+			Hosts:       schedHosts(map[string]string{}, map[string]string{}),
+			Constraints: &schedConstraints{
+				Scale:             3,
+				BatchSize:         1,
+				Labels:            map[string]string{LABEL_STACK_SERVICE_NAME: "backend/api"},
+				ContainerLabelsNe: map[string]string{LABEL_STACK_SERVICE_NAME: "backend/api"},
+			},
+			Error: "container 3 of 3: host-a: runs a container with label io.rancher.stack_service.name=backend/api; host-b: runs a container with label io.rancher.stack_service.name=backend/api",
+		},
+		{
+			Description: "container affinity requires a matching container",
+			Hosts: []*schedHost{
+				{Id: "a", Name: "host-a"},
+				{Id: "b", Name: "host-b", Containers: []*schedContainer{{Id: "db", Labels: map[string]string{"app": "db"}}}},
+			},
+			Constraints: &schedConstraints{Scale: 2, BatchSize: 1, ContainerLabels: map[string]string{"app": "db"}},
+		},
+		{
+			Description: "global service with a port conflict on an eligible host",
+			Hosts: []*schedHost{
+				{Id: "a", Name: "host-a", Labels: map[string]string{"tier": "api"}},
+				{Id: "b", Name: "host-b", Labels: map[string]string{"tier": "api"}, Containers: []*schedContainer{{Id: "other", Ports: []string{"80/tcp"}}}},
+				{Id: "c", Name: "host-c", Labels: map[string]string{"tier": "db"}, Containers: []*schedContainer{{Id: "other", Ports: []string{"80/tcp"}}}},
+			},
+			Constraints: &schedConstraints{Global: true, BatchSize: 1, HostLabels: map[string]string{"tier": "api"}, Ports: []string{"80/tcp"}},
+			Error:       "global container on host host-b: port 80/tcp in use",
+		},
+		{
+			Description: "no active hosts",
+			Hosts:       []*schedHost{},
+			Constraints: &schedConstraints{Scale: 1, BatchSize: 1},
+			Error:       "container 1 of 1: no active hosts",
+		},
+	}
+
+	for _, test := range tests {
+		err := simulatePlacement(test.Hosts, test.Constraints)
+
+		if test.Error == "" && err != nil {
+			t.Errorf("%s: placement should have succeeded but failed with: %v", test.Description, err)
+		}
+		if test.Error != "" && (err == nil || err.Error() != test.Error) {
+			t.Errorf("%s: expected `%s` but received `%v`", test.Description, test.Error, err)
+		}
+	}
+}
+
+func TestSchedulingValidatorValidateUpgrade(t *testing.T) {
+	cli := &Client{
+		RancherClient: &client.RancherClient{
+			Environment: &SchedulingStacks{},
+			ServiceExposeMap: &mocks.ServiceExposeMapOperations{
+				Maps: []client.ServiceExposeMap{
+					{ServiceId: "1s1", InstanceId: "1i1"},
+				},
+			},
+			Container: &mocks.ContainerOperations{
+				Containers: []client.Container{
+					{
+						Resource: client.Resource{Id: "1i1"},
+						HostId:   "1h1",
+						Ports:    []string{"80:80/tcp"},
+						Labels:   map[string]interface{}{LABEL_STACK_SERVICE_NAME: "backend/api"},
+					},
+				},
+			},
+			Host: &mocks.HostOperations{
+				Hosts: []client.Host{
+					{Resource: client.Resource{Id: "1h1"}, Hostname: "host-1", State: "active", Labels: map[string]interface{}{"tier": "api"}},
+					{Resource: client.Resource{Id: "1h2"}, Hostname: "host-2", State: "active", Labels: map[string]interface{}{"tier": "db"}},
+					{Resource: client.Resource{Id: "1h3"}, Hostname: "host-3", State: "inactive", Labels: map[string]interface{}{"tier": "api"}},
+				},
+			},
+		},
+	}
+	validator := NewSchedulingValidator(cli)
+	service := &client.Service{Resource: client.Resource{Id: "1s1"}, Name: "api", Scale: 1}
+	upgrade := &client.ServiceUpgrade{
+		InServiceStrategy: &client.InServiceUpgradeStrategy{
+			BatchSize:  1,
+			StartFirst: true,
+			LaunchConfig: &client.LaunchConfig{
+				Ports:  []string{"80:80/tcp"},
+				Labels: map[string]interface{}{LABEL_HOST_AFFINITY: "tier=api"},
+			},
+		},
+	}
+
+	err := validator.ValidateUpgrade(service, upgrade, config.UpgradeOpts{})
+
+	if err == nil || !strings.Contains(err.Error(), "host-1: port 80/tcp in use; host-2: missing host label tier=api") {
+		t.Errorf("start first upgrade on the only api host should fail, received %v", err)
+	}
+
+	if err := validator.ValidateUpgrade(service, upgrade, config.UpgradeOpts{SkipSchedulingCheck: true}); err != nil {
+		t.Errorf("skipping the scheduling check should not fail, received %v", err)
+	}
+
+	upgrade.InServiceStrategy.StartFirst = false
+	if err := validator.ValidateUpgrade(service, upgrade, config.UpgradeOpts{}); err != nil {
+		t.Errorf("stop first upgrade should be placeable, received %v", err)
+	}
+}
+
+func TestDeploymentUnits(t *testing.T) {
+	containers := []client.Container{
+		{Resource: client.Resource{Id: "1i1"}, Labels: map[string]interface{}{LABEL_DEPLOYMENT_UNIT: "unit-1"}},
+		{Resource: client.Resource{Id: "1i2"}, Labels: map[string]interface{}{LABEL_DEPLOYMENT_UNIT: "unit-2"}},
+		{Resource: client.Resource{Id: "1i3"}, Labels: map[string]interface{}{LABEL_DEPLOYMENT_UNIT: "unit-1"}},
+		{Resource: client.Resource{Id: "1i4"}},
+	}
+	expected := [][]string{{"1i1", "1i3"}, {"1i2"}, {"1i4"}}
+
+	if units := deploymentUnits(containers); !reflect.DeepEqual(units, expected) {
+		t.Errorf("expected deployment units %v but received %v", expected, units)
+	}
+}
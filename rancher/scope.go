@@ -0,0 +1,131 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+// Scope limits the stacks and services the client looks up to a project and
+// optionally a stack of it.  Without a project, api keys of an account can
+// see the services of every project, so acting on services of more than one
+// project has to be allowed explicitly.
+type Scope struct {
+	ProjectId string
+	StackId   string
+	// Names of the project and stack for messages
+	Project string
+	Stack   string
+	// Allow acting on services of several projects at once
+	AllProjects bool
+}
+
+// SetScope resolves the project and stack names into the scope of every
+// lookup the client makes.  Either name may be empty.
+func (cli *Client) SetScope(project, stack string, allProjects bool) error {
+	cli.Scope = Scope{AllProjects: allProjects}
+
+	if project != "" {
+		found, err := cli.ProjectByName(project)
+		if err != nil {
+			return err
+		}
+		cli.Scope.ProjectId = found.Id
+		cli.Scope.Project = found.Name
+	}
+
+	if stack != "" {
+		found, err := cli.StackByName(stack)
+		if err != nil {
+			return err
+		}
+		cli.Scope.StackId = found.Id
+		cli.Scope.Stack = found.Name
+	}
+
+	return nil
+}
+
+// Add the filters of the scope to the filters of a service or stack lookup.
+func (cli *Client) scopeFilters(filters map[string]interface{}, stacks bool) map[string]interface{} {
+	if cli.Scope.ProjectId != "" {
+		filters["accountId"] = cli.Scope.ProjectId
+	}
+	if cli.Scope.StackId != "" && !stacks {
+		filters["environmentId"] = cli.Scope.StackId
+	}
+	return filters
+}
+
+// Look up the stack of a stack/service address and add it to the filters of
+// the service lookup, returning the service part of the address.  An address
+// outside of the stack of the scope is refused.
+func (cli *Client) serviceAddressFilters(filters map[string]interface{}, address string) (string, error) {
+	cli.scopeFilters(filters, false)
+
+	pos := strings.Index(address, "/")
+	if pos == -1 {
+		return address, nil
+	}
+
+	stack, err := cli.StackByName(address[:pos])
+	if err != nil {
+		return "", err
+	}
+	if cli.Scope.StackId != "" && stack.Id != cli.Scope.StackId {
+		return "", fmt.Errorf("service %s is outside of the stack %s of the global --stack", address, cli.Scope.Stack)
+	}
+	filters["environmentId"] = stack.Id
+	return address[pos+1:], nil
+}
+
+// Refuse to act on services of more than one project unless the scope
+// allows it.
+func (cli *Client) checkSingleProject(services []client.Service) error {
+	if cli.Scope.AllProjects {
+		return nil
+	}
+
+	projects := make(map[string]bool)
+	for _, service := range services {
+		projects[service.AccountId] = true
+	}
+	if len(projects) <= 1 {
+		return nil
+	}
+
+	names := []string{}
+	for id := range projects {
+		names = append(names, cli.projectNameOrId(id))
+	}
+	sort.Strings(names)
+	return fmt.Errorf("services in environments %s match, pass the global --env to pick one or --all-projects to act on all of them", strings.Join(names, ", "))
+}
+
+// Describe the services matching a single service name so the user can
+// address the one they meant.
+func (cli *Client) ambiguousService(name string, services []client.Service) error {
+	locations := []string{}
+	for _, service := range services {
+		stack := service.EnvironmentId
+		if found, err := cli.RancherClient.Environment.ById(service.EnvironmentId); err == nil && found != nil {
+			stack = found.Name
+		}
+		location := stack + "/" + service.Name
+		if cli.Scope.ProjectId == "" {
+			location = cli.projectNameOrId(service.AccountId) + ": " + location
+		}
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	return fmt.Errorf("found %d services with name %s (%s), address it as stack/service or pass the global --env", len(services), name, strings.Join(locations, ", "))
+}
+
+func (cli *Client) projectNameOrId(id string) string {
+	if name, err := cli.ProjectName(id); err == nil {
+		return name
+	}
+	return id
+}
@@ -0,0 +1,172 @@
+package rancher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+// ScopedStacks and ScopedServices apply the filters the client sends.
+type ScopedStacks struct {
+	mocks.NoopEnvironmentOperations
+	Stacks []client.Environment
+}
+
+func (env *ScopedStacks) List(opts *client.ListOpts) (*client.EnvironmentCollection, error) {
+	found := []client.Environment{}
+	for _, stack := range env.Stacks {
+		if matchesFilter(opts, "name", stack.Name) && matchesFilter(opts, "accountId", stack.AccountId) {
+			found = append(found, stack)
+		}
+	}
+	return &client.EnvironmentCollection{Data: found}, nil
+}
+
+func (env *ScopedStacks) ById(id string) (*client.Environment, error) {
+	for _, stack := range env.Stacks {
+		if stack.Id == id {
+			return &stack, nil
+		}
+	}
+	return nil, nil
+}
+
+type ScopedServices struct {
+	client.ServiceOperations
+	Services []client.Service
+}
+
+func (srv *ScopedServices) List(opts *client.ListOpts) (*client.ServiceCollection, error) {
+	found := []client.Service{}
+	for _, service := range srv.Services {
+		like, _ := opts.Filters["name_like"].(string)
+		if !strings.HasPrefix(service.Name, strings.TrimSuffix(like, "%")) {
+			continue
+		}
+		if matchesFilter(opts, "name", service.Name) && matchesFilter(opts, "accountId", service.AccountId) &&
+			matchesFilter(opts, "environmentId", service.EnvironmentId) {
+			found = append(found, service)
+		}
+	}
+	return &client.ServiceCollection{Data: found}, nil
+}
+
+func matchesFilter(opts *client.ListOpts, key, value string) bool {
+	filter, ok := opts.Filters[key]
+	return !ok || filter == value
+}
+
+func scopedClient() *Client {
+	return &Client{
+		RancherClient: &client.RancherClient{
+			Project: &mocks.SuccessfulProjectOperations{},
+			Environment: &ScopedStacks{
+				Stacks: []client.Environment{
+					{Resource: client.Resource{Id: "1e1"}, Name: "backend", AccountId: mocks.ProjectOne.Id},
+					{Resource: client.Resource{Id: "1e2"}, Name: "workers", AccountId: mocks.ProjectOne.Id},
+					{Resource: client.Resource{Id: "1e3"}, Name: "backend", AccountId: mocks.ProjectTwo.Id},
+				},
+			},
+			Service: &ScopedServices{
+				Services: []client.Service{
+					{Resource: client.Resource{Id: "1s1"}, Name: "api", EnvironmentId: "1e1", AccountId: mocks.ProjectOne.Id},
+					{Resource: client.Resource{Id: "1s2"}, Name: "api", EnvironmentId: "1e2", AccountId: mocks.ProjectOne.Id},
+					{Resource: client.Resource{Id: "1s3"}, Name: "api", EnvironmentId: "1e3", AccountId: mocks.ProjectTwo.Id},
+					{Resource: client.Resource{Id: "1s4"}, Name: "api-worker", EnvironmentId: "1e2", AccountId: mocks.ProjectOne.Id},
+				},
+			},
+		},
+	}
+}
+
+func TestServiceByNameScope(t *testing.T) {
+	tests := []struct {
+		Description string
+		Project     string
+		Stack       string
+		Name        string
+		Id          string
+		Error       string
+	}{
+		{
+			Description: "Services with the same name in several projects",
+			Name:        "api",
+			Error:       "found 3 services with name api",
+		},
+		{
+			Description: "Services with the same name in several stacks of the project",
+			Project:     mocks.ProjectOneName,
+			Name:        "api",
+			Error:       "backend/api, workers/api",
+		},
+		{
+			Description: "Stack and service address within the project",
+			Project:     mocks.ProjectOneName,
+			Name:        "workers/api",
+			Id:          "1s2",
+		},
+		{
+			Description: "Stack of the scope",
+			Project:     mocks.ProjectTwoName,
+			Stack:       "backend",
+			Name:        "api",
+			Id:          "1s3",
+		},
+		{
+			Description: "Stack address outside of the stack of the scope",
+			Project:     mocks.ProjectOneName,
+			Stack:       "backend",
+			Name:        "workers/api",
+			Error:       "service workers/api is outside of the stack backend of the global --stack",
+		},
+		{
+			Description: "Stack address of the stack of the scope",
+			Project:     mocks.ProjectOneName,
+			Stack:       "backend",
+			Name:        "backend/api",
+			Id:          "1s1",
+		},
+		{
+			Description: "Stack address without a project is ambiguous",
+			Name:        "backend/api",
+			Error:       "found stacks with name backend in environments",
+		},
+	}
+
+	for _, test := range tests {
+		cli := scopedClient()
+		if err := cli.SetScope(test.Project, test.Stack, false); err != nil {
+			t.Fatalf("%s: setting the scope failed: %v", test.Description, err)
+		}
+
+		service, err := cli.ServiceByName(test.Name)
+		if test.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Error) {
+				t.Errorf("%s: expected error %s, received %v", test.Description, test.Error, err)
+			}
+			continue
+		}
+		if err != nil || service.Id != test.Id {
+			t.Errorf("%s: expected service %s, received %v %v", test.Description, test.Id, service, err)
+		}
+	}
+}
+
+func TestServiceLikeNameRefusesSeveralProjects(t *testing.T) {
+	cli := scopedClient()
+	if _, err := cli.ServiceLikeName("api"); err == nil || !strings.Contains(err.Error(), "--all-projects") {
+		t.Errorf("expected matches in several projects to be refused, received %v", err)
+	}
+
+	cli.SetScope("", "", true)
+	if services, err := cli.ServiceLikeName("api"); err != nil || len(services.Data) != 4 {
+		t.Errorf("expected --all-projects to match every project, received %v", err)
+	}
+
+	cli.SetScope(mocks.ProjectOneName, "", false)
+	if services, err := cli.ServiceLikeName("workers/api"); err != nil || len(services.Data) != 2 {
+		t.Errorf("expected the services of the addressed stack, received %v", err)
+	}
+}
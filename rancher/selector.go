@@ -0,0 +1,96 @@
+package rancher
+
+import (
+	"sort"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/rancher/go-rancher/client"
+)
+
+// SelectedService is a service picked by a selector along with the name of
+// its stack.
+type SelectedService struct {
+	Stack   string
+	Service client.Service
+	Images  []string
+}
+
+// SelectServices lists the services of the scope whose name starts with the
+// serviceLike prefix, when given, and keeps the ones the selector matches.
+// The selector is applied to the listed services since Rancher can only
+// filter by name prefix.
+func (cli *Client) SelectServices(serviceLike string, selector *config.ServiceSelector) ([]SelectedService, error) {
+	filters := make(map[string]interface{})
+	prefix, err := cli.serviceAddressFilters(filters, serviceLike)
+	if err != nil {
+		return nil, err
+	}
+	addServiceLikeFilters(filters, prefix)
+
+	services, err := cli.listServices(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := []client.Service{}
+	for _, service := range services {
+		if !removed(service.State) {
+			listed = append(listed, service)
+		}
+	}
+
+	names := cli.newServiceNames(listed)
+	matched := []client.Service{}
+	selected := []SelectedService{}
+	for _, service := range listed {
+		stack, err := names.stack(service.EnvironmentId)
+		if err != nil {
+			return nil, err
+		}
+		if !selector.Matches(stack, &service) {
+			continue
+		}
+		matched = append(matched, service)
+		selected = append(selected, SelectedService{
+			Stack:   stack,
+			Service: service,
+			Images:  serviceImageNames(&service),
+		})
+	}
+
+	if err := cli.checkSingleProject(matched); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(selectedByName(selected))
+	return selected, nil
+}
+
+// SelectService looks up the named service along with its stack.
+func (cli *Client) SelectService(name string) (*SelectedService, error) {
+	service, err := cli.ServiceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stack, err := cli.newServiceNames(nil).stack(service.EnvironmentId)
+	if err != nil {
+		return nil, err
+	}
+	return &SelectedService{
+		Stack:   stack,
+		Service: *service,
+		Images:  serviceImageNames(service),
+	}, nil
+}
+
+type selectedByName []SelectedService
+
+func (s selectedByName) Len() int      { return len(s) }
+func (s selectedByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s selectedByName) Less(i, j int) bool {
+	if s[i].Stack != s[j].Stack {
+		return s[i].Stack < s[j].Stack
+	}
+	return s[i].Service.Name < s[j].Service.Name
+}
@@ -0,0 +1,75 @@
+package rancher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+)
+
+func TestSelectServices(t *testing.T) {
+	tests := []struct {
+		Description string
+		ServiceLike string
+		Patterns    []string
+		Exclude     []string
+		Selected    []string
+		Error       string
+	}{
+		{
+			Description: "Pattern within the project",
+			Patterns:    []string{"api*"},
+			Selected:    []string{"backend/api", "workers/api", "workers/api-worker"},
+		},
+		{
+			Description: "Stack pattern with exclusions",
+			Patterns:    []string{"workers/*"},
+			Exclude:     []string{"*-worker"},
+			Selected:    []string{"workers/api"},
+		},
+		{
+			Description: "Prefix narrowed down by the pattern",
+			ServiceLike: "api",
+			Patterns:    []string{"/-worker$/"},
+			Selected:    []string{"workers/api-worker"},
+		},
+		{
+			Description: "Nothing matches",
+			Patterns:    []string{"web"},
+			Selected:    []string{},
+		},
+	}
+
+	for _, test := range tests {
+		cli := scopedClient()
+		if err := cli.SetScope(mocks.ProjectOneName, "", false); err != nil {
+			t.Fatalf("%s: setting the scope failed: %v", test.Description, err)
+		}
+		selector, err := config.NewServiceSelector(test.Patterns, "", test.Exclude)
+		if err != nil {
+			t.Fatalf("%s: parsing the selector failed: %v", test.Description, err)
+		}
+
+		selected, err := cli.SelectServices(test.ServiceLike, selector)
+		if err != nil {
+			t.Errorf("%s: selecting failed with: %v", test.Description, err)
+			continue
+		}
+
+		names := []string{}
+		for _, sel := range selected {
+			names = append(names, sel.Stack+"/"+sel.Service.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(test.Selected, ",") {
+			t.Errorf("%s: expected %v, received %v", test.Description, test.Selected, names)
+		}
+	}
+}
+
+func TestSelectServicesRefusesSeveralProjects(t *testing.T) {
+	selector, _ := config.NewServiceSelector([]string{"backend/api"}, "", nil)
+	if _, err := scopedClient().SelectServices("", selector); err == nil || !strings.Contains(err.Error(), "--all-projects") {
+		t.Errorf("selecting services of several projects should be refused, received %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	STACK_ACTION_CREATE  = "create"
+	STACK_ACTION_UPGRADE = "upgrade"
+)
+
+// StackExport describes the compose files written for a stack.
+type StackExport struct {
+	Stack string `json:"stack"`
+	// Placeholders that replaced secret environment values
+	Variables []string `json:"variables"`
+}
+
+// StackImport describes what importing a stack did.
+type StackImport struct {
+	Stack  string `json:"stack"`
+	Action string `json:"action"`
+}
+
+// ExportStacks writes the compose files of every active stack of the project
+// into a directory per stack under dir.  Keys are sorted so exports of an
+// unchanged stack are identical, and environment variables matching the
+// secret globs are replaced by ${VAR} placeholders.
+func (cli *Client) ExportStacks(projectId, dir string, secrets []string) ([]StackExport, error) {
+	stacks, err := cli.projectStacks(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find stacks for project")
+	}
+
+	exports := []StackExport{}
+	for _, name := range sortedStackNames(stacks) {
+		stack := stacks[name]
+		if stack.State != "active" {
+			log.Debugf("Skipping stack %s in state %s", name, stack.State)
+			continue
+		}
+
+		exported, err := cli.RancherClient.Environment.ActionExportconfig(stack, &client.ComposeConfigInput{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to export stack %s", name)
+		}
+
+		cfg, err := compose.Parse(exported)
+		if err != nil {
+			return nil, fmt.Errorf("stack %s: %v", name, err)
+		}
+
+		variables, err := cfg.MaskSecrets(secrets)
+		if err != nil {
+			return nil, err
+		}
+
+		normalized, err := cfg.ComposeConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := compose.WriteStack(dir, compose.Stack{Name: name, Config: normalized}); err != nil {
+			return nil, err
+		}
+		exports = append(exports, StackExport{Stack: name, Variables: variables})
+	}
+
+	return exports, nil
+}
+
+// PlanImport lists what importing the stacks of dir into the project would
+// do to each of them, without changing anything.
+func (cli *Client) PlanImport(projectId, dir string) ([]StackImport, error) {
+	stacks, err := compose.ReadStacks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := cli.projectStacks(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find stacks for project")
+	}
+
+	plan := []StackImport{}
+	for _, stack := range stacks {
+		action := STACK_ACTION_CREATE
+		if _, ok := existing[stack.Name]; ok {
+			action = STACK_ACTION_UPGRADE
+		}
+		plan = append(plan, StackImport{Stack: stack.Name, Action: action})
+	}
+	return plan, nil
+}
+
+// ImportStacks creates the stacks read from opts.Dir that do not exist in the
+// project and upgrades the ones that do.  The ${VAR} placeholders of the
+// compose files are resolved from opts.Variables, every placeholder must have
+// a value before any stack is changed.  The services of upgraded stacks are
+// locked while they are upgraded.  Upgraded stacks are left upgraded unless
+// opts.Wait, which finishes each upgrade or rolls it back when it fails.
+func (cli *Client) ImportStacks(projectId string, opts config.StackImportOpts) ([]StackImport, error) {
+	stacks, err := compose.ReadStacks(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	environments := make(map[string]map[string]interface{})
+	missing := []string{}
+	for _, stack := range stacks {
+		environment, unresolved := compose.ResolveVariables(stack.Config, opts.Variables)
+		for _, variable := range unresolved {
+			missing = append(missing, fmt.Sprintf("%s (stack %s)", variable, stack.Name))
+		}
+		environments[stack.Name] = environment
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing values for variables %s", strings.Join(missing, ", "))
+	}
+
+	existing, err := cli.projectStacks(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find stacks for project")
+	}
+
+	imports := []StackImport{}
+	for _, stack := range stacks {
+		environment := environments[stack.Name]
+		started := time.Now()
+
+		if current, ok := existing[stack.Name]; ok {
+			upgraded, err := cli.importUpgrade(current, stack, environment, opts)
+			cli.reportStack(started, stack.Name, upgraded, err)
+			if err != nil {
+				return imports, err
+			}
+			imports = append(imports, StackImport{Stack: stack.Name, Action: STACK_ACTION_UPGRADE})
+			continue
+		}
+
+		created, err := cli.RancherClient.Environment.Create(&client.Environment{
+			AccountId:      projectId,
+			Name:           stack.Name,
+			DockerCompose:  stack.Config.DockerComposeConfig,
+			RancherCompose: stack.Config.RancherComposeConfig,
+			Environment:    environment,
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "Failed to create stack %s", stack.Name)
+		}
+		cli.reportStack(started, stack.Name, created, err)
+		if err != nil {
+			return imports, err
+		}
+		imports = append(imports, StackImport{Stack: stack.Name, Action: STACK_ACTION_CREATE})
+	}
+
+	return imports, nil
+}
+
+// Upgrade the existing stack to the imported one, holding the locks of its
+// services until the upgrade is started or, with opts.Wait, finished.  The
+// stack is returned in the state the upgrade left it.
+func (cli *Client) importUpgrade(current *client.Environment, stack compose.Stack, environment map[string]interface{}, opts config.StackImportOpts) (*client.Environment, error) {
+	unlock, err := cli.lockStack(current, opts.LockTTL)
+	if err != nil {
+		return current, err
+	}
+	defer unlock()
+
+	upgraded, err := cli.RancherClient.Environment.ActionUpgrade(current, &client.EnvironmentUpgrade{
+		DockerCompose:  stack.Config.DockerComposeConfig,
+		RancherCompose: stack.Config.RancherComposeConfig,
+		Environment:    environment,
+	})
+	if err != nil {
+		return current, errors.Wrapf(err, "Failed to upgrade stack %s", stack.Name)
+	}
+	if upgraded == nil {
+		upgraded = current
+	}
+
+	if !opts.Wait {
+		log.Infof("Upgraded stack %s, finish it with stack upgrade-finish --stack %s once its services are healthy", stack.Name, stack.Name)
+		return upgraded, nil
+	}
+	return cli.finishStackUpgrade(stack.Name, upgraded, opts.Timeout)
+}
+
+func sortedStackNames(stacks map[string]*client.Environment) []string {
+	names := []string{}
+	for name := range stacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
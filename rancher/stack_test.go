@@ -0,0 +1,113 @@
+package rancher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+// Records the placeholder values stacks are created and upgraded with.
+type ImportEnvironments struct {
+	CloneEnvironments
+	Environments map[string]map[string]interface{}
+}
+
+func (env *ImportEnvironments) Create(opts *client.Environment) (*client.Environment, error) {
+	env.Environments[opts.Name] = opts.Environment
+	return env.CloneEnvironments.Create(opts)
+}
+
+func (env *ImportEnvironments) ActionUpgrade(existing *client.Environment, upgrade *client.EnvironmentUpgrade) (*client.Environment, error) {
+	env.Environments[existing.Name] = upgrade.Environment
+	return env.CloneEnvironments.ActionUpgrade(existing, upgrade)
+}
+
+func TestExportAndImportStacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cli, envs := cloneClient()
+	envs.Stacks[mocks.ProjectOne.Id] = []client.Environment{
+		{Name: "api", State: "active"},
+		{Name: "web", State: "active"},
+		{Name: "old", State: "inactive"},
+	}
+	envs.Compose = &client.ComposeConfig{
+		DockerComposeConfig:  "web:\n  image: nowait/web:1.0\n  environment:\n    DB_PASSWORD: hunter2\n",
+		RancherComposeConfig: "web:\n  scale: 2\n",
+	}
+
+	exports, err := cli.ExportStacks(mocks.ProjectOne.Id, dir, compose.DefaultSecretPatterns)
+	if err != nil {
+		t.Fatalf("exporting stacks failed: %v", err)
+	}
+
+	expectedExports := []StackExport{
+		{Stack: "api", Variables: []string{"WEB_DB_PASSWORD"}},
+		{Stack: "web", Variables: []string{"WEB_DB_PASSWORD"}},
+	}
+	if !reflect.DeepEqual(exports, expectedExports) {
+		t.Errorf("expected exports %v but received %v", expectedExports, exports)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "web", compose.DOCKER_COMPOSE_FILE))
+	if err != nil {
+		t.Fatalf("reading the exported compose failed: %v", err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("exported compose should not contain secrets:\n%s", data)
+	}
+
+	imports := &ImportEnvironments{
+		CloneEnvironments: CloneEnvironments{
+			Stacks: map[string][]client.Environment{
+				mocks.ProjectTwo.Id: {{Name: "web"}},
+			},
+		},
+		Environments: make(map[string]map[string]interface{}),
+	}
+	cli.RancherClient.Environment = imports
+
+	expectedImports := []StackImport{
+		{Stack: "api", Action: STACK_ACTION_CREATE},
+		{Stack: "web", Action: STACK_ACTION_UPGRADE},
+	}
+	plan, err := cli.PlanImport(mocks.ProjectTwo.Id, dir)
+	if err != nil || !reflect.DeepEqual(plan, expectedImports) {
+		t.Errorf("expected the import plan %v but received %v, %v", expectedImports, plan, err)
+	}
+
+	if _, err := cli.ImportStacks(mocks.ProjectTwo.Id, config.StackImportOpts{Dir: dir, Variables: map[string]string{}}); err == nil {
+		t.Error("importing without placeholder values should fail")
+	}
+	if len(imports.Created) != 0 || len(imports.Upgraded) != 0 {
+		t.Errorf("a failed import should not change stacks, created %v upgraded %v", imports.Created, imports.Upgraded)
+	}
+
+	cli.Report = NewReport("stack import")
+	result, err := cli.ImportStacks(mocks.ProjectTwo.Id, config.StackImportOpts{Dir: dir, Variables: map[string]string{"WEB_DB_PASSWORD": "hunter2"}})
+	if err != nil {
+		t.Fatalf("importing stacks failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(result, expectedImports) {
+		t.Errorf("expected imports %v but received %v", expectedImports, result)
+	}
+	if len(cli.Report.Cases) != 2 || cli.Report.Cases[0].Name != "api" || cli.Report.Cases[1].Name != "web" || cli.Report.Cases[1].Error != "" {
+		t.Errorf("expected both stacks to be reported, received %+v", cli.Report.Cases)
+	}
+	if imports.Environments["web"]["WEB_DB_PASSWORD"] != "hunter2" {
+		t.Errorf("expected the placeholder value to be passed to the upgrade, received %v", imports.Environments["web"])
+	}
+}
@@ -0,0 +1,341 @@
+package rancher
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nowait/rancher-cli/rancher/compose"
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	STACK_STATE_UPGRADED = "upgraded"
+)
+
+var (
+	stackPollInterval = 2 * time.Second
+)
+
+// StackByName returns the stack with the given name that has not been
+// removed within the project of the client's scope.
+func (cli *Client) StackByName(name string) (*client.Environment, error) {
+	filters := cli.scopeFilters(make(map[string]interface{}), true)
+	filters["name"] = name
+	stacks, err := cli.listStacks(filters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	found := []client.Environment{}
+	for _, stack := range stacks {
+		if stack.Name == name && stack.State != "removed" && stack.State != "purged" {
+			found = append(found, stack)
+		}
+	}
+
+	if len(found) > 1 {
+		projects := []string{}
+		for _, stack := range found {
+			projects = append(projects, cli.projectNameOrId(stack.AccountId))
+		}
+		return nil, fmt.Errorf("found stacks with name %s in environments %s, pass the global --env to pick one", name, strings.Join(projects, ", "))
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("failed to find stack with name %s", name)
+	}
+	return &found[0], nil
+}
+
+// UpgradeStack upgrades the stack to the compose files after validating
+// every image they reference.  The services of the stack are locked for the
+// upgrade, with opts.Wait until the upgrade is finished once the stack is
+// upgraded, or rolled back when it fails or times out.
+func (cli *Client) UpgradeStack(opts config.StackUpgradeOpts) (*client.Environment, error) {
+	stack, err := cli.StackByName(opts.Stack)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	upgraded, err := cli.upgradeStack(stack, opts)
+	cli.reportStack(started, stack.Name, upgraded, err)
+	return upgraded, err
+}
+
+func (cli *Client) upgradeStack(stack *client.Environment, opts config.StackUpgradeOpts) (*client.Environment, error) {
+
+	composeConfig := &client.ComposeConfig{
+		DockerComposeConfig:  opts.DockerCompose,
+		RancherComposeConfig: opts.RancherCompose,
+	}
+
+	environment, missing := compose.ResolveVariables(composeConfig, opts.Variables)
+	if len(missing) > 0 {
+		return stack, NewDeployError(EXIT_VALIDATION, fmt.Errorf("missing values for variables %s", strings.Join(missing, ", ")))
+	}
+
+	if err := cli.ValidateStack(stack, composeConfig, opts); err != nil {
+		return stack, NewDeployError(EXIT_VALIDATION, err)
+	}
+
+	unlock, err := cli.lockStack(stack, opts.LockTTL)
+	if err != nil {
+		return stack, NewDeployError(EXIT_UPGRADE, err)
+	}
+	defer unlock()
+
+	upgraded, err := cli.RancherClient.Environment.ActionUpgrade(stack, &client.EnvironmentUpgrade{
+		DockerCompose:  opts.DockerCompose,
+		RancherCompose: opts.RancherCompose,
+		Environment:    environment,
+	})
+	if err != nil {
+		return stack, NewDeployError(EXIT_UPGRADE, errors.Wrapf(err, "Failed to upgrade stack %s", stack.Name))
+	}
+	if upgraded == nil {
+		upgraded = stack
+	}
+
+	if !opts.Wait {
+		return upgraded, nil
+	}
+	return cli.finishStackUpgrade(stack.Name, upgraded, opts.Timeout)
+}
+
+// Wait for the upgraded stack and finish its upgrade, rolling it back when it
+// fails or times out.
+func (cli *Client) finishStackUpgrade(name string, upgraded *client.Environment, timeout time.Duration) (*client.Environment, error) {
+	waited, err := cli.WaitStack(upgraded, timeout)
+	if err != nil {
+		err = NewDeployError(EXIT_UPGRADE, err)
+		log.Infof("Rolling back stack %s", name)
+		if _, rollbackErr := cli.RancherClient.Environment.ActionRollback(waited); rollbackErr != nil {
+			return waited, &DeployError{Code: EXIT_ROLLBACK, Err: errors.Wrapf(err, "rolling back stack %s also failed: %v", name, rollbackErr)}
+		}
+		return waited, err
+	}
+
+	finished, err := cli.RancherClient.Environment.ActionFinishupgrade(waited)
+	return finished, NewDeployError(EXIT_UPGRADE, err)
+}
+
+// Take the lock on every service of the stack for its upgrade, releasing the
+// locks already taken when a service is locked by somebody else.  The
+// returned func releases the locks.
+func (cli *Client) lockStack(stack *client.Environment, ttl time.Duration) (func(), error) {
+	locked := []*client.Service{}
+	locks := []*Lock{}
+	unlock := func() {
+		for i, service := range locked {
+			cli.unlockUpgrade(service, locks[i])
+		}
+	}
+	if ttl <= 0 {
+		return unlock, nil
+	}
+
+	services, err := cli.stackServices(stack.Id)
+	if err != nil {
+		return unlock, err
+	}
+	names := []string{}
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		service, lock, err := cli.lockUpgrade(services[name], ttl)
+		if err != nil {
+			unlock()
+			return func() {}, errors.Wrapf(err, "Failed to lock stack %s", stack.Name)
+		}
+		locked = append(locked, service)
+		locks = append(locks, lock)
+	}
+	return unlock, nil
+}
+
+// RollbackStack rolls the upgrade of the stack back.
+func (cli *Client) RollbackStack(stack *client.Environment) (*client.Environment, error) {
+	started := time.Now()
+	rolledBack, err := cli.RancherClient.Environment.ActionRollback(stack)
+	if err != nil {
+		err = &DeployError{Code: EXIT_ROLLBACK, Err: errors.Wrapf(err, "Failed to roll back stack %s", stack.Name)}
+	}
+	if rolledBack == nil {
+		rolledBack = stack
+	}
+	cli.reportStack(started, stack.Name, rolledBack, err)
+	return rolledBack, err
+}
+
+// WaitStack waits for the stack to finish transitioning into the upgraded
+// state and returns the reloaded stack.
+func (cli *Client) WaitStack(stack *client.Environment, timeout time.Duration) (*client.Environment, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		current, err := cli.RancherClient.Environment.ById(stack.Id)
+		if err != nil {
+			return stack, err
+		}
+		if current == nil {
+			return stack, fmt.Errorf("stack %s was removed while upgrading", stack.Name)
+		}
+
+		switch {
+		case current.Transitioning == "error":
+			return current, fmt.Errorf("upgrading stack %s failed: %s", current.Name, current.TransitioningMessage)
+		case current.Transitioning != "yes" && current.State == STACK_STATE_UPGRADED:
+			return current, nil
+		case current.Transitioning != "yes" && current.State != "upgrading":
+			return current, fmt.Errorf("upgrading stack %s ended in state %s", current.Name, current.State)
+		}
+
+		if time.Now().After(deadline) {
+			return current, &DeployError{Code: EXIT_TIMEOUT, Err: fmt.Errorf("upgrading stack %s timed out", current.Name)}
+		}
+		time.Sleep(stackPollInterval)
+	}
+}
+
+// ValidateStack runs the validators against every service of the compose
+// files, with the values of opts.Variables filled in.  Every image is checked
+// as if the service was upgraded to it, and services that already exist in
+// the stack are also checked with the launch configs they are upgraded to,
+// including their sidekicks.
+func (cli *Client) ValidateStack(stack *client.Environment, composeConfig *client.ComposeConfig, opts config.StackUpgradeOpts) error {
+	cfg, err := compose.Parse(compose.SubstituteVariables(composeConfig, opts.Variables))
+	if err != nil {
+		return err
+	}
+
+	existing, err := cli.stackServices(stack.Id)
+	if err != nil {
+		return err
+	}
+
+	sidekicks := make(map[string]bool)
+	for _, name := range cfg.ServiceNames() {
+		for _, sidekick := range cfg.Sidekicks(name) {
+			sidekicks[sidekick] = true
+		}
+	}
+
+	for _, name := range cfg.ServiceNames() {
+		lc, err := cfg.LaunchConfig(name)
+		if err != nil {
+			return err
+		}
+
+		service, ok := existing[name]
+		if !ok {
+			service = &client.Service{
+				Name:          name,
+				AccountId:     stack.AccountId,
+				EnvironmentId: stack.Id,
+				LaunchConfig:  lc,
+			}
+		}
+
+		// The registry validator checks tags, images pinned by digest are skipped
+		if image := strings.TrimPrefix(lc.ImageUuid, "docker:"); image != "" && !strings.Contains(image, "@") {
+			if err := cli.ValidateService(service, config.UpgradeOpts{Service: name, RuntimeTag: withDefaultTag(image)}); err != nil {
+				return errors.Wrapf(err, "service %s", name)
+			}
+		}
+
+		if !ok || sidekicks[name] {
+			continue
+		}
+
+		upgrade, err := stackServiceUpgrade(cfg, name, lc)
+		if err != nil {
+			return err
+		}
+
+		candidate := *service
+		if scale, ok := cfg.Scale(name); ok {
+			candidate.Scale = scale
+		}
+		upgradeOpts := config.UpgradeOpts{
+			Service:             name,
+			SkipSchedulingCheck: opts.SkipSchedulingCheck,
+		}
+		if err := cli.ValidateUpgrade(&candidate, upgrade, upgradeOpts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Build the service upgrade Rancher performs for the service of the stack.
+// Sidekick launch configs are untyped maps in the api client so they are
+// converted through their json representation.
+func stackServiceUpgrade(cfg *compose.Config, name string, lc *client.LaunchConfig) (*client.ServiceUpgrade, error) {
+	batchSize, startFirst := cfg.UpgradeStrategy(name)
+	strategy := &client.InServiceUpgradeStrategy{
+		BatchSize:    batchSize,
+		StartFirst:   startFirst,
+		LaunchConfig: lc,
+	}
+
+	for _, sidekick := range cfg.Sidekicks(name) {
+		slc, err := cfg.LaunchConfig(sidekick)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := json.Marshal(slc)
+		if err != nil {
+			return nil, err
+		}
+		secondary := make(map[string]interface{})
+		if err := json.Unmarshal(data, &secondary); err != nil {
+			return nil, err
+		}
+		secondary["name"] = sidekick
+		strategy.SecondaryLaunchConfigs = append(strategy.SecondaryLaunchConfigs, secondary)
+	}
+
+	return &client.ServiceUpgrade{
+		InServiceStrategy: strategy,
+	}, nil
+}
+
+// Return the services of the stack that have not been removed by name.
+func (cli *Client) stackServices(stackId string) (map[string]*client.Service, error) {
+	filters := make(map[string]interface{})
+	filters["environmentId"] = stackId
+	services, err := cli.listServices(filters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*client.Service)
+	for i, service := range services {
+		if service.State == "removed" || service.State == "purged" {
+			continue
+		}
+		byName[service.Name] = &services[i]
+	}
+	return byName, nil
+}
+
+// Images without a tag implicitly use the latest tag.
+func withDefaultTag(image string) string {
+	if pos := strings.LastIndex(image, ":"); pos == -1 || pos < strings.LastIndex(image, "/") {
+		return image + ":latest"
+	}
+	return image
+}
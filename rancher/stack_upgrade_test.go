@@ -0,0 +1,303 @@
+package rancher
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nowait/rancher-cli/rancher/config"
+	"github.com/nowait/rancher-cli/rancher/mocks"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	stackDockerCompose = `version: '2'
+services:
+  api:
+    image: nowait/api:${API_TAG}
+    labels:
+      io.rancher.sidekicks: api-code
+  api-code:
+    image: nowait/api-code:2.0
+  web:
+    image: nowait/web
+`
+	stackRancherCompose = `version: '2'
+services:
+  api:
+    scale: 3
+    upgrade_strategy:
+      start_first: true
+`
+)
+
+// UpgradeStacks returns the states of the stack after the upgrade in order
+// and records the actions taken on it.
+type UpgradeStacks struct {
+	mocks.NoopEnvironmentOperations
+	States   []string
+	Upgrade  *client.EnvironmentUpgrade
+	Finished bool
+	Rolled   bool
+}
+
+func (env *UpgradeStacks) List(opts *client.ListOpts) (*client.EnvironmentCollection, error) {
+	return &client.EnvironmentCollection{
+		Data: []client.Environment{
+			{Resource: client.Resource{Id: "1e1"}, Name: opts.Filters["name"].(string), AccountId: "1a5", State: "active"},
+		},
+	}, nil
+}
+
+func (env *UpgradeStacks) ActionUpgrade(stack *client.Environment, upgrade *client.EnvironmentUpgrade) (*client.Environment, error) {
+	env.Upgrade = upgrade
+	return stack, nil
+}
+
+func (env *UpgradeStacks) ById(id string) (*client.Environment, error) {
+	state := env.States[0]
+	if len(env.States) > 1 {
+		env.States = env.States[1:]
+	}
+	transitioning := "no"
+	if state == "upgrading" {
+		transitioning = "yes"
+	}
+	return &client.Environment{Resource: client.Resource{Id: id}, Name: "backend", State: state, Transitioning: transitioning}, nil
+}
+
+func (env *UpgradeStacks) ActionFinishupgrade(stack *client.Environment) (*client.Environment, error) {
+	env.Finished = true
+	stack.State = "active"
+	return stack, nil
+}
+
+func (env *UpgradeStacks) ActionRollback(stack *client.Environment) (*client.Environment, error) {
+	env.Rolled = true
+	return stack, nil
+}
+
+type StackServices struct {
+	client.ServiceOperations
+}
+
+func (ops *StackServices) List(opts *client.ListOpts) (*client.ServiceCollection, error) {
+	return &client.ServiceCollection{
+		Data: []client.Service{
+			{Name: "api", EnvironmentId: opts.Filters["environmentId"].(string), Scale: 1, LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:nowait/api:1.0"}},
+		},
+	}, nil
+}
+
+// Records the images and upgrades the validators are called with.
+type RecordingValidator struct {
+	Images   []string
+	Upgrades map[string]*client.ServiceUpgrade
+	Scales   map[string]int64
+	Fail     string
+}
+
+func (val *RecordingValidator) Validate(service *client.Service, opts config.UpgradeOpts) error {
+	val.Images = append(val.Images, opts.RuntimeTag)
+	if opts.RuntimeTag == val.Fail {
+		return errors.New("image not found")
+	}
+	return nil
+}
+
+func (val *RecordingValidator) ValidateUpgrade(service *client.Service, upgrade *client.ServiceUpgrade, opts config.UpgradeOpts) error {
+	val.Upgrades[service.Name] = upgrade
+	val.Scales[service.Name] = service.Scale
+	return nil
+}
+
+func stackUpgradeClient(states ...string) (*Client, *UpgradeStacks, *RecordingValidator) {
+	stackPollInterval = time.Millisecond
+	stacks := &UpgradeStacks{States: states}
+	validator := &RecordingValidator{
+		Upgrades: make(map[string]*client.ServiceUpgrade),
+		Scales:   make(map[string]int64),
+	}
+	return &Client{
+		RancherClient: &client.RancherClient{
+			Environment: stacks,
+			Service:     &StackServices{},
+		},
+		Validators:        []config.Validator{validator},
+		UpgradeValidators: []config.UpgradeValidator{validator},
+	}, stacks, validator
+}
+
+func stackUpgradeOpts() config.StackUpgradeOpts {
+	return config.StackUpgradeOpts{
+		Stack:          "backend",
+		DockerCompose:  stackDockerCompose,
+		RancherCompose: stackRancherCompose,
+		Variables:      map[string]string{"API_TAG": "1.1", "UNUSED": "value"},
+		Wait:           true,
+		Timeout:        time.Second,
+	}
+}
+
+func TestUpgradeStackValidatesEveryImage(t *testing.T) {
+	cli, stacks, validator := stackUpgradeClient("upgrading", "upgrading", "upgraded")
+
+	stack, err := cli.UpgradeStack(stackUpgradeOpts())
+	if err != nil {
+		t.Fatalf("upgrading the stack failed: %v", err)
+	}
+
+	sort.Strings(validator.Images)
+	expectedImages := []string{"nowait/api-code:2.0", "nowait/api:1.1", "nowait/web:latest"}
+	if !reflect.DeepEqual(validator.Images, expectedImages) {
+		t.Errorf("expected images %v to be validated, received %v", expectedImages, validator.Images)
+	}
+
+	upgrade, ok := validator.Upgrades["api"]
+	if !ok || len(validator.Upgrades) != 1 {
+		t.Fatalf("expected only the existing api service to be validated as an upgrade, received %v", validator.Upgrades)
+	}
+	strategy := upgrade.InServiceStrategy
+	if !strategy.StartFirst || len(strategy.SecondaryLaunchConfigs) != 1 || validator.Scales["api"] != 3 {
+		t.Errorf("expected the upgrade strategy, sidekick and scale of the compose files, received %+v scale %d", strategy, validator.Scales["api"])
+	}
+
+	if !reflect.DeepEqual(stacks.Upgrade.Environment, map[string]interface{}{"API_TAG": "1.1"}) {
+		t.Errorf("expected only the used variables to be passed, received %v", stacks.Upgrade.Environment)
+	}
+	if !stacks.Finished || stacks.Rolled || stack.State != "active" {
+		t.Errorf("expected the upgrade to be finished, finished %t rolled back %t", stacks.Finished, stacks.Rolled)
+	}
+}
+
+func TestUpgradeStackFailures(t *testing.T) {
+	tests := []struct {
+		Description string
+		States      []string
+		Variables   map[string]string
+		FailImage   string
+		Upgraded    bool
+		RolledBack  bool
+		Error       string
+	}{
+		{
+			Description: "Missing variables",
+			States:      []string{"upgraded"},
+			Variables:   map[string]string{},
+			Error:       "missing values for variables API_TAG",
+		},
+		{
+			Description: "Image not in the registry",
+			States:      []string{"upgraded"},
+			FailImage:   "nowait/web:latest",
+			Error:       "service web: image not found",
+		},
+		{
+			Description: "Upgrade ends in an error",
+			States:      []string{"upgrading", "error"},
+			Upgraded:    true,
+			RolledBack:  true,
+			Error:       "upgrading stack backend ended in state error",
+		},
+		{
+			Description: "Upgrade times out",
+			States:      []string{"upgrading"},
+			Upgraded:    true,
+			RolledBack:  true,
+			Error:       "upgrading stack backend timed out",
+		},
+	}
+
+	for _, test := range tests {
+		cli, stacks, validator := stackUpgradeClient(test.States...)
+		validator.Fail = test.FailImage
+		opts := stackUpgradeOpts()
+		opts.Timeout = 20 * time.Millisecond
+		if test.Variables != nil {
+			opts.Variables = test.Variables
+		}
+
+		_, err := cli.UpgradeStack(opts)
+
+		if err == nil || !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("%s: expected error %s but received %v", test.Description, test.Error, err)
+		}
+		if (stacks.Upgrade != nil) != test.Upgraded || stacks.Rolled != test.RolledBack || stacks.Finished {
+			t.Errorf("%s: expected upgraded %t rolled back %t, received upgraded %t rolled back %t finished %t",
+				test.Description, test.Upgraded, test.RolledBack, stacks.Upgrade != nil, stacks.Rolled, stacks.Finished)
+		}
+	}
+}
+
+// LockCheckingStacks records the locks held on the services when the stack
+// is upgraded.
+type LockCheckingStacks struct {
+	*UpgradeStacks
+	Services *LockingServices
+	Held     []string
+}
+
+func (env *LockCheckingStacks) ActionUpgrade(stack *client.Environment, upgrade *client.EnvironmentUpgrade) (*client.Environment, error) {
+	for _, id := range []string{"1s1", "1s2"} {
+		if lock, _ := ServiceLock(env.Services.Services[id]); lock != nil && lock.Held() {
+			env.Held = append(env.Held, id)
+		}
+	}
+	return env.UpgradeStacks.ActionUpgrade(stack, upgrade)
+}
+
+func TestUpgradeStackLocksServices(t *testing.T) {
+	tests := []struct {
+		Description string
+		Existing    *Lock
+		Held        []string
+		Error       string
+	}{
+		{
+			Description: "Services are locked during the upgrade",
+			Held:        []string{"1s1", "1s2"},
+		},
+		{
+			Description: "Service locked by somebody else",
+			Existing:    otherLock(time.Now()),
+			Error:       "Failed to lock stack backend",
+		},
+	}
+
+	for _, test := range tests {
+		cli, stacks, _ := stackUpgradeClient("upgrading", "upgraded")
+		metadata := map[string]interface{}{}
+		if test.Existing != nil {
+			metadata[LOCK_METADATA_KEY] = test.Existing
+		}
+		_, services := lockingClient(nil)
+		services.Services["1s2"].Metadata = metadata
+		checking := &LockCheckingStacks{UpgradeStacks: stacks, Services: services}
+		cli.RancherClient.Environment = checking
+		cli.RancherClient.Service = services
+
+		opts := stackUpgradeOpts()
+		opts.LockTTL = time.Minute
+		_, err := cli.UpgradeStack(opts)
+
+		if test.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Error) || stacks.Upgrade != nil {
+				t.Errorf("%s: expected the upgrade to be refused with %q, received %v", test.Description, test.Error, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: expected the upgrade to succeed, received %v", test.Description, err)
+		}
+		if !reflect.DeepEqual(checking.Held, test.Held) {
+			t.Errorf("%s: expected the locks of %v held during the upgrade, received %v", test.Description, test.Held, checking.Held)
+		}
+		if lock, _ := ServiceLock(services.Services["1s1"]); lock != nil {
+			t.Errorf("%s: expected the lock of 1s1 to be released, received %v", test.Description, lock)
+		}
+		if lock, _ := ServiceLock(services.Services["1s2"]); test.Existing != nil && (lock == nil || !lock.same(test.Existing)) {
+			t.Errorf("%s: expected the lock of somebody else to be kept, received %v", test.Description, lock)
+		}
+	}
+}
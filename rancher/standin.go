@@ -0,0 +1,133 @@
+package rancher
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// StandIn is a local stand-in for the webhooks and smtp servers of the
+// notifiers, recording what they are sent so notifiers can be tried out.
+type StandIn struct {
+	// Base url of the webhooks and address of the smtp server
+	Url      string
+	SmtpAddr string
+
+	server   *httptest.Server
+	listener net.Listener
+	lock     sync.Mutex
+	received []StandInMessage
+}
+
+// StandInMessage is a request or email received by the stand-in.
+type StandInMessage struct {
+	// Path of the webhook or the recipients of the email
+	Target string `json:"target"`
+	Body   string `json:"body"`
+}
+
+// NewStandIn starts a stand-in listening on local ports.
+func NewStandIn() (*StandIn, error) {
+	standIn := &StandIn{}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	standIn.listener = listener
+	standIn.SmtpAddr = listener.Addr().String()
+	go standIn.acceptSmtp()
+
+	standIn.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		standIn.record(StandInMessage{Target: r.URL.Path, Body: string(body)})
+	}))
+	standIn.Url = standIn.server.URL
+	return standIn, nil
+}
+
+// Received returns what the stand-in has received so far.
+func (standIn *StandIn) Received() []StandInMessage {
+	standIn.lock.Lock()
+	defer standIn.lock.Unlock()
+	return append([]StandInMessage{}, standIn.received...)
+}
+
+// Close stops the stand-in.
+func (standIn *StandIn) Close() {
+	standIn.server.Close()
+	standIn.listener.Close()
+}
+
+func (standIn *StandIn) record(message StandInMessage) {
+	standIn.lock.Lock()
+	defer standIn.lock.Unlock()
+	standIn.received = append(standIn.received, message)
+}
+
+func (standIn *StandIn) acceptSmtp() {
+	for {
+		conn, err := standIn.listener.Accept()
+		if err != nil {
+			return
+		}
+		go standIn.serveSmtp(conn)
+	}
+}
+
+// Speak just enough smtp to receive an email, without extensions.
+func (standIn *StandIn) serveSmtp(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	recipients := []string{}
+	reply("220 rancher-cli stand-in")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 rancher-cli stand-in")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			recipients = append(recipients, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			body := []string{}
+			for {
+				data, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				data = strings.TrimRight(data, "\r\n")
+				if data == "." {
+					break
+				}
+				body = append(body, strings.TrimPrefix(data, "."))
+			}
+			standIn.record(StandInMessage{Target: strings.Join(recipients, ","), Body: strings.Join(body, "\n")})
+			recipients = []string{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}